@@ -3,7 +3,10 @@ package api
 import (
 	"time"
 
+	"github.com/jerryan999/goapp/internal/apikeys"
+	"github.com/jerryan999/goapp/internal/auth"
 	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/sessions"
 	"github.com/jerryan999/goapp/internal/users"
 )
 
@@ -13,27 +16,46 @@ var (
 
 // API holds all the dependencies required to expose APIs. And each API is a function with *API as its receiver
 type API struct {
-	Logger logger.Logger
-	users  *users.Users
+	Logger       logger.Logger
+	users        *users.Users
+	tokens       *auth.Tokens
+	sessions     *sessions.Sessions
+	apiKeys      *apikeys.APIKeys
+	build        BuildInfo
+	healthChecks []HealthCheck
 }
 
 // Health returns the health of the app along with other info like version
 func (a *API) Health() (map[string]interface{}, error) {
 	return map[string]interface{}{
-		"env":        "testing",
-		"version":    "v0.1.0",
-		"commit":     "<git commit hash>",
+		"env":        a.build.Env,
+		"version":    a.build.Version,
+		"commit":     a.build.Commit,
 		"status":     "all systems up and running",
 		"startedAt":  now.String(),
-		"releasedOn": now.String(),
+		"releasedOn": a.build.BuildTime,
 	}, nil
 
 }
 
-// NewService returns a new instance of API with all the dependencies initialized
-func NewService(l logger.Logger, us *users.Users) (*API, error) {
+// NewService returns a new instance of API with all the dependencies initialized. healthChecks are
+// the checks of all dependencies which are run for readiness
+func NewService(
+	l logger.Logger,
+	us *users.Users,
+	tokens *auth.Tokens,
+	ss *sessions.Sessions,
+	ak *apikeys.APIKeys,
+	build BuildInfo,
+	healthChecks []HealthCheck,
+) (*API, error) {
 	return &API{
-		Logger: l,
-		users:  us,
+		Logger:       l,
+		users:        us,
+		tokens:       tokens,
+		sessions:     ss,
+		apiKeys:      ak,
+		build:        build,
+		healthChecks: healthChecks,
 	}, nil
 }
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/mail"
+	"testing"
+
+	"github.com/jerryan999/goapp/internal/apikeys"
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/pkg/mailer"
+	"github.com/jerryan999/goapp/internal/pkg/password"
+	"github.com/jerryan999/goapp/internal/sessions"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+const testPassword = "correct horse battery"
+
+// newTestAPI returns an API with the in-memory implementations of all its dependencies, and cheap
+// password hashing so that tests are fast
+func newTestAPI(t *testing.T) *API {
+	t.Helper()
+
+	l := logger.New("goapp", "test", 1)
+	l.SetOutput(io.Discard)
+	h, err := password.NewService(&password.Config{
+		Argon2MemoryKiB:   64,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+	})
+	if err != nil {
+		t.Fatalf("password.NewService: %v", err)
+	}
+
+	us, err := users.NewService(&users.Config{
+		Store:      users.StoreMemory,
+		Cache:      users.CacheMemory,
+		TokenStore: users.TokenStoreMemory,
+	}, l, nil, nil, h, mailer.NewWriterMailer(&mail.Address{Address: "no-reply@localhost"}, io.Discard))
+	if err != nil {
+		t.Fatalf("users.NewService: %v", err)
+	}
+	tokens, err := auth.NewService(&auth.Config{
+		Store:     auth.StoreMemory,
+		Algorithm: "HS256",
+		Key:       "a secret of at least thirty-two bytes",
+	}, l, nil)
+	if err != nil {
+		t.Fatalf("auth.NewService: %v", err)
+	}
+	ss, err := sessions.NewService(&sessions.Config{Store: sessions.StoreMemory, CookieSecure: true}, l, nil)
+	if err != nil {
+		t.Fatalf("sessions.NewService: %v", err)
+	}
+	ak, err := apikeys.NewService(&apikeys.Config{Store: apikeys.StoreMemory}, l, nil)
+	if err != nil {
+		t.Fatalf("apikeys.NewService: %v", err)
+	}
+
+	a, err := NewService(l, us, tokens, ss, ak, BuildInfo{}, nil)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return a
+}
+
+// createUser creates a user with email and the test password
+func (a *API) createUser(t *testing.T, email string) *users.User {
+	t.Helper()
+
+	u, err := a.users.CreateUser(context.Background(), &users.User{
+		FirstName: "Jane",
+		Email:     email,
+		Password:  testPassword,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return u
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/apikeys"
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// authorizeAPIKeys returns an error unless the principal of ctx is a user allowed to manage API
+// keys. Services are never allowed to, even with a key of their own
+func (a *API) authorizeAPIKeys(ctx context.Context) error {
+	p := auth.PrincipalFromContext(ctx)
+	if p != nil && p.IsService() {
+		return fmt.Errorf("authorizeAPIKeys: %w: services cannot manage api keys", auth.ErrForbidden)
+	}
+	return a.authorize(ctx, users.PermissionAPIKeysWrite, "")
+}
+
+// IssueAPIKey is the API to issue a new API key for a service. It returns the key, which is shown
+// only once, along with its details
+func (a *API) IssueAPIKey(
+	ctx context.Context,
+	name string,
+	scopes []string,
+	ttl time.Duration,
+) (string, *apikeys.Key, error) {
+	ctx, span := tracing.Start(ctx, "api.IssueAPIKey")
+	defer span.End()
+
+	err := a.authorizeAPIKeys(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return "", nil, err
+	}
+
+	key, k, err := a.apiKeys.Issue(ctx, name, scopes, ttl, auth.PrincipalFromContext(ctx).Subject)
+	span.RecordError(err)
+	return key, k, err
+}
+
+// RotateAPIKey is the API to replace the secret of an API key. The previous secret is accepted for
+// a grace period, so that the service can be updated without downtime
+func (a *API) RotateAPIKey(ctx context.Context, id string) (string, *apikeys.Key, error) {
+	ctx, span := tracing.Start(ctx, "api.RotateAPIKey")
+	defer span.End()
+
+	err := a.authorizeAPIKeys(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return "", nil, err
+	}
+
+	key, k, err := a.apiKeys.Rotate(ctx, id)
+	span.RecordError(err)
+	return key, k, err
+}
+
+// RevokeAPIKey is the API to revoke an API key right away
+func (a *API) RevokeAPIKey(ctx context.Context, id string) (*apikeys.Key, error) {
+	ctx, span := tracing.Start(ctx, "api.RevokeAPIKey")
+	defer span.End()
+
+	err := a.authorizeAPIKeys(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	k, err := a.apiKeys.Revoke(ctx, id)
+	span.RecordError(err)
+	return k, err
+}
+
+// ListAPIKeys is the API to list all the API keys, including revoked and expired ones
+func (a *API) ListAPIKeys(ctx context.Context) ([]*apikeys.Key, error) {
+	ctx, span := tracing.Start(ctx, "api.ListAPIKeys")
+	defer span.End()
+
+	err := a.authorizeAPIKeys(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	list, err := a.apiKeys.List(ctx)
+	span.RecordError(err)
+	return list, err
+}
+
+// AuthenticateAPIKey is the API to verify an API key, and returns the service principal it was
+// issued to
+func (a *API) AuthenticateAPIKey(ctx context.Context, key string) (*auth.Principal, error) {
+	ctx, span := tracing.Start(ctx, "api.AuthenticateAPIKey")
+	defer span.End()
+
+	k, err := a.apiKeys.Authenticate(ctx, key)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	p := &auth.Principal{
+		APIKeyID: k.ID,
+		Scopes:   k.Scopes,
+	}
+	if k.ExpiresAt != nil {
+		p.ExpiresAt = *k.ExpiresAt
+	}
+	return p, nil
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/jwt"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// IssueTokens is the API to log in a user with their email and password, in exchange for a pair of
+// access and refresh tokens. Users with MFA enabled get a *users.MFAChallengeError instead, and
+// complete the login with CompleteMFATokens
+func (a *API) IssueTokens(ctx context.Context, email string, password string, clientIP string) (*auth.TokenPair, error) {
+	ctx, span := tracing.Start(ctx, "api.IssueTokens")
+	defer span.End()
+
+	u, err := a.users.Authenticate(ctx, email, password, clientIP)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	pair, err := a.tokens.Issue(ctx, u.ID, u.Email, false)
+	span.RecordError(err)
+	return pair, err
+}
+
+// CompleteMFATokens is the API to complete the login of IssueTokens with a one-time code, in exchange
+// for a pair of tokens
+func (a *API) CompleteMFATokens(
+	ctx context.Context,
+	challenge string,
+	code string,
+	clientIP string,
+) (*auth.TokenPair, error) {
+	ctx, span := tracing.Start(ctx, "api.CompleteMFATokens")
+	defer span.End()
+
+	u, err := a.users.CompleteMFAChallenge(ctx, challenge, code, clientIP)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	pair, err := a.tokens.Issue(ctx, u.ID, u.Email, true)
+	span.RecordError(err)
+	return pair, err
+}
+
+// RefreshTokens is the API to exchange a refresh token for a new pair of tokens
+func (a *API) RefreshTokens(ctx context.Context, refreshToken string) (*auth.TokenPair, error) {
+	ctx, span := tracing.Start(ctx, "api.RefreshTokens")
+	defer span.End()
+
+	pair, err := a.tokens.Refresh(ctx, refreshToken, func(subject string, email string, issuedAt time.Time) error {
+		// the user is read by ID, like the actor of access tokens, so that tokens survive an email
+		// change but are not refreshed for users who have been deleted
+		u, err := a.users.ReadByID(ctx, subject)
+		if errors.Is(err, users.ErrUserNotFound) {
+			return fmt.Errorf("refreshTokens: %w: user not found", auth.ErrInvalidToken)
+		}
+		if err == nil && u.CredentialsChangedAfter(issuedAt) {
+			return fmt.Errorf("refreshTokens: %w: credentials changed", auth.ErrInvalidToken)
+		}
+		return err
+	})
+	span.RecordError(err)
+	return pair, err
+}
+
+// RevokeTokens is the API to log out the authenticated principal of ctx, by revoking their access
+// token and the family of refreshToken, if any
+func (a *API) RevokeTokens(ctx context.Context, refreshToken string) error {
+	ctx, span := tracing.Start(ctx, "api.RevokeTokens")
+	defer span.End()
+
+	err := a.tokens.Revoke(ctx, auth.PrincipalFromContext(ctx), refreshToken)
+	span.RecordError(err)
+	return err
+}
+
+// Authenticate is the API to verify an access token, and returns the principal it was issued to
+func (a *API) Authenticate(ctx context.Context, accessToken string) (*auth.Principal, error) {
+	ctx, span := tracing.Start(ctx, "api.Authenticate")
+	defer span.End()
+
+	p, err := a.tokens.Verify(ctx, accessToken)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	changed, err := a.credentialsChanged(ctx, p)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	if changed {
+		err = fmt.Errorf("authenticate: %w: credentials changed", auth.ErrInvalidToken)
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// JWKS is the API to list the public keys tokens are verified with, so that other services can
+// verify them as well
+func (a *API) JWKS() jwt.JWKS {
+	return a.tokens.JWKS()
+}
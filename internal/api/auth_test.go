@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(t *testing.T, a *API)
+		wantErr error
+	}{
+		{name: "unchanged", change: func(t *testing.T, a *API) {}},
+		{
+			name: "email changed",
+			change: func(t *testing.T, a *API) {
+				_, err := a.users.UpdateUser(context.Background(), "jane@example.com", &users.User{
+					FirstName: "Jane",
+					Email:     "janet@example.com",
+				})
+				if err != nil {
+					t.Fatalf("UpdateUser: %v", err)
+				}
+			},
+		},
+		{
+			name: "user deleted",
+			change: func(t *testing.T, a *API) {
+				err := a.users.DeleteUser(context.Background(), "jane@example.com")
+				if err != nil {
+					t.Fatalf("DeleteUser: %v", err)
+				}
+			},
+			wantErr: auth.ErrInvalidToken,
+		},
+		{
+			name: "email given to another user",
+			change: func(t *testing.T, a *API) {
+				_ = a.users.DeleteUser(context.Background(), "jane@example.com")
+				a.createUser(t, "jane@example.com")
+			},
+			wantErr: auth.ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			a := newTestAPI(t)
+			a.createUser(t, "jane@example.com")
+			pair, err := a.IssueTokens(ctx, "jane@example.com", testPassword, "192.0.2.1")
+			if err != nil {
+				t.Fatalf("IssueTokens: %v", err)
+			}
+
+			tt.change(t, a)
+
+			_, err = a.RefreshTokens(ctx, pair.RefreshToken)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RefreshTokens error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
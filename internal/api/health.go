@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// HealthStatusUp is the status when all dependencies are healthy
+	HealthStatusUp = "up"
+	// HealthStatusDegraded is the status when only non-critical dependencies are unhealthy
+	HealthStatusDegraded = "degraded"
+	// HealthStatusDown is the status when at least one critical dependency is unhealthy
+	HealthStatusDown = "down"
+
+	defaultHealthCheckTimeout = time.Second * 2
+)
+
+// BuildInfo holds the details of the running build. Version, Commit and BuildTime are expected to be
+// injected at build time
+type BuildInfo struct {
+	Env       string
+	Version   string
+	Commit    string
+	BuildTime string
+}
+
+// HealthCheck checks the health of a single dependency of the app
+type HealthCheck struct {
+	Name string
+	// Critical dependencies make the app not ready when unhealthy, others only degrade it
+	Critical bool
+	// Timeout is the maximum time allowed for Check, the default is 2 seconds
+	Timeout time.Duration
+	Check   func(ctx context.Context) error
+}
+
+// DependencyHealth is the result of a HealthCheck
+type DependencyHealth struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the health of the app, along with all its dependencies
+type HealthReport struct {
+	Status       string             `json:"status"`
+	Version      string             `json:"version"`
+	Commit       string             `json:"commit"`
+	Dependencies []DependencyHealth `json:"dependencies"`
+}
+
+// Liveness returns the liveness of the app. It does not check any dependencies, since a restart of
+// the app would not fix an unavailable dependency
+func (a *API) Liveness() map[string]interface{} {
+	return map[string]interface{}{
+		"status":    HealthStatusUp,
+		"version":   a.build.Version,
+		"startedAt": now.String(),
+	}
+}
+
+// Readiness checks all the dependencies concurrently and returns the health of the app. The app is
+// ready to serve requests unless the status is HealthStatusDown
+func (a *API) Readiness(ctx context.Context) *HealthReport {
+	report := &HealthReport{
+		Status:       HealthStatusUp,
+		Version:      a.build.Version,
+		Commit:       a.build.Commit,
+		Dependencies: make([]DependencyHealth, len(a.healthChecks)),
+	}
+
+	wg := sync.WaitGroup{}
+	for idx, hc := range a.healthChecks {
+		wg.Add(1)
+		go func(idx int, hc HealthCheck) {
+			defer wg.Done()
+			report.Dependencies[idx] = runHealthCheck(ctx, hc)
+		}(idx, hc)
+	}
+	wg.Wait()
+
+	for _, dh := range report.Dependencies {
+		if dh.Status == HealthStatusUp {
+			continue
+		}
+		if dh.Critical {
+			report.Status = HealthStatusDown
+			a.Logger.With("dependency", dh.Name, "error", dh.Error).Error("health check failed")
+			continue
+		}
+		if report.Status == HealthStatusUp {
+			report.Status = HealthStatusDegraded
+		}
+		a.Logger.With("dependency", dh.Name, "error", dh.Error).Warn("health check failed")
+	}
+
+	return report
+}
+
+func runHealthCheck(ctx context.Context, hc HealthCheck) DependencyHealth {
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := hc.Check(ctx)
+	dh := DependencyHealth{
+		Name:      hc.Name,
+		Status:    HealthStatusUp,
+		Critical:  hc.Critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		dh.Status = HealthStatusDown
+		dh.Error = err.Error()
+	}
+
+	return dh
+}
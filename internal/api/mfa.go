@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// mfaUser returns the current email of the principal of ctx, which has to be a user since MFA is
+// managed by users themselves
+func (a *API) mfaUser(ctx context.Context) (string, error) {
+	p := auth.PrincipalFromContext(ctx)
+	if p == nil {
+		return "", fmt.Errorf("mfaUser: %w", auth.ErrUnauthenticated)
+	}
+	if p.IsService() {
+		return "", fmt.Errorf("mfaUser: %w: services cannot enroll in mfa", auth.ErrForbidden)
+	}
+	return a.actorEmail(ctx)
+}
+
+// EnrollMFA is the API to start the TOTP enrollment of the principal of ctx
+func (a *API) EnrollMFA(ctx context.Context) (*users.MFAEnrollment, error) {
+	ctx, span := tracing.Start(ctx, "api.EnrollMFA")
+	defer span.End()
+
+	email, err := a.mfaUser(ctx)
+	if err == nil {
+		err = a.authorize(ctx, users.PermissionUsersWrite, email)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	enrollment, err := a.users.EnrollMFA(ctx, email)
+	span.RecordError(err)
+	return enrollment, err
+}
+
+// ConfirmMFA is the API to enable MFA for the principal of ctx, with a one-time code of the secret
+// returned by EnrollMFA. It returns the recovery codes
+func (a *API) ConfirmMFA(ctx context.Context, code string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "api.ConfirmMFA")
+	defer span.End()
+
+	email, err := a.mfaUser(ctx)
+	if err == nil {
+		err = a.authorize(ctx, users.PermissionUsersWrite, email)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	codes, err := a.users.ConfirmMFA(ctx, email, code)
+	span.RecordError(err)
+	return codes, err
+}
+
+// DisableMFA is the API to disable MFA for the user identified by email, or the principal of ctx if
+// it's empty. Users disabling their own MFA have to enter a one-time or recovery code, while
+// disabling it for other users requires the users:write permission instead, e.g. for a user who lost
+// their device
+func (a *API) DisableMFA(ctx context.Context, email string, code string) error {
+	ctx, span := tracing.Start(ctx, "api.DisableMFA")
+	defer span.End()
+
+	self, err := a.mfaUser(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if email == "" {
+		email = self
+	}
+
+	err = a.authorize(ctx, users.PermissionUsersWrite, email)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if strings.EqualFold(strings.TrimSpace(email), self) {
+		err = a.users.DisableMFA(ctx, email, code)
+	} else {
+		err = a.users.ResetMFA(ctx, email)
+	}
+	span.RecordError(err)
+	return err
+}
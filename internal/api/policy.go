@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// actorCache holds the users read by ID while serving a single request, so that the user of the
+// principal is read once no matter how many checks need it
+type actorCache struct {
+	mu    sync.Mutex
+	users map[string]*users.User
+}
+
+type actorCacheCtxKey struct{}
+
+// ContextWithActorCache returns a copy of ctx in which the user of the principal is read at most once.
+// It's meant for the context of a single request, since later changes of the user are not seen
+func ContextWithActorCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, actorCacheCtxKey{}, &actorCache{users: make(map[string]*users.User)})
+}
+
+// readActor returns the user with id, read from the datastore unless ctx already holds it, see
+// ContextWithActorCache
+func (a *API) readActor(ctx context.Context, id string) (*users.User, error) {
+	cache, _ := ctx.Value(actorCacheCtxKey{}).(*actorCache)
+	if cache != nil {
+		cache.mu.Lock()
+		u, ok := cache.users[id]
+		cache.mu.Unlock()
+		if ok {
+			return u, nil
+		}
+	}
+
+	u, err := a.users.ReadByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.mu.Lock()
+		cache.users[id] = u
+		cache.mu.Unlock()
+	}
+	return u, nil
+}
+
+// actor returns the user who's the principal p. The user is read by ID on every request, rather than
+// trusting roles carried by the token or session, so that role changes and deletions are effective
+// immediately, while email changes are not
+func (a *API) actor(ctx context.Context, p *auth.Principal) (*users.User, error) {
+	u, err := a.readActor(ctx, p.Subject)
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return nil, fmt.Errorf("actor: %w: user not found", auth.ErrUnauthenticated)
+		}
+		return nil, fmt.Errorf("actor: %w", err)
+	}
+
+	return u, nil
+}
+
+// actorEmail returns the current email of the user who's the principal of ctx, which may have changed
+// since the principal was authenticated
+func (a *API) actorEmail(ctx context.Context) (string, error) {
+	p := auth.PrincipalFromContext(ctx)
+	if p == nil {
+		return "", fmt.Errorf("actorEmail: %w", auth.ErrUnauthenticated)
+	}
+	if p.IsService() {
+		return "", nil
+	}
+
+	u, err := a.actor(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	return u.Email, nil
+}
+
+// credentialsChanged reports if the credentials of the user who's the principal p changed since p
+// was issued, e.g. the password was reset. Principals of users who do not exist anymore are rejected
+// by actor instead
+func (a *API) credentialsChanged(ctx context.Context, p *auth.Principal) (bool, error) {
+	u, err := a.readActor(ctx, p.Subject)
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("credentialsChanged: %w", err)
+	}
+	return u.CredentialsChangedAfter(p.IssuedAt), nil
+}
+
+// authorize returns an error unless the principal of ctx is allowed to act on the user identified
+// by email. Every user is allowed to act on themself, while acting on other users requires the
+// permission. An empty email always requires the permission. Users required to use MFA have their
+// permissions only if they logged in with a one-time code. Services are allowed only if the
+// permission is one of the scopes of their API key
+func (a *API) authorize(ctx context.Context, permission string, email string) error {
+	p := auth.PrincipalFromContext(ctx)
+	if p == nil {
+		return fmt.Errorf("authorize: %w", auth.ErrUnauthenticated)
+	}
+
+	allowed, mfaMissing := false, false
+	if p.IsService() {
+		allowed = p.HasScope(permission)
+	} else {
+		actor, err := a.actor(ctx, p)
+		if err != nil {
+			return err
+		}
+		self := email != "" && strings.EqualFold(strings.TrimSpace(email), actor.Email)
+		granted := actor.HasPermission(permission)
+		mfaMissing = !self && granted && !p.MFA && a.users.MFARequired(actor)
+		allowed = self || (granted && !mfaMissing)
+	}
+	if allowed {
+		return nil
+	}
+
+	a.Logger.WithContext(ctx).With(
+		"event", "access_denied",
+		"permission", permission,
+		"mfaMissing", mfaMissing,
+	).Warn("access denied")
+	if mfaMissing {
+		return fmt.Errorf("authorize: %w: %s requires logging in with mfa", auth.ErrForbidden, permission)
+	}
+	return fmt.Errorf("authorize: %w: %s is required", auth.ErrForbidden, permission)
+}
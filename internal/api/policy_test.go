@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jerryan999/goapp/internal/auth"
+)
+
+func TestActorReadOncePerRequest(t *testing.T) {
+	a := newTestAPI(t)
+	u := a.createUser(t, "jane@example.com")
+	p := &auth.Principal{Subject: u.ID, Email: u.Email}
+
+	ctx := auth.ContextWithPrincipal(ContextWithActorCache(context.Background()), p)
+	if _, err := a.actor(ctx, p); err != nil {
+		t.Fatalf("actor: %v", err)
+	}
+	err := a.users.DeleteUser(context.Background(), u.Email)
+	if err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	// the request which already read the user keeps it, later requests read it again
+	if _, err := a.actorEmail(ctx); err != nil {
+		t.Errorf("actorEmail in the same request: %v", err)
+	}
+	ctx = auth.ContextWithPrincipal(ContextWithActorCache(context.Background()), p)
+	if _, err := a.actorEmail(ctx); !errors.Is(err, auth.ErrUnauthenticated) {
+		t.Errorf("actorEmail in a later request error = %v, want %v", err, auth.ErrUnauthenticated)
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+	"github.com/jerryan999/goapp/internal/sessions"
+)
+
+// CreateSession is the API to log in a user from a browser with their email and password. It
+// returns the session token, which is to be set as a cookie, and the new session. Users with MFA
+// enabled get a *users.MFAChallengeError instead, and complete the login with CreateMFASession
+func (a *API) CreateSession(
+	ctx context.Context,
+	email string,
+	password string,
+	userAgent string,
+	clientIP string,
+) (string, *sessions.Session, error) {
+	ctx, span := tracing.Start(ctx, "api.CreateSession")
+	defer span.End()
+
+	u, err := a.users.Authenticate(ctx, email, password, clientIP)
+	if err != nil {
+		span.RecordError(err)
+		return "", nil, err
+	}
+
+	token, s, err := a.sessions.Create(ctx, u.ID, u.Email, userAgent, clientIP, false)
+	span.RecordError(err)
+	return token, s, err
+}
+
+// CreateMFASession is the API to complete the login of CreateSession with a one-time code
+func (a *API) CreateMFASession(
+	ctx context.Context,
+	challenge string,
+	code string,
+	userAgent string,
+	clientIP string,
+) (string, *sessions.Session, error) {
+	ctx, span := tracing.Start(ctx, "api.CreateMFASession")
+	defer span.End()
+
+	u, err := a.users.CompleteMFAChallenge(ctx, challenge, code, clientIP)
+	if err != nil {
+		span.RecordError(err)
+		return "", nil, err
+	}
+
+	token, s, err := a.sessions.Create(ctx, u.ID, u.Email, userAgent, clientIP, true)
+	span.RecordError(err)
+	return token, s, err
+}
+
+// AuthenticateSession is the API to verify a session token, and returns its session. Every use
+// extends the idle expiry of the session. Sessions created before the credentials of the user changed
+// are revoked, in case revoking them on the change failed
+func (a *API) AuthenticateSession(ctx context.Context, token string) (*sessions.Session, error) {
+	ctx, span := tracing.Start(ctx, "api.AuthenticateSession")
+	defer span.End()
+
+	s, err := a.sessions.Authenticate(ctx, token)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	changed, err := a.credentialsChanged(ctx, &auth.Principal{Subject: s.UserID, Email: s.Email, IssuedAt: s.CreatedAt})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	if changed {
+		err = a.sessions.Revoke(ctx, s.UserID, s.ID)
+		if err != nil {
+			a.Logger.WithContext(ctx).Error(err.Error())
+		}
+		err = fmt.Errorf("authenticateSession: %w: credentials changed", sessions.ErrSessionNotFound)
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// VerifyCSRF is the API to verify the CSRF token sent along with a state changing request
+// authenticated by the session s
+func (a *API) VerifyCSRF(s *sessions.Session, csrfToken string) error {
+	return a.sessions.VerifyCSRF(s, csrfToken)
+}
+
+// CurrentSession is the API to read the session the principal of ctx is authenticated with
+func (a *API) CurrentSession(ctx context.Context) (*sessions.Session, error) {
+	ctx, span := tracing.Start(ctx, "api.CurrentSession")
+	defer span.End()
+
+	p := auth.PrincipalFromContext(ctx)
+	if p == nil || p.SessionID == "" {
+		return nil, fmt.Errorf("currentSession: %w", sessions.ErrSessionNotFound)
+	}
+
+	s, err := a.sessions.Get(ctx, p.Subject, p.SessionID)
+	span.RecordError(err)
+	return s, err
+}
+
+// ListSessions is the API to list all the active sessions of the principal of ctx
+func (a *API) ListSessions(ctx context.Context) ([]*sessions.Session, error) {
+	ctx, span := tracing.Start(ctx, "api.ListSessions")
+	defer span.End()
+
+	p := auth.PrincipalFromContext(ctx)
+	if p == nil {
+		return nil, fmt.Errorf("listSessions: %w", auth.ErrUnauthenticated)
+	}
+
+	list, err := a.sessions.List(ctx, p.Subject)
+	span.RecordError(err)
+	return list, err
+}
+
+// RevokeSession is the API to revoke one of the sessions of the principal of ctx, e.g. to log out
+// of another browser
+func (a *API) RevokeSession(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "api.RevokeSession")
+	defer span.End()
+
+	p := auth.PrincipalFromContext(ctx)
+	if p == nil {
+		return fmt.Errorf("revokeSession: %w", auth.ErrUnauthenticated)
+	}
+
+	err := a.sessions.Revoke(ctx, p.Subject, id)
+	span.RecordError(err)
+	return err
+}
+
+// RevokeSessions is the API to revoke all the sessions of the principal of ctx, i.e. to log out
+// everywhere
+func (a *API) RevokeSessions(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "api.RevokeSessions")
+	defer span.End()
+
+	p := auth.PrincipalFromContext(ctx)
+	if p == nil {
+		return fmt.Errorf("revokeSessions: %w", auth.ErrUnauthenticated)
+	}
+
+	err := a.sessions.RevokeAll(ctx, p.Subject)
+	span.RecordError(err)
+	return err
+}
+
+// SessionCookie is the API to get the cookie carrying a session token. An empty token returns the
+// cookie which removes the session cookie
+func (a *API) SessionCookie(token string) *http.Cookie {
+	return a.sessions.Cookie(token)
+}
+
+// SessionCookieName is the API to get the name of the cookie carrying the session token
+func (a *API) SessionCookieName() string {
+	return a.sessions.CookieName()
+}
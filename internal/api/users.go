@@ -2,16 +2,264 @@ package api
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
 	"github.com/jerryan999/goapp/internal/users"
 )
 
-// CreateUser is the API to create/signup a new user
+// CreateUser is the API to create/signup a new user. Signup is open to anyone, but only a principal
+// allowed to assign roles can create a user with roles other than the default ones
 func (a *API) CreateUser(ctx context.Context, u *users.User) (*users.User, error) {
-	return a.users.CreateUser(ctx, u)
+	ctx, span := tracing.Start(ctx, "api.CreateUser")
+	defer span.End()
+
+	if !users.DefaultRoles(u.Roles) {
+		err := a.authorize(ctx, users.PermissionRolesWrite, "")
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	u, err := a.users.CreateUser(ctx, u)
+	span.RecordError(err)
+	return u, err
 }
 
-// ReadUserByEmail is the API to read an existing user by their email
+// Login is the API to verify the password of a user, identified by their email. Users with MFA
+// enabled get a *users.MFAChallengeError instead, and complete the login with LoginMFA
+func (a *API) Login(ctx context.Context, email string, password string, clientIP string) (*users.User, error) {
+	ctx, span := tracing.Start(ctx, "api.Login")
+	defer span.End()
+
+	u, err := a.users.Authenticate(ctx, email, password, clientIP)
+	span.RecordError(err)
+	return u, err
+}
+
+// LoginMFA is the API to complete the login of Login with a one-time code
+func (a *API) LoginMFA(ctx context.Context, challenge string, code string, clientIP string) (*users.User, error) {
+	ctx, span := tracing.Start(ctx, "api.LoginMFA")
+	defer span.End()
+
+	u, err := a.users.CompleteMFAChallenge(ctx, challenge, code, clientIP)
+	span.RecordError(err)
+	return u, err
+}
+
+// ReadUserByEmail is the API to read an existing user by their email. Users can read themselves,
+// reading other users requires the users:read permission
 func (a *API) ReadUserByEmail(ctx context.Context, email string) (*users.User, error) {
-	return a.users.ReadByEmail(ctx, email)
+	ctx, span := tracing.Start(ctx, "api.ReadUserByEmail")
+	defer span.End()
+
+	err := a.authorize(ctx, users.PermissionUsersRead, email)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	u, err := a.users.ReadByEmail(ctx, email)
+	span.RecordError(err)
+	return u, err
+}
+
+// UpdateUser is the API to replace an existing user, identified by their email. Users can update
+// themselves, updating other users requires the users:write permission
+func (a *API) UpdateUser(ctx context.Context, email string, u *users.User) (*users.User, error) {
+	ctx, span := tracing.Start(ctx, "api.UpdateUser")
+	defer span.End()
+
+	err := a.authorize(ctx, users.PermissionUsersWrite, email)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	u, err = a.users.UpdateUser(ctx, email, u)
+	span.RecordError(err)
+	return u, err
+}
+
+// PatchUser is the API to partially update an existing user, identified by their email, using a
+// JSON Merge Patch document. It's authorized like UpdateUser
+func (a *API) PatchUser(ctx context.Context, email string, patch []byte) (*users.User, error) {
+	ctx, span := tracing.Start(ctx, "api.PatchUser")
+	defer span.End()
+
+	err := a.authorize(ctx, users.PermissionUsersWrite, email)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	u, err := a.users.PatchUser(ctx, email, patch)
+	span.RecordError(err)
+	return u, err
+}
+
+// DeleteUser is the API to delete an existing user by their email. Users can delete themselves,
+// deleting other users requires the users:write permission
+func (a *API) DeleteUser(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "api.DeleteUser")
+	defer span.End()
+
+	err := a.authorize(ctx, users.PermissionUsersWrite, email)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	err = a.users.DeleteUser(ctx, email)
+	span.RecordError(err)
+	return err
+}
+
+// ListUsers is the API to list users matching the filter, a page at a time. It requires the
+// users:read permission
+func (a *API) ListUsers(ctx context.Context, filter *users.ListFilter, cursor string, limit int) (*users.Page, error) {
+	ctx, span := tracing.Start(ctx, "api.ListUsers")
+	defer span.End()
+
+	err := a.authorize(ctx, users.PermissionUsersRead, "")
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	page, err := a.users.List(ctx, filter, cursor, limit)
+	span.RecordError(err)
+	return page, err
+}
+
+// SetUserRoles is the API to replace the roles of the user identified by email. It requires the
+// roles:write permission, and principals cannot change their own roles, so that the last admin
+// cannot demote themself by mistake
+func (a *API) SetUserRoles(ctx context.Context, email string, roles []string) (*users.User, error) {
+	ctx, span := tracing.Start(ctx, "api.SetUserRoles")
+	defer span.End()
+
+	err := a.authorize(ctx, users.PermissionRolesWrite, "")
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	self, err := a.actorEmail(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	if self != "" && strings.EqualFold(strings.TrimSpace(email), self) {
+		err = fmt.Errorf("setUserRoles: %w: own roles cannot be changed", auth.ErrForbidden)
+		span.RecordError(err)
+		return nil, err
+	}
+
+	u, err := a.users.SetRoles(ctx, email, roles)
+	span.RecordError(err)
+	return u, err
+}
+
+// Unlock is the API to lift the lockout of the account with email and/or of clientIP, after too many
+// failed logins. It requires the users:write permission, even for the principal's own account
+func (a *API) Unlock(ctx context.Context, email string, clientIP string) error {
+	ctx, span := tracing.Start(ctx, "api.Unlock")
+	defer span.End()
+
+	err := a.authorize(ctx, users.PermissionUsersWrite, "")
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	email, clientIP = strings.TrimSpace(email), strings.TrimSpace(clientIP)
+	if email == "" && clientIP == "" {
+		err = fmt.Errorf("unlock: %w, email or ip is required", users.ErrUserValidation)
+		span.RecordError(err)
+		return err
+	}
+
+	if email != "" {
+		err = a.users.Unlock(ctx, email)
+	}
+	if err == nil && clientIP != "" {
+		err = a.users.UnlockIP(ctx, clientIP)
+	}
+	span.RecordError(err)
+	return err
+}
+
+// VerifyEmail is the API to verify the email of a user, using the single use token sent to it. It's
+// open to anyone, since the token proves ownership of the email
+func (a *API) VerifyEmail(ctx context.Context, token string) (*users.User, error) {
+	ctx, span := tracing.Start(ctx, "api.VerifyEmail")
+	defer span.End()
+
+	u, err := a.users.VerifyEmail(ctx, token)
+	span.RecordError(err)
+	return u, err
+}
+
+// ResendVerification is the API to send a new verification token to the email of the user identified
+// by email, or of the principal if email is empty. Users can ask for themselves, asking for other
+// users requires the users:write permission
+func (a *API) ResendVerification(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "api.ResendVerification")
+	defer span.End()
+
+	if strings.TrimSpace(email) == "" && auth.PrincipalFromContext(ctx) != nil {
+		self, err := a.actorEmail(ctx)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		email = self
+	}
+
+	err := a.authorize(ctx, users.PermissionUsersWrite, email)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	err = a.users.SendVerification(ctx, email)
+	span.RecordError(err)
+	return err
+}
+
+// RequestPasswordReset is the API to send a password reset token to email. It's open to anyone, and
+// succeeds whether a user with the email exists or not. Requests are rate limited per email and per
+// clientIP
+func (a *API) RequestPasswordReset(ctx context.Context, email string, clientIP string) error {
+	ctx, span := tracing.Start(ctx, "api.RequestPasswordReset")
+	defer span.End()
+
+	err := a.users.RequestPasswordReset(ctx, email, clientIP)
+	span.RecordError(err)
+	return err
+}
+
+// ResetPassword is the API to replace the password of a user, using the single use token sent to
+// their email. All the sessions of the user are revoked, and tokens issued earlier are rejected
+func (a *API) ResetPassword(ctx context.Context, token string, password string) error {
+	ctx, span := tracing.Start(ctx, "api.ResetPassword")
+	defer span.End()
+
+	u, err := a.users.ResetPassword(ctx, token, password)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	// sessions created earlier are rejected anyway, revoking them removes them from the list of
+	// sessions of the user as well
+	err = a.sessions.RevokeAll(ctx, u.ID)
+	if err != nil {
+		a.Logger.WithContext(ctx).Error(err.Error())
+	}
+
+	return nil
 }
@@ -0,0 +1,372 @@
+// Package apikeys manages the API keys services authenticate with. A key is shown only once, when
+// it's issued or rotated, and only the hash of its secret is stored. Keys are looked up by their
+// prefix, which is not secret, so that the secret can be verified against a single stored hash.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+const (
+	// StoreMongo stores API keys in MongoDB
+	StoreMongo = "mongo"
+	// StoreMemory stores API keys in memory, it's meant for tests and local development
+	StoreMemory = "memory"
+
+	// ScopeUsersRead allows reading and listing any user
+	ScopeUsersRead = "users:read"
+	// ScopeUsersWrite allows updating and deleting any user
+	ScopeUsersWrite = "users:write"
+
+	// keyPrefix identifies API keys of this app, e.g. for secret scanners
+	keyPrefix = "gak_"
+	// lastUsedInterval is the minimum time between two updates of the last used time of a key, so
+	// that not every request results in a write
+	lastUsedInterval     = time.Minute
+	defaultRotationGrace = time.Hour
+)
+
+var (
+	ErrKeyNotFound = errors.New("api key not found")
+	// ErrInvalidKey is returned for keys which are malformed, unknown, expired or revoked
+	ErrInvalidKey = errors.New("invalid api key")
+	// ErrKeyValidation is returned when the name or scopes of a key are invalid
+	ErrKeyValidation = errors.New("api key validation error")
+)
+
+// knownScopes are all the scopes which can be granted to API keys
+var knownScopes = map[string]struct{}{
+	ScopeUsersRead:  {},
+	ScopeUsersWrite: {},
+}
+
+// Config holds all the configuration required for the apikeys package
+type Config struct {
+	// Store is the datastore of API keys, either 'mongo' or 'memory'
+	Store string `json:"store"`
+	// RotationGraceSecond is how long the secret of a key is still accepted after it's rotated, so
+	// that services can be updated with the new secret without downtime
+	RotationGraceSecond int `json:"rotation_grace_second"`
+}
+
+// Key is an API key of a service. Neither the secret nor its hash are ever returned
+type Key struct {
+	ID   string `json:"id" bson:"_id,omitempty"`
+	Name string `json:"name"`
+	// Prefix identifies the key, it's the part of the key before the secret
+	Prefix string `json:"prefix"`
+	Hash   string `json:"-"`
+	// PreviousHash is the hash of the secret before the last rotation, which is accepted until
+	// PreviousExpiresAt
+	PreviousHash      string     `json:"-"`
+	PreviousExpiresAt *time.Time `json:"previousExpiresAt,omitempty"`
+	Scopes            []string   `json:"scopes"`
+	// CreatedBy is the ID of the user who issued the key
+	CreatedBy  string     `json:"createdBy,omitempty"`
+	CreatedAt  *time.Time `json:"createdAt,omitempty"`
+	RotatedAt  *time.Time `json:"rotatedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// HasScope reports if the key was granted scope
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// active reports if the key can be used at now
+func (k *Key) active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// matches reports if secret is the current secret of the key, or the previous one within the grace
+// period of the last rotation
+func (k *Key) matches(secret string, now time.Time) bool {
+	hash := hashSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(k.Hash)) == 1 {
+		return true
+	}
+	return k.PreviousHash != "" &&
+		k.PreviousExpiresAt != nil &&
+		now.Before(*k.PreviousExpiresAt) &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(k.PreviousHash)) == 1
+}
+
+// hashSecret returns the hash of secret. Secrets are random and long, so unlike passwords they
+// don't need a slow hash
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+	return b
+}
+
+func newSecret() string {
+	return base64.RawURLEncoding.EncodeToString(randomBytes(32))
+}
+
+// format returns the API key as it's given to the service, i.e. 'gak_<prefix>_<secret>'
+func format(prefix, secret string) string {
+	return keyPrefix + prefix + "_" + secret
+}
+
+// parse returns the prefix and the secret of key
+func parse(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return "", "", false
+	}
+	prefix, secret, found := strings.Cut(strings.TrimPrefix(key, keyPrefix), "_")
+	if !found || prefix == "" || secret == "" {
+		return "", "", false
+	}
+	return prefix, secret, true
+}
+
+// normalizeScopes returns the sorted, deduplicated scopes. An error is returned for unknown scopes
+func normalizeScopes(list []string) ([]string, error) {
+	seen := make(map[string]struct{})
+	normalized := make([]string, 0, len(list))
+	for _, scope := range list {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if _, ok := knownScopes[scope]; !ok {
+			return nil, fmt.Errorf("%w: unknown scope '%s'", ErrKeyValidation, scope)
+		}
+		if _, ok := seen[scope]; !ok {
+			seen[scope] = struct{}{}
+			normalized = append(normalized, scope)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrKeyValidation)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// APIKeys issues, rotates, revokes and verifies API keys
+type APIKeys struct {
+	logHandler    logger.Logger
+	store         Store
+	rotationGrace time.Duration
+}
+
+func (ak *APIKeys) log(ctx context.Context) logger.Logger {
+	return ak.logHandler.WithContext(ctx)
+}
+
+// Issue creates a new key for the service name with the given scopes, and returns the key which is
+// to be given to the service. The key expires after ttl, unless ttl is 0
+func (ak *APIKeys) Issue(
+	ctx context.Context,
+	name string,
+	scopes []string,
+	ttl time.Duration,
+	createdBy string,
+) (string, *Key, error) {
+	ctx, span := tracing.Start(ctx, "apikeys.Issue")
+	defer span.End()
+
+	// validation errors are not wrapped, since they're meant to be shown to the caller
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, fmt.Errorf("%w: name is required", ErrKeyValidation)
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	secret := newSecret()
+	k := &Key{
+		Name:      name,
+		Prefix:    hex.EncodeToString(randomBytes(8)),
+		Hash:      hashSecret(secret),
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: &now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		k.ExpiresAt = &expiresAt
+	}
+
+	err = ak.store.Create(ctx, k)
+	if err != nil {
+		ak.log(ctx).Error(err.Error())
+		return "", nil, err
+	}
+	ak.log(ctx).With("event", "api_key_issued", "apiKeyID", k.ID, "scopes", scopes).Info("api key issued")
+
+	return format(k.Prefix, secret), k, nil
+}
+
+// Rotate replaces the secret of the key, and returns the new key. The previous secret is still
+// accepted during the rotation grace period
+func (ak *APIKeys) Rotate(ctx context.Context, id string) (string, *Key, error) {
+	ctx, span := tracing.Start(ctx, "apikeys.Rotate")
+	defer span.End()
+
+	k, err := ak.store.ReadByID(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	if !k.active(now) {
+		return "", nil, fmt.Errorf("rotate: %w", ErrInvalidKey)
+	}
+
+	secret := newSecret()
+	previousExpiresAt := now.Add(ak.rotationGrace)
+	k.PreviousHash = k.Hash
+	k.PreviousExpiresAt = &previousExpiresAt
+	k.Hash = hashSecret(secret)
+	k.RotatedAt = &now
+
+	err = ak.store.Update(ctx, k)
+	if err != nil {
+		ak.log(ctx).Error(err.Error())
+		return "", nil, err
+	}
+	ak.log(ctx).With("event", "api_key_rotated", "apiKeyID", k.ID).Info("api key rotated")
+
+	return format(k.Prefix, secret), k, nil
+}
+
+// Revoke revokes the key right away, including its previous secret
+func (ak *APIKeys) Revoke(ctx context.Context, id string) (*Key, error) {
+	ctx, span := tracing.Start(ctx, "apikeys.Revoke")
+	defer span.End()
+
+	k, err := ak.store.ReadByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if k.RevokedAt != nil {
+		return k, nil
+	}
+
+	now := time.Now()
+	k.RevokedAt = &now
+	err = ak.store.Update(ctx, k)
+	if err != nil {
+		ak.log(ctx).Error(err.Error())
+		return nil, err
+	}
+	ak.log(ctx).With("event", "api_key_revoked", "apiKeyID", k.ID).Warn("api key revoked")
+
+	return k, nil
+}
+
+// List returns all the keys, including the revoked and expired ones
+func (ak *APIKeys) List(ctx context.Context) ([]*Key, error) {
+	ctx, span := tracing.Start(ctx, "apikeys.List")
+	defer span.End()
+
+	return ak.store.List(ctx)
+}
+
+// Authenticate returns the key matching the given API key, and records its use
+func (ak *APIKeys) Authenticate(ctx context.Context, key string) (*Key, error) {
+	ctx, span := tracing.Start(ctx, "apikeys.Authenticate")
+	defer span.End()
+
+	prefix, secret, ok := parse(key)
+	if !ok {
+		return nil, fmt.Errorf("authenticate: %w: malformed", ErrInvalidKey)
+	}
+
+	k, err := ak.store.ReadByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, fmt.Errorf("authenticate: %w", ErrInvalidKey)
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if !k.matches(secret, now) {
+		ak.log(ctx).With("event", "api_key_mismatch", "apiKeyID", k.ID).Warn("api key secret mismatch")
+		return nil, fmt.Errorf("authenticate: %w", ErrInvalidKey)
+	}
+	if !k.active(now) {
+		return nil, fmt.Errorf("authenticate: %w: expired or revoked", ErrInvalidKey)
+	}
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= lastUsedInterval {
+		// failing to record the use does not fail the request
+		err = ak.store.SetLastUsed(ctx, k.ID, now)
+		if err != nil {
+			ak.log(ctx).Error(err.Error())
+		} else {
+			k.LastUsedAt = &now
+		}
+	}
+
+	return k, nil
+}
+
+// NewService returns a new instance of APIKeys. The mongo client is not required when the
+// in-memory store is configured
+func NewService(cfg *Config, l logger.Logger, m *mongo.Client) (*APIKeys, error) {
+	var store Store
+	switch cfg.Store {
+	case StoreMemory:
+		store = NewMemoryStore()
+	case StoreMongo, "":
+		mstore, err := newStore(m)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+		defer cancel()
+		err = mstore.ensureIndexes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		store = mstore
+	default:
+		return nil, fmt.Errorf("apikeys: unknown store '%s'", cfg.Store)
+	}
+
+	grace := time.Duration(cfg.RotationGraceSecond) * time.Second
+	if grace <= 0 {
+		grace = defaultRotationGrace
+	}
+
+	return &APIKeys{
+		logHandler:    l,
+		store:         store,
+		rotationGrace: grace,
+	}, nil
+}
@@ -0,0 +1,105 @@
+package apikeys
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore is a thread-safe, in-memory implementation of Store. It's meant for tests and
+// local development, all the keys are lost when the app stops
+type MemoryStore struct {
+	mu   sync.RWMutex
+	keys map[string]*Key
+}
+
+// copyKey returns a copy of k, so that callers cannot modify the stored keys. Times are never
+// modified in place, so they're shared
+func copyKey(k *Key) *Key {
+	cp := *k
+	cp.Scopes = append([]string(nil), k.Scopes...)
+	return &cp
+}
+
+func (ms *MemoryStore) Create(ctx context.Context, k *Key) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if k.ID == "" {
+		k.ID = primitive.NewObjectID().Hex()
+	}
+	ms.keys[k.ID] = copyKey(k)
+	return nil
+}
+
+func (ms *MemoryStore) ReadByID(ctx context.Context, id string) (*Key, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	k, ok := ms.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("memorystore readByID: %w", ErrKeyNotFound)
+	}
+	return copyKey(k), nil
+}
+
+func (ms *MemoryStore) ReadByPrefix(ctx context.Context, prefix string) (*Key, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, k := range ms.keys {
+		if k.Prefix == prefix {
+			return copyKey(k), nil
+		}
+	}
+	return nil, fmt.Errorf("memorystore readByPrefix: %w", ErrKeyNotFound)
+}
+
+func (ms *MemoryStore) Update(ctx context.Context, k *Key) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.keys[k.ID]; !ok {
+		return fmt.Errorf("memorystore update: %w", ErrKeyNotFound)
+	}
+	ms.keys[k.ID] = copyKey(k)
+	return nil
+}
+
+func (ms *MemoryStore) SetLastUsed(ctx context.Context, id string, at time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	k, ok := ms.keys[id]
+	if !ok {
+		return fmt.Errorf("memorystore setLastUsed: %w", ErrKeyNotFound)
+	}
+	k.LastUsedAt = &at
+	return nil
+}
+
+func (ms *MemoryStore) List(ctx context.Context) ([]*Key, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	list := make([]*Key, 0, len(ms.keys))
+	for _, k := range ms.keys {
+		list = append(list, copyKey(k))
+	}
+	// IDs are object IDs, which are ordered by creation
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// NewMemoryStore returns a new, empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		keys: make(map[string]*Key),
+	}
+}
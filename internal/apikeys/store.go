@@ -0,0 +1,184 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jerryan999/goapp/internal/pkg/datastore"
+)
+
+var (
+	Database         = "goapp"
+	APIKeyCollection = "apikey"
+)
+
+// indexTimeout is the maximum time allowed for creating indexes at startup
+const indexTimeout = time.Second * 30
+
+// Store is the datastore of API keys
+type Store interface {
+	Create(ctx context.Context, k *Key) error
+	ReadByID(ctx context.Context, id string) (*Key, error)
+	ReadByPrefix(ctx context.Context, prefix string) (*Key, error)
+	// Update replaces the key with the ID of k
+	Update(ctx context.Context, k *Key) error
+	// SetLastUsed sets only the last used time of the key
+	SetLastUsed(ctx context.Context, id string, at time.Time) error
+	// List returns all the keys, ordered by creation
+	List(ctx context.Context) ([]*Key, error)
+}
+
+// startOperation starts a span for an operation on the API keys collection, see datastore.StartOperation
+func startOperation(ctx context.Context, operation string) (context.Context, func()) {
+	return datastore.StartOperation(ctx, Database, APIKeyCollection, operation)
+}
+
+type keyStore struct {
+	collection *mongo.Collection
+}
+
+func objectID(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return oid, ErrKeyNotFound
+	}
+	return oid, nil
+}
+
+func (ks *keyStore) Create(ctx context.Context, k *Key) error {
+	ctx, end := startOperation(ctx, "create")
+	defer end()
+
+	result, err := ks.collection.InsertOne(ctx, k)
+	if err != nil {
+		return fmt.Errorf("keystore create: %w", err)
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		k.ID = id.Hex()
+	}
+	return nil
+}
+
+func (ks *keyStore) readOne(ctx context.Context, filter bson.D) (*Key, error) {
+	k := new(Key)
+	err := ks.collection.FindOne(ctx, filter).Decode(k)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return k, nil
+}
+
+func (ks *keyStore) ReadByID(ctx context.Context, id string) (*Key, error) {
+	ctx, end := startOperation(ctx, "readByID")
+	defer end()
+
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, fmt.Errorf("keystore readByID: %w", err)
+	}
+	k, err := ks.readOne(ctx, bson.D{{Key: "_id", Value: oid}})
+	if err != nil {
+		return nil, fmt.Errorf("keystore readByID: %w", err)
+	}
+	return k, nil
+}
+
+func (ks *keyStore) ReadByPrefix(ctx context.Context, prefix string) (*Key, error) {
+	ctx, end := startOperation(ctx, "readByPrefix")
+	defer end()
+
+	k, err := ks.readOne(ctx, bson.D{{Key: "prefix", Value: prefix}})
+	if err != nil {
+		return nil, fmt.Errorf("keystore readByPrefix: %w", err)
+	}
+	return k, nil
+}
+
+func (ks *keyStore) Update(ctx context.Context, k *Key) error {
+	ctx, end := startOperation(ctx, "update")
+	defer end()
+
+	oid, err := objectID(k.ID)
+	if err != nil {
+		return fmt.Errorf("keystore update: %w", err)
+	}
+
+	// _id is immutable, and is stored as an ObjectID rather than a string. So it's left out of the replacement
+	replacement := *k
+	replacement.ID = ""
+	result, err := ks.collection.ReplaceOne(ctx, bson.D{{Key: "_id", Value: oid}}, &replacement)
+	if err != nil {
+		return fmt.Errorf("keystore update: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("keystore update: %w", ErrKeyNotFound)
+	}
+	return nil
+}
+
+func (ks *keyStore) SetLastUsed(ctx context.Context, id string, at time.Time) error {
+	ctx, end := startOperation(ctx, "setLastUsed")
+	defer end()
+
+	oid, err := objectID(id)
+	if err != nil {
+		return fmt.Errorf("keystore setLastUsed: %w", err)
+	}
+	_, err = ks.collection.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: oid}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "lastusedat", Value: at}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("keystore setLastUsed: %w", err)
+	}
+	return nil
+}
+
+func (ks *keyStore) List(ctx context.Context) ([]*Key, error) {
+	ctx, end := startOperation(ctx, "list")
+	defer end()
+
+	cursor, err := ks.collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("keystore list: %w", err)
+	}
+
+	list := make([]*Key, 0)
+	err = cursor.All(ctx, &list)
+	if err != nil {
+		return nil, fmt.Errorf("keystore list: %w", err)
+	}
+	return list, nil
+}
+
+// ensureIndexes creates all the indexes required by keyStore, if they don't exist already
+func (ks *keyStore) ensureIndexes(ctx context.Context) error {
+	_, err := ks.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "prefix", Value: 1}},
+		Options: options.Index().SetName("prefix_unique").SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("keystore ensureIndexes: %w", err)
+	}
+	return nil
+}
+
+func newStore(mongoClient *mongo.Client) (*keyStore, error) {
+	if mongoClient == nil {
+		return nil, errors.New("apikeys: mongo client is required")
+	}
+	return &keyStore{
+		collection: mongoClient.Database(Database).Collection(APIKeyCollection),
+	}, nil
+}
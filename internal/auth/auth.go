@@ -0,0 +1,418 @@
+// Package auth issues and verifies the tokens clients authenticate with, and carries the
+// authenticated principal of a request in its context.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/jwt"
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+)
+
+const (
+	// StoreRedis keeps the revocation list in Redis, so that it's shared by all instances of the app
+	StoreRedis = "redis"
+	// StoreMemory keeps the revocation list in memory, it's meant for tests and local development
+	StoreMemory = "memory"
+
+	tokenUseAccess  = "access"
+	tokenUseRefresh = "refresh"
+
+	// amrPassword and amrOTP are the authentication methods of logins, as registered by RFC 8176
+	amrPassword = "pwd"
+	amrOTP      = "otp"
+
+	defaultAccessTokenTTL  = time.Minute * 15
+	defaultRefreshTokenTTL = time.Hour * 24 * 30
+)
+
+var (
+	// ErrInvalidToken is returned for tokens which are malformed, expired, revoked or otherwise invalid
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrUnauthenticated is returned by APIs which require an authenticated principal
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrForbidden is returned when the authenticated principal is not allowed to perform an action
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Principal is the authenticated identity of a request, either a user or a service
+type Principal struct {
+	// Subject is the ID of the authenticated user, it's empty for services
+	Subject string
+	Email   string
+	// TokenID is the ID of the access token the principal was authenticated with, if any
+	TokenID string
+	// SessionID is the ID of the session the principal was authenticated with, if any
+	SessionID string
+	// APIKeyID is the ID of the API key a service was authenticated with. Services are not users,
+	// they're only granted the Scopes of their key
+	APIKeyID string
+	Scopes   []string
+	// MFA reports if the user logged in with a one-time code, in addition to their password
+	MFA bool
+	// IssuedAt is when the token or session was issued, so that it can be rejected if the user's
+	// credentials changed since
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// IsService reports if p is a service authenticated with an API key, rather than a user
+func (p *Principal) IsService() bool {
+	return p.APIKeyID != ""
+}
+
+// HasScope reports if p was granted scope. Only services have scopes
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalCtxKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx with p as the authenticated principal
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+// PrincipalFromContext returns the authenticated principal of ctx, or nil if it's not authenticated
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalCtxKey{}).(*Principal)
+	return p
+}
+
+// Config holds all the configuration required for this package
+type Config struct {
+	// Store is where the revocation list is kept, either 'redis' or 'memory'
+	Store                 string `json:"store"`
+	Issuer                string `json:"issuer"`
+	Audience              string `json:"audience"`
+	AccessTokenTTLSecond  int    `json:"access_token_ttl_second"`
+	RefreshTokenTTLSecond int    `json:"refresh_token_ttl_second"`
+
+	// Algorithm is one of HS256, RS256 or EdDSA. Key is the secret for HS256, and a PEM encoded
+	// private key otherwise. It's required unless AllowRandomKey is set
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	Key       string `json:"key"`
+	// AllowRandomKey generates a random key if Key is empty. It's only suitable for local development,
+	// since tokens are then rejected by other instances of the app, and invalidated on every restart
+	AllowRandomKey bool `json:"allow_random_key"`
+
+	// PreviousAlgorithm, PreviousKeyID and PreviousKey are the key tokens were signed with before the
+	// last rotation. Tokens signed with it are still accepted until they expire
+	PreviousAlgorithm string `json:"previous_algorithm"`
+	PreviousKeyID     string `json:"previous_key_id"`
+	PreviousKey       string `json:"previous_key"`
+}
+
+// TokenPair is the response of a successful login or refresh
+type TokenPair struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+}
+
+type tokenClaims struct {
+	jwt.Claims
+	Email string `json:"email,omitempty"`
+	Use   string `json:"token_use"`
+	// AMR lists the authentication methods of the login (RFC 8176), 'pwd' and 'otp' if a one-time code
+	// was entered as well
+	AMR []string `json:"amr,omitempty"`
+	// Family is shared by all the refresh tokens rotated from the same login, so that all of them can
+	// be revoked at once
+	Family string `json:"fam,omitempty"`
+}
+
+// hasAMR reports if method is one of the authentication methods of the claims
+func (tc *tokenClaims) hasAMR(method string) bool {
+	for _, m := range tc.AMR {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Tokens issues, verifies and revokes access and refresh tokens. Access tokens are short lived, and
+// are verified without any lookup except for the revocation list. Refresh tokens are single use,
+// every refresh returns a new one. If a refresh token is used twice, it's considered stolen and all
+// the refresh tokens of its family are revoked
+type Tokens struct {
+	logHandler logger.Logger
+	keys       *jwt.KeySet
+	store      Store
+
+	issuer     string
+	audience   string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func newTokenID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func familyRevocationID(family string) string {
+	return "family-" + family
+}
+
+// Issue returns a new pair of tokens for the user, starting a new refresh token family. mfa reports
+// if the user entered a one-time code, which is carried by all the tokens of the family
+func (t *Tokens) Issue(ctx context.Context, subject string, email string, mfa bool) (*TokenPair, error) {
+	amr := []string{amrPassword}
+	if mfa {
+		amr = append(amr, amrOTP)
+	}
+	return t.issue(subject, email, amr, newTokenID())
+}
+
+func (t *Tokens) issue(subject string, email string, amr []string, family string) (*TokenPair, error) {
+	now := time.Now()
+
+	access := tokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    t.issuer,
+			Subject:   subject,
+			Audience:  t.audience,
+			ExpiresAt: now.Add(t.accessTTL).Unix(),
+			IssuedAt:  now.Unix(),
+			ID:        newTokenID(),
+		},
+		Email: email,
+		Use:   tokenUseAccess,
+		AMR:   amr,
+	}
+	accessToken, err := t.keys.Sign(&access)
+	if err != nil {
+		return nil, fmt.Errorf("issue: %w", err)
+	}
+
+	refresh := access
+	refresh.ExpiresAt = now.Add(t.refreshTTL).Unix()
+	refresh.ID = newTokenID()
+	refresh.Use = tokenUseRefresh
+	refresh.Family = family
+	refreshToken, err := t.keys.Sign(&refresh)
+	if err != nil {
+		return nil, fmt.Errorf("issue: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(t.accessTTL / time.Second),
+		RefreshToken:     refreshToken,
+		RefreshExpiresIn: int(t.refreshTTL / time.Second),
+	}, nil
+}
+
+// verify verifies token, which must be of the given use, and that it's not revoked
+func (t *Tokens) verify(ctx context.Context, token string, use string) (*tokenClaims, error) {
+	claims := new(tokenClaims)
+	err := t.keys.Verify(token, claims, t.issuer, t.audience)
+	if err != nil {
+		return nil, fmt.Errorf("verify: %w: %s", ErrInvalidToken, err.Error())
+	}
+	if claims.Use != use || claims.ID == "" || claims.Subject == "" {
+		return nil, fmt.Errorf("verify: %w: not an %s token", ErrInvalidToken, use)
+	}
+
+	revoked, err := t.store.IsRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("verify: %w", err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("verify: %w: revoked", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// Verify returns the principal authenticated by the access token
+func (t *Tokens) Verify(ctx context.Context, accessToken string) (*Principal, error) {
+	claims, err := t.verify(ctx, accessToken, tokenUseAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		TokenID:   claims.ID,
+		MFA:       claims.hasAMR(amrOTP),
+		IssuedAt:  time.Unix(claims.IssuedAt, 0),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// Refresh returns a new pair of tokens in exchange for refreshToken, which can't be used again.
+// expected is called with the subject, email and issue time of the token before issuing new tokens,
+// so that the caller can verify the user still exists and their credentials did not change
+func (t *Tokens) Refresh(
+	ctx context.Context,
+	refreshToken string,
+	expected func(subject string, email string, issuedAt time.Time) error,
+) (*TokenPair, error) {
+	claims, err := t.verify(ctx, refreshToken, tokenUseRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := t.store.IsRevoked(ctx, familyRevocationID(claims.Family))
+	if err != nil {
+		return nil, fmt.Errorf("refresh: %w", err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("refresh: %w: revoked", ErrInvalidToken)
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	reused, err := t.store.MarkUsed(ctx, claims.ID, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("refresh: %w", err)
+	}
+	if reused {
+		// either the client or an attacker holds a copy of a rotated token. There's no telling which,
+		// so the whole family is revoked and the user has to log in again
+		err = t.store.Revoke(ctx, familyRevocationID(claims.Family), time.Now().Add(t.refreshTTL))
+		if err != nil {
+			return nil, fmt.Errorf("refresh: %w", err)
+		}
+		t.logHandler.WithContext(ctx).With(
+			"event", "refresh_token_reuse",
+			"subject", claims.Subject,
+			"tokenFamily", claims.Family,
+		).Warn("refresh token reused, revoked all the refresh tokens of its family")
+		return nil, fmt.Errorf("refresh: %w: already used", ErrInvalidToken)
+	}
+
+	err = expected(claims.Subject, claims.Email, time.Unix(claims.IssuedAt, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	return t.issue(claims.Subject, claims.Email, claims.AMR, claims.Family)
+}
+
+// Revoke revokes the access token of p, and the family of refreshToken if it's a valid refresh
+// token of the same user
+func (t *Tokens) Revoke(ctx context.Context, p *Principal, refreshToken string) error {
+	if p != nil && p.TokenID != "" {
+		err := t.store.Revoke(ctx, p.TokenID, p.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("revoke: %w", err)
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	claims, err := t.verify(ctx, refreshToken, tokenUseRefresh)
+	if err != nil {
+		return err
+	}
+	if p != nil && p.Subject != claims.Subject {
+		return fmt.Errorf("revoke: %w: issued to another user", ErrInvalidToken)
+	}
+
+	err = t.store.Revoke(ctx, familyRevocationID(claims.Family), time.Now().Add(t.refreshTTL))
+	if err != nil {
+		return fmt.Errorf("revoke: %w", err)
+	}
+	return nil
+}
+
+// JWKS returns the public keys which tokens are verified with
+func (t *Tokens) JWKS() jwt.JWKS {
+	return t.keys.JWKS()
+}
+
+// keySet returns the signing and previous keys configured in cfg
+func keySet(cfg *Config, l logger.Logger) (*jwt.KeySet, error) {
+	keyID := cfg.KeyID
+	if keyID == "" {
+		keyID = "default"
+	}
+
+	var (
+		signing *jwt.Key
+		err     error
+	)
+	switch {
+	case cfg.Key == "" && !cfg.AllowRandomKey:
+		return nil, errors.New("auth: a signing key is required, random keys are only allowed for local development")
+	case cfg.Key == "":
+		l.Warn("auth: no signing key is configured, using a random key which is lost on restart")
+		signing, err = jwt.GenerateKey(keyID)
+	default:
+		signing, err = jwt.ParseKey(keyID, cfg.Algorithm, []byte(cfg.Key))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+
+	others := make([]*jwt.Key, 0, 1)
+	if cfg.PreviousKey != "" {
+		previous, err := jwt.ParseKey(cfg.PreviousKeyID, cfg.PreviousAlgorithm, []byte(cfg.PreviousKey))
+		if err != nil {
+			return nil, fmt.Errorf("auth: previous key: %w", err)
+		}
+		others = append(others, previous)
+	}
+
+	return jwt.NewKeySet(signing, others...)
+}
+
+// NewService returns a new instance of Tokens. cache is only required if the store is 'redis'
+func NewService(cfg *Config, l logger.Logger, cache *cachestore.Handle) (*Tokens, error) {
+	keys, err := keySet(cfg, l)
+	if err != nil {
+		return nil, err
+	}
+
+	var store Store
+	switch cfg.Store {
+	case StoreMemory:
+		store = NewMemoryStore()
+	case StoreRedis, "":
+		store = newRedisStore(cache)
+	default:
+		return nil, fmt.Errorf("auth: unknown store '%s'", cfg.Store)
+	}
+
+	accessTTL := time.Duration(cfg.AccessTokenTTLSecond) * time.Second
+	if accessTTL <= 0 {
+		accessTTL = defaultAccessTokenTTL
+	}
+	refreshTTL := time.Duration(cfg.RefreshTokenTTLSecond) * time.Second
+	if refreshTTL <= 0 {
+		refreshTTL = defaultRefreshTokenTTL
+	}
+
+	return &Tokens{
+		logHandler: l,
+		keys:       keys,
+		store:      store,
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}, nil
+}
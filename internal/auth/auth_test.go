@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+)
+
+const testSecret = "a secret of at least thirty-two bytes"
+
+func testLogger() *logger.LogHandler {
+	l := logger.New("goapp", "test", 1)
+	l.SetOutput(io.Discard)
+	return l
+}
+
+func newTestTokens(t *testing.T) *Tokens {
+	t.Helper()
+
+	tokens, err := NewService(&Config{
+		Store:     StoreMemory,
+		Issuer:    "goapp",
+		Audience:  "goapp-api",
+		Algorithm: "HS256",
+		Key:       testSecret,
+	}, testLogger(), nil)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return tokens
+}
+
+func TestNewServiceSigningKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{name: "key", cfg: Config{Algorithm: "HS256", Key: testSecret}},
+		{name: "no key", cfg: Config{}, wantErr: "a signing key is required"},
+		{name: "random key allowed", cfg: Config{AllowRandomKey: true}},
+		{name: "invalid key", cfg: Config{Algorithm: "HS256", Key: "short"}, wantErr: "secret must be at least"},
+		{
+			name: "previous key",
+			cfg: Config{
+				Algorithm:         "HS256",
+				KeyID:             "2024",
+				Key:               testSecret,
+				PreviousAlgorithm: "HS256",
+				PreviousKeyID:     "2023",
+				PreviousKey:       testSecret + " before",
+			},
+		},
+		{
+			name:    "invalid previous key",
+			cfg:     Config{Algorithm: "HS256", Key: testSecret, PreviousAlgorithm: "HS256", PreviousKey: "short"},
+			wantErr: "previous key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Store = StoreMemory
+			_, err := NewService(&cfg, testLogger(), nil)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewService error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewService error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIssueVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		mfa  bool
+	}{
+		{name: "password", mfa: false},
+		{name: "password and one-time code", mfa: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tokens := newTestTokens(t)
+
+			pair, err := tokens.Issue(ctx, "user-1", "jane@example.com", tt.mfa)
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+
+			p, err := tokens.Verify(ctx, pair.AccessToken)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if p.Subject != "user-1" || p.Email != "jane@example.com" || p.MFA != tt.mfa || p.TokenID == "" {
+				t.Errorf("Verify = %+v, want the subject, email and MFA of the login", p)
+			}
+			if until := time.Until(p.ExpiresAt); until <= 0 || until > defaultAccessTokenTTL {
+				t.Errorf("ExpiresAt in %s, want within %s", until, defaultAccessTokenTTL)
+			}
+
+			// refresh tokens are not accepted as access tokens
+			_, err = tokens.Verify(ctx, pair.RefreshToken)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify of a refresh token error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestVerifyOtherIssuer(t *testing.T) {
+	ctx := context.Background()
+	other, err := NewService(&Config{
+		Store:     StoreMemory,
+		Issuer:    "other",
+		Audience:  "goapp-api",
+		Algorithm: "HS256",
+		Key:       testSecret,
+	}, testLogger(), nil)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	pair, err := other.Issue(ctx, "user-1", "jane@example.com", false)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	_, err = newTestTokens(t).Verify(ctx, pair.AccessToken)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	tokens := newTestTokens(t)
+	expected := func(subject string, email string, issuedAt time.Time) error { return nil }
+
+	first, err := tokens.Issue(ctx, "user-1", "jane@example.com", true)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	second, err := tokens.Refresh(ctx, first.RefreshToken, expected)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	p, err := tokens.Verify(ctx, second.AccessToken)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if p.Subject != "user-1" || !p.MFA {
+		t.Errorf("Verify = %+v, want the subject and MFA of the login", p)
+	}
+
+	// reusing a rotated token revokes the whole family, including the token it was rotated to
+	_, err = tokens.Refresh(ctx, first.RefreshToken, expected)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Refresh with a rotated token error = %v, want %v", err, ErrInvalidToken)
+	}
+	_, err = tokens.Refresh(ctx, second.RefreshToken, expected)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Refresh after a reuse error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestRefreshUnexpected(t *testing.T) {
+	ctx := context.Background()
+	tokens := newTestTokens(t)
+	errChanged := errors.New("credentials changed")
+
+	pair, err := tokens.Issue(ctx, "user-1", "jane@example.com", false)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	_, err = tokens.Refresh(ctx, pair.RefreshToken, func(subject string, email string, issuedAt time.Time) error {
+		if subject != "user-1" || email != "jane@example.com" {
+			t.Errorf("expected called with %s, %s", subject, email)
+		}
+		return errChanged
+	})
+	if !errors.Is(err, errChanged) {
+		t.Errorf("Refresh error = %v, want %v", err, errChanged)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	ctx := context.Background()
+	tokens := newTestTokens(t)
+
+	pair, err := tokens.Issue(ctx, "user-1", "jane@example.com", false)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	p, err := tokens.Verify(ctx, pair.AccessToken)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// the refresh token of another user is left alone
+	other, err := tokens.Issue(ctx, "user-2", "john@example.com", false)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	err = tokens.Revoke(ctx, p, other.RefreshToken)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Revoke with the token of another user error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	err = tokens.Revoke(ctx, p, pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	_, err = tokens.Verify(ctx, pair.AccessToken)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify after Revoke error = %v, want %v", err, ErrInvalidToken)
+	}
+	_, err = tokens.Refresh(ctx, pair.RefreshToken, func(string, string, time.Time) error { return nil })
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Refresh after Revoke error = %v, want %v", err, ErrInvalidToken)
+	}
+}
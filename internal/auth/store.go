@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+// Store is the revocation list of tokens. Entries are kept only until the respective token expires,
+// since expired tokens are rejected anyway
+type Store interface {
+	// Revoke adds id to the revocation list until expiresAt
+	Revoke(ctx context.Context, id string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, id string) (bool, error)
+	// MarkUsed marks the single use token id as used until expiresAt, and reports if it was already used
+	MarkUsed(ctx context.Context, id string, expiresAt time.Time) (bool, error)
+}
+
+// ttlSeconds returns the seconds until expiresAt, at least 1 since Redis rejects non-positive expiries
+func ttlSeconds(expiresAt time.Time) int64 {
+	ttl := int64(time.Until(expiresAt) / time.Second)
+	if ttl < 1 {
+		return 1
+	}
+	return ttl
+}
+
+type redisStore struct {
+	cache *cachestore.Handle
+}
+
+func (rs *redisStore) conn(ctx context.Context) (redis.Conn, error) {
+	pool := rs.cache.Pool()
+	if pool == nil {
+		return nil, cachestore.ErrCacheNotInitialized
+	}
+	return pool.GetContext(ctx)
+}
+
+func revokedKey(id string) string {
+	return fmt.Sprintf("auth-revoked-%s", id)
+}
+
+func usedKey(id string) string {
+	return fmt.Sprintf("auth-used-%s", id)
+}
+
+func (rs *redisStore) Revoke(ctx context.Context, id string, expiresAt time.Time) error {
+	ctx, span := tracing.StartClient(ctx, "redis SET", "db.system", "redis", "db.operation", "SET")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return fmt.Errorf("revoke: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SET", revokedKey(id), 1, "EX", ttlSeconds(expiresAt))
+	if err != nil {
+		return fmt.Errorf("revoke: %w", err)
+	}
+	return nil
+}
+
+func (rs *redisStore) IsRevoked(ctx context.Context, id string) (bool, error) {
+	ctx, span := tracing.StartClient(ctx, "redis EXISTS", "db.system", "redis", "db.operation", "EXISTS")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("isRevoked: %w", err)
+	}
+	defer conn.Close()
+
+	revoked, err := redis.Bool(conn.Do("EXISTS", revokedKey(id)))
+	if err != nil {
+		return false, fmt.Errorf("isRevoked: %w", err)
+	}
+	return revoked, nil
+}
+
+func (rs *redisStore) MarkUsed(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	ctx, span := tracing.StartClient(ctx, "redis SET", "db.system", "redis", "db.operation", "SET")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("markUsed: %w", err)
+	}
+	defer conn.Close()
+
+	// SET NX is atomic, so a token can be used only once even with concurrent requests
+	reply, err := conn.Do("SET", usedKey(id), 1, "NX", "EX", ttlSeconds(expiresAt))
+	if err != nil {
+		return false, fmt.Errorf("markUsed: %w", err)
+	}
+	return reply == nil, nil
+}
+
+func newRedisStore(cache *cachestore.Handle) *redisStore {
+	if cache == nil {
+		cache = cachestore.NewHandle(nil)
+	}
+	return &redisStore{cache: cache}
+}
+
+// MemoryStore is a thread-safe, in-memory implementation of Store. It's meant for tests and local
+// development, since the revocation list is not shared with other instances of the app
+type MemoryStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	used    map[string]time.Time
+}
+
+// sweep removes all the expired entries of m. It must be called with the lock held
+func sweep(m map[string]time.Time, now time.Time) {
+	for id, expiresAt := range m {
+		if !now.Before(expiresAt) {
+			delete(m, id)
+		}
+	}
+}
+
+func (ms *MemoryStore) Revoke(ctx context.Context, id string, expiresAt time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	sweep(ms.revoked, time.Now())
+	ms.revoked[id] = expiresAt
+	return nil
+}
+
+func (ms *MemoryStore) IsRevoked(ctx context.Context, id string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	expiresAt, ok := ms.revoked[id]
+	return ok && time.Now().Before(expiresAt), nil
+}
+
+func (ms *MemoryStore) MarkUsed(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	sweep(ms.used, now)
+	if _, ok := ms.used[id]; ok {
+		return true, nil
+	}
+	ms.used[id] = expiresAt
+	return false, nil
+}
+
+// NewMemoryStore returns a new, empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		revoked: make(map[string]time.Time),
+		used:    make(map[string]time.Time),
+	}
+}
@@ -1,78 +1,479 @@
+// Package configs reads the configuration of all the packages of the app. Every key can be set using
+// an environment variable, e.g. HTTP_PORT, or in a configuration file where the key is nested under
+// its section, e.g. 'http: {port: 8080}'. Environment variables take precedence over the file, and
+// secrets can be read from a file by setting the variable with a '_FILE' suffix, e.g.
+// DATASTORE_PASSWORD_FILE=/run/secrets/datastore_password.
 package configs
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/jerryan999/goapp/internal/apikeys"
+	"github.com/jerryan999/goapp/internal/auth"
 	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/contact"
 	"github.com/jerryan999/goapp/internal/pkg/datastore"
+	"github.com/jerryan999/goapp/internal/pkg/jwt"
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/pkg/mailer"
+	"github.com/jerryan999/goapp/internal/pkg/password"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
 	"github.com/jerryan999/goapp/internal/server/http"
+	"github.com/jerryan999/goapp/internal/sessions"
+	"github.com/jerryan999/goapp/internal/users"
 )
 
 // AppConfigs struct handles all dependencies required for handling configurations
 type AppConfigs struct {
+	// path is the configuration file, it's empty if the app is configured only using environment variables
+	path string
+	// file holds all the values of the configuration file, keyed by the name of the respective
+	// environment variable
+	file map[string]string
+
+	mu sync.Mutex
+	// known holds all the keys read by the getters, so that unknown keys in the file can be reported
+	known map[string]struct{}
+}
+
+// defaultRateLimits protects the endpoints checking credentials or sending emails with tighter
+// limits per client IP, and limits every other request per principal
+var defaultRateLimits = []string{
+	"POST /auth/login ip 20/60",
+	"POST /auth/login/mfa ip 20/60",
+	"POST /sessions ip 20/60",
+	"POST /sessions/mfa ip 20/60",
+	"POST /users/login ip 20/60",
+	"POST /users/login/mfa ip 20/60",
+	"POST /users/create ip 10/3600",
+	"POST /users/password/forgot ip 10/60",
+	"* * principal 600/60",
+}
+
+// defaultDisposableEmailDomains are well known providers of throwaway email addresses
+var defaultDisposableEmailDomains = []string{
+	"10minutemail.com",
+	"guerrillamail.com",
+	"mailinator.com",
+	"temp-mail.org",
+	"trashmail.com",
+	"yopmail.com",
 }
 
 // HTTP returns the configuration required for HTTP package
 func (cfg *AppConfigs) HTTP() (*http.Config, error) {
+	r := cfg.reader()
 	var httpConfig http.Config = http.Config{
-		Port:               GetInt(os.Getenv("HTTP_PORT"), 8080),
-		ReadTimeoutSecond:  GetInt(os.Getenv("HTTP_READ_TIMEOUT_SECOND"), 30),
-		WriteTimeoutSecond: GetInt(os.Getenv("HTTP_WRITE_TIMEOUT_SECOND"), 30),
-		DialTimeoutSecond:  GetInt(os.Getenv("HTTP_DIAL_TIMEOUT_SECOND"), 30),
+		Port:                r.port("HTTP_PORT", 8080),
+		ReadTimeoutSecond:   r.int("HTTP_READ_TIMEOUT_SECOND", 30, 1),
+		WriteTimeoutSecond:  r.int("HTTP_WRITE_TIMEOUT_SECOND", 30, 1),
+		DialTimeoutSecond:   r.int("HTTP_DIAL_TIMEOUT_SECOND", 30, 1),
+		ShutdownGraceSecond: r.int("HTTP_SHUTDOWN_GRACE_SECOND", 20, 0),
+		MaxBodyBytes:        int64(r.int("HTTP_MAX_BODY_BYTES", 1<<20, 0)),
+		RateLimitStore: r.oneOf(
+			"HTTP_RATE_LIMIT_STORE",
+			http.RateLimitStoreRedis,
+			http.RateLimitStoreRedis, http.RateLimitStoreMemory, http.RateLimitStoreNone,
+		),
+		RateLimits:     r.list("HTTP_RATE_LIMITS", defaultRateLimits),
+		TrustedProxies: r.list("HTTP_TRUSTED_PROXIES", nil),
+	}
+	for _, policy := range httpConfig.RateLimits {
+		_, err := http.ParseRateLimit(policy)
+		if err != nil {
+			r.fail("HTTP_RATE_LIMITS", policy, err.Error())
+		}
+	}
+	for _, proxy := range httpConfig.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		if err != nil && net.ParseIP(proxy) == nil {
+			r.fail("HTTP_TRUSTED_PROXIES", proxy, "is neither an IP nor a CIDR")
+		}
 	}
 
-	return &httpConfig, nil
+	return &httpConfig, r.err()
 }
 
 // Datastore returns datastore configuration
 func (cfg *AppConfigs) Datastore() (*datastore.Config, error) {
+	r := cfg.reader()
 	var dsConfig datastore.Config = datastore.Config{
-		Host:         getStr(os.Getenv("DATASTORE_HOST"), "localhost"),
-		Port:         GetInt(os.Getenv("DATASTORE_PORT"), 27017),
-		Username:     getStr(os.Getenv("DATASTORE_USER"), ""),
-		Password:     getStr(os.Getenv("DATASTORE_PASSWORD"), ""),
-		ConnPoolSize: GetInt(os.Getenv("DATASTORE_CONN_POOL_SIZE"), 10),
-		DialTimeout:  GetInt(os.Getenv("DATASTORE_DIAL_TIMEOUT"), 10),
+		Host:         r.str("DATASTORE_HOST", "localhost"),
+		Port:         r.port("DATASTORE_PORT", 27017),
+		Username:     r.str("DATASTORE_USER", ""),
+		Password:     r.str("DATASTORE_PASSWORD", ""),
+		ConnPoolSize: r.int("DATASTORE_CONN_POOL_SIZE", 10, 1),
+		DialTimeout:  r.int("DATASTORE_DIAL_TIMEOUT", 10, 1),
 	}
-	return &dsConfig, nil
+	return &dsConfig, r.err()
 }
 
 // Cachestore returns the configuration required for cache
 func (cfg *AppConfigs) Cachestore() (*cachestore.Config, error) {
+	r := cfg.reader()
 	var cacheConfig cachestore.Config = cachestore.Config{
-		Host: getStr(os.Getenv("CACHE_HOST"), "127.0.0.1"),
-		Port: GetInt(os.Getenv("CACHE_PORT"), 6379),
+		Host: r.str("CACHE_HOST", "127.0.0.1"),
+		Port: r.port("CACHE_PORT", 6379),
+
+		// the store name is the index of the Redis database
+		StoreName: strconv.Itoa(r.int("CACHE_STORE_NAME", 0, 0)),
+		Username:  r.str("CACHE_USER", ""),
+		Password:  r.str("CACHE_PASSWORD", ""),
 
-		StoreName: getStr(os.Getenv("CACHE_STORE_NAME"), "0"),
-		Username:  getStr(os.Getenv("CACHE_USER"), ""),
-		Password:  getStr(os.Getenv("CACHE_PASSWORD"), ""),
+		PoolSize:     r.int("CACHE_POOL_SIZE", 10, 1),
+		DialTimeout:  r.int("CACHE_DIAL_TIMEOUT", 5, 0),
+		ReadTimeout:  r.int("CACHE_READ_TIMEOUT", 5, 0),
+		WriteTimeout: r.int("CACHE_WRITE_TIMEOUT", 5, 0),
+		IdleTimeout:  r.int("CACHE_IDLE_TIMEOUT", 5, 0),
 
-		PoolSize:     GetInt(os.Getenv("CACHE_POOL_SIZE"), 10),
-		DialTimeout:  GetInt(os.Getenv("CACHE_DIAL_TIMEOUT"), 5),
-		ReadTimeout:  GetInt(os.Getenv("CACHE_READ_TIMEOUT"), 5),
-		WriteTimeout: GetInt(os.Getenv("CACHE_WRITE_TIMEOUT"), 5),
-		IdleTimeout:  GetInt(os.Getenv("CACHE_IDLE_TIMEOUT"), 5),
+		Optional:               r.bool("CACHE_OPTIONAL", false),
+		RetryMaxIntervalSecond: r.int("CACHE_RETRY_MAX_INTERVAL_SECOND", 60, 1),
 	}
-	return &cacheConfig, nil
+	return &cacheConfig, r.err()
 }
 
-func NewService() (*AppConfigs, error) {
-	cfg := AppConfigs{}
-	return &cfg, nil
+// Users returns the configuration required for the users package
+func (cfg *AppConfigs) Users() (*users.Config, error) {
+	r := cfg.reader()
+	// the client IP is the address of the proxy unless it's trusted, and all the clients behind it
+	// would be locked out together, so client IPs are locked out by default only behind trusted proxies
+	maxFailuresPerIP := 0
+	if len(r.list("HTTP_TRUSTED_PROXIES", nil)) > 0 {
+		maxFailuresPerIP = 100
+	}
+
+	var usersConfig users.Config = users.Config{
+		Store:          r.oneOf("USERS_STORE", users.StoreMongo, users.StoreMongo, users.StoreMemory),
+		Cache:          r.oneOf("USERS_CACHE", users.CacheRedis, users.CacheRedis, users.CacheMemory),
+		CacheTTLSecond: r.int("USERS_CACHE_TTL_SECOND", 60*60, 1),
+
+		BootstrapAdminEmail: r.str("USERS_BOOTSTRAP_ADMIN_EMAIL", ""),
+
+		TokenStore:            r.oneOf("USERS_TOKEN_STORE", users.TokenStoreRedis, users.TokenStoreRedis, users.TokenStoreMemory),
+		VerificationTTLSecond: r.int("USERS_VERIFICATION_TTL_SECOND", 24*60*60, 60),
+		VerificationURL:       r.str("USERS_VERIFICATION_URL", ""),
+
+		ResetTTLSecond:         r.int("USERS_RESET_TTL_SECOND", 60*60, 60),
+		ResetURL:               r.str("USERS_RESET_URL", ""),
+		ResetLimitPerEmail:     r.int("USERS_RESET_LIMIT_PER_EMAIL", 3, 1),
+		ResetLimitPerIP:        r.int("USERS_RESET_LIMIT_PER_IP", 20, 1),
+		ResetLimitWindowSecond: r.int("USERS_RESET_LIMIT_WINDOW_SECOND", 60*60, 1),
+
+		MFAIssuer:            r.str("USERS_MFA_ISSUER", "goapp"),
+		MFARequiredForAdmins: r.bool("USERS_MFA_REQUIRED_FOR_ADMINS", true),
+
+		LockoutMaxFailuresPerAccount: r.int("USERS_LOCKOUT_MAX_FAILURES_PER_ACCOUNT", 10, 1),
+		LockoutMaxFailuresPerIP:      r.int("USERS_LOCKOUT_MAX_FAILURES_PER_IP", maxFailuresPerIP, 0),
+		LockoutWindowSecond:          r.int("USERS_LOCKOUT_WINDOW_SECOND", 15*60, 1),
+		LockoutDurationSecond:        r.int("USERS_LOCKOUT_DURATION_SECOND", 15*60, 1),
+		LockoutDelayAfter:            r.int("USERS_LOCKOUT_DELAY_AFTER", 3, 0),
+		LockoutMaxDelaySecond:        r.int("USERS_LOCKOUT_MAX_DELAY_SECOND", 30, 1),
+
+		MobileDefaultRegion:    strings.ToUpper(r.str("USERS_MOBILE_DEFAULT_REGION", "US")),
+		BlockDisposableEmails:  r.bool("USERS_BLOCK_DISPOSABLE_EMAILS", true),
+		DisposableEmailDomains: r.list("USERS_DISPOSABLE_EMAIL_DOMAINS", defaultDisposableEmailDomains),
+	}
+	if !contact.ValidRegion(usersConfig.MobileDefaultRegion) {
+		r.fail("USERS_MOBILE_DEFAULT_REGION", usersConfig.MobileDefaultRegion, "is not a supported ISO 3166-1 alpha-2 region")
+	}
+	for _, domain := range usersConfig.DisposableEmailDomains {
+		_, err := contact.NormalizeDomain(domain)
+		if err != nil {
+			r.fail("USERS_DISPOSABLE_EMAIL_DOMAINS", domain, "is not a valid domain")
+		}
+	}
+	return &usersConfig, r.err()
+}
+
+// Logger returns the configuration required for the logger
+func (cfg *AppConfigs) Logger() (*logger.Config, error) {
+	r := cfg.reader()
+	var logConfig logger.Config = logger.Config{
+		Level: r.oneOf(
+			"LOG_LEVEL",
+			logger.LogTypeInfo,
+			logger.LogTypeDebug, logger.LogTypeInfo, logger.LogTypeWarn, logger.LogTypeError, logger.LogTypeFatal,
+		),
+		Outputs: r.list("LOG_OUTPUTS", []string{"stdout"}),
+	}
+	return &logConfig, r.err()
+}
+
+// Tracing returns the configuration required for tracing
+func (cfg *AppConfigs) Tracing() (*tracing.Config, error) {
+	r := cfg.reader()
+	var tracingConfig tracing.Config = tracing.Config{
+		ServiceName:  r.str("TRACING_SERVICE_NAME", "goapp"),
+		Exporter:     r.oneOf("TRACING_EXPORTER", "none", "none", "stdout", "file", "otlp"),
+		FilePath:     r.str("TRACING_FILE_PATH", "traces.jsonl"),
+		OTLPEndpoint: r.str("TRACING_OTLP_ENDPOINT", "http://localhost:4318/v1/traces"),
+		OTLPHeaders:  r.str("TRACING_OTLP_HEADERS", ""),
+		SampleRatio:  r.float("TRACING_SAMPLE_RATIO", 1, 0, 1),
+	}
+	return &tracingConfig, r.err()
+}
+
+// Password returns the configuration required for hashing passwords
+func (cfg *AppConfigs) Password() (*password.Config, error) {
+	r := cfg.reader()
+	var passwordConfig password.Config = password.Config{
+		Algorithm:         r.oneOf("PASSWORD_ALGORITHM", password.AlgorithmArgon2id, password.AlgorithmArgon2id, password.AlgorithmBcrypt),
+		Argon2MemoryKiB:   r.int("PASSWORD_ARGON2_MEMORY_KIB", 64*1024, 8*1024),
+		Argon2Iterations:  r.int("PASSWORD_ARGON2_ITERATIONS", 3, 1),
+		Argon2Parallelism: r.int("PASSWORD_ARGON2_PARALLELISM", 2, 1),
+		BcryptCost:        r.int("PASSWORD_BCRYPT_COST", 12, 10),
+		MinLength:         r.int("PASSWORD_MIN_LENGTH", 10, 8),
+		MaxLength:         r.int("PASSWORD_MAX_LENGTH", 128, 8),
+		MaxConcurrent:     r.int("PASSWORD_MAX_CONCURRENT", 1, 1),
+	}
+	return &passwordConfig, r.err()
+}
+
+// Auth returns the configuration required for issuing and verifying tokens
+func (cfg *AppConfigs) Auth() (*auth.Config, error) {
+	r := cfg.reader()
+	algorithms := []string{jwt.AlgHS256, jwt.AlgRS256, jwt.AlgEdDSA}
+	var authConfig auth.Config = auth.Config{
+		Store:                 r.oneOf("AUTH_STORE", auth.StoreRedis, auth.StoreRedis, auth.StoreMemory),
+		Issuer:                r.str("AUTH_ISSUER", "goapp"),
+		Audience:              r.str("AUTH_AUDIENCE", "goapp"),
+		AccessTokenTTLSecond:  r.int("AUTH_ACCESS_TOKEN_TTL_SECOND", 15*60, 1),
+		RefreshTokenTTLSecond: r.int("AUTH_REFRESH_TOKEN_TTL_SECOND", 30*24*60*60, 1),
+
+		Algorithm: r.oneOf("AUTH_JWT_ALGORITHM", jwt.AlgEdDSA, algorithms...),
+		KeyID:     r.str("AUTH_JWT_KEY_ID", "default"),
+		Key:       r.str("AUTH_JWT_KEY", ""),
+		// random keys are only allowed when asked for explicitly, since they break multiple replicas
+		AllowRandomKey: r.bool("AUTH_JWT_ALLOW_RANDOM_KEY", false),
+
+		PreviousAlgorithm: r.oneOf("AUTH_JWT_PREVIOUS_ALGORITHM", jwt.AlgEdDSA, algorithms...),
+		PreviousKeyID:     r.str("AUTH_JWT_PREVIOUS_KEY_ID", ""),
+		PreviousKey:       r.str("AUTH_JWT_PREVIOUS_KEY", ""),
+	}
+	return &authConfig, r.err()
+}
+
+// Sessions returns the configuration of the server-side sessions of browser clients
+func (cfg *AppConfigs) Sessions() (*sessions.Config, error) {
+	r := cfg.reader()
+	var sessionsConfig sessions.Config = sessions.Config{
+		Store:                 r.oneOf("SESSIONS_STORE", sessions.StoreRedis, sessions.StoreRedis, sessions.StoreMemory),
+		CookieName:            r.str("SESSIONS_COOKIE_NAME", "goapp_session"),
+		CookieDomain:          r.str("SESSIONS_COOKIE_DOMAIN", ""),
+		CookieSecure:          r.bool("SESSIONS_COOKIE_SECURE", true),
+		CookieSameSite:        r.oneOf("SESSIONS_COOKIE_SAME_SITE", "lax", "strict", "lax", "none"),
+		IdleTimeoutSecond:     r.int("SESSIONS_IDLE_TIMEOUT_SECOND", 30*60, 1),
+		AbsoluteTimeoutSecond: r.int("SESSIONS_ABSOLUTE_TIMEOUT_SECOND", 24*60*60, 1),
+	}
+	return &sessionsConfig, r.err()
+}
+
+// APIKeys returns the configuration of the API keys of services
+func (cfg *AppConfigs) APIKeys() (*apikeys.Config, error) {
+	r := cfg.reader()
+	var apiKeysConfig apikeys.Config = apikeys.Config{
+		Store:               r.oneOf("APIKEYS_STORE", apikeys.StoreMongo, apikeys.StoreMongo, apikeys.StoreMemory),
+		RotationGraceSecond: r.int("APIKEYS_ROTATION_GRACE_SECOND", 60*60, 1),
+	}
+	return &apiKeysConfig, r.err()
 }
 
-func GetInt(name string, fallback int) int {
-	i, err := strconv.ParseInt(name, 10, 0)
-	if nil != err {
-		return fallback
+// Mailer returns the configuration required for sending emails
+func (cfg *AppConfigs) Mailer() (*mailer.Config, error) {
+	r := cfg.reader()
+	var mailerConfig mailer.Config = mailer.Config{
+		Driver: r.oneOf("MAILER_DRIVER", mailer.DriverStdout, mailer.DriverSMTP, mailer.DriverFile, mailer.DriverStdout),
+		From:   r.str("MAILER_FROM", "goapp <no-reply@localhost>"),
+
+		SMTPHost:          r.str("MAILER_SMTP_HOST", "localhost"),
+		SMTPPort:          r.int("MAILER_SMTP_PORT", 587, 1),
+		SMTPUser:          r.str("MAILER_SMTP_USER", ""),
+		SMTPPassword:      r.str("MAILER_SMTP_PASSWORD", ""),
+		SMTPTLS:           r.oneOf("MAILER_SMTP_TLS", mailer.TLSStartTLS, mailer.TLSStartTLS, mailer.TLSImplicit, mailer.TLSNone),
+		SMTPTimeoutSecond: r.int("MAILER_SMTP_TIMEOUT_SECOND", 10, 1),
+
+		FilePath: r.str("MAILER_FILE_PATH", "mail.log"),
+	}
+	return &mailerConfig, r.err()
+}
+
+// Environment returns the name of the environment the app is running in, e.g. production
+func (cfg *AppConfigs) Environment() string {
+	r := cfg.reader()
+	return r.str("APP_ENV", "development")
+}
+
+// sections returns the configuration of every package, keyed by the name of its section in the
+// configuration file. The error lists every invalid key of all the sections
+func (cfg *AppConfigs) sections() (map[string]interface{}, error) {
+	errs := Errors{}
+	collect := func(err error) {
+		if kerrs, ok := err.(Errors); ok {
+			errs = append(errs, kerrs...)
+		}
+	}
+
+	httpCfg, err := cfg.HTTP()
+	collect(err)
+	dsCfg, err := cfg.Datastore()
+	collect(err)
+	cacheCfg, err := cfg.Cachestore()
+	collect(err)
+	usersCfg, err := cfg.Users()
+	collect(err)
+	logCfg, err := cfg.Logger()
+	collect(err)
+	tracingCfg, err := cfg.Tracing()
+	collect(err)
+	passwordCfg, err := cfg.Password()
+	collect(err)
+	authCfg, err := cfg.Auth()
+	collect(err)
+	sessionsCfg, err := cfg.Sessions()
+	collect(err)
+	apiKeysCfg, err := cfg.APIKeys()
+	collect(err)
+	mailerCfg, err := cfg.Mailer()
+	collect(err)
+
+	sections := map[string]interface{}{
+		"app":       map[string]string{"env": cfg.Environment()},
+		"http":      httpCfg,
+		"datastore": dsCfg,
+		"cache":     cacheCfg,
+		"users":     usersCfg,
+		"log":       logCfg,
+		"tracing":   tracingCfg,
+		"password":  passwordCfg,
+		"auth":      authCfg,
+		"sessions":  sessionsCfg,
+		"apikeys":   apiKeysCfg,
+		"mailer":    mailerCfg,
+	}
+	if len(errs) > 0 {
+		return sections, errs
+	}
+
+	return sections, nil
+}
+
+// Validate reads the configuration of all the packages, and returns an error listing every invalid
+// key, including the keys in the configuration file which are not known
+func (cfg *AppConfigs) Validate() error {
+	_, err := cfg.sections()
+	errs, _ := err.(Errors)
+
+	cfg.mu.Lock()
+	unknown := make([]string, 0)
+	for key := range cfg.file {
+		if _, ok := cfg.known[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	cfg.mu.Unlock()
+
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs = append(errs, &KeyError{Key: key, Reason: fmt.Sprintf("unknown key in %s", cfg.path)})
+	}
+
+	if len(errs) > 0 {
+		return errs
 	}
-	return int(i)
+	return nil
 }
 
-func getStr(name, fallback string) string {
-	if len(name) == 0 {
-		return fallback
+// Print writes the effective configuration as JSON to w, with all the secrets redacted
+func (cfg *AppConfigs) Print(w io.Writer) error {
+	sections, _ := cfg.sections()
+
+	// the sections are converted to plain maps, so that secrets can be found by their keys
+	// irrespective of the package they're in
+	raw, err := json.Marshal(sections)
+	if err != nil {
+		return fmt.Errorf("print: %w", err)
+	}
+	effective := make(map[string]interface{})
+	err = json.Unmarshal(raw, &effective)
+	if err != nil {
+		return fmt.Errorf("print: %w", err)
+	}
+	redact(effective)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(effective)
+}
+
+// redact replaces the values of all the secrets in m, with a placeholder
+func redact(m map[string]interface{}) {
+	for key, value := range m {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			redact(v)
+		case string:
+			if v != "" && isSecret(key) {
+				m[key] = redacted
+			}
+		}
+	}
+}
+
+// NewService returns the configuration of the app. Values are read from the environment, and from
+// the configuration file at path unless it's empty. The file can be JSON, YAML or TOML, as per its
+// extension
+func NewService(path string) (*AppConfigs, error) {
+	cfg := AppConfigs{
+		path:  path,
+		file:  make(map[string]string),
+		known: make(map[string]struct{}),
+	}
+
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.file = values
+	}
+
+	return &cfg, nil
+}
+
+// reader returns a new reader for a single getter
+func (cfg *AppConfigs) reader() *reader {
+	return &reader{cfg: cfg}
+}
+
+// lookup returns the value of key, from the environment or the configuration file
+func (cfg *AppConfigs) lookup(key string) (string, bool, error) {
+	cfg.mu.Lock()
+	cfg.known[key] = struct{}{}
+	value, inFile := cfg.file[key]
+	cfg.mu.Unlock()
+
+	envValue, inEnv := os.LookupEnv(key)
+	secretPath, inSecretFile := os.LookupEnv(key + "_FILE")
+	switch {
+	case inEnv && inSecretFile:
+		return "", false, fmt.Errorf("both %s and %s_FILE are set", key, key)
+	case inSecretFile:
+		b, err := os.ReadFile(secretPath)
+		if err != nil {
+			return "", false, fmt.Errorf("read %s_FILE: %w", key, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), true, nil
+	case inEnv:
+		return envValue, true, nil
 	}
-	return name
+
+	return value, inFile, nil
 }
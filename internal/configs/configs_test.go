@@ -0,0 +1,233 @@
+package configs
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLookupPrecedence(t *testing.T) {
+	secret := writeFile(t, "password", "from secret file\n")
+	cfg, err := NewService(writeFile(t, "config.yaml", `
+datastore:
+  host: file.example.com
+  port: 27018
+  user: file-user
+  password: from config file
+`))
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	t.Setenv("DATASTORE_HOST", "env.example.com")
+	t.Setenv("DATASTORE_PASSWORD_FILE", secret)
+
+	ds, err := cfg.Datastore()
+	if err != nil {
+		t.Fatalf("Datastore: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "environment over file", got: ds.Host, want: "env.example.com"},
+		{name: "secret file over file", got: ds.Password, want: "from secret file"},
+		{name: "file", got: ds.Username, want: "file-user"},
+		{name: "file number", got: ds.Port, want: 27018},
+		{name: "default", got: ds.ConnPoolSize, want: 10},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	t.Setenv("DATASTORE_PASSWORD", "from environment")
+	_, err = cfg.Datastore()
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "both DATASTORE_PASSWORD and DATASTORE_PASSWORD_FILE") {
+		t.Errorf("Datastore error = %v, want both variables reported", err)
+	}
+}
+
+func TestLockoutMaxFailuresPerIP(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want int
+	}{
+		{name: "without trusted proxies", want: 0},
+		{name: "behind trusted proxies", env: map[string]string{"HTTP_TRUSTED_PROXIES": "10.0.0.0/8"}, want: 100},
+		{
+			name: "set",
+			env:  map[string]string{"USERS_LOCKOUT_MAX_FAILURES_PER_IP": "20"},
+			want: 20,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg, err := NewService("")
+			if err != nil {
+				t.Fatalf("NewService: %v", err)
+			}
+
+			uc, err := cfg.Users()
+			if err != nil {
+				t.Fatalf("Users: %v", err)
+			}
+			if uc.LockoutMaxFailuresPerIP != tt.want {
+				t.Errorf("LockoutMaxFailuresPerIP = %d, want %d", uc.LockoutMaxFailuresPerIP, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		env      map[string]string
+		wantKeys []string
+	}{
+		{name: "defaults"},
+		{
+			name: "valid file",
+			file: "config.json",
+			content: `{
+				"http": {"port": 9090, "trusted_proxies": ["10.0.0.0/8"]},
+				"users": {"store": "MEMORY", "mobile_default_region": "gb"},
+				"tracing": {"sample_ratio": 0.5}
+			}`,
+		},
+		{
+			name:    "invalid values",
+			file:    "config.yaml",
+			content: "http:\n  port: 70000\n  rate_limits: [\"POST /x ip nope\"]\ntracing:\n  sample_ratio: 2\n",
+			env: map[string]string{
+				"USERS_STORE":                 "postgres",
+				"USERS_MOBILE_DEFAULT_REGION": "XX",
+				"CACHE_OPTIONAL":              "maybe",
+				"PASSWORD_MIN_LENGTH":         "4",
+			},
+			wantKeys: []string{
+				"HTTP_PORT",
+				"HTTP_RATE_LIMITS",
+				"USERS_STORE",
+				"USERS_MOBILE_DEFAULT_REGION",
+				"CACHE_OPTIONAL",
+				"TRACING_SAMPLE_RATIO",
+				"PASSWORD_MIN_LENGTH",
+			},
+		},
+		{
+			name:     "unknown keys",
+			file:     "config.toml",
+			content:  "[http]\nport = 9090\nprot = 9091\n\n[sesions]\nstore = \"memory\"\n",
+			wantKeys: []string{"HTTP_PROT", "SESIONS_STORE"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			path := ""
+			if tt.file != "" {
+				path = writeFile(t, tt.file, tt.content)
+			}
+			cfg, err := NewService(path)
+			if err != nil {
+				t.Fatalf("NewService: %v", err)
+			}
+
+			err = cfg.Validate()
+			if len(tt.wantKeys) == 0 {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+
+			var errs Errors
+			if !errors.As(err, &errs) || !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("Validate error = %v, want %T", err, errs)
+			}
+			got := make(map[string]bool, len(errs))
+			for _, ke := range errs {
+				got[ke.Key] = true
+			}
+			for _, key := range tt.wantKeys {
+				if !got[key] {
+					t.Errorf("Validate error = %v, want %s reported", err, key)
+				}
+			}
+			if len(errs) != len(tt.wantKeys) {
+				t.Errorf("Validate reported %d keys, want %d: %v", len(errs), len(tt.wantKeys), err)
+			}
+		})
+	}
+}
+
+func TestSecretsRedacted(t *testing.T) {
+	t.Setenv("AUTH_JWT_KEY", "super secret signing key")
+	t.Setenv("DATASTORE_PASSWORD", "super secret password")
+	t.Setenv("MAILER_SMTP_PORT", "super secret port")
+	cfg, err := NewService("")
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = cfg.Print(buf)
+	if err != nil {
+		t.Fatalf("Print: %v", err)
+	}
+	if strings.Contains(buf.String(), "super secret signing key") || strings.Contains(buf.String(), "super secret password") {
+		t.Errorf("Print wrote a secret:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), redacted) {
+		t.Errorf("Print did not redact the secrets:\n%s", buf.String())
+	}
+
+	// values of keys which are not secrets are reported as is
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "super secret port") {
+		t.Errorf("Validate error = %v, want the invalid port reported", err)
+	}
+
+	// while the values of secrets are not
+	r := cfg.reader()
+	r.fail("CACHE_PASSWORD", "super secret password", "is invalid")
+	if strings.Contains(r.err().Error(), "super secret password") {
+		t.Errorf("error = %v, want the secret redacted", r.err())
+	}
+}
+
+func TestIsSecret(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "DATASTORE_PASSWORD", want: true},
+		{key: "AUTH_JWT_KEY", want: true},
+		{key: "AUTH_JWT_PREVIOUS_KEY", want: true},
+		{key: "TRACING_OTLP_HEADERS", want: true},
+		{key: "smtp_password", want: true},
+		{key: "previous_key", want: true},
+		{key: "AUTH_JWT_KEY_ID", want: false},
+		{key: "DATASTORE_USER", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isSecret(tt.key); got != tt.want {
+			t.Errorf("isSecret(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
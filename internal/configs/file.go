@@ -0,0 +1,117 @@
+package configs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// readFile returns all the values of the configuration file at path, keyed by the name of the
+// respective environment variable
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var doc interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		// numbers are kept as they are written, instead of being converted to float64
+		dec.UseNumber()
+		err = dec.Decode(&doc)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &doc)
+	case ".toml":
+		err = toml.Unmarshal(b, &doc)
+	default:
+		return nil, fmt.Errorf("read config file: unsupported format '%s', expected .json, .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	if doc == nil {
+		return values, nil
+	}
+
+	err = flatten("", doc, values)
+	if err != nil {
+		return nil, fmt.Errorf("read config file %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// envKey returns the name of the environment variable of the nested key, e.g. http.read-timeout
+// is HTTP_READ_TIMEOUT
+func envKey(prefix, key string) string {
+	key = strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(strings.TrimSpace(key)))
+	if prefix == "" {
+		return key
+	}
+	return prefix + "_" + key
+}
+
+// flatten stores all the values in doc with the name of their respective environment variable.
+// Lists are stored as comma separated values
+func flatten(prefix string, doc interface{}, values map[string]string) error {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			err := flatten(envKey(prefix, key), child, values)
+			if err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		for key, child := range v {
+			err := flatten(envKey(prefix, fmt.Sprint(key)), child, values)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, child := range v {
+			item, err := scalar(prefix, child)
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		values[prefix] = strings.Join(items, ",")
+	default:
+		value, err := scalar(prefix, v)
+		if err != nil {
+			return err
+		}
+		values[prefix] = value
+	}
+
+	return nil
+}
+
+func scalar(key string, v interface{}) (string, error) {
+	switch value := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool, int, int64, uint64, json.Number:
+		return fmt.Sprint(value), nil
+	default:
+		return "", fmt.Errorf("%s: unsupported value of type %T", key, v)
+	}
+}
@@ -0,0 +1,135 @@
+package configs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to a file named name in a temporary directory, and returns its path
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestReadFile(t *testing.T) {
+	want := map[string]string{
+		"HTTP_PORT":                      "9090",
+		"HTTP_READ_TIMEOUT_SECOND":       "15",
+		"HTTP_TRUSTED_PROXIES":           "10.0.0.0/8,192.0.2.1",
+		"TRACING_SAMPLE_RATIO":           "0.25",
+		"CACHE_OPTIONAL":                 "true",
+		"USERS_MOBILE_DEFAULT_REGION":    "GB",
+		"USERS_DISPOSABLE_EMAIL_DOMAINS": "",
+	}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "config.json",
+			content: `{
+				"http": {"port": 9090, "read-timeout-second": 15, "trusted_proxies": ["10.0.0.0/8", "192.0.2.1"]},
+				"tracing": {"sample_ratio": 0.25},
+				"cache": {"optional": true},
+				"users": {"mobile_default_region": "GB", "disposable_email_domains": null}
+			}`,
+		},
+		{
+			name: "config.yaml",
+			content: `
+http:
+  port: 9090
+  read-timeout-second: 15
+  trusted_proxies:
+    - 10.0.0.0/8
+    - 192.0.2.1
+tracing:
+  sample_ratio: 0.25
+cache:
+  optional: true
+users:
+  mobile_default_region: GB
+  disposable_email_domains:
+`,
+		},
+		{
+			name: "config.TOML",
+			content: `
+[http]
+port = 9090
+read-timeout-second = 15
+trusted_proxies = ["10.0.0.0/8", "192.0.2.1"]
+
+[tracing]
+sample_ratio = 0.25
+
+[cache]
+optional = true
+
+[users]
+mobile_default_region = "GB"
+disposable_email_domains = []
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFile(writeFile(t, tt.name, tt.content))
+			if err != nil {
+				t.Fatalf("readFile: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("readFile = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "config.ini", content: "[http]\nport = 9090", wantErr: "unsupported format"},
+		{name: "config.json", content: `{"http": {"port": }}`, wantErr: "config.json"},
+		{name: "config.yaml", content: "http:\n  port: [9090", wantErr: "config.yaml"},
+		{name: "config.toml", content: "[http\nport = 9090", wantErr: "config.toml"},
+		{name: "nested.json", content: `{"http": {"rate_limits": [{"path": "/"}]}}`, wantErr: "HTTP_RATE_LIMITS: unsupported value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readFile(writeFile(t, tt.name, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("readFile error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	_, err := readFile(filepath.Join(t.TempDir(), "missing.json"))
+	if err == nil {
+		t.Error("readFile of a missing file returned no error")
+	}
+}
+
+func TestReadFileEmpty(t *testing.T) {
+	got, err := readFile(writeFile(t, "config.yaml", ""))
+	if err != nil {
+		t.Fatalf("readFile: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("readFile = %v, want no values", got)
+	}
+}
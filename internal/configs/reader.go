@@ -0,0 +1,199 @@
+package configs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// redacted replaces the values of secrets wherever the configuration is printed
+const redacted = "[REDACTED]"
+
+// ErrInvalidConfig is matched by the errors of all the getters, when any of the keys is invalid
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// KeyError is an invalid value of a single configuration key
+type KeyError struct {
+	// Key is the name of the environment variable of the configuration key, e.g. HTTP_PORT
+	Key    string
+	Reason string
+}
+
+func (ke *KeyError) Error() string {
+	return fmt.Sprintf("%s: %s", ke.Key, ke.Reason)
+}
+
+// Errors lists every invalid key of a configuration, so that all of them can be fixed at once
+type Errors []*KeyError
+
+func (errs Errors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, ke := range errs {
+		msgs = append(msgs, ke.Error())
+	}
+	return fmt.Sprintf("%s: %s", ErrInvalidConfig.Error(), strings.Join(msgs, "; "))
+}
+
+// Is makes Errors match ErrInvalidConfig with errors.Is
+func (errs Errors) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// secretSuffixes are the last words of the keys whose values are secrets
+var secretSuffixes = map[string]struct{}{
+	"PASSWORD": {},
+	"SECRET":   {},
+	"TOKEN":    {},
+	"KEY":      {},
+	"KEYS":     {},
+	"HEADERS":  {},
+}
+
+// isSecret reports if the value of key must never be printed or logged. key is either the name of
+// an environment variable, e.g. DATASTORE_PASSWORD, or of a field of a package configuration
+func isSecret(key string) bool {
+	words := strings.Split(strings.ToUpper(key), "_")
+	_, ok := secretSuffixes[words[len(words)-1]]
+	return ok
+}
+
+// reader reads the keys of a single getter, and collects the errors of all the invalid ones
+type reader struct {
+	cfg  *AppConfigs
+	errs Errors
+}
+
+func (r *reader) fail(key, value, reason string) {
+	if isSecret(key) {
+		value = redacted
+	}
+	r.errs = append(r.errs, &KeyError{Key: key, Reason: fmt.Sprintf("'%s' %s", value, reason)})
+}
+
+// value returns the value of key, and false if it's not set or empty
+func (r *reader) value(key string) (string, bool) {
+	value, ok, err := r.cfg.lookup(key)
+	if err != nil {
+		r.errs = append(r.errs, &KeyError{Key: key, Reason: err.Error()})
+		return "", false
+	}
+	value = strings.TrimSpace(value)
+	return value, ok && value != ""
+}
+
+func (r *reader) str(key, fallback string) string {
+	value, ok := r.value(key)
+	if !ok {
+		return fallback
+	}
+	return value
+}
+
+// int returns the integer value of key, which must not be less than min
+func (r *reader) int(key string, fallback int, min int) int {
+	value, ok := r.value(key)
+	if !ok {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		r.fail(key, value, "is not an integer")
+		return fallback
+	}
+	if i < min {
+		r.fail(key, value, fmt.Sprintf("must be at least %d", min))
+		return fallback
+	}
+	return i
+}
+
+func (r *reader) port(key string, fallback int) int {
+	value, ok := r.value(key)
+	if !ok {
+		return fallback
+	}
+
+	p, err := strconv.Atoi(value)
+	if err != nil || p < 1 || p > 65535 {
+		r.fail(key, value, "is not a valid port, expected 1-65535")
+		return fallback
+	}
+	return p
+}
+
+func (r *reader) bool(key string, fallback bool) bool {
+	value, ok := r.value(key)
+	if !ok {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		r.fail(key, value, "is not a boolean")
+		return fallback
+	}
+	return b
+}
+
+// float returns the float value of key, which must be within [min, max]
+func (r *reader) float(key string, fallback, min, max float64) float64 {
+	value, ok := r.value(key)
+	if !ok {
+		return fallback
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.fail(key, value, "is not a number")
+		return fallback
+	}
+	if f < min || f > max {
+		r.fail(key, value, fmt.Sprintf("must be between %g and %g", min, max))
+		return fallback
+	}
+	return f
+}
+
+// oneOf returns the value of key, which must be one of allowed
+func (r *reader) oneOf(key string, fallback string, allowed ...string) string {
+	value, ok := r.value(key)
+	if !ok {
+		return fallback
+	}
+
+	for _, a := range allowed {
+		if strings.EqualFold(value, a) {
+			return a
+		}
+	}
+	r.fail(key, value, fmt.Sprintf("must be one of %s", strings.Join(allowed, ", ")))
+	return fallback
+}
+
+// list returns the comma separated values of key
+func (r *reader) list(key string, fallback []string) []string {
+	value, ok := r.value(key)
+	if !ok {
+		return fallback
+	}
+
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return fallback
+	}
+	return items
+}
+
+func (r *reader) err() error {
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return r.errs
+}
@@ -0,0 +1,224 @@
+package configs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/server/http"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// watchInterval is how often the configuration file is checked for changes
+const watchInterval = time.Second * 5
+
+// reloadableKeys are the keys which can be changed without restarting the app. Changes to any other
+// key are ignored on reload
+var reloadableKeys = map[string]struct{}{
+	"LOG_LEVEL":              {},
+	"USERS_CACHE_TTL_SECOND": {},
+	"HTTP_MAX_BODY_BYTES":    {},
+	"HTTP_RATE_LIMITS":       {},
+}
+
+// Runtime is the configuration which can be changed while the app is running. Only the reloadable
+// fields of every package configuration are updated on reload, the rest keep their startup values
+type Runtime struct {
+	HTTP   *http.Config
+	Users  *users.Config
+	Logger *logger.Config
+}
+
+// Subscriber is notified with the new runtime configuration after every successful reload
+type Subscriber func(rt *Runtime)
+
+// Reloader re-reads the configuration on SIGHUP or when the configuration file changes, and notifies
+// all the subscribers once the new configuration is validated
+type Reloader struct {
+	l logger.Logger
+	// initial is the configuration the app was started with, which holds the non-reloadable values
+	initial *AppConfigs
+
+	mu sync.Mutex
+	// last is the configuration of the last successful reload
+	last        *AppConfigs
+	current     *Runtime
+	subscribers []Subscriber
+}
+
+// Subscribe adds fn to the subscribers notified on reload
+func (rl *Reloader) Subscribe(fn Subscriber) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.subscribers = append(rl.subscribers, fn)
+}
+
+// Current returns the runtime configuration currently in effect
+func (rl *Reloader) Current() *Runtime {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.current
+}
+
+// Reload re-reads the configuration, and notifies all the subscribers if it's valid. Changes to keys
+// which are not reloadable are ignored with a warning. The current configuration is kept if the
+// new one is invalid
+func (rl *Reloader) Reload() error {
+	next, err := NewService(rl.initial.path)
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+
+	err = next.Validate()
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+
+	rt, err := runtimeConfig(next)
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// non-reloadable keys are compared with the startup configuration, so that the warning is
+	// repeated on every reload until the app is restarted
+	for _, key := range rl.initial.changedKeys(next) {
+		if _, ok := reloadableKeys[key]; !ok {
+			rl.l.With("key", key).Warn("configuration key cannot be changed without a restart, ignoring the change")
+		}
+	}
+	applied := make([]string, 0)
+	for _, key := range rl.last.changedKeys(next) {
+		if _, ok := reloadableKeys[key]; ok {
+			applied = append(applied, key)
+		}
+	}
+
+	// subscribers are notified while holding the lock, so that concurrent reloads cannot interleave
+	// and every subscriber always ends up with the same configuration
+	rl.last = next
+	rl.current = rt
+	for _, fn := range rl.subscribers {
+		fn(rt)
+	}
+	rl.l.With("changed", applied).Info("configuration reloaded")
+
+	return nil
+}
+
+// Watch reloads the configuration on every SIGHUP, and whenever the configuration file is modified.
+// It blocks until ctx is done
+func (rl *Reloader) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	lastMod := rl.modTime()
+	reload := func(reason string) {
+		err := rl.Reload()
+		if err != nil {
+			rl.l.With("reason", reason, "error", err).Error("configuration reload failed, keeping the current configuration")
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			lastMod = rl.modTime()
+			reload("SIGHUP")
+		case <-ticker.C:
+			// the file is polled instead of watched for events, since editors and config map mounts
+			// often replace the file rather than writing to it
+			mod := rl.modTime()
+			if mod.Equal(lastMod) {
+				continue
+			}
+			lastMod = mod
+			reload("file changed")
+		}
+	}
+}
+
+// modTime returns the modification time of the configuration file, or zero if there's no file
+func (rl *Reloader) modTime() time.Time {
+	if rl.initial.path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(rl.initial.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// runtimeConfig returns the runtime configuration from cfg, which must be already validated
+func runtimeConfig(cfg *AppConfigs) (*Runtime, error) {
+	httpCfg, err := cfg.HTTP()
+	if err != nil {
+		return nil, err
+	}
+	usersCfg, err := cfg.Users()
+	if err != nil {
+		return nil, err
+	}
+	logCfg, err := cfg.Logger()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Runtime{
+		HTTP:   httpCfg,
+		Users:  usersCfg,
+		Logger: logCfg,
+	}, nil
+}
+
+// changedKeys returns all the keys whose values are different in next
+func (cfg *AppConfigs) changedKeys(next *AppConfigs) []string {
+	cfg.mu.Lock()
+	keys := make([]string, 0, len(cfg.known))
+	for key := range cfg.known {
+		keys = append(keys, key)
+	}
+	cfg.mu.Unlock()
+	sort.Strings(keys)
+
+	changed := make([]string, 0)
+	for _, key := range keys {
+		// errors were already reported by Validate, so only the values are compared
+		previous, _, _ := cfg.lookup(key)
+		value, _, _ := next.lookup(key)
+		if previous != value {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// NewReloader returns a Reloader of cfg, which must be already validated
+func NewReloader(cfg *AppConfigs, l logger.Logger) (*Reloader, error) {
+	rt, err := runtimeConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reloader{
+		l:       l,
+		initial: cfg,
+		last:    cfg,
+		current: rt,
+	}, nil
+}
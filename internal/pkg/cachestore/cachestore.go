@@ -1,6 +1,7 @@
 package cachestore
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -30,6 +31,11 @@ type Config struct {
 	ReadTimeout  int `json:"read_timeout"`
 	WriteTimeout int `json:"write_timeout"`
 	DialTimeout  int `json:"dial_timeout"`
+
+	// Optional lets the app start without cache, in which case it keeps retrying to connect in the
+	// background. Retries are done with exponential backoff, capped at RetryMaxIntervalSecond
+	Optional               bool `json:"optional"`
+	RetryMaxIntervalSecond int  `json:"retry_max_interval_second"`
 }
 
 // NewService returns an instance of redis.Pool with all the required configurations set
@@ -54,17 +60,32 @@ func NewService(cfg *Config) (*redis.Pool, error) {
 		},
 	}
 
-	conn := rpool.Get()
-	rep, err := conn.Do("PING")
+	err := Ping(context.Background(), rpool)
 	if err != nil {
+		rpool.Close()
 		return nil, err
 	}
 
+	return rpool, nil
+}
+
+// Ping checks if the cache is reachable, using a connection from the pool
+func Ping(ctx context.Context, pool *redis.Pool) error {
+	conn, err := pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rep, err := redis.DoContext(conn, ctx, "PING")
+	if err != nil {
+		return err
+	}
+
 	pong, _ := rep.(string)
 	if pong != "PONG" {
-		return nil, errors.New("ping failed")
+		return errors.New("ping failed")
 	}
-	conn.Close()
 
-	return rpool, nil
+	return nil
 }
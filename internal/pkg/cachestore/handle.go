@@ -0,0 +1,114 @@
+package cachestore
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/jerryan999/goapp/internal/pkg/metrics"
+)
+
+// Handle holds a redis.Pool which may become available only after startup, i.e. when the app starts
+// without cache and connects to it later. All the dependents of the cache share the same Handle, so
+// that attaching the pool makes it available to all of them at once. It's safe for concurrent use
+type Handle struct {
+	mu   sync.RWMutex
+	pool *redis.Pool
+}
+
+// Pool returns the attached pool, or nil if no pool is attached yet
+func (h *Handle) Pool() *redis.Pool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.pool
+}
+
+// Attach makes pool available to all the dependents of the handle
+func (h *Handle) Attach(pool *redis.Pool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pool = pool
+}
+
+// Close closes the attached pool, if any
+func (h *Handle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pool == nil {
+		return nil
+	}
+
+	err := h.pool.Close()
+	h.pool = nil
+	return err
+}
+
+// RegisterMetrics registers the connection stats of the pool attached to h, in the Default metrics registry
+func (h *Handle) RegisterMetrics() {
+	metrics.NewGaugeFunc(
+		"redis_pool_active_connections",
+		"Number of connections in the Redis pool, both idle and in use",
+		func() float64 {
+			pool := h.Pool()
+			if pool == nil {
+				return 0
+			}
+			return float64(pool.Stats().ActiveCount)
+		},
+	)
+	metrics.NewGaugeFunc(
+		"redis_pool_idle_connections",
+		"Number of idle connections in the Redis pool",
+		func() float64 {
+			pool := h.Pool()
+			if pool == nil {
+				return 0
+			}
+			return float64(pool.Stats().IdleCount)
+		},
+	)
+}
+
+// NewHandle returns a new Handle with pool attached. pool can be nil
+func NewHandle(pool *redis.Pool) *Handle {
+	return &Handle{
+		pool: pool,
+	}
+}
+
+// Connect tries to create a new pool with cfg until it succeeds, or until ctx is done. It waits
+// between attempts with an exponential backoff, capped at cfg.RetryMaxIntervalSecond. onError, if
+// not nil, is called after every failed attempt
+func Connect(ctx context.Context, cfg *Config, onError func(attempt int, err error)) (*redis.Pool, error) {
+	maxInterval := time.Duration(cfg.RetryMaxIntervalSecond) * time.Second
+	if maxInterval <= 0 {
+		maxInterval = time.Minute
+	}
+	interval := time.Second
+
+	for attempt := 1; ; attempt++ {
+		pool, err := NewService(cfg)
+		if err == nil {
+			return pool, nil
+		}
+		if onError != nil {
+			onError(attempt, err)
+		}
+
+		// jitter avoids all replicas of the app retrying in lockstep
+		wait := interval/2 + time.Duration(rand.Int63n(int64(interval/2)+1))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package contact normalizes and validates contact details, i.e. email addresses and phone numbers,
+// so that each address or number has a single canonical form which can be stored and compared.
+package contact
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	// maxEmailLength is the maximum length of an address usable in SMTP commands (RFC 5321)
+	maxEmailLength = 254
+	// maxLocalPartLength is the maximum length of the part before the @ (RFC 5321)
+	maxLocalPartLength = 64
+)
+
+var (
+	// ErrInvalidEmail is matched by all the errors returned for invalid email addresses
+	ErrInvalidEmail = errors.New("invalid email")
+)
+
+func invalidEmail(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidEmail, reason)
+}
+
+// NormalizeEmail returns the canonical form of addr, which must be a bare address as per RFC 5322,
+// e.g. jane.doe@example.com. Display names, quoted local parts, comments and IP literals are refused.
+// Internationalized domains are converted to their ASCII (punycode) form, and domains are lowercased
+// since they're case insensitive. The local part is kept as is, since only the receiving server can
+// tell how to compare it
+func NormalizeEmail(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return "", invalidEmail("email is empty")
+	}
+
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil || parsed.Name != "" || parsed.Address != addr {
+		return "", invalidEmail("email must be an address like name@example.com")
+	}
+
+	at := strings.LastIndex(addr, "@")
+	local, domain := addr[:at], addr[at+1:]
+	if len(local) > maxLocalPartLength {
+		return "", invalidEmail(fmt.Sprintf("the part before @ must be at most %d characters", maxLocalPartLength))
+	}
+
+	domain, err = NormalizeDomain(domain)
+	if err != nil {
+		return "", err
+	}
+
+	addr = local + "@" + domain
+	if len(addr) > maxEmailLength {
+		return "", invalidEmail(fmt.Sprintf("email must be at most %d characters", maxEmailLength))
+	}
+	return addr, nil
+}
+
+// NormalizeDomain returns the lowercased ASCII form of the domain of an email address. The domain
+// must be a fully qualified host name, with a top-level domain which is not numeric
+func NormalizeDomain(domain string) (string, error) {
+	if strings.HasPrefix(domain, "[") {
+		return "", invalidEmail("IP addresses are not allowed as domain")
+	}
+
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return "", invalidEmail(fmt.Sprintf("invalid domain '%s'", domain))
+	}
+	ascii = strings.ToLower(ascii)
+
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", invalidEmail("domain must include a top-level domain, e.g. example.com")
+	}
+	tld := labels[len(labels)-1]
+	if strings.Trim(tld, "0123456789") == "" {
+		return "", invalidEmail("IP addresses are not allowed as domain")
+	}
+	for _, label := range labels {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", invalidEmail(fmt.Sprintf("invalid domain '%s'", domain))
+		}
+	}
+
+	return ascii, nil
+}
+
+// EmailDomain returns the domain of a normalized email address
+func EmailDomain(addr string) string {
+	return addr[strings.LastIndex(addr, "@")+1:]
+}
+
+// DomainIn reports if domain, or any of its parent domains, is one of domains. All the domains must
+// be normalized, see NormalizeDomain
+func DomainIn(domain string, domains map[string]struct{}) bool {
+	for {
+		if _, ok := domains[domain]; ok {
+			return true
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+}
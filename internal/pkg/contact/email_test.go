@@ -0,0 +1,126 @@
+package contact
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", addr: "jane.doe@example.com", want: "jane.doe@example.com"},
+		{name: "spaces around", addr: "  jane@example.com ", want: "jane@example.com"},
+		{name: "domain case", addr: "Jane@Example.COM", want: "Jane@example.com"},
+		{name: "plus addressing", addr: "jane+news@example.com", want: "jane+news@example.com"},
+		{name: "subdomain", addr: "jane@mail.example.co.uk", want: "jane@mail.example.co.uk"},
+		{name: "internationalized domain", addr: "jane@bücher.example", want: "jane@xn--bcher-kva.example"},
+		{name: "empty", addr: " ", wantErr: true},
+		{name: "no at", addr: "jane", wantErr: true},
+		{name: "no local part", addr: "@example.com", wantErr: true},
+		{name: "display name", addr: "Jane <jane@example.com>", wantErr: true},
+		{name: "quoted local part", addr: `"jane doe"@example.com`, wantErr: true},
+		{name: "comment", addr: "jane@example.com (Jane)", wantErr: true},
+		{name: "ip literal", addr: "jane@[192.0.2.1]", wantErr: true},
+		{name: "numeric tld", addr: "jane@192.0.2.1", wantErr: true},
+		{name: "no tld", addr: "jane@localhost", wantErr: true},
+		{name: "trailing dot", addr: "jane@example.com.", wantErr: true},
+		{name: "empty label", addr: "jane@example..com", wantErr: true},
+		{name: "label with hyphen", addr: "jane@-example.com", wantErr: true},
+		{name: "local part too long", addr: strings.Repeat("a", maxLocalPartLength+1) + "@example.com", wantErr: true},
+		{name: "too long", addr: "jane@" + strings.Repeat(strings.Repeat("a", 60)+".", 5) + "com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeEmail(tt.addr)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidEmail) {
+					t.Errorf("NormalizeEmail(%q) = %q, %v, want %v", tt.addr, got, err, ErrInvalidEmail)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeEmail(%q): %v", tt.addr, err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeEmail(%q) = %q, want %q", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain  string
+		want    string
+		wantErr bool
+	}{
+		{domain: "Example.COM", want: "example.com"},
+		{domain: "example.com.", want: "example.com"},
+		{domain: "xn--bcher-kva.example", want: "xn--bcher-kva.example"},
+		{domain: "Bücher.example", want: "xn--bcher-kva.example"},
+		{domain: "example", wantErr: true},
+		{domain: "example.123", wantErr: true},
+		{domain: "[::1]", wantErr: true},
+		{domain: "example-.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeDomain(tt.domain)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidEmail) {
+				t.Errorf("NormalizeDomain(%q) = %q, %v, want %v", tt.domain, got, err, ErrInvalidEmail)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeDomain(%q) = %q, %v, want %q", tt.domain, got, err, tt.want)
+		}
+	}
+}
+
+func TestEmailDomain(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: "jane@example.com", want: "example.com"},
+		{addr: "jane@mail.example.com", want: "mail.example.com"},
+	}
+
+	for _, tt := range tests {
+		if got := EmailDomain(tt.addr); got != tt.want {
+			t.Errorf("EmailDomain(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestDomainIn(t *testing.T) {
+	domains := map[string]struct{}{
+		"mailinator.com": {},
+		"tempmail.dev":   {},
+	}
+
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{domain: "mailinator.com", want: true},
+		{domain: "eu.mailinator.com", want: true},
+		{domain: "a.b.tempmail.dev", want: true},
+		{domain: "example.com", want: false},
+		{domain: "notmailinator.com", want: false},
+		{domain: "com", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := DomainIn(tt.domain, domains); got != tt.want {
+			t.Errorf("DomainIn(%q) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+}
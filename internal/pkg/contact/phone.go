@@ -0,0 +1,198 @@
+package contact
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxPhoneDigits is the maximum number of digits of an E.164 number, country code included
+	maxPhoneDigits = 15
+	// minNationalDigits is the minimum number of digits of a number without its country code
+	minNationalDigits = 4
+	// nanpCode is the country code of the North American Numbering Plan, e.g. US and Canada
+	nanpCode = "1"
+)
+
+var (
+	// ErrInvalidPhone is matched by all the errors returned for invalid phone numbers
+	ErrInvalidPhone = errors.New("invalid phone number")
+)
+
+func invalidPhone(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPhone, reason)
+}
+
+// region is how numbers are dialed within a region
+type region struct {
+	// code is the country code of the region
+	code string
+	// trunk is the prefix of national numbers dialed within the region, which is dropped in E.164,
+	// e.g. the 0 of 020 7946 0000 in GB
+	trunk string
+	// intl is the prefix dialed within the region before international numbers, e.g. 00 in most of
+	// Europe, which is the same as +
+	intl string
+}
+
+// regions are the regions numbers can be written nationally for, by ISO 3166-1 alpha-2 code
+var regions = map[string]region{
+	"AE": {code: "971", trunk: "0", intl: "00"},
+	"AR": {code: "54", trunk: "0", intl: "00"},
+	"AT": {code: "43", trunk: "0", intl: "00"},
+	"AU": {code: "61", trunk: "0", intl: "0011"},
+	"BE": {code: "32", trunk: "0", intl: "00"},
+	"BR": {code: "55", trunk: "0", intl: "00"},
+	"CA": {code: "1", trunk: "1", intl: "011"},
+	"CH": {code: "41", trunk: "0", intl: "00"},
+	"CL": {code: "56", intl: "00"},
+	"CN": {code: "86", trunk: "0", intl: "00"},
+	"CO": {code: "57", intl: "00"},
+	"DE": {code: "49", trunk: "0", intl: "00"},
+	"DK": {code: "45", intl: "00"},
+	"EG": {code: "20", trunk: "0", intl: "00"},
+	"ES": {code: "34", intl: "00"},
+	"FI": {code: "358", trunk: "0", intl: "00"},
+	"FR": {code: "33", trunk: "0", intl: "00"},
+	"GB": {code: "44", trunk: "0", intl: "00"},
+	"HK": {code: "852", intl: "001"},
+	"ID": {code: "62", trunk: "0", intl: "001"},
+	"IE": {code: "353", trunk: "0", intl: "00"},
+	"IL": {code: "972", trunk: "0", intl: "00"},
+	"IN": {code: "91", trunk: "0", intl: "00"},
+	"IT": {code: "39", intl: "00"},
+	"JP": {code: "81", trunk: "0", intl: "010"},
+	"KR": {code: "82", trunk: "0", intl: "001"},
+	"MX": {code: "52", intl: "00"},
+	"MY": {code: "60", trunk: "0", intl: "00"},
+	"NG": {code: "234", trunk: "0", intl: "009"},
+	"NL": {code: "31", trunk: "0", intl: "00"},
+	"NO": {code: "47", intl: "00"},
+	"NZ": {code: "64", trunk: "0", intl: "00"},
+	"PH": {code: "63", trunk: "0", intl: "00"},
+	"PK": {code: "92", trunk: "0", intl: "00"},
+	"PL": {code: "48", intl: "00"},
+	"PT": {code: "351", intl: "00"},
+	"RU": {code: "7", trunk: "8", intl: "810"},
+	"SA": {code: "966", trunk: "0", intl: "00"},
+	"SE": {code: "46", trunk: "0", intl: "00"},
+	"SG": {code: "65", intl: "000"},
+	"TH": {code: "66", trunk: "0", intl: "001"},
+	"TR": {code: "90", trunk: "0", intl: "00"},
+	"TW": {code: "886", trunk: "0", intl: "002"},
+	"US": {code: "1", trunk: "1", intl: "011"},
+	"VN": {code: "84", trunk: "0", intl: "00"},
+	"ZA": {code: "27", trunk: "0", intl: "00"},
+}
+
+// countryCodes are the country codes assigned to geographic areas. Codes of global services, e.g.
+// satellite phones, are left out since users are expected to have mobile numbers. Country codes are
+// prefix-free, so the code of a number is the only one of its first 1, 2 or 3 digits in the set
+var countryCodes = func() map[string]struct{} {
+	codes := strings.Fields(`
+		1 7
+		20 27 30 31 32 33 34 36 39 40 41 43 44 45 46 47 48 49 51 52 53 54 55 56 57 58
+		60 61 62 63 64 65 66 81 82 84 86 90 91 92 93 94 95 98
+		211 212 213 216 218 220 221 222 223 224 225 226 227 228 229 230 231 232 233 234 235 236
+		237 238 239 240 241 242 243 244 245 246 247 248 249 250 251 252 253 254 255 256 257 258
+		260 261 262 263 264 265 266 267 268 269 290 291 297 298 299
+		350 351 352 353 354 355 356 357 358 359 370 371 372 373 374 375 376 377 378 379 380 381
+		382 383 385 386 387 389 420 421 423
+		500 501 502 503 504 505 506 507 508 509 590 591 592 593 594 595 596 597 598 599
+		670 672 673 674 675 676 677 678 679 680 681 682 683 685 686 687 688 689 690 691 692
+		850 852 853 855 856 880 886
+		960 961 962 963 964 965 966 967 968 970 971 972 973 974 975 976 977
+		992 993 994 995 996 998
+	`)
+	set := make(map[string]struct{}, len(codes))
+	for _, code := range codes {
+		set[code] = struct{}{}
+	}
+	return set
+}()
+
+// ValidRegion reports if numbers can be written nationally for the region with the ISO 3166-1 alpha-2
+// code, see NormalizePhone
+func ValidRegion(code string) bool {
+	_, ok := regions[strings.ToUpper(code)]
+	return ok
+}
+
+// countryCode returns the country code digits start with, and the national number after it
+func countryCode(digits string) (string, string, bool) {
+	for i := 1; i <= 3 && i < len(digits); i++ {
+		if _, ok := countryCodes[digits[:i]]; ok {
+			return digits[:i], digits[i:], true
+		}
+	}
+	return "", "", false
+}
+
+// validNANP reports if national is a NANP number, i.e. a 3 digits area code and a 7 digits number,
+// neither of which starts with 0 or 1
+func validNANP(national string) bool {
+	return len(national) == 10 && national[0] >= '2' && national[3] >= '2'
+}
+
+// NormalizePhone returns number in the E.164 format, e.g. +14155550100. Numbers can be written with
+// spaces, dashes, dots and parentheses, and either internationally, i.e. starting with + or the
+// international prefix of defaultRegion, or nationally as they're dialed within defaultRegion, an
+// ISO 3166-1 alpha-2 code. Only the structure of numbers is checked: the country code must be
+// assigned and the length plausible, but the number itself may not be in service
+func NormalizePhone(number string, defaultRegion string) (string, error) {
+	number = strings.TrimSpace(number)
+	if number == "" {
+		return "", invalidPhone("phone number is empty")
+	}
+
+	international := strings.HasPrefix(number, "+")
+	if international {
+		// the trunk prefix is sometimes written in parentheses, e.g. +44 (0)20 7946 0000, but it's
+		// not dialed from abroad
+		number = strings.Replace(number, "(0)", "", 1)
+	}
+	digits := make([]byte, 0, len(number))
+	for i, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, byte(r))
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", invalidPhone("phone number can only include digits, spaces, dashes, dots, parentheses and a leading +")
+		}
+	}
+	e164 := string(digits)
+
+	if !international {
+		reg, ok := regions[strings.ToUpper(defaultRegion)]
+		switch {
+		case !ok:
+			return "", invalidPhone("phone number must start with + and the country code")
+		case strings.HasPrefix(e164, reg.intl):
+			e164 = strings.TrimPrefix(e164, reg.intl)
+		default:
+			if reg.trunk != "" {
+				e164 = strings.TrimPrefix(e164, reg.trunk)
+			}
+			e164 = reg.code + e164
+		}
+	}
+
+	if len(e164) > maxPhoneDigits {
+		return "", invalidPhone(fmt.Sprintf("phone number must have at most %d digits", maxPhoneDigits))
+	}
+	code, national, ok := countryCode(e164)
+	if !ok {
+		return "", invalidPhone("unknown country code")
+	}
+	if len(national) < minNationalDigits {
+		return "", invalidPhone("phone number is too short")
+	}
+	if code == nanpCode && !validNANP(national) {
+		return "", invalidPhone("phone number must have a 3 digits area code and 7 digits, e.g. +1 415 555 0100")
+	}
+
+	return "+" + e164, nil
+}
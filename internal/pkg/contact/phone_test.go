@@ -0,0 +1,75 @@
+package contact
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		name    string
+		number  string
+		region  string
+		want    string
+		wantErr bool
+	}{
+		{name: "e164", number: "+14155550100", want: "+14155550100"},
+		{name: "formatted", number: "+1 (415) 555-0100", want: "+14155550100"},
+		{name: "dots", number: "+44.20.7946.0000", want: "+442079460000"},
+		{name: "trunk prefix in parentheses", number: "+44 (0)20 7946 0000", want: "+442079460000"},
+		{name: "three digits country code", number: "+353 1 234 5678", want: "+35312345678"},
+		{name: "national", number: "(415) 555-0100", region: "US", want: "+14155550100"},
+		{name: "national with trunk prefix", number: "1 415 555 0100", region: "us", want: "+14155550100"},
+		{name: "national gb", number: "020 7946 0000", region: "GB", want: "+442079460000"},
+		{name: "national without trunk prefix", number: "612 345 678", region: "ES", want: "+34612345678"},
+		{name: "international prefix", number: "00 44 20 7946 0000", region: "DE", want: "+442079460000"},
+		{name: "international prefix us", number: "011 44 20 7946 0000", region: "US", want: "+442079460000"},
+		{name: "empty", number: "  ", wantErr: true},
+		{name: "letters", number: "+1 415 CALL NOW", wantErr: true},
+		{name: "plus inside", number: "1+4155550100", region: "US", wantErr: true},
+		{name: "national without region", number: "020 7946 0000", wantErr: true},
+		{name: "national with unknown region", number: "020 7946 0000", region: "XX", wantErr: true},
+		{name: "unknown country code", number: "+0 123 456 789", wantErr: true},
+		{name: "too short", number: "+44 123", wantErr: true},
+		{name: "too long", number: "+44 1234 5678 9012 34", wantErr: true},
+		{name: "nanp area code", number: "+1 115 555 0100", wantErr: true},
+		{name: "nanp exchange", number: "+1 415 055 0100", wantErr: true},
+		{name: "nanp length", number: "+1 415 555 010", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhone(tt.number, tt.region)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPhone) {
+					t.Errorf("NormalizePhone(%q, %q) = %q, %v, want %v", tt.number, tt.region, got, err, ErrInvalidPhone)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizePhone(%q, %q): %v", tt.number, tt.region, err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizePhone(%q, %q) = %q, want %q", tt.number, tt.region, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidRegion(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{code: "US", want: true},
+		{code: "gb", want: true},
+		{code: "XX", want: false},
+		{code: "", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidRegion(tt.code); got != tt.want {
+			t.Errorf("ValidRegion(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
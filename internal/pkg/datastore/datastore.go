@@ -7,8 +7,40 @@ import (
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/jerryan999/goapp/internal/pkg/metrics"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+var (
+	operationDuration = metrics.NewHistogramVec(
+		"datastore_operation_duration_seconds",
+		"Latency of datastore operations, partitioned by collection and operation",
+		nil,
+		"collection", "operation",
+	)
 )
 
+// StartOperation starts a span for an operation on a collection. The returned function ends the span
+// and records the latency of the operation, it's meant to be deferred
+func StartOperation(ctx context.Context, database, collection, operation string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracing.StartClient(
+		ctx,
+		fmt.Sprintf("mongo %s.%s", collection, operation),
+		"db.system", "mongodb",
+		"db.name", database,
+		"db.mongodb.collection", collection,
+		"db.operation", operation,
+	)
+
+	return ctx, func() {
+		operationDuration.With(collection, operation).Observe(time.Since(start).Seconds())
+		span.End()
+	}
+}
+
 // Config struct holds all the configurations required the datastore package
 type Config struct {
 	Host string `json:"host"`
@@ -48,9 +80,18 @@ func NewService(cfg *Config) (*mongo.Client, error) {
 		return nil, fmt.Errorf("connect to mongo failed: %w", err)
 	}
 
-	err = client.Ping(ctx, nil)
+	err = Ping(ctx, client)
 	if err != nil {
-		return nil, fmt.Errorf("ping to mongo failed: %w", err)
+		return nil, err
 	}
 	return client, nil
 }
+
+// Ping checks if the primary of the datastore is reachable
+func Ping(ctx context.Context, client *mongo.Client) error {
+	err := client.Ping(ctx, readpref.Primary())
+	if err != nil {
+		return fmt.Errorf("ping to mongo failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Package jwt signs and verifies JSON Web Tokens (RFC 7519) in the compact JWS serialization. Only
+// the algorithms HS256, RS256 and EdDSA (Ed25519) are supported. Keys are identified by their key
+// ID, which is set as the 'kid' header of every token, so that keys can be rotated while tokens
+// signed with the previous key are still valid.
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	// leeway is the allowed clock skew between the issuer and the verifier of a token
+	leeway = time.Second * 30
+)
+
+var (
+	// ErrInvalidToken is returned for tokens which are malformed, or whose signature or claims are invalid
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpired is returned for tokens which are otherwise valid, but have expired
+	ErrExpired = errors.New("token expired")
+)
+
+var b64 = base64.RawURLEncoding
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Claims are the registered claims of a token. They're meant to be embedded in the claims of the
+// app, so that the registered claims are validated by Verify
+type Claims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ID        string `json:"jti,omitempty"`
+}
+
+func (c *Claims) registered() *Claims {
+	return c
+}
+
+// claimer is implemented by all structs which embed Claims
+type claimer interface {
+	registered() *Claims
+}
+
+func (c *Claims) validate(now time.Time, issuer, audience string) error {
+	if c.ExpiresAt == 0 {
+		return fmt.Errorf("%w: missing expiry", ErrInvalidToken)
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(leeway)) {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if issuer != "" && c.Issuer != issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	if audience != "" && c.Audience != audience {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+// KeySet holds the key which signs new tokens, and all the keys which tokens are verified with
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	// ordered are the verification keys in the order they were added, for a stable JWKS
+	ordered []*Key
+}
+
+// SigningKey returns the key new tokens are signed with
+func (ks *KeySet) SigningKey() *Key {
+	return ks.signing
+}
+
+// Sign returns the signed token with claims
+func (ks *KeySet) Sign(claims claimer) (string, error) {
+	h, err := json.Marshal(header{Alg: ks.signing.Algorithm, Typ: "JWT", Kid: ks.signing.ID})
+	if err != nil {
+		return "", fmt.Errorf("sign: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("sign: %w", err)
+	}
+
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(payload)
+	sig, err := ks.signing.sign([]byte(input))
+	if err != nil {
+		return "", fmt.Errorf("sign: %w", err)
+	}
+
+	return input + "." + b64.EncodeToString(sig), nil
+}
+
+// Verify verifies the signature of token, and decodes its claims into claims. The expiry is
+// required, and the issuer and audience are verified unless empty
+func (ks *KeySet) Verify(token string, claims claimer, issuer, audience string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	rawHeader, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	h := header{}
+	err = json.Unmarshal(rawHeader, &h)
+	if err != nil {
+		return fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	key, ok := ks.keys[h.Kid]
+	if !ok {
+		return fmt.Errorf("%w: unknown key '%s'", ErrInvalidToken, h.Kid)
+	}
+	// the algorithm is pinned by the key rather than trusted from the header, which prevents
+	// algorithm confusion attacks, e.g. a token signed with HS256 using a public RSA key as secret
+	if h.Alg != key.Algorithm {
+		return fmt.Errorf("%w: unexpected algorithm '%s'", ErrInvalidToken, h.Alg)
+	}
+
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	err = key.verify([]byte(parts[0]+"."+parts[1]), sig)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidToken, err.Error())
+	}
+
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	return claims.registered().validate(time.Now(), issuer, audience)
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// N and E are the modulus and exponent of RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv and X are the curve and public key of Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a set of public keys, as served by the JWKS endpoint of an issuer
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of all the asymmetric keys of ks. HMAC keys are secret, so they're
+// never included
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.ordered))}
+	for _, k := range ks.ordered {
+		if jwk, ok := k.jwk(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
+
+// NewKeySet returns a KeySet which signs tokens with signing, and verifies tokens signed with
+// signing or any of the others, e.g. the previous signing key during a rotation
+func NewKeySet(signing *Key, others ...*Key) (*KeySet, error) {
+	if signing == nil || !signing.canSign() {
+		return nil, errors.New("jwt: a private key is required for signing")
+	}
+
+	ks := &KeySet{
+		signing: signing,
+		keys:    make(map[string]*Key, len(others)+1),
+	}
+	for _, k := range append([]*Key{signing}, others...) {
+		if _, exists := ks.keys[k.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key ID '%s'", k.ID)
+		}
+		ks.keys[k.ID] = k
+		ks.ordered = append(ks.ordered, k)
+	}
+
+	return ks, nil
+}
@@ -0,0 +1,349 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClaims struct {
+	Claims
+	Scope string `json:"scope,omitempty"`
+}
+
+func hmacKey(t *testing.T, id string) *Key {
+	t.Helper()
+
+	k, err := ParseKey(id, AlgHS256, []byte(strings.Repeat("s", minHMACSecretLength)+id))
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	return k
+}
+
+func rsaPEM(t *testing.T, bits int) ([]byte, []byte) {
+	t.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKIXPublicKey: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})
+}
+
+func ed25519PEM(t *testing.T) []byte {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKCS8PrivateKey: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func validClaims() *testClaims {
+	now := time.Now()
+	return &testClaims{
+		Claims: Claims{
+			Issuer:    "goapp",
+			Subject:   "user-1",
+			Audience:  "goapp-api",
+			ExpiresAt: now.Add(time.Minute).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+		Scope: "users:read",
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	rsaPrivate, _ := rsaPEM(t, minRSAKeyBits)
+	rsaKey, err := ParseKey("rsa", AlgRS256, rsaPrivate)
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	edKey, err := ParseKey("ed", AlgEdDSA, ed25519PEM(t))
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	generated, err := GenerateKey("generated")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		key  *Key
+	}{
+		{name: AlgHS256, key: hmacKey(t, "hmac")},
+		{name: AlgRS256, key: rsaKey},
+		{name: AlgEdDSA, key: edKey},
+		{name: "generated", key: generated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := NewKeySet(tt.key)
+			if err != nil {
+				t.Fatalf("NewKeySet: %v", err)
+			}
+
+			claims := validClaims()
+			token, err := ks.Sign(claims)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			got := new(testClaims)
+			err = ks.Verify(token, got, "goapp", "goapp-api")
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if *got != *claims {
+				t.Errorf("Verify claims = %+v, want %+v", got, claims)
+			}
+
+			rawHeader, _ := b64.DecodeString(strings.Split(token, ".")[0])
+			h := header{}
+			_ = json.Unmarshal(rawHeader, &h)
+			if h.Kid != tt.key.ID || h.Alg != tt.key.Algorithm {
+				t.Errorf("header = %+v, want kid %s and alg %s", h, tt.key.ID, tt.key.Algorithm)
+			}
+		})
+	}
+}
+
+func TestVerifyClaims(t *testing.T) {
+	ks, err := NewKeySet(hmacKey(t, "hmac"))
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		modify  func(c *testClaims)
+		wantErr error
+	}{
+		{name: "valid", modify: func(c *testClaims) {}},
+		{name: "expired", modify: func(c *testClaims) { c.ExpiresAt = now.Add(-time.Minute).Unix() }, wantErr: ErrExpired},
+		{name: "expired within the leeway", modify: func(c *testClaims) { c.ExpiresAt = now.Add(-time.Second * 10).Unix() }},
+		{name: "missing expiry", modify: func(c *testClaims) { c.ExpiresAt = 0 }, wantErr: ErrInvalidToken},
+		{name: "not valid yet", modify: func(c *testClaims) { c.NotBefore = now.Add(time.Minute).Unix() }, wantErr: ErrInvalidToken},
+		{name: "not valid yet within the leeway", modify: func(c *testClaims) { c.NotBefore = now.Add(time.Second * 10).Unix() }},
+		{name: "other issuer", modify: func(c *testClaims) { c.Issuer = "other" }, wantErr: ErrInvalidToken},
+		{name: "other audience", modify: func(c *testClaims) { c.Audience = "other" }, wantErr: ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+			token, err := ks.Sign(claims)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			err = ks.Verify(token, new(testClaims), "goapp", "goapp-api")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyInvalidTokens(t *testing.T) {
+	rsaPrivate, rsaPublic := rsaPEM(t, minRSAKeyBits)
+	rsaKey, err := ParseKey("rsa", AlgRS256, rsaPrivate)
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	ks, err := NewKeySet(rsaKey)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	token, err := ks.Sign(validClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	parts := strings.Split(token, ".")
+
+	otherPrivate, _ := rsaPEM(t, minRSAKeyBits)
+	otherKey, err := ParseKey("rsa", AlgRS256, otherPrivate)
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	otherKS, err := NewKeySet(otherKey)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	otherToken, err := otherKS.Sign(validClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	// a token signed with HS256, using the public RSA key as the secret, under the ID of the RSA key
+	confusedKey := &Key{ID: "rsa", Algorithm: AlgHS256, secret: rsaPublic}
+	confusedKS, err := NewKeySet(confusedKey)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	confusedToken, err := confusedKS.Sign(validClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	tamperedClaims := validClaims()
+	tamperedClaims.Subject = "admin"
+	tamperedPayload, _ := json.Marshal(tamperedClaims)
+
+	unknownKS, err := NewKeySet(hmacKey(t, "unknown"))
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	unknownToken, err := unknownKS.Sign(validClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "empty", token: ""},
+		{name: "missing signature", token: parts[0] + "." + parts[1]},
+		{name: "malformed header", token: "!!." + parts[1] + "." + parts[2]},
+		{name: "malformed signature", token: parts[0] + "." + parts[1] + ".!!"},
+		{name: "tampered claims", token: parts[0] + "." + b64.EncodeToString(tamperedPayload) + "." + parts[2]},
+		{name: "unknown key", token: unknownToken},
+		{name: "same key ID, other key", token: otherToken},
+		{name: "algorithm confusion", token: confusedToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ks.Verify(tt.token, new(testClaims), "", "")
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	previous := hmacKey(t, "2023")
+	current, err := GenerateKey("2024")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	oldKS, err := NewKeySet(previous)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	oldToken, err := oldKS.Sign(validClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	ks, err := NewKeySet(current, previous)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	if ks.SigningKey() != current {
+		t.Errorf("SigningKey() = %s, want %s", ks.SigningKey().ID, current.ID)
+	}
+
+	err = ks.Verify(oldToken, new(testClaims), "", "")
+	if err != nil {
+		t.Errorf("Verify of a token signed with the previous key: %v", err)
+	}
+
+	// HMAC keys are secret, only the public key of the current one is published
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != "2024" || jwks.Keys[0].Kty != "OKP" {
+		t.Errorf("JWKS() = %+v, want only the Ed25519 key", jwks)
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	_, rsaPublic := rsaPEM(t, minRSAKeyBits)
+	publicOnly, err := ParseKey("public", AlgRS256, rsaPublic)
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		signing *Key
+		others  []*Key
+	}{
+		{name: "no signing key", signing: nil},
+		{name: "public signing key", signing: publicOnly},
+		{name: "duplicate key ID", signing: hmacKey(t, "a"), others: []*Key{hmacKey(t, "a")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeySet(tt.signing, tt.others...)
+			if err == nil {
+				t.Error("NewKeySet returned no error")
+			}
+		})
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	rsaPrivate, rsaPublic := rsaPEM(t, minRSAKeyBits)
+	smallRSA, _ := rsaPEM(t, 1024)
+	edPrivate := ed25519PEM(t)
+
+	tests := []struct {
+		name      string
+		id        string
+		algorithm string
+		material  []byte
+		wantErr   bool
+		canSign   bool
+	}{
+		{name: "HS256", id: "k", algorithm: AlgHS256, material: []byte(strings.Repeat("s", 32)), canSign: true},
+		{name: "short HS256 secret", id: "k", algorithm: AlgHS256, material: []byte("short"), wantErr: true},
+		{name: "missing ID", id: "", algorithm: AlgHS256, material: []byte(strings.Repeat("s", 32)), wantErr: true},
+		{name: "RSA private key", id: "k", algorithm: AlgRS256, material: rsaPrivate, canSign: true},
+		{name: "RSA public key", id: "k", algorithm: AlgRS256, material: rsaPublic},
+		{name: "small RSA key", id: "k", algorithm: AlgRS256, material: smallRSA, wantErr: true},
+		{name: "RSA key for EdDSA", id: "k", algorithm: AlgEdDSA, material: rsaPrivate, wantErr: true},
+		{name: "Ed25519 private key", id: "k", algorithm: AlgEdDSA, material: edPrivate, canSign: true},
+		{name: "Ed25519 key for RS256", id: "k", algorithm: AlgRS256, material: edPrivate, wantErr: true},
+		{name: "not PEM", id: "k", algorithm: AlgRS256, material: []byte("not a key"), wantErr: true},
+		{name: "unsupported PEM block", id: "k", algorithm: AlgRS256, material: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1}}), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKey(tt.id, tt.algorithm, tt.material)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKey error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && k.canSign() != tt.canSign {
+				t.Errorf("canSign() = %v, want %v", k.canSign(), tt.canSign)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	minHMACSecretLength = 32
+	minRSAKeyBits       = 2048
+)
+
+// Key is a single key, used for signing and/or verifying tokens with its algorithm
+type Key struct {
+	// ID is the 'kid' header of the tokens signed with the key
+	ID        string
+	Algorithm string
+
+	secret []byte
+	signer crypto.Signer
+	public crypto.PublicKey
+}
+
+func (k *Key) canSign() bool {
+	return k.secret != nil || k.signer != nil
+}
+
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case AlgRS256:
+		digest := sha256.Sum256(input)
+		return k.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case AlgEdDSA:
+		// Ed25519 signs the message itself rather than a digest
+		return k.signer.Sign(rand.Reader, input, crypto.Hash(0))
+	}
+	return nil, fmt.Errorf("unsupported algorithm '%s'", k.Algorithm)
+}
+
+func (k *Key) verify(input, sig []byte) error {
+	switch k.Algorithm {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	case AlgRS256:
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k.public.(*rsa.PublicKey), crypto.SHA256, digest[:], sig)
+	case AlgEdDSA:
+		if !ed25519.Verify(k.public.(ed25519.PublicKey), input, sig) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm '%s'", k.Algorithm)
+}
+
+func (k *Key) jwk() (JWK, bool) {
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: k.ID,
+			Alg: k.Algorithm,
+			Use: "sig",
+			N:   b64.EncodeToString(pub.N.Bytes()),
+			E:   b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: k.ID,
+			Alg: k.Algorithm,
+			Use: "sig",
+			Crv: "Ed25519",
+			X:   b64.EncodeToString(pub),
+		}, true
+	}
+	return JWK{}, false
+}
+
+// ParseKey returns the key with the given ID and algorithm. material is the secret for HS256, and a
+// PEM encoded private key (PKCS #1 or PKCS #8) for RS256 and EdDSA. A PEM encoded public key (PKIX)
+// can be used as well, for keys which are only used for verifying, e.g. after a rotation
+func ParseKey(id, algorithm string, material []byte) (*Key, error) {
+	if id == "" {
+		return nil, errors.New("jwt: key ID is required")
+	}
+
+	if algorithm == AlgHS256 {
+		if len(material) < minHMACSecretLength {
+			return nil, fmt.Errorf("jwt: HS256 secret must be at least %d bytes", minHMACSecretLength)
+		}
+		return &Key{ID: id, Algorithm: algorithm, secret: material}, nil
+	}
+
+	block, _ := pem.Decode(material)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: key '%s' is not PEM encoded", id)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("jwt: unsupported PEM block '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt: parse key '%s': %w", id, err)
+	}
+
+	k := &Key{ID: id, Algorithm: algorithm}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		k.signer = signer
+		k.public = signer.Public()
+	} else {
+		k.public = parsed
+	}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		if algorithm != AlgRS256 {
+			return nil, fmt.Errorf("jwt: key '%s' is an RSA key, expected algorithm %s", id, AlgRS256)
+		}
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("jwt: RSA key '%s' must be at least %d bits", id, minRSAKeyBits)
+		}
+	case ed25519.PublicKey:
+		if algorithm != AlgEdDSA {
+			return nil, fmt.Errorf("jwt: key '%s' is an Ed25519 key, expected algorithm %s", id, AlgEdDSA)
+		}
+	default:
+		return nil, fmt.Errorf("jwt: key '%s' is of an unsupported type %T", id, k.public)
+	}
+
+	return k, nil
+}
+
+// GenerateKey returns a new random Ed25519 key with the given ID
+func GenerateKey(id string) (*Key, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: generate key: %w", err)
+	}
+	return &Key{ID: id, Algorithm: AlgEdDSA, signer: priv, public: pub}, nil
+}
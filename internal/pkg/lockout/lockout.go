@@ -0,0 +1,349 @@
+// Package lockout counts failed attempts per key, e.g. failed logins per account, and slows down then
+// locks out keys with too many of them, to protect credentials against brute-force attacks.
+package lockout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+var (
+	// ErrLocked is matched by all the errors returned when a key has to wait before its next attempt
+	ErrLocked = errors.New("too many failed attempts")
+)
+
+// LockedError is returned when a key is locked out, or has to wait before its next attempt because
+// of the progressive delays. It matches ErrLocked with errors.Is
+type LockedError struct {
+	// RetryAfter is the time until the next attempt is allowed
+	RetryAfter time.Duration
+	// Locked is set if the key reached the maximum number of failures, and not only has to wait
+	Locked bool
+}
+
+func (le *LockedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrLocked.Error(), le.RetryAfter.Round(time.Second))
+}
+
+func (le *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Policy sets how a key is slowed down and locked out as its failed attempts add up
+type Policy struct {
+	// MaxFailures is the number of failures within Window after which the key is locked out
+	MaxFailures int
+	// Window is the time after the last failure at which all the failures of the key are forgotten
+	Window time.Duration
+	// Lockout is the time a key is locked out for, after which it's unlocked automatically
+	Lockout time.Duration
+	// DelayAfter is the number of failures after which every attempt has to wait, starting with
+	// BaseDelay and doubling with every failure up to MaxDelay. Delays are disabled if it's 0
+	DelayAfter int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// wait returns the time the key has to wait after its failures-th failure, and if it's locked out
+func (p *Policy) wait(failures int64) (time.Duration, bool) {
+	if p.MaxFailures > 0 && failures >= int64(p.MaxFailures) {
+		return p.Lockout, true
+	}
+	if p.DelayAfter <= 0 || failures < int64(p.DelayAfter) {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := int64(p.DelayAfter); i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay, false
+}
+
+// ttl returns the time the failures of a key are kept after the last one
+func (p *Policy) ttl(wait time.Duration) time.Duration {
+	if wait > p.Window {
+		return wait
+	}
+	return p.Window
+}
+
+// schedule returns the waits in milliseconds after each failure, from the first one up to the one
+// after which the wait does not change anymore, so that scripts can apply the policy atomically
+func (p *Policy) schedule() []int64 {
+	waits := make([]int64, 0)
+	for failures := int64(1); ; failures++ {
+		wait, locked := p.wait(failures)
+		waits = append(waits, wait.Milliseconds())
+		if locked {
+			return waits
+		}
+		if p.MaxFailures <= 0 && (p.DelayAfter <= 0 || (failures >= int64(p.DelayAfter) && wait >= p.MaxDelay)) {
+			return waits
+		}
+	}
+}
+
+// State is the state of a key after counting an attempt
+type State struct {
+	Failures int
+	// Locked is set if the key reached the maximum number of failures
+	Locked bool
+	// RetryAfter is the time until the next attempt is allowed, 0 if it's allowed right away
+	RetryAfter time.Duration
+}
+
+// Err returns a LockedError if the key has to wait before its next attempt, nil otherwise
+func (s *State) Err() error {
+	if s.RetryAfter <= 0 {
+		return nil
+	}
+	return &LockedError{RetryAfter: s.RetryAfter, Locked: s.Locked}
+}
+
+// Guard keeps the failed attempts of keys. Attempts are counted as failures before the credentials
+// are checked, so that concurrent attempts cannot get more checks than the policy allows
+type Guard interface {
+	// Attempt returns a *LockedError if key has to wait before its next attempt, in which case the
+	// credentials must not be checked at all. Otherwise the attempt is counted as a failure, and the
+	// policy is applied to it, until it's reset or released once the credentials turn out valid
+	Attempt(ctx context.Context, key string, policy *Policy) (*State, error)
+	// Release uncounts an attempt of key which succeeded, and lifts the wait it caused if any. The
+	// previous failures of key are kept
+	Release(ctx context.Context, key string, policy *Policy) error
+	// Reset forgets all the failed attempts of key, which unlocks it
+	Reset(ctx context.Context, key string) error
+}
+
+// attemptScript rejects the attempt if the key has to wait, otherwise it counts the attempt as a
+// failure, sets the wait after it and keeps the failures for at least the window after it. It returns
+// the failures, 0 if the attempt was rejected, the wait in milliseconds and 1 if the key is locked out.
+// KEYS[1] is the key, ARGV[1] the Unix time in milliseconds, ARGV[2] the window in milliseconds,
+// ARGV[3] the maximum number of failures and the rest the waits of Policy.schedule
+var attemptScript = redis.NewScript(1, `
+local now = tonumber(ARGV[1])
+local state = redis.call('HMGET', KEYS[1], 'until', 'locked')
+local waitUntil = tonumber(state[1] or '0')
+if waitUntil > now then
+	return {0, waitUntil - now, tonumber(state[2] or '0')}
+end
+
+local failures = redis.call('HINCRBY', KEYS[1], 'failures', 1)
+local maxFailures = tonumber(ARGV[3])
+local locked = 0
+if maxFailures > 0 and failures >= maxFailures then
+	locked = 1
+end
+local wait = tonumber(ARGV[3 + math.min(failures, #ARGV - 3)])
+if wait > 0 then
+	redis.call('HSET', KEYS[1], 'until', now + wait, 'locked', locked)
+end
+local ttl = math.max(tonumber(ARGV[2]), wait)
+if redis.call('PTTL', KEYS[1]) < ttl then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return {failures, wait, locked}
+`)
+
+// releaseScript uncounts an attempt, and lifts the wait of the key if the remaining failures do not
+// cause any. Keys reset since the attempt are left alone. KEYS[1] is the key, ARGV the waits of
+// Policy.schedule
+var releaseScript = redis.NewScript(1, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local failures = redis.call('HINCRBY', KEYS[1], 'failures', -1)
+if failures <= 0 then
+	redis.call('DEL', KEYS[1])
+	return 0
+end
+if tonumber(ARGV[math.min(failures, #ARGV)]) == 0 then
+	redis.call('HDEL', KEYS[1], 'until', 'locked')
+end
+return failures
+`)
+
+type redisGuard struct {
+	cache  *cachestore.Handle
+	prefix string
+}
+
+func (rg *redisGuard) conn(ctx context.Context) (redis.Conn, error) {
+	pool := rg.cache.Pool()
+	if pool == nil {
+		return nil, cachestore.ErrCacheNotInitialized
+	}
+	return pool.GetContext(ctx)
+}
+
+func (rg *redisGuard) Attempt(ctx context.Context, key string, policy *Policy) (*State, error) {
+	ctx, span := tracing.StartClient(ctx, "redis EVALSHA", "db.system", "redis", "db.operation", "EVALSHA")
+	defer span.End()
+
+	conn, err := rg.conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("attempt: %w", err)
+	}
+	defer conn.Close()
+
+	args := []interface{}{rg.prefix + key, time.Now().UnixMilli(), policy.Window.Milliseconds(), policy.MaxFailures}
+	for _, wait := range policy.schedule() {
+		args = append(args, wait)
+	}
+	reply, err := redis.Int64s(attemptScript.Do(conn, args...))
+	if err != nil {
+		return nil, fmt.Errorf("attempt: %w", err)
+	}
+
+	failures, wait, locked := reply[0], time.Duration(reply[1])*time.Millisecond, reply[2] == 1
+	if failures == 0 {
+		return nil, &LockedError{RetryAfter: wait, Locked: locked}
+	}
+	return &State{Failures: int(failures), Locked: locked, RetryAfter: wait}, nil
+}
+
+func (rg *redisGuard) Release(ctx context.Context, key string, policy *Policy) error {
+	ctx, span := tracing.StartClient(ctx, "redis EVALSHA", "db.system", "redis", "db.operation", "EVALSHA")
+	defer span.End()
+
+	conn, err := rg.conn(ctx)
+	if err != nil {
+		return fmt.Errorf("release: %w", err)
+	}
+	defer conn.Close()
+
+	args := []interface{}{rg.prefix + key}
+	for _, wait := range policy.schedule() {
+		args = append(args, wait)
+	}
+	_, err = releaseScript.Do(conn, args...)
+	if err != nil {
+		return fmt.Errorf("release: %w", err)
+	}
+	return nil
+}
+
+func (rg *redisGuard) Reset(ctx context.Context, key string) error {
+	ctx, span := tracing.StartClient(ctx, "redis DEL", "db.system", "redis", "db.operation", "DEL")
+	defer span.End()
+
+	conn, err := rg.conn(ctx)
+	if err != nil {
+		return fmt.Errorf("reset: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("DEL", rg.prefix+key)
+	if err != nil {
+		return fmt.Errorf("reset: %w", err)
+	}
+	return nil
+}
+
+// NewRedisGuard returns a Guard which keeps the failed attempts in Redis, so that they're shared by
+// all instances of the app. All keys are prefixed with prefix
+func NewRedisGuard(cache *cachestore.Handle, prefix string) Guard {
+	if cache == nil {
+		cache = cachestore.NewHandle(nil)
+	}
+	return &redisGuard{cache: cache, prefix: prefix}
+}
+
+type memoryEntry struct {
+	failures  int64
+	until     time.Time
+	locked    bool
+	expiresAt time.Time
+}
+
+// MemoryGuard is a thread-safe, in-memory implementation of Guard. It's meant for tests and local
+// development, since the failed attempts are not shared with other instances of the app
+type MemoryGuard struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+func (mg *MemoryGuard) sweep(now time.Time) {
+	for k, e := range mg.entries {
+		if !now.Before(e.expiresAt) {
+			delete(mg.entries, k)
+		}
+	}
+}
+
+func (mg *MemoryGuard) Attempt(ctx context.Context, key string, policy *Policy) (*State, error) {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+
+	now := time.Now()
+	mg.sweep(now)
+
+	e, ok := mg.entries[key]
+	if !ok {
+		e = new(memoryEntry)
+		mg.entries[key] = e
+	}
+	if now.Before(e.until) {
+		return nil, &LockedError{RetryAfter: e.until.Sub(now), Locked: e.locked}
+	}
+	e.failures++
+
+	wait, locked := policy.wait(e.failures)
+	if wait > 0 {
+		e.until = now.Add(wait)
+		e.locked = locked
+	}
+	if expiresAt := now.Add(policy.ttl(wait)); expiresAt.After(e.expiresAt) {
+		e.expiresAt = expiresAt
+	}
+
+	return &State{Failures: int(e.failures), Locked: locked, RetryAfter: wait}, nil
+}
+
+func (mg *MemoryGuard) Release(ctx context.Context, key string, policy *Policy) error {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+
+	mg.sweep(time.Now())
+
+	e, ok := mg.entries[key]
+	if !ok {
+		return nil
+	}
+	e.failures--
+	if e.failures <= 0 {
+		delete(mg.entries, key)
+		return nil
+	}
+	if wait, _ := policy.wait(e.failures); wait == 0 {
+		e.until = time.Time{}
+		e.locked = false
+	}
+	return nil
+}
+
+func (mg *MemoryGuard) Reset(ctx context.Context, key string) error {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+
+	delete(mg.entries, key)
+	return nil
+}
+
+// NewMemoryGuard returns a new MemoryGuard, without any failed attempt
+func NewMemoryGuard() *MemoryGuard {
+	return &MemoryGuard{
+		entries: make(map[string]*memoryEntry),
+	}
+}
@@ -0,0 +1,310 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicyWait(t *testing.T) {
+	policy := &Policy{
+		MaxFailures: 8,
+		Window:      time.Minute,
+		Lockout:     time.Hour,
+		DelayAfter:  3,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Second * 5,
+	}
+
+	tests := []struct {
+		failures   int64
+		wantWait   time.Duration
+		wantLocked bool
+	}{
+		{failures: 1, wantWait: 0},
+		{failures: 2, wantWait: 0},
+		{failures: 3, wantWait: time.Second},
+		{failures: 4, wantWait: time.Second * 2},
+		{failures: 5, wantWait: time.Second * 4},
+		{failures: 6, wantWait: time.Second * 5},
+		{failures: 7, wantWait: time.Second * 5},
+		{failures: 8, wantWait: time.Hour, wantLocked: true},
+		{failures: 20, wantWait: time.Hour, wantLocked: true},
+	}
+
+	for _, tt := range tests {
+		wait, locked := policy.wait(tt.failures)
+		if wait != tt.wantWait || locked != tt.wantLocked {
+			t.Errorf("wait(%d) = %s, %v, want %s, %v", tt.failures, wait, locked, tt.wantWait, tt.wantLocked)
+		}
+	}
+}
+
+func TestPolicySchedule(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   []int64
+	}{
+		{
+			name:   "delays then lockout",
+			policy: Policy{MaxFailures: 5, Lockout: time.Minute, DelayAfter: 2, BaseDelay: time.Second, MaxDelay: time.Second * 3},
+			want:   []int64{0, 1000, 2000, 3000, 60000},
+		},
+		{
+			name:   "lockout only",
+			policy: Policy{MaxFailures: 3, Lockout: time.Minute},
+			want:   []int64{0, 0, 60000},
+		},
+		{
+			name:   "delays only",
+			policy: Policy{DelayAfter: 1, BaseDelay: time.Second, MaxDelay: time.Second * 4},
+			want:   []int64{1000, 2000, 4000},
+		},
+		{
+			name:   "no wait",
+			policy: Policy{},
+			want:   []int64{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.schedule()
+			if len(got) != len(tt.want) {
+				t.Fatalf("schedule() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("schedule() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryGuardAttempt(t *testing.T) {
+	policy := &Policy{
+		MaxFailures: 3,
+		Window:      time.Minute,
+		Lockout:     time.Hour,
+	}
+
+	tests := []struct {
+		name       string
+		attempts   int
+		wantLocked bool
+		wantErr    error
+	}{
+		{name: "below the maximum", attempts: 2},
+		{name: "reaching the maximum", attempts: 3, wantLocked: true},
+		{name: "after the lockout", attempts: 4, wantLocked: true, wantErr: ErrLocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mg := NewMemoryGuard()
+
+			var (
+				state *State
+				err   error
+			)
+			for i := 0; i < tt.attempts; i++ {
+				var s *State
+				s, err = mg.Attempt(ctx, "key", policy)
+				if s != nil {
+					state = s
+				}
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Attempt error = %v, want %v", err, tt.wantErr)
+			}
+			if state.Locked != tt.wantLocked {
+				t.Errorf("Locked = %v, want %v", state.Locked, tt.wantLocked)
+			}
+			if tt.wantErr != nil {
+				var lockedErr *LockedError
+				if !errors.As(err, &lockedErr) || !lockedErr.Locked || lockedErr.RetryAfter <= 0 {
+					t.Errorf("Attempt error = %#v, want a locked *LockedError", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryGuardProgressiveDelay(t *testing.T) {
+	ctx := context.Background()
+	mg := NewMemoryGuard()
+	policy := &Policy{
+		Window:     time.Minute,
+		DelayAfter: 2,
+		BaseDelay:  time.Millisecond * 50,
+		MaxDelay:   time.Second,
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := mg.Attempt(ctx, "key", policy)
+		if err != nil {
+			t.Fatalf("Attempt %d: %v", i+1, err)
+		}
+	}
+
+	var lockedErr *LockedError
+	_, err := mg.Attempt(ctx, "key", policy)
+	if !errors.As(err, &lockedErr) || lockedErr.Locked {
+		t.Fatalf("Attempt error = %v, want a delay", err)
+	}
+
+	time.Sleep(lockedErr.RetryAfter)
+	state, err := mg.Attempt(ctx, "key", policy)
+	if err != nil {
+		t.Fatalf("Attempt after the delay: %v", err)
+	}
+	if state.Failures != 3 || state.RetryAfter != time.Millisecond*100 {
+		t.Errorf("state = %+v, want 3 failures and a doubled delay", state)
+	}
+}
+
+func TestMemoryGuardConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	mg := NewMemoryGuard()
+	policy := &Policy{
+		MaxFailures: 5,
+		Window:      time.Minute,
+		Lockout:     time.Hour,
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := mg.Attempt(ctx, "key", policy)
+			if err == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != policy.MaxFailures {
+		t.Errorf("allowed %d concurrent attempts, want %d", allowed, policy.MaxFailures)
+	}
+}
+
+func TestMemoryGuardRelease(t *testing.T) {
+	policy := &Policy{
+		MaxFailures: 3,
+		Window:      time.Minute,
+		Lockout:     time.Hour,
+	}
+
+	tests := []struct {
+		name         string
+		attempts     int
+		wantFailures int
+	}{
+		{name: "only attempt", attempts: 1, wantFailures: 1},
+		{name: "after failures", attempts: 2, wantFailures: 2},
+		{name: "lifts the lockout it caused", attempts: 3, wantFailures: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mg := NewMemoryGuard()
+
+			for i := 0; i < tt.attempts; i++ {
+				_, err := mg.Attempt(ctx, "key", policy)
+				if err != nil {
+					t.Fatalf("Attempt %d: %v", i+1, err)
+				}
+			}
+			err := mg.Release(ctx, "key", policy)
+			if err != nil {
+				t.Fatalf("Release: %v", err)
+			}
+
+			// the released attempt is not counted, so the next one is counted in its place
+			state, err := mg.Attempt(ctx, "key", policy)
+			if err != nil {
+				t.Fatalf("Attempt after Release: %v", err)
+			}
+			if state.Failures != tt.wantFailures {
+				t.Errorf("Failures = %d, want %d", state.Failures, tt.wantFailures)
+			}
+		})
+	}
+}
+
+func TestMemoryGuardReset(t *testing.T) {
+	ctx := context.Background()
+	mg := NewMemoryGuard()
+	policy := &Policy{
+		MaxFailures: 1,
+		Window:      time.Minute,
+		Lockout:     time.Hour,
+	}
+
+	_, err := mg.Attempt(ctx, "key", policy)
+	if err != nil {
+		t.Fatalf("Attempt: %v", err)
+	}
+	_, err = mg.Attempt(ctx, "key", policy)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Attempt error = %v, want %v", err, ErrLocked)
+	}
+	// other keys are not affected
+	_, err = mg.Attempt(ctx, "other", policy)
+	if err != nil {
+		t.Fatalf("Attempt of another key: %v", err)
+	}
+
+	err = mg.Reset(ctx, "key")
+	if err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	state, err := mg.Attempt(ctx, "key", policy)
+	if err != nil {
+		t.Fatalf("Attempt after Reset: %v", err)
+	}
+	if state.Failures != 1 {
+		t.Errorf("Failures = %d, want 1", state.Failures)
+	}
+}
+
+func TestMemoryGuardWindow(t *testing.T) {
+	ctx := context.Background()
+	mg := NewMemoryGuard()
+	policy := &Policy{
+		MaxFailures: 3,
+		Window:      time.Millisecond * 50,
+		Lockout:     time.Millisecond * 50,
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := mg.Attempt(ctx, "key", policy)
+		if err != nil {
+			t.Fatalf("Attempt %d: %v", i+1, err)
+		}
+	}
+
+	time.Sleep(policy.Window)
+	state, err := mg.Attempt(ctx, "key", policy)
+	if err != nil {
+		t.Fatalf("Attempt after the window: %v", err)
+	}
+	if state.Failures != 1 {
+		t.Errorf("Failures = %d, want the failures before the window to be forgotten", state.Failures)
+	}
+}
@@ -0,0 +1,29 @@
+package logger
+
+import (
+	"context"
+)
+
+type fieldsCtxKey struct{}
+
+// ContextWithFields returns a copy of ctx which carries the given key/value pairs, in addition to
+// the ones already in ctx. Loggers returned by WithContext add these fields to every log, so that
+// request scoped fields like request ID are available in all logs of a request
+func ContextWithFields(ctx context.Context, fields ...interface{}) context.Context {
+	if len(fields)%2 != 0 {
+		fields = append(fields, nil)
+	}
+
+	existing := FieldsFromContext(ctx)
+	merged := make([]interface{}, 0, len(existing)+len(fields))
+	merged = append(merged, existing...)
+	merged = append(merged, fields...)
+
+	return context.WithValue(ctx, fieldsCtxKey{}, merged)
+}
+
+// FieldsFromContext returns all the key/value pairs stored in ctx by ContextWithFields
+func FieldsFromContext(ctx context.Context) []interface{} {
+	fields, _ := ctx.Value(fieldsCtxKey{}).([]interface{})
+	return fields
+}
@@ -4,14 +4,21 @@
 package logger
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
+	// LogTypeDebug is for logging type 'debug'
+	LogTypeDebug = "debug"
 	// LogTypeInfo is for logging type 'info'
 	LogTypeInfo = "info"
 	// LogTypeWarn is for logging type 'warn'
@@ -22,12 +29,78 @@ const (
 	LogTypeFatal = "fatal"
 )
 
+// Level is the severity of a log. Logs with severity lower than the minimum level of the logger are dropped
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: LogTypeDebug,
+	LevelInfo:  LogTypeInfo,
+	LevelWarn:  LogTypeWarn,
+	LevelError: LogTypeError,
+	LevelFatal: LogTypeFatal,
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the Level with the given name, e.g. 'info'
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for level, levelName := range levelNames {
+		if levelName == name {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level '%s'", name)
+}
+
 // Logger interface defines all the logging methods to be implemented
 type Logger interface {
+	Debug(payload ...interface{}) error
 	Info(payload ...interface{}) error
 	Warn(payload ...interface{}) error
 	Error(payload ...interface{}) error
 	Fatal(payload ...interface{}) error
+
+	Debugf(format string, args ...interface{}) error
+	Infof(format string, args ...interface{}) error
+	Warnf(format string, args ...interface{}) error
+	Errorf(format string, args ...interface{}) error
+	Fatalf(format string, args ...interface{}) error
+
+	// With returns a Logger which adds the given key/value pairs to every log
+	With(fields ...interface{}) Logger
+	// WithContext returns a Logger which adds all the fields stored in ctx, by ContextWithFields, to every log
+	WithContext(ctx context.Context) Logger
+}
+
+// output is shared by a LogHandler and all the loggers derived from it using With, so that the
+// level and writers can be changed for all of them at once
+type output struct {
+	level int32
+
+	mu      sync.Mutex
+	writer  io.Writer
+	closers []io.Closer
+}
+
+func (o *output) enabled(level Level) bool {
+	return Level(atomic.LoadInt32(&o.level)) <= level
+}
+
+func (o *output) write(p []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, _ = o.writer.Write(p)
 }
 
 // LogHandler implements Logger
@@ -35,6 +108,16 @@ type LogHandler struct {
 	Skipstack  int
 	appName    string
 	appVersion string
+	fields     []interface{}
+	out        *output
+}
+
+// Config holds all the configuration required for this package
+type Config struct {
+	// Level is the minimum level of logs which are written
+	Level string `json:"level"`
+	// Outputs are where logs are written to. Each could be 'stdout', 'stderr' or a file path
+	Outputs []string `json:"outputs"`
 }
 
 func (lh *LogHandler) defaultPayload(severity string) map[string]interface{} {
@@ -50,8 +133,24 @@ func (lh *LogHandler) defaultPayload(severity string) map[string]interface{} {
 
 func (lh *LogHandler) serialize(severity string, data ...interface{}) (string, error) {
 	payload := lh.defaultPayload(severity)
-	for idx, value := range data {
-		payload[fmt.Sprintf("%d", idx)] = value
+	for idx := 0; idx < len(lh.fields); idx += 2 {
+		key := fmt.Sprint(lh.fields[idx])
+		if _, reserved := payload[key]; reserved {
+			continue
+		}
+
+		var value interface{}
+		if idx+1 < len(lh.fields) {
+			value = lh.fields[idx+1]
+		}
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		payload[key] = value
+	}
+
+	if len(data) > 0 {
+		payload["message"] = strings.TrimSuffix(fmt.Sprintln(data...), "\n")
 	}
 
 	b, err := json.Marshal(payload)
@@ -62,45 +161,179 @@ func (lh *LogHandler) serialize(severity string, data ...interface{}) (string, e
 	return string(b), nil
 }
 
-func (lh *LogHandler) log(severity string, payload ...interface{}) error {
-	out, err := lh.serialize(severity, payload...)
+func (lh *LogHandler) log(level Level, payload ...interface{}) error {
+	if !lh.out.enabled(level) {
+		return nil
+	}
+
+	out, err := lh.serialize(level.String(), payload...)
 	if err != nil {
 		return err
 	}
 
-	switch severity {
-	case "fatal":
-		{
-			fmt.Println(out)
-			os.Exit(1)
-		}
+	lh.out.write([]byte(out + "\n"))
+	if level == LevelFatal {
+		os.Exit(1)
 	}
-	fmt.Println(out)
 
 	return nil
 }
 
+// Debug is for logging items with severity 'Debug'
+func (lh *LogHandler) Debug(payload ...interface{}) error {
+	return lh.log(LevelDebug, payload...)
+}
+
 // Info is for logging items with severity 'info'
 func (lh *LogHandler) Info(payload ...interface{}) error {
-	return lh.log(LogTypeInfo, payload...)
+	return lh.log(LevelInfo, payload...)
 }
 
 // Warn is for logging items with severity 'Warn'
 func (lh *LogHandler) Warn(payload ...interface{}) error {
-	return lh.log(LogTypeWarn, payload...)
+	return lh.log(LevelWarn, payload...)
 }
 
 // Error is for logging items with severity 'Error'
 func (lh *LogHandler) Error(payload ...interface{}) error {
-	return lh.log(LogTypeError, payload...)
+	return lh.log(LevelError, payload...)
 }
 
 // Fatal is for logging items with severity 'Fatal'
 func (lh *LogHandler) Fatal(payload ...interface{}) error {
-	return lh.log(LogTypeFatal, payload...)
+	return lh.log(LevelFatal, payload...)
+}
+
+// Debugf is for logging a formatted message with severity 'Debug'
+func (lh *LogHandler) Debugf(format string, args ...interface{}) error {
+	return lh.log(LevelDebug, fmt.Sprintf(format, args...))
+}
+
+// Infof is for logging a formatted message with severity 'Info'
+func (lh *LogHandler) Infof(format string, args ...interface{}) error {
+	return lh.log(LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// Warnf is for logging a formatted message with severity 'Warn'
+func (lh *LogHandler) Warnf(format string, args ...interface{}) error {
+	return lh.log(LevelWarn, fmt.Sprintf(format, args...))
+}
+
+// Errorf is for logging a formatted message with severity 'Error'
+func (lh *LogHandler) Errorf(format string, args ...interface{}) error {
+	return lh.log(LevelError, fmt.Sprintf(format, args...))
+}
+
+// Fatalf is for logging a formatted message with severity 'Fatal'
+func (lh *LogHandler) Fatalf(format string, args ...interface{}) error {
+	return lh.log(LevelFatal, fmt.Sprintf(format, args...))
+}
+
+// With returns a new LogHandler which adds the key/value pairs in fields to every log, e.g.
+// With("userID", id, "attempt", 2). Level and outputs are shared with lh
+func (lh *LogHandler) With(fields ...interface{}) Logger {
+	return lh.with(fields...)
+}
+
+func (lh *LogHandler) with(fields ...interface{}) *LogHandler {
+	if len(fields)%2 != 0 {
+		fields = append(fields, nil)
+	}
+
+	child := *lh
+	child.fields = make([]interface{}, 0, len(lh.fields)+len(fields))
+	child.fields = append(child.fields, lh.fields...)
+	child.fields = append(child.fields, fields...)
+
+	return &child
+}
+
+// WithContext returns a new LogHandler which adds all the fields stored in ctx to every log
+func (lh *LogHandler) WithContext(ctx context.Context) Logger {
+	fields := FieldsFromContext(ctx)
+	if len(fields) == 0 {
+		return lh
+	}
+	return lh.with(fields...)
+}
+
+// SetLevel sets the minimum level of logs which are written, for lh and all loggers derived from it
+func (lh *LogHandler) SetLevel(level Level) {
+	atomic.StoreInt32(&lh.out.level, int32(level))
+}
+
+// SetOutput sets the writers where logs are written to, for lh and all loggers derived from it
+func (lh *LogHandler) SetOutput(writers ...io.Writer) {
+	lh.out.mu.Lock()
+	defer lh.out.mu.Unlock()
+	lh.out.writer = io.MultiWriter(writers...)
+}
+
+// Configure sets the level and outputs of lh as per cfg. Files in the outputs are opened in append mode
+// and are closed on Close
+func (lh *LogHandler) Configure(cfg *Config) error {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+
+	writers := make([]io.Writer, 0, len(cfg.Outputs))
+	closers := make([]io.Closer, 0, len(cfg.Outputs))
+	for _, name := range cfg.Outputs {
+		switch name = strings.TrimSpace(name); name {
+		case "", "stdout":
+			writers = append(writers, os.Stdout)
+		case "stderr":
+			writers = append(writers, os.Stderr)
+		default:
+			f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				for _, c := range closers {
+					_ = c.Close()
+				}
+				return fmt.Errorf("open log output: %w", err)
+			}
+			writers = append(writers, f)
+			closers = append(closers, f)
+		}
+	}
+	if len(writers) == 0 {
+		writers = append(writers, os.Stdout)
+	}
+
+	lh.SetLevel(level)
+	lh.SetOutput(writers...)
+
+	lh.out.mu.Lock()
+	previous := lh.out.closers
+	lh.out.closers = closers
+	lh.out.mu.Unlock()
+	for _, c := range previous {
+		_ = c.Close()
+	}
+
+	return nil
+}
+
+// Close closes all the files opened as outputs by Configure
+func (lh *LogHandler) Close() error {
+	lh.out.mu.Lock()
+	defer lh.out.mu.Unlock()
+
+	var err error
+	for _, c := range lh.out.closers {
+		if cerr := c.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	lh.out.closers = nil
+	lh.out.writer = os.Stdout
+
+	return err
 }
 
-// New returns a new instance of LogHandler
+// New returns a new instance of LogHandler. It writes all logs of level 'info' and above to stdout,
+// until configured otherwise
 func New(appname string, appversion string, skipStack uint) *LogHandler {
 	if skipStack <= 1 {
 		skipStack = 4
@@ -110,5 +343,9 @@ func New(appname string, appversion string, skipStack uint) *LogHandler {
 		Skipstack:  int(skipStack),
 		appName:    appname,
 		appVersion: appversion,
+		out: &output{
+			level:  int32(LevelInfo),
+			writer: os.Stdout,
+		},
 	}
 }
@@ -0,0 +1,193 @@
+// Package mailer sends plain text emails, either using an SMTP server, or by writing them to a file or
+// stdout for local development and tests.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DriverSMTP sends emails using an SMTP server
+	DriverSMTP = "smtp"
+	// DriverFile appends emails to a file
+	DriverFile = "file"
+	// DriverStdout writes emails to stdout
+	DriverStdout = "stdout"
+
+	// TLSStartTLS upgrades the connection with STARTTLS, which the server must support
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects using TLS right away, usually on port 465
+	TLSImplicit = "tls"
+	// TLSNone sends emails in plain text. It's only meant for local SMTP servers
+	TLSNone = "none"
+)
+
+var (
+	// ErrInvalidMessage is returned for messages with missing or malformed fields
+	ErrInvalidMessage = errors.New("invalid message")
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Config holds all the configuration required for the mailer package
+type Config struct {
+	// Driver is one of 'smtp', 'file' or 'stdout'
+	Driver string `json:"driver"`
+	// From is the sender of all emails, e.g. 'goapp <no-reply@example.com>'
+	From string `json:"from"`
+
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUser     string `json:"smtp_user"`
+	SMTPPassword string `json:"smtp_password"`
+	// SMTPTLS is one of 'starttls', 'tls' or 'none'
+	SMTPTLS           string `json:"smtp_tls"`
+	SMTPTimeoutSecond int    `json:"smtp_timeout_second"`
+
+	// FilePath is the file emails are appended to by the file driver
+	FilePath string `json:"file_path"`
+}
+
+// compose returns msg in the Internet Message Format (RFC 5322), ready to be sent
+func compose(from *mail.Address, to *mail.Address, msg *Message) ([]byte, error) {
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, fmt.Errorf("%w: subject contains a line break", ErrInvalidMessage)
+	}
+
+	id := make([]byte, 16)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(id)
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	// bare line feeds are not allowed by SMTP
+	body := strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n")
+	buf.WriteString(body)
+	if !strings.HasSuffix(body, "\r\n") {
+		buf.WriteString("\r\n")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// parseRecipient returns the address of msg's recipient
+func parseRecipient(msg *Message) (*mail.Address, error) {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("%w: recipient: %s", ErrInvalidMessage, err.Error())
+	}
+	return to, nil
+}
+
+// writerMailer writes emails to a writer, separated by a line, so that they can be read by humans
+// or tests
+type writerMailer struct {
+	mu   sync.Mutex
+	from *mail.Address
+	// open returns the writer for a single email, and a function which closes it
+	open func() (io.Writer, func() error, error)
+}
+
+func (wm *writerMailer) Send(ctx context.Context, msg *Message) error {
+	to, err := parseRecipient(msg)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	raw, err := compose(wm.from, to, msg)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	w, closeFn, err := wm.open()
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "%s\r\n%s", raw, "-----\r\n")
+	cerr := closeFn()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	return nil
+}
+
+// NewFileMailer returns a Mailer which appends emails to the file at path
+func NewFileMailer(from *mail.Address, path string) Mailer {
+	return &writerMailer{
+		from: from,
+		open: func() (io.Writer, func() error, error) {
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+			if err != nil {
+				return nil, nil, err
+			}
+			return f, f.Close, nil
+		},
+	}
+}
+
+// NewWriterMailer returns a Mailer which writes emails to w, e.g. stdout
+func NewWriterMailer(from *mail.Address, w io.Writer) Mailer {
+	return &writerMailer{
+		from: from,
+		open: func() (io.Writer, func() error, error) {
+			return w, func() error { return nil }, nil
+		},
+	}
+}
+
+// NewService returns the Mailer of the configured driver
+func NewService(cfg *Config) (Mailer, error) {
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: invalid sender '%s': %w", cfg.From, err)
+	}
+
+	switch cfg.Driver {
+	case DriverSMTP:
+		return newSMTPMailer(cfg, from)
+	case DriverFile:
+		if cfg.FilePath == "" {
+			return nil, errors.New("mailer: file path is required")
+		}
+		return NewFileMailer(from, cfg.FilePath), nil
+	case DriverStdout, "":
+		return NewWriterMailer(from, os.Stdout), nil
+	}
+	return nil, fmt.Errorf("mailer: unknown driver '%s'", cfg.Driver)
+}
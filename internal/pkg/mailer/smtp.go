@@ -0,0 +1,151 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+const defaultSMTPTimeout = time.Second * 10
+
+// smtpMailer sends emails using an SMTP server, with a new connection for every email
+type smtpMailer struct {
+	from      *mail.Address
+	addr      string
+	host      string
+	auth      smtp.Auth
+	tlsMode   string
+	timeout   time.Duration
+	tlsConfig *tls.Config
+}
+
+// dial connects to the server, using TLS right away in the implicit TLS mode
+func (sm *smtpMailer) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: sm.timeout}
+	if sm.tlsMode == TLSImplicit {
+		td := &tls.Dialer{NetDialer: dialer, Config: sm.tlsConfig}
+		return td.DialContext(ctx, "tcp", sm.addr)
+	}
+	return dialer.DialContext(ctx, "tcp", sm.addr)
+}
+
+func (sm *smtpMailer) Send(ctx context.Context, msg *Message) error {
+	ctx, span := tracing.StartClient(ctx, "smtp send", "net.peer.name", sm.host)
+	defer span.End()
+
+	err := sm.send(ctx, msg)
+	span.RecordError(err)
+	return err
+}
+
+func (sm *smtpMailer) send(ctx context.Context, msg *Message) error {
+	to, err := parseRecipient(msg)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	raw, err := compose(sm.from, to, msg)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sm.timeout)
+	defer cancel()
+
+	conn, err := sm.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, sm.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("send: %w", err)
+	}
+	defer client.Close()
+
+	if sm.tlsMode == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("send: server does not support STARTTLS")
+		}
+		err = client.StartTLS(sm.tlsConfig)
+		if err != nil {
+			return fmt.Errorf("send: %w", err)
+		}
+	}
+
+	if sm.auth != nil {
+		err = client.Auth(sm.auth)
+		if err != nil {
+			return fmt.Errorf("send: %w", err)
+		}
+	}
+
+	err = client.Mail(sm.from.Address)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	err = client.Rcpt(to.Address)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	_, err = w.Write(raw)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	return client.Quit()
+}
+
+func newSMTPMailer(cfg *Config, from *mail.Address) (*smtpMailer, error) {
+	if cfg.SMTPHost == "" {
+		return nil, errors.New("mailer: smtp host is required")
+	}
+
+	tlsMode := cfg.SMTPTLS
+	switch tlsMode {
+	case "":
+		tlsMode = TLSStartTLS
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("mailer: unknown smtp tls mode '%s'", cfg.SMTPTLS)
+	}
+
+	timeout := time.Duration(cfg.SMTPTimeoutSecond) * time.Second
+	if timeout <= 0 {
+		timeout = defaultSMTPTimeout
+	}
+
+	sm := &smtpMailer{
+		from:      from,
+		addr:      net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		host:      cfg.SMTPHost,
+		tlsMode:   tlsMode,
+		timeout:   timeout,
+		tlsConfig: &tls.Config{ServerName: cfg.SMTPHost, MinVersion: tls.VersionTLS12},
+	}
+	if cfg.SMTPUser != "" {
+		// PlainAuth refuses to send credentials over unencrypted connections, except to localhost
+		sm.auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+
+	return sm, nil
+}
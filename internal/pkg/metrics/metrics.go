@@ -0,0 +1,327 @@
+// Package metrics is a minimal registry of application metrics. Packages define their metrics here
+// without depending on any particular exporter, and the registry is exposed in the Prometheus text
+// exposition format by Handler.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the histogram buckets, in seconds, suitable for most latencies
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry where all the metrics of the app are registered
+var Default = NewRegistry()
+
+type collector interface {
+	// write writes all the samples of the metric in text exposition format
+	write(w io.Writer)
+}
+
+// Registry holds all the registered metrics. It's safe for concurrent use
+type Registry struct {
+	mu         sync.RWMutex
+	names      []string
+	collectors map[string]collector
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.collectors[name]; exists {
+		// metrics are registered once at initialization, so a duplicate is a programming error
+		panic(fmt.Sprintf("metrics: '%s' is already registered", name))
+	}
+	r.collectors[name] = c
+	r.names = append(r.names, name)
+	sort.Strings(r.names)
+}
+
+// WriteText writes all the registered metrics in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for _, name := range r.names {
+		r.collectors[name].write(bw)
+	}
+	return bw.Flush()
+}
+
+// NewRegistry returns a new, empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]collector),
+	}
+}
+
+// Handler returns an http.Handler which responds with all the metrics of r
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels returns the labels in text exposition format, e.g. {method="GET",status="200"}
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for idx, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(values[idx])))
+	}
+	for idx := 0; idx+1 < len(extra); idx += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[idx], labelValueEscaper.Replace(extra[idx+1])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// vec holds one series per unique combination of label values
+type vec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.RWMutex
+	keys   []string
+	values map[string][]string
+	series map[string]interface{}
+}
+
+func (v *vec) get(labelValues []string, create func() interface{}) interface{} {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: '%s' expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok = v.series[key]; ok {
+		return s
+	}
+
+	s = create()
+	v.series[key] = s
+	v.values[key] = append([]string(nil), labelValues...)
+	v.keys = append(v.keys, key)
+	sort.Strings(v.keys)
+
+	return s
+}
+
+func (v *vec) each(fn func(labelValues []string, series interface{})) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, key := range v.keys {
+		fn(v.values[key], v.series[key])
+	}
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string][]string),
+		series: make(map[string]interface{}),
+	}
+}
+
+// Counter is a value which only ever increases
+type Counter struct {
+	bits uint64
+}
+
+// Add increases the counter by delta, which must not be negative
+func (c *Counter) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&c.bits, old, updated) {
+			return
+		}
+	}
+}
+
+// Inc increases the counter by 1
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+// CounterVec is a set of counters which share the same name, partitioned by labels
+type CounterVec struct {
+	vec
+}
+
+// With returns the counter for the given label values, in the order of the labels of the CounterVec
+func (cv *CounterVec) With(labelValues ...string) *Counter {
+	return cv.get(labelValues, func() interface{} { return new(Counter) }).(*Counter)
+}
+
+func (cv *CounterVec) write(w io.Writer) {
+	writeHeader(w, cv.name, cv.help, "counter")
+	cv.each(func(labelValues []string, series interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", cv.name, formatLabels(cv.labels, labelValues), formatFloat(series.(*Counter).value()))
+	})
+}
+
+// NewCounterVec registers a new CounterVec in r
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{vec: newVec(name, help, labels)}
+	r.register(name, cv)
+	return cv
+}
+
+// Histogram counts observations in configurable buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe adds a single observation to the histogram
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.count++
+	h.sum += v
+	for idx, upper := range h.buckets {
+		if v <= upper {
+			h.counts[idx]++
+			break
+		}
+	}
+}
+
+// HistogramVec is a set of histograms which share the same name and buckets, partitioned by labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// With returns the histogram for the given label values, in the order of the labels of the HistogramVec
+func (hv *HistogramVec) With(labelValues ...string) *Histogram {
+	return hv.get(labelValues, func() interface{} {
+		return &Histogram{
+			buckets: hv.buckets,
+			counts:  make([]uint64, len(hv.buckets)),
+		}
+	}).(*Histogram)
+}
+
+func (hv *HistogramVec) write(w io.Writer) {
+	writeHeader(w, hv.name, hv.help, "histogram")
+	hv.each(func(labelValues []string, series interface{}) {
+		h := series.(*Histogram)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		cumulative := uint64(0)
+		for idx, upper := range h.buckets {
+			cumulative += h.counts[idx]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, formatLabels(hv.labels, labelValues, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, formatLabels(hv.labels, labelValues, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.name, formatLabels(hv.labels, labelValues), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.name, formatLabels(hv.labels, labelValues), h.count)
+	})
+}
+
+// NewHistogramVec registers a new HistogramVec in r. DefaultBuckets are used if buckets is empty
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	hv := &HistogramVec{
+		vec:     newVec(name, help, labels),
+		buckets: sorted,
+	}
+	r.register(name, hv)
+	return hv
+}
+
+// GaugeFunc is a gauge whose value is read from a function, every time the metrics are collected
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func (gf *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, gf.name, gf.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", gf.name, formatFloat(gf.fn()))
+}
+
+// NewGaugeFunc registers a new GaugeFunc in r
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	gf := &GaugeFunc{
+		name: name,
+		help: help,
+		fn:   fn,
+	}
+	r.register(name, gf)
+	return gf
+}
+
+// NewCounterVec registers a new CounterVec in the Default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewHistogramVec registers a new HistogramVec in the Default registry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewGaugeFunc registers a new GaugeFunc in the Default registry
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return Default.NewGaugeFunc(name, help, fn)
+}
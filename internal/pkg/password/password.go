@@ -0,0 +1,332 @@
+// Package password hashes and verifies passwords, and enforces the password strength policy.
+// Passwords are hashed with argon2id by default, and the hashes are encoded in the PHC string format
+// so that the parameters are stored along with every hash. bcrypt is supported as well, both for
+// hashing and for verifying legacy hashes.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// AlgorithmArgon2id hashes passwords with argon2id, which is recommended
+	AlgorithmArgon2id = "argon2id"
+	// AlgorithmBcrypt hashes passwords with bcrypt. Only the first 72 bytes of a password are used by
+	// bcrypt, so longer passwords are rejected by the policy
+	AlgorithmBcrypt = "bcrypt"
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+	bcryptMaxBytes   = 72
+)
+
+var (
+	// ErrMismatch is returned when a password does not match its hash
+	ErrMismatch = errors.New("password does not match")
+	// ErrInvalidHash is returned for hashes which are not in any of the supported formats
+	ErrInvalidHash = errors.New("invalid password hash")
+)
+
+// commonPasswords are rejected irrespective of their length, since they're the first ones tried
+// in any guessing attack
+var commonPasswords = map[string]struct{}{
+	"password":     {},
+	"password1":    {},
+	"password123":  {},
+	"passw0rd":     {},
+	"123456789":    {},
+	"1234567890":   {},
+	"12345678910":  {},
+	"qwertyuiop":   {},
+	"qwerty123":    {},
+	"1q2w3e4r5t":   {},
+	"iloveyou":     {},
+	"letmein123":   {},
+	"welcome123":   {},
+	"admin12345":   {},
+	"abcdefghij":   {},
+	"changeme123":  {},
+	"trustno1234":  {},
+	"football123":  {},
+	"sunshine123":  {},
+	"superman123":  {},
+	"0987654321":   {},
+	"asdfghjkl":    {},
+	"zxcvbnm123":   {},
+	"1qaz2wsx3edc": {},
+}
+
+// PolicyError is returned for passwords which do not meet the strength policy
+type PolicyError struct {
+	Reason string
+}
+
+func (pe *PolicyError) Error() string {
+	return "weak password: " + pe.Reason
+}
+
+// Config holds all the configuration required for this package
+type Config struct {
+	// Algorithm is either 'argon2id' or 'bcrypt'. Hashes of the other algorithm are still verified,
+	// and are rehashed with this one
+	Algorithm         string `json:"algorithm"`
+	Argon2MemoryKiB   int    `json:"argon2_memory_kib"`
+	Argon2Iterations  int    `json:"argon2_iterations"`
+	Argon2Parallelism int    `json:"argon2_parallelism"`
+	BcryptCost        int    `json:"bcrypt_cost"`
+
+	// MinLength and MaxLength are the limits of the length of passwords, in characters
+	MinLength int `json:"min_length"`
+	MaxLength int `json:"max_length"`
+
+	// MaxConcurrent is the maximum number of passwords hashed or verified at once, the others wait
+	// their turn. Every argon2id hash uses Argon2MemoryKiB of memory, so this bounds the memory used
+	// for hashing to MaxConcurrent * Argon2MemoryKiB however many logins are attempted at once
+	MaxConcurrent int `json:"max_concurrent"`
+}
+
+// Hasher hashes and verifies passwords with the configured algorithm and parameters
+type Hasher struct {
+	cfg Config
+	// dummy is a hash which is verified when there's no hash to verify against, e.g. for an unknown
+	// user, so that the response time does not reveal if a user exists
+	dummy string
+	// slots has a buffer of cfg.MaxConcurrent, it's sent to before hashing and received from after
+	slots chan struct{}
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	keyLength   uint32
+}
+
+func (h *Hasher) argon2Params() argon2Params {
+	return argon2Params{
+		memory:      uint32(h.cfg.Argon2MemoryKiB),
+		iterations:  uint32(h.cfg.Argon2Iterations),
+		parallelism: uint8(h.cfg.Argon2Parallelism),
+		keyLength:   argon2KeyLength,
+	}
+}
+
+// acquire waits until fewer than cfg.MaxConcurrent passwords are being hashed, and returns the
+// function which must be called once done hashing
+func (h *Hasher) acquire() (release func()) {
+	h.slots <- struct{}{}
+	return func() {
+		<-h.slots
+	}
+}
+
+// Hash returns the hash of password, encoded with all the parameters required to verify it
+func (h *Hasher) Hash(password string) (string, error) {
+	release := h.acquire()
+	defer release()
+
+	if h.cfg.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cfg.BcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("hash: %w", err)
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", fmt.Errorf("hash: %w", err)
+	}
+
+	p := h.argon2Params()
+	key := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, p.keyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		p.memory,
+		p.iterations,
+		p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify returns nil if password matches hash, and ErrMismatch otherwise. needsRehash is true if
+// the password matches, but hash was created with a different algorithm or parameters than the
+// configured ones, in which case the password should be hashed again and the hash replaced
+func (h *Hasher) Verify(password, hash string) (needsRehash bool, err error) {
+	release := h.acquire()
+	defer release()
+
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		p, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, p.keyLength)
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, ErrMismatch
+		}
+		return h.cfg.Algorithm != AlgorithmArgon2id || p != h.argon2Params(), nil
+
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, ErrMismatch
+		}
+		if err != nil {
+			return false, fmt.Errorf("verify: %w: %s", ErrInvalidHash, err.Error())
+		}
+
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return false, fmt.Errorf("verify: %w: %s", ErrInvalidHash, err.Error())
+		}
+		return h.cfg.Algorithm != AlgorithmBcrypt || cost != h.cfg.BcryptCost, nil
+	}
+
+	return false, fmt.Errorf("verify: %w", ErrInvalidHash)
+}
+
+// VerifyDummy verifies password against a fixed hash, and always returns ErrMismatch. It takes as
+// long as Verify, so it's used when there's no hash to verify against
+func (h *Hasher) VerifyDummy(password string) error {
+	_, _ = h.Verify(password, h.dummy)
+	return ErrMismatch
+}
+
+// decodeArgon2id decodes a hash in the format $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func decodeArgon2id(hash string) (argon2Params, []byte, []byte, error) {
+	p := argon2Params{}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, fmt.Errorf("decode: %w", ErrInvalidHash)
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("decode: %w, unsupported version", ErrInvalidHash)
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism)
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("decode: %w: %s", ErrInvalidHash, err.Error())
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("decode: %w: %s", ErrInvalidHash, err.Error())
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("decode: %w: %s", ErrInvalidHash, err.Error())
+	}
+	p.keyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
+
+// Validate returns a PolicyError if password does not meet the strength policy. userInputs are
+// values which the password must not be the same as, e.g. the email or name of the user
+func (h *Hasher) Validate(password string, userInputs ...string) error {
+	length := utf8.RuneCountInString(password)
+	if length < h.cfg.MinLength {
+		return &PolicyError{Reason: fmt.Sprintf("must be at least %d characters", h.cfg.MinLength)}
+	}
+	if length > h.cfg.MaxLength {
+		return &PolicyError{Reason: fmt.Sprintf("must be at most %d characters", h.cfg.MaxLength)}
+	}
+	if h.cfg.Algorithm == AlgorithmBcrypt && len(password) > bcryptMaxBytes {
+		return &PolicyError{Reason: fmt.Sprintf("must be at most %d bytes", bcryptMaxBytes)}
+	}
+
+	lower := strings.ToLower(password)
+	if strings.Count(lower, string([]rune(lower)[:1])) == utf8.RuneCountInString(lower) {
+		return &PolicyError{Reason: "must not be a single repeated character"}
+	}
+	if _, common := commonPasswords[lower]; common {
+		return &PolicyError{Reason: "is too common"}
+	}
+
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if input == "" {
+			continue
+		}
+		if lower == input {
+			return &PolicyError{Reason: "must not be the same as your personal details"}
+		}
+		// the local part of an email is just as guessable as the email itself
+		if local, _, found := strings.Cut(input, "@"); found && lower == local {
+			return &PolicyError{Reason: "must not be the same as your personal details"}
+		}
+	}
+
+	return nil
+}
+
+// NewService returns a new Hasher, with the defaults set for all the parameters missing in cfg
+func NewService(cfg *Config) (*Hasher, error) {
+	c := *cfg
+	if c.Algorithm == "" {
+		c.Algorithm = AlgorithmArgon2id
+	}
+	if c.Argon2MemoryKiB <= 0 {
+		c.Argon2MemoryKiB = 64 * 1024
+	}
+	if c.Argon2Iterations <= 0 {
+		c.Argon2Iterations = 3
+	}
+	if c.Argon2Parallelism <= 0 {
+		c.Argon2Parallelism = 2
+	}
+	if c.BcryptCost <= 0 {
+		c.BcryptCost = 12
+	}
+	if c.MinLength <= 0 {
+		c.MinLength = 10
+	}
+	if c.MaxLength <= 0 {
+		c.MaxLength = 128
+	}
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = 1
+	}
+
+	switch {
+	case c.Algorithm != AlgorithmArgon2id && c.Algorithm != AlgorithmBcrypt:
+		return nil, fmt.Errorf("password: unknown algorithm '%s'", c.Algorithm)
+	case c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost:
+		return nil, fmt.Errorf("password: bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	case c.Argon2Parallelism > 255:
+		return nil, fmt.Errorf("password: argon2 parallelism must be at most 255")
+	case c.MaxLength < c.MinLength:
+		return nil, fmt.Errorf("password: max length must not be less than min length")
+	}
+
+	h := &Hasher{cfg: c, slots: make(chan struct{}, c.MaxConcurrent)}
+
+	dummy, err := h.Hash("dummy password, never matched")
+	if err != nil {
+		return nil, fmt.Errorf("password: %w", err)
+	}
+	h.dummy = dummy
+
+	return h, nil
+}
@@ -0,0 +1,265 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestHasher returns a Hasher with cheap parameters, so that tests are fast
+func newTestHasher(t *testing.T, algorithm string) *Hasher {
+	t.Helper()
+
+	h, err := NewService(&Config{
+		Algorithm:         algorithm,
+		Argon2MemoryKiB:   64,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+		BcryptCost:        bcrypt.MinCost,
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return h
+}
+
+func TestHashVerify(t *testing.T) {
+	tests := []struct {
+		name       string
+		algorithm  string
+		prefix     string
+		password   string
+		verifyWith string
+		wantErr    error
+	}{
+		{name: "argon2id match", algorithm: AlgorithmArgon2id, prefix: "$argon2id$v=19$m=64,t=1,p=1$", password: "correct horse", verifyWith: "correct horse"},
+		{name: "argon2id mismatch", algorithm: AlgorithmArgon2id, prefix: "$argon2id$", password: "correct horse", verifyWith: "Correct horse", wantErr: ErrMismatch},
+		{name: "bcrypt match", algorithm: AlgorithmBcrypt, prefix: "$2a$04$", password: "correct horse", verifyWith: "correct horse"},
+		{name: "bcrypt mismatch", algorithm: AlgorithmBcrypt, prefix: "$2a$", password: "correct horse", verifyWith: "battery staple", wantErr: ErrMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHasher(t, tt.algorithm)
+
+			hash, err := h.Hash(tt.password)
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !strings.HasPrefix(hash, tt.prefix) {
+				t.Errorf("Hash = %q, want prefix %q", hash, tt.prefix)
+			}
+
+			needsRehash, err := h.Verify(tt.verifyWith, hash)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+			if needsRehash {
+				t.Error("Verify needsRehash = true for a hash of the current parameters")
+			}
+		})
+	}
+}
+
+func TestHashIsSalted(t *testing.T) {
+	h := newTestHasher(t, AlgorithmArgon2id)
+
+	a, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	b, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if a == b {
+		t.Error("two hashes of the same password are equal")
+	}
+}
+
+func TestVerifyNeedsRehash(t *testing.T) {
+	argon2Hasher := newTestHasher(t, AlgorithmArgon2id)
+	bcryptHasher := newTestHasher(t, AlgorithmBcrypt)
+	strongerHasher, err := NewService(&Config{
+		Argon2MemoryKiB:   128,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+		BcryptCost:        bcrypt.MinCost,
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		hashBy *Hasher
+		verify *Hasher
+		want   bool
+	}{
+		{name: "same parameters", hashBy: argon2Hasher, verify: argon2Hasher, want: false},
+		{name: "other argon2 parameters", hashBy: argon2Hasher, verify: strongerHasher, want: true},
+		{name: "bcrypt to argon2id", hashBy: bcryptHasher, verify: argon2Hasher, want: true},
+		{name: "argon2id to bcrypt", hashBy: argon2Hasher, verify: bcryptHasher, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hashBy.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+
+			needsRehash, err := tt.verify.Verify("correct horse", hash)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if needsRehash != tt.want {
+				t.Errorf("Verify needsRehash = %v, want %v", needsRehash, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyInvalidHash(t *testing.T) {
+	h := newTestHasher(t, AlgorithmArgon2id)
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "empty", hash: ""},
+		{name: "unknown algorithm", hash: "$md5$abc"},
+		{name: "missing parts", hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA"},
+		{name: "unsupported version", hash: "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5"},
+		{name: "invalid parameters", hash: "$argon2id$v=19$m=x,t=1,p=1$c2FsdA$a2V5"},
+		{name: "invalid salt", hash: "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5"},
+		{name: "invalid bcrypt", hash: "$2a$04$short"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := h.Verify("correct horse", tt.hash)
+			if !errors.Is(err, ErrInvalidHash) {
+				t.Errorf("Verify error = %v, want %v", err, ErrInvalidHash)
+			}
+		})
+	}
+}
+
+func TestVerifyDummy(t *testing.T) {
+	h := newTestHasher(t, AlgorithmArgon2id)
+
+	err := h.VerifyDummy("dummy password, never matched")
+	if !errors.Is(err, ErrMismatch) {
+		t.Errorf("VerifyDummy error = %v, want %v", err, ErrMismatch)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	argon2Hasher := newTestHasher(t, AlgorithmArgon2id)
+	bcryptHasher := newTestHasher(t, AlgorithmBcrypt)
+
+	tests := []struct {
+		name       string
+		hasher     *Hasher
+		password   string
+		userInputs []string
+		wantReason string
+	}{
+		{name: "valid", hasher: argon2Hasher, password: "correct horse battery"},
+		{name: "too short", hasher: argon2Hasher, password: "short", wantReason: "must be at least 10 characters"},
+		{name: "length in characters", hasher: argon2Hasher, password: "ñññññññññx"},
+		{name: "too long", hasher: argon2Hasher, password: strings.Repeat("ab", 65), wantReason: "must be at most 128 characters"},
+		{name: "too long for bcrypt", hasher: bcryptHasher, password: strings.Repeat("ñ", 40), wantReason: "must be at most 72 bytes"},
+		{name: "repeated character", hasher: argon2Hasher, password: "aaaaaaaaaaaa", wantReason: "must not be a single repeated character"},
+		{name: "common", hasher: argon2Hasher, password: "Password123", wantReason: "is too common"},
+		{name: "same as email", hasher: argon2Hasher, password: "jane.doe@example.com", userInputs: []string{"Jane.Doe@example.com"}, wantReason: "must not be the same as your personal details"},
+		{name: "same as email local part", hasher: argon2Hasher, password: "jane.doe.1984", userInputs: []string{"jane.doe.1984@example.com"}, wantReason: "must not be the same as your personal details"},
+		{name: "same as name", hasher: argon2Hasher, password: "Montgomery", userInputs: []string{"", " montgomery "}, wantReason: "must not be the same as your personal details"},
+		{name: "contains personal details", hasher: argon2Hasher, password: "montgomery-was-here", userInputs: []string{"montgomery"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.hasher.Validate(tt.password, tt.userInputs...)
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("Validate error = %v, want nil", err)
+				}
+				return
+			}
+
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Validate error = %v, want a *PolicyError", err)
+			}
+			if policyErr.Reason != tt.wantReason {
+				t.Errorf("Validate reason = %q, want %q", policyErr.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestNewServiceConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "defaults", cfg: Config{}},
+		{name: "unknown algorithm", cfg: Config{Algorithm: "md5"}, wantErr: true},
+		{name: "bcrypt cost too low", cfg: Config{Algorithm: AlgorithmBcrypt, BcryptCost: 2}, wantErr: true},
+		{name: "bcrypt cost too high", cfg: Config{Algorithm: AlgorithmBcrypt, BcryptCost: 40}, wantErr: true},
+		{name: "argon2 parallelism too high", cfg: Config{Argon2Parallelism: 256}, wantErr: true},
+		{name: "max length below min length", cfg: Config{MinLength: 20, MaxLength: 12}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "defaults" && testing.Short() {
+				t.Skip("hashes with the default argon2 parameters")
+			}
+
+			_, err := NewService(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewService error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	h, err := NewService(&Config{
+		Argon2MemoryKiB:   64,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+		MaxConcurrent:     2,
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	releases := []func(){h.acquire(), h.acquire()}
+	done := make(chan struct{})
+	go func() {
+		_ = h.VerifyDummy("correct horse")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("VerifyDummy did not wait while MaxConcurrent passwords were being hashed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	releases[0]()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("VerifyDummy did not finish once a password was done hashing")
+	}
+	releases[1]()
+}
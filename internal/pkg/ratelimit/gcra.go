@@ -0,0 +1,205 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+// gcraScript implements the generic cell rate algorithm. Events are spaced evenly within the window,
+// and a burst of up to limit events is allowed once the key has been idle for a whole window. The
+// only state is the theoretical arrival time (TAT) of the next event, which expires once it's in the
+// past. The time of the Redis server is used, so that all instances of the app share the same clock.
+// KEYS[1] is the key, ARGV[1] the emission interval (window / limit) in microseconds and ARGV[2] the
+// limit. It returns whether the event is allowed, the remaining events, and the times until the next
+// event is allowed and until the key is back to its full limit, in milliseconds
+var gcraScript = redis.NewScript(1, `
+redis.replicate_commands()
+local interval = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local now = redis.call('TIME')
+now = tonumber(now[1]) * 1000000 + tonumber(now[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local newTAT = tat + interval
+local allowAt = newTAT - limit * interval
+if now < allowAt then
+	return {0, 0, math.ceil((allowAt - now) / 1000), math.ceil((tat - now) / 1000)}
+end
+
+redis.call('SET', KEYS[1], string.format('%d', newTAT), 'PX', math.ceil((newTAT - now) / 1000))
+return {1, math.floor((now - allowAt) / interval), 0, math.ceil((newTAT - now) / 1000)}
+`)
+
+type gcraLimiter struct {
+	cache  *cachestore.Handle
+	prefix string
+}
+
+func (gl *gcraLimiter) conn(ctx context.Context) (redis.Conn, error) {
+	pool := gl.cache.Pool()
+	if pool == nil {
+		return nil, cachestore.ErrCacheNotInitialized
+	}
+	return pool.GetContext(ctx)
+}
+
+func (gl *gcraLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (*Result, error) {
+	ctx, span := tracing.StartClient(ctx, "redis EVALSHA", "db.system", "redis", "db.operation", "EVALSHA")
+	defer span.End()
+
+	if limit < 1 {
+		return nil, fmt.Errorf("allow: invalid limit %d", limit)
+	}
+
+	conn, err := gl.conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	defer conn.Close()
+
+	interval := int64(math.Ceil(float64(window.Microseconds()) / float64(limit)))
+	reply, err := redis.Int64s(gcraScript.Do(conn, gl.prefix+key, interval, limit))
+	if err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	if len(reply) != 4 {
+		return nil, fmt.Errorf("allow: unexpected reply %v", reply)
+	}
+
+	return &Result{
+		Allowed:    reply[0] == 1,
+		Limit:      limit,
+		Remaining:  int(reply[1]),
+		RetryAfter: time.Duration(reply[2]) * time.Millisecond,
+		ResetAfter: time.Duration(reply[3]) * time.Millisecond,
+	}, nil
+}
+
+// NewRedisGCRALimiter returns a Limiter which spaces events evenly within the window, using the
+// generic cell rate algorithm in Redis. Unlike fixed windows, it does not allow twice the limit
+// around the end of a window. All keys are prefixed with prefix
+func NewRedisGCRALimiter(cache *cachestore.Handle, prefix string) Limiter {
+	if cache == nil {
+		cache = cachestore.NewHandle(nil)
+	}
+	return &gcraLimiter{cache: cache, prefix: prefix}
+}
+
+// bucketSweepInterval is the minimum time between two sweeps of the idle buckets
+const bucketSweepInterval = time.Second * 10
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// idleAfter is the time at which the bucket is full again, and can be forgotten
+	idleAfter time.Time
+}
+
+// TokenBucketLimiter is a thread-safe, in-memory implementation of Limiter, which refills a bucket of
+// limit tokens per key at a steady rate of limit per window. It behaves like the GCRA limiter, but
+// its buckets are not shared with other instances of the app
+type TokenBucketLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func (tl *TokenBucketLimiter) sweep(now time.Time) {
+	if now.Sub(tl.lastSweep) < bucketSweepInterval {
+		return
+	}
+	tl.lastSweep = now
+	for k, b := range tl.buckets {
+		if !now.Before(b.idleAfter) {
+			delete(tl.buckets, k)
+		}
+	}
+}
+
+func (tl *TokenBucketLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (*Result, error) {
+	if limit < 1 {
+		return nil, fmt.Errorf("allow: invalid limit %d", limit)
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	now := time.Now()
+	tl.sweep(now)
+
+	capacity := float64(limit)
+	rate := capacity / window.Seconds()
+	b, ok := tl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		tl.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	result := &Result{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(b.tokens)
+	result.ResetAfter = time.Duration((capacity - b.tokens) / rate * float64(time.Second))
+	b.idleAfter = now.Add(result.ResetAfter)
+
+	return result, nil
+}
+
+// NewTokenBucketLimiter returns a new TokenBucketLimiter, with all buckets full
+func NewTokenBucketLimiter() *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+type fallbackLimiter struct {
+	primary  Limiter
+	fallback Limiter
+	notify   func(err error)
+	// degraded is 1 while the primary limiter is failing, and is accessed atomically
+	degraded int32
+}
+
+func (fl *fallbackLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (*Result, error) {
+	result, err := fl.primary.Allow(ctx, key, limit, window)
+	if err == nil {
+		if atomic.CompareAndSwapInt32(&fl.degraded, 1, 0) && fl.notify != nil {
+			fl.notify(nil)
+		}
+		return result, nil
+	}
+
+	if atomic.CompareAndSwapInt32(&fl.degraded, 0, 1) && fl.notify != nil {
+		fl.notify(err)
+	}
+	return fl.fallback.Allow(ctx, key, limit, window)
+}
+
+// NewFallbackLimiter returns a Limiter which uses fallback whenever primary fails, e.g. a local
+// limiter while Redis is down. notify, if any, is called with the error when primary starts failing,
+// and with nil once it works again, so that the transitions can be logged without flooding the logs
+func NewFallbackLimiter(primary Limiter, fallback Limiter, notify func(err error)) Limiter {
+	return &fallbackLimiter{
+		primary:  primary,
+		fallback: fallback,
+		notify:   notify,
+	}
+}
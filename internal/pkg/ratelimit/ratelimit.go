@@ -0,0 +1,175 @@
+// Package ratelimit counts events per key, e.g. requests per IP, and reports when a key has exceeded
+// its limit within a window of time.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+var (
+	// ErrLimited is matched by all the errors returned when a limit is exceeded
+	ErrLimited = errors.New("rate limit exceeded")
+)
+
+// LimitError is returned when a limit is exceeded. It matches ErrLimited with errors.Is
+type LimitError struct {
+	// RetryAfter is the time until the next event is allowed
+	RetryAfter time.Duration
+}
+
+func (le *LimitError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrLimited.Error(), le.RetryAfter.Round(time.Second))
+}
+
+func (le *LimitError) Is(target error) bool {
+	return target == ErrLimited
+}
+
+// Result is the state of a key after counting an event
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is the time until the key is back to its full limit, e.g. the end of a fixed window
+	ResetAfter time.Duration
+	// RetryAfter is the time until the next event is allowed, 0 if the event was allowed
+	RetryAfter time.Duration
+}
+
+// Err returns a LimitError if the event was not allowed, nil otherwise
+func (r *Result) Err() error {
+	if r.Allowed {
+		return nil
+	}
+	return &LimitError{RetryAfter: r.RetryAfter}
+}
+
+// Limiter counts events per key, and allows up to a limit of them per window of time. How events are
+// spread within a window depends on the implementation
+type Limiter interface {
+	// Allow counts an event of key, and reports if it's within limit events per window
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (*Result, error)
+}
+
+func newResult(count int64, limit int, resetAfter time.Duration) *Result {
+	remaining := int64(limit) - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	result := &Result{
+		Allowed:    count <= int64(limit),
+		Limit:      limit,
+		Remaining:  int(remaining),
+		ResetAfter: resetAfter,
+	}
+	if !result.Allowed {
+		result.RetryAfter = resetAfter
+	}
+	return result
+}
+
+// incrScript increments the counter of a window, and starts the window on the first event. The
+// expiry is set again if it's missing, so that a counter can never outlive its window. KEYS[1] is the
+// key of the counter, ARGV[1] the window in milliseconds
+var incrScript = redis.NewScript(1, `
+local count = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+type redisLimiter struct {
+	cache  *cachestore.Handle
+	prefix string
+}
+
+func (rl *redisLimiter) conn(ctx context.Context) (redis.Conn, error) {
+	pool := rl.cache.Pool()
+	if pool == nil {
+		return nil, cachestore.ErrCacheNotInitialized
+	}
+	return pool.GetContext(ctx)
+}
+
+func (rl *redisLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (*Result, error) {
+	ctx, span := tracing.StartClient(ctx, "redis EVALSHA", "db.system", "redis", "db.operation", "EVALSHA")
+	defer span.End()
+
+	conn, err := rl.conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	defer conn.Close()
+
+	reply, err := redis.Int64s(incrScript.Do(conn, rl.prefix+key, window.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	if len(reply) != 2 {
+		return nil, fmt.Errorf("allow: unexpected reply %v", reply)
+	}
+
+	return newResult(reply[0], limit, time.Duration(reply[1])*time.Millisecond), nil
+}
+
+// NewRedisLimiter returns a Limiter which counts events within fixed windows in Redis, so that the
+// counters are shared by all instances of the app. All keys are prefixed with prefix
+func NewRedisLimiter(cache *cachestore.Handle, prefix string) Limiter {
+	if cache == nil {
+		cache = cachestore.NewHandle(nil)
+	}
+	return &redisLimiter{cache: cache, prefix: prefix}
+}
+
+type memoryWindow struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// MemoryLimiter is a thread-safe, in-memory implementation of Limiter, which counts events within
+// fixed windows. It's meant for tests and local development, since the counters are not shared with
+// other instances of the app
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*memoryWindow
+}
+
+func (ml *MemoryLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (*Result, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	now := time.Now()
+	for k, w := range ml.windows {
+		if !now.Before(w.expiresAt) {
+			delete(ml.windows, k)
+		}
+	}
+
+	w, ok := ml.windows[key]
+	if !ok {
+		w = &memoryWindow{expiresAt: now.Add(window)}
+		ml.windows[key] = w
+	}
+	w.count++
+
+	return newResult(w.count, limit, w.expiresAt.Sub(now)), nil
+}
+
+// NewMemoryLimiter returns a new MemoryLimiter, without any counter
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		windows: make(map[string]*memoryWindow),
+	}
+}
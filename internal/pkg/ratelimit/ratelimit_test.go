@@ -0,0 +1,273 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+)
+
+func TestResultErr(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  Result
+		wantErr error
+	}{
+		{name: "allowed", result: Result{Allowed: true}},
+		{name: "limited", result: Result{RetryAfter: time.Second}, wantErr: ErrLimited},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.result.Err()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Err() = %v, want %v", err, tt.wantErr)
+			}
+
+			var limitErr *LimitError
+			if errors.As(err, &limitErr) && limitErr.RetryAfter != tt.result.RetryAfter {
+				t.Errorf("RetryAfter = %s, want %s", limitErr.RetryAfter, tt.result.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	ml := NewMemoryLimiter()
+
+	tests := []struct {
+		key           string
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{key: "a", wantAllowed: true, wantRemaining: 2},
+		{key: "a", wantAllowed: true, wantRemaining: 1},
+		{key: "a", wantAllowed: true, wantRemaining: 0},
+		{key: "a", wantAllowed: false, wantRemaining: 0},
+		// keys are counted separately
+		{key: "b", wantAllowed: true, wantRemaining: 2},
+	}
+
+	for i, tt := range tests {
+		result, err := ml.Allow(ctx, tt.key, 3, time.Minute)
+		if err != nil {
+			t.Fatalf("Allow %d: %v", i+1, err)
+		}
+		if result.Allowed != tt.wantAllowed || result.Remaining != tt.wantRemaining {
+			t.Errorf("Allow %d = %+v, want allowed %v and %d remaining", i+1, result, tt.wantAllowed, tt.wantRemaining)
+		}
+		if !result.Allowed && (result.RetryAfter <= 0 || result.RetryAfter > time.Minute) {
+			t.Errorf("Allow %d RetryAfter = %s, want the rest of the window", i+1, result.RetryAfter)
+		}
+	}
+}
+
+func TestMemoryLimiterWindow(t *testing.T) {
+	ctx := context.Background()
+	ml := NewMemoryLimiter()
+	window := time.Millisecond * 50
+
+	for i := 0; i < 2; i++ {
+		_, _ = ml.Allow(ctx, "key", 1, window)
+	}
+	time.Sleep(window)
+
+	result, err := ml.Allow(ctx, "key", 1, window)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if !result.Allowed {
+		t.Error("Allow in a new window is not allowed")
+	}
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	ctx := context.Background()
+	tl := NewTokenBucketLimiter()
+	limit, window := 4, time.Second*4
+
+	// a burst of up to the limit is allowed, after which events are spaced by window / limit
+	for i := 0; i < limit; i++ {
+		result, err := tl.Allow(ctx, "key", limit, window)
+		if err != nil {
+			t.Fatalf("Allow %d: %v", i+1, err)
+		}
+		if !result.Allowed || result.Remaining != limit-i-1 {
+			t.Fatalf("Allow %d = %+v, want allowed with %d remaining", i+1, result, limit-i-1)
+		}
+	}
+
+	result, err := tl.Allow(ctx, "key", limit, window)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if result.Allowed {
+		t.Fatal("Allow after the burst is allowed")
+	}
+	if result.RetryAfter <= time.Millisecond*900 || result.RetryAfter > time.Second {
+		t.Errorf("RetryAfter = %s, want about a second", result.RetryAfter)
+	}
+	if result.ResetAfter <= time.Millisecond*3900 || result.ResetAfter > window {
+		t.Errorf("ResetAfter = %s, want about the window", result.ResetAfter)
+	}
+	if !errors.Is(result.Err(), ErrLimited) {
+		t.Errorf("Err() = %v, want %v", result.Err(), ErrLimited)
+	}
+
+	// other keys have their own bucket
+	result, err = tl.Allow(ctx, "other", limit, window)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if !result.Allowed {
+		t.Error("Allow of another key is not allowed")
+	}
+}
+
+func TestTokenBucketLimiterRefill(t *testing.T) {
+	ctx := context.Background()
+	tl := NewTokenBucketLimiter()
+	limit, window := 2, time.Millisecond*100
+
+	for i := 0; i < limit; i++ {
+		_, _ = tl.Allow(ctx, "key", limit, window)
+	}
+	result, _ := tl.Allow(ctx, "key", limit, window)
+	if result.Allowed {
+		t.Fatal("Allow after the burst is allowed")
+	}
+
+	time.Sleep(result.RetryAfter)
+	result, err := tl.Allow(ctx, "key", limit, window)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if !result.Allowed {
+		t.Errorf("Allow after RetryAfter = %+v, want allowed", result)
+	}
+}
+
+func TestTokenBucketLimiterConcurrent(t *testing.T) {
+	ctx := context.Background()
+	tl := NewTokenBucketLimiter()
+	limit := 10
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := tl.Allow(ctx, "key", limit, time.Hour)
+			if err == nil && result.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != limit {
+		t.Errorf("allowed %d concurrent events, want %d", allowed, limit)
+	}
+}
+
+func TestInvalidLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter Limiter
+	}{
+		{name: "token bucket", limiter: NewTokenBucketLimiter()},
+		{name: "gcra", limiter: NewRedisGCRALimiter(nil, "test-")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.limiter.Allow(context.Background(), "key", 0, time.Minute)
+			if err == nil {
+				t.Error("Allow with a limit of 0 returned no error")
+			}
+		})
+	}
+}
+
+func TestRedisLimitersWithoutCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter Limiter
+	}{
+		{name: "fixed window", limiter: NewRedisLimiter(nil, "test-")},
+		{name: "gcra", limiter: NewRedisGCRALimiter(nil, "test-")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.limiter.Allow(context.Background(), "key", 1, time.Minute)
+			if !errors.Is(err, cachestore.ErrCacheNotInitialized) {
+				t.Errorf("Allow error = %v, want %v", err, cachestore.ErrCacheNotInitialized)
+			}
+		})
+	}
+}
+
+// toggleLimiter fails while down is set, and allows every event otherwise
+type toggleLimiter struct {
+	down bool
+}
+
+func (tl *toggleLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (*Result, error) {
+	if tl.down {
+		return nil, cachestore.ErrCacheNotInitialized
+	}
+	return &Result{Allowed: true, Limit: limit, Remaining: limit}, nil
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	ctx := context.Background()
+	primary := &toggleLimiter{down: true}
+	var notified []error
+	fl := NewFallbackLimiter(primary, NewTokenBucketLimiter(), func(err error) {
+		notified = append(notified, err)
+	})
+
+	steps := []struct {
+		down         bool
+		wantAllowed  bool
+		wantNotified int
+	}{
+		// the fallback limits the events while the primary is failing, and the failure is notified
+		// only once
+		{down: true, wantAllowed: true, wantNotified: 1},
+		{down: true, wantAllowed: false, wantNotified: 1},
+		// the recovery is notified once as well
+		{down: false, wantAllowed: true, wantNotified: 2},
+		{down: false, wantAllowed: true, wantNotified: 2},
+		{down: true, wantAllowed: false, wantNotified: 3},
+	}
+
+	for i, step := range steps {
+		primary.down = step.down
+		result, err := fl.Allow(ctx, "key", 1, time.Hour)
+		if err != nil {
+			t.Fatalf("step %d: Allow: %v", i+1, err)
+		}
+		if result.Allowed != step.wantAllowed {
+			t.Errorf("step %d: Allowed = %v, want %v", i+1, result.Allowed, step.wantAllowed)
+		}
+		if len(notified) != step.wantNotified {
+			t.Fatalf("step %d: notified %d times, want %d", i+1, len(notified), step.wantNotified)
+		}
+	}
+
+	if notified[0] == nil || notified[1] != nil {
+		t.Errorf("notified %v, want the failure then nil", notified)
+	}
+}
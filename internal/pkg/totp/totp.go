@@ -0,0 +1,135 @@
+// Package totp implements time-based one-time passwords (RFC 6238), as generated by authenticator
+// apps. Codes have 6 digits, change every 30 seconds, and are derived with HMAC-SHA1, which are the
+// only parameters supported by all the common apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is the time each code is valid for
+	Period = time.Second * 30
+	// Digits is the number of digits of each code
+	Digits = 6
+	// Skew is the number of periods before and after the current one, whose codes are accepted as
+	// well, to allow for clock drift and the time taken to type the code
+	Skew = 1
+
+	// secretBytes is the size of secrets, 160 bits as recommended by RFC 4226
+	secretBytes = 20
+)
+
+var (
+	// ErrInvalidSecret is returned for secrets which are not valid base32
+	ErrInvalidSecret = errors.New("invalid secret")
+
+	encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// NewSecret returns a new random secret, base32 encoded without padding as expected by
+// authenticator apps
+func NewSecret() (string, error) {
+	raw := make([]byte, secretBytes)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", fmt.Errorf("newSecret: %w", err)
+	}
+	return encoding.EncodeToString(raw), nil
+}
+
+// URI returns the otpauth:// URI of secret, which authenticator apps import, usually from a QR code.
+// The account is shown in the app along with the issuer, e.g. the email of the user
+func URI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", Digits))
+	query.Set("period", fmt.Sprintf("%d", int(Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSecret, err.Error())
+	}
+	return key, nil
+}
+
+// counter returns the number of periods elapsed at t since the Unix epoch
+func counter(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(Period/time.Second)
+}
+
+// code returns the code of counter, as per the HOTP algorithm (RFC 4226)
+func code(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// Code returns the code of secret at t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", fmt.Errorf("code: %w", err)
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate reports if passcode is the code of secret at t, or of up to Skew periods before or after.
+// It returns the counter of the matching period, so that callers can reject codes which were used
+// already
+func Validate(secret string, passcode string, t time.Time) (uint64, bool, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false, fmt.Errorf("validate: %w", err)
+	}
+
+	passcode = strings.ReplaceAll(strings.TrimSpace(passcode), " ", "")
+	if len(passcode) != Digits {
+		return 0, false, nil
+	}
+
+	current := counter(t)
+	matched, ok := uint64(0), false
+	// all the candidates are compared in constant time, so that the response time does not tell
+	// which period matched, if any
+	for c := current - Skew; c <= current+Skew; c++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, c)), []byte(passcode)) == 1 && !ok {
+			matched, ok = c, true
+		}
+	}
+	return matched, ok, nil
+}
@@ -0,0 +1,136 @@
+package totp
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of the test vectors of RFC 6238, "12345678901234567890" base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// the test vectors of RFC 6238, truncated to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current, _ := Code(rfcSecret, now)
+	previous, _ := Code(rfcSecret, now.Add(-Period))
+	next, _ := Code(rfcSecret, now.Add(Period))
+	tooOld, _ := Code(rfcSecret, now.Add(-Period*2))
+
+	tests := []struct {
+		name        string
+		secret      string
+		passcode    string
+		wantOK      bool
+		wantCounter uint64
+		wantErr     error
+	}{
+		{name: "current", secret: rfcSecret, passcode: current, wantOK: true, wantCounter: counter(now)},
+		{name: "previous period", secret: rfcSecret, passcode: previous, wantOK: true, wantCounter: counter(now) - 1},
+		{name: "next period", secret: rfcSecret, passcode: next, wantOK: true, wantCounter: counter(now) + 1},
+		{name: "outside the skew", secret: rfcSecret, passcode: tooOld},
+		{name: "with spaces", secret: rfcSecret, passcode: " " + current[:3] + " " + current[3:] + " ", wantOK: true, wantCounter: counter(now)},
+		{name: "lower case secret with padding", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq====", passcode: current, wantOK: true, wantCounter: counter(now)},
+		{name: "wrong code", secret: rfcSecret, passcode: "000000"},
+		{name: "too short", secret: rfcSecret, passcode: current[:5]},
+		{name: "invalid secret", secret: "not base32!", passcode: current, wantErr: ErrInvalidSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok, err := Validate(tt.secret, tt.passcode, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate error = %v, want %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("Validate ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && c != tt.wantCounter {
+				t.Errorf("Validate counter = %d, want %d", c, tt.wantCounter)
+			}
+		})
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret: %v", err)
+	}
+	b, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret: %v", err)
+	}
+	if a == b {
+		t.Error("two secrets are equal")
+	}
+
+	key, err := decodeSecret(a)
+	if err != nil {
+		t.Fatalf("decodeSecret: %v", err)
+	}
+	if len(key) != secretBytes {
+		t.Errorf("secret is %d bytes, want %d", len(key), secretBytes)
+	}
+
+	now := time.Now()
+	code, err := Code(a, now)
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+	_, ok, err := Validate(a, code, now)
+	if err != nil || !ok {
+		t.Errorf("Validate of the current code = %v, %v, want true", ok, err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("goapp", "jane@example.com", rfcSecret)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/goapp:jane@example.com" {
+		t.Errorf("URI = %s, want otpauth://totp/goapp:jane@example.com", uri)
+	}
+
+	want := map[string]string{
+		"secret":    rfcSecret,
+		"issuer":    "goapp",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+	query := u.Query()
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("URI %s = %q, want %q", key, got, value)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// writerExporter writes every span as a single line of JSON
+type writerExporter struct {
+	serviceName string
+
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+type spanJSON struct {
+	Service      string                 `json:"service"`
+	Name         string                 `json:"name"`
+	Kind         SpanKind               `json:"kind"`
+	TraceID      string                 `json:"traceID"`
+	SpanID       string                 `json:"spanID"`
+	ParentSpanID string                 `json:"parentSpanID,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	DurationMS   float64                `json:"durationMs"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Status       StatusCode             `json:"status"`
+	Message      string                 `json:"message,omitempty"`
+}
+
+func (we *writerExporter) Export(ctx context.Context, spans []*SpanData) error {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+
+	enc := json.NewEncoder(we.w)
+	for _, sd := range spans {
+		sj := spanJSON{
+			Service:    we.serviceName,
+			Name:       sd.Name,
+			Kind:       sd.Kind,
+			TraceID:    sd.SpanContext.TraceID.String(),
+			SpanID:     sd.SpanContext.SpanID.String(),
+			Start:      sd.Start,
+			End:        sd.End,
+			DurationMS: float64(sd.End.Sub(sd.Start).Microseconds()) / 1000,
+			Attributes: sd.Attributes,
+			Status:     sd.StatusCode,
+			Message:    sd.StatusMessage,
+		}
+		if sd.ParentSpanID.IsValid() {
+			sj.ParentSpanID = sd.ParentSpanID.String()
+		}
+
+		err := enc.Encode(sj)
+		if err != nil {
+			return fmt.Errorf("export spans: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (we *writerExporter) Shutdown(ctx context.Context) error {
+	if we.closer == nil {
+		return nil
+	}
+	return we.closer.Close()
+}
+
+func newStdoutExporter(serviceName string) *writerExporter {
+	return &writerExporter{
+		serviceName: serviceName,
+		w:           os.Stdout,
+	}
+}
+
+func newFileExporter(serviceName string, path string) (*writerExporter, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file path is required for the tracing file exporter")
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open tracing file: %w", err)
+	}
+
+	return &writerExporter{
+		serviceName: serviceName,
+		w:           f,
+		closer:      f,
+	}, nil
+}
+
+// otlpExporter exports spans to an OpenTelemetry collector, using the JSON encoding of OTLP over HTTP
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+	resource otlpResource
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+// otlpValue returns the OTLP AnyValue representation of v
+func otlpValue(v interface{}) map[string]interface{} {
+	switch value := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": value}
+	case bool:
+		return map[string]interface{}{"boolValue": value}
+	case int:
+		// 64 bit integers are encoded as strings in the JSON encoding of OTLP
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(value), 10)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": value}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprint(value)}
+	}
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for key, value := range attrs {
+		kvs = append(kvs, otlpKeyValue{Key: key, Value: otlpValue(value)})
+	}
+	return kvs
+}
+
+func (oe *otlpExporter) Export(ctx context.Context, spans []*SpanData) error {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, sd := range spans {
+		span := otlpSpan{
+			TraceID:           sd.SpanContext.TraceID.String(),
+			SpanID:            sd.SpanContext.SpanID.String(),
+			Name:              sd.Name,
+			Kind:              sd.Kind,
+			StartTimeUnixNano: strconv.FormatInt(sd.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sd.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(sd.Attributes),
+			Status: otlpStatus{
+				Code:    sd.StatusCode,
+				Message: sd.StatusMessage,
+			},
+		}
+		if sd.ParentSpanID.IsValid() {
+			span.ParentSpanID = sd.ParentSpanID.String()
+		}
+		otlpSpans = append(otlpSpans, span)
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": oe.resource,
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/jerryan999/goapp/internal/pkg/tracing"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("otlp export: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oe.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("otlp export: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range oe.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := oe.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("otlp export: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("otlp export: collector responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (oe *otlpExporter) Shutdown(ctx context.Context) error {
+	oe.client.CloseIdleConnections()
+	return nil
+}
+
+func newOTLPExporter(serviceName, serviceVersion, endpoint, headers string) (*otlpExporter, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required for the tracing otlp exporter")
+	}
+
+	hmap := make(map[string]string)
+	for _, pair := range strings.Split(headers, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid otlp header '%s', expected key=value", pair)
+		}
+		hmap[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return &otlpExporter{
+		endpoint: endpoint,
+		headers:  hmap,
+		client:   &http.Client{Timeout: flushInterval},
+		resource: otlpResource{
+			Attributes: otlpAttributes(map[string]interface{}{
+				"service.name":    serviceName,
+				"service.version": serviceVersion,
+			}),
+		},
+	}, nil
+}
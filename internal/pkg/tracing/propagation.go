@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// HeaderTraceparent is the W3C Trace Context header which carries the span context across services
+const HeaderTraceparent = "traceparent"
+
+// ParseTraceparent parses the value of a W3C traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(value string) (SpanContext, error) {
+	sc := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("invalid traceparent '%s'", value)
+	}
+
+	version := parts[0]
+	// version ff is forbidden, and version 00 has exactly 4 parts. Future versions may add more parts
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent version '%s'", version)
+	}
+
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) || strings.ToLower(parts[1]) != parts[1] {
+		return sc, fmt.Errorf("invalid traceparent trace ID '%s'", parts[1])
+	}
+	copy(sc.TraceID[:], traceID)
+
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) || strings.ToLower(parts[2]) != parts[2] {
+		return sc, fmt.Errorf("invalid traceparent parent ID '%s'", parts[2])
+	}
+	copy(sc.SpanID[:], spanID)
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, fmt.Errorf("invalid traceparent flags '%s'", parts[3])
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent '%s', IDs cannot be all zeroes", value)
+	}
+
+	return sc, nil
+}
+
+// FormatTraceparent returns the value of the W3C traceparent header for sc
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
@@ -0,0 +1,425 @@
+// Package tracing is a minimal distributed tracing implementation. Spans are propagated across
+// services using the W3C Trace Context 'traceparent' header, and exported either as JSON lines to a
+// file/stdout for local use, or to an OpenTelemetry collector using OTLP over HTTP.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TraceID uniquely identifies a trace
+type TraceID [16]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid reports if t is not all zeroes
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// SpanID uniquely identifies a span within a trace
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid reports if s is not all zeroes
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext is the part of a span which is propagated to child spans, including the ones in
+// other services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports if both the trace ID and span ID are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// SpanKind describes the relationship of a span with its parent and children. The values are the
+// same as defined by OpenTelemetry
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode is the status of a span. The values are the same as defined by OpenTelemetry
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// SpanData is a snapshot of an ended span, which is exported
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	ParentSpanID  SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{}
+	StatusCode    StatusCode
+	StatusMessage string
+}
+
+// Span is a single operation within a trace. A nil Span is valid, and does nothing
+type Span struct {
+	tracer *Tracer
+
+	mu   sync.Mutex
+	data SpanData
+	done bool
+}
+
+// SpanContext returns the span context of s
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttributes sets the key/value pairs in kv as attributes of the span, e.g.
+// SetAttributes("http.method", "GET", "http.status_code", 200)
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil || !s.data.SpanContext.Sampled {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	for idx := 0; idx+1 < len(kv); idx += 2 {
+		s.data.Attributes[fmt.Sprint(kv[idx])] = kv[idx+1]
+	}
+}
+
+// RecordError sets the status of the span as error, with err as the message. It does nothing if err is nil
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil || !s.data.SpanContext.Sampled {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.data.StatusCode = StatusError
+	s.data.StatusMessage = err.Error()
+}
+
+// SetStatus sets the status of the span
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil || !s.data.SpanContext.Sampled {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.data.StatusCode = code
+	s.data.StatusMessage = message
+}
+
+// End ends the span and queues it for export. Calls after the first one are ignored
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled {
+		s.tracer.enqueue(&data)
+	}
+}
+
+type spanCtxKey struct{}
+type remoteCtxKey struct{}
+
+// ContextWithRemoteSpanContext returns a copy of ctx with sc, received from another service, as the
+// parent of the spans started from it
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteCtxKey{}, sc)
+}
+
+// SpanFromContext returns the current span in ctx, or nil if there's none
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanCtxKey{}).(*Span)
+	return s
+}
+
+// Start starts a new span of kind internal, as a child of the current span in ctx
+func Start(ctx context.Context, name string, kv ...interface{}) (context.Context, *Span) {
+	return global().start(ctx, name, SpanKindInternal, kv...)
+}
+
+// StartServer starts a new span for serving a request received from a client
+func StartServer(ctx context.Context, name string, kv ...interface{}) (context.Context, *Span) {
+	return global().start(ctx, name, SpanKindServer, kv...)
+}
+
+// StartClient starts a new span for a request to another service, e.g. a database
+func StartClient(ctx context.Context, name string, kv ...interface{}) (context.Context, *Span) {
+	return global().start(ctx, name, SpanKindClient, kv...)
+}
+
+// Exporter exports ended spans to a tracing backend
+type Exporter interface {
+	Export(ctx context.Context, spans []*SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// Config holds all the configuration required for this package
+type Config struct {
+	ServiceName string `json:"service_name"`
+	// Exporter is one of 'none', 'stdout', 'file' or 'otlp'
+	Exporter string `json:"exporter"`
+	// FilePath is the file spans are written to, with the 'file' exporter
+	FilePath string `json:"file_path"`
+	// OTLPEndpoint is the URL of the OTLP/HTTP traces endpoint, e.g. http://localhost:4318/v1/traces
+	OTLPEndpoint string `json:"otlp_endpoint"`
+	// OTLPHeaders are sent with every export request, as comma separated key=value pairs
+	OTLPHeaders string `json:"otlp_headers"`
+	// SampleRatio is the fraction of new traces which are sampled. Traces started by other services
+	// follow the sampling decision of the caller
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
+const (
+	maxQueueSize  = 2048
+	maxBatchSize  = 512
+	flushInterval = time.Second * 5
+)
+
+// Tracer creates spans, and exports them in batches in the background
+type Tracer struct {
+	serviceName    string
+	serviceVersion string
+	sampleRatio    float64
+	exporter       Exporter
+
+	queue    chan *SpanData
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func randomBytes(b []byte) {
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+}
+
+func (t *Tracer) sample(traceID TraceID) bool {
+	if t.exporter == nil || t.sampleRatio <= 0 {
+		return false
+	}
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	// the decision is based on the trace ID, so that it's the same for all spans of a trace
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11)/(1<<53) < t.sampleRatio
+}
+
+func (t *Tracer) start(ctx context.Context, name string, kind SpanKind, kv ...interface{}) (context.Context, *Span) {
+	var parent SpanContext
+	if ps := SpanFromContext(ctx); ps != nil {
+		parent = ps.SpanContext()
+	} else if rsc, ok := ctx.Value(remoteCtxKey{}).(SpanContext); ok {
+		parent = rsc
+	}
+
+	sc := SpanContext{}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled && t.exporter != nil
+	} else {
+		randomBytes(sc.TraceID[:])
+		sc.Sampled = t.sample(sc.TraceID)
+	}
+	randomBytes(sc.SpanID[:])
+
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			SpanContext:  sc,
+			ParentSpanID: parent.SpanID,
+			Start:        time.Now(),
+			Attributes:   make(map[string]interface{}, len(kv)/2),
+		},
+	}
+	s.SetAttributes(kv...)
+
+	return context.WithValue(ctx, spanCtxKey{}, s), s
+}
+
+func (t *Tracer) enqueue(sd *SpanData) {
+	select {
+	case t.queue <- sd:
+	default:
+		// spans are dropped rather than blocking the app when the exporter cannot keep up
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, maxBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), flushInterval)
+		// export errors are not fatal for the app, and there's nowhere to report them but the logs,
+		// which are themselves correlated using traces. So they're ignored
+		_ = t.exporter.Export(ctx, batch)
+		cancel()
+		batch = make([]*SpanData, 0, maxBatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case sd := <-t.queue:
+				batch = append(batch, sd)
+				if len(batch) >= maxBatchSize {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case sd := <-t.queue:
+			batch = append(batch, sd)
+			if len(batch) >= maxBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case <-t.stop:
+			drain()
+			return
+		}
+	}
+}
+
+// Shutdown exports all the queued spans and shuts down the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return t.exporter.Shutdown(ctx)
+}
+
+var (
+	globalMu     sync.RWMutex
+	globalTracer = newTracer("", "", 0, nil)
+)
+
+func global() *Tracer {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return globalTracer
+}
+
+// SetGlobal sets t as the tracer used by Start, StartServer and StartClient
+func SetGlobal(t *Tracer) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	globalTracer = t
+}
+
+func newTracer(serviceName, serviceVersion string, sampleRatio float64, exporter Exporter) *Tracer {
+	t := &Tracer{
+		serviceName:    serviceName,
+		serviceVersion: serviceVersion,
+		sampleRatio:    sampleRatio,
+		exporter:       exporter,
+		queue:          make(chan *SpanData, maxQueueSize),
+		stop:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+	if exporter != nil {
+		go t.run()
+	}
+	return t
+}
+
+// NewService returns a new Tracer with the exporter configured in cfg. Spans are still created and
+// propagated when the exporter is 'none', so that trace IDs are available in logs
+func NewService(cfg *Config, serviceVersion string) (*Tracer, error) {
+	var (
+		exporter Exporter
+		err      error
+	)
+
+	switch cfg.Exporter {
+	case "", "none":
+	case "stdout":
+		exporter = newStdoutExporter(cfg.ServiceName)
+	case "file":
+		exporter, err = newFileExporter(cfg.ServiceName, cfg.FilePath)
+	case "otlp":
+		exporter, err = newOTLPExporter(cfg.ServiceName, serviceVersion, cfg.OTLPEndpoint, cfg.OTLPHeaders)
+	default:
+		err = fmt.Errorf("unknown tracing exporter '%s'", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newTracer(cfg.ServiceName, serviceVersion, cfg.SampleRatio, exporter), nil
+}
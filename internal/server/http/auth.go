@@ -0,0 +1,183 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/api"
+	"github.com/jerryan999/goapp/internal/apikeys"
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/sessions"
+)
+
+// csrfHeader is the header which state changing requests authenticated by a session cookie must
+// carry the CSRF token of the session in
+const csrfHeader = "X-CSRF-Token"
+
+// credentials returns the credentials of an 'Authorization: <scheme> <credentials>' header, and
+// false if there's no such header with the given scheme
+func credentials(c *gin.Context, scheme string) (string, bool) {
+	actual, value, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(actual, scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(value), true
+}
+
+// bearerToken returns the token of an 'Authorization: Bearer <token>' header, and false if there's
+// no such header
+func bearerToken(c *gin.Context) (string, bool) {
+	return credentials(c, "Bearer")
+}
+
+// unauthorized aborts the request with status 401, and the challenge of RFC 6750
+func unauthorized(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	abortWithProblem(c, http.StatusUnauthorized, err.Error())
+}
+
+// authenticate verifies the bearer token of the request, if any, and adds the principal it was
+// issued to to the request context. Requests without a token are served anonymously, it's up to
+// the routes or the APIs to require a principal
+func authenticate(a *api.API) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// the user of the principal is read once per request, by whichever of the authentication
+		// middleware and the APIs needs it first
+		c.Request = c.Request.WithContext(api.ContextWithActorCache(c.Request.Context()))
+
+		token, ok := bearerToken(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		p, err := a.Authenticate(ctx, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrInvalidToken):
+				unauthorized(c, auth.ErrInvalidToken)
+			case errors.Is(err, cachestore.ErrCacheNotInitialized):
+				// revoked tokens cannot be told apart without the revocation list, so none are accepted
+				abortWithProblem(c, http.StatusServiceUnavailable, "authentication unavailable")
+			default:
+				a.Logger.WithContext(ctx).Error(err.Error())
+				abortWithProblem(c, http.StatusInternalServerError, "server error")
+			}
+			return
+		}
+
+		ctx = auth.ContextWithPrincipal(ctx, p)
+		ctx = logger.ContextWithFields(ctx, "userID", p.Subject)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// authenticateAPIKey verifies the API key of an 'Authorization: ApiKey <key>' header, if any, and
+// adds the service principal it was issued to to the request context. Like authenticate, requests
+// without a key are passed on, it's up to the APIs to require a principal
+func authenticateAPIKey(a *api.API) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := credentials(c, "ApiKey")
+		if !ok {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		p, err := a.AuthenticateAPIKey(ctx, key)
+		if err != nil {
+			if errors.Is(err, apikeys.ErrInvalidKey) {
+				c.Header("WWW-Authenticate", "ApiKey")
+				abortWithProblem(c, http.StatusUnauthorized, apikeys.ErrInvalidKey.Error())
+			} else {
+				a.Logger.WithContext(ctx).Error(err.Error())
+				abortWithProblem(c, http.StatusInternalServerError, "server error")
+			}
+			return
+		}
+
+		ctx = auth.ContextWithPrincipal(ctx, p)
+		ctx = logger.ContextWithFields(ctx, "apiKeyID", p.APIKeyID)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// safeMethod reports if method does not change state, so that it does not require a CSRF token
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// authenticateSession verifies the session cookie of requests which were not authenticated with a
+// bearer token, and adds the principal of the session to the request context. Requests with an
+// unknown or expired session are served anonymously, and the cookie is removed. State changing
+// requests must carry the CSRF token of the session, since browsers send cookies along with
+// cross-site requests
+func authenticateSession(a *api.API) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		token, err := c.Cookie(a.SessionCookieName())
+		if err != nil || token == "" || auth.PrincipalFromContext(ctx) != nil {
+			c.Next()
+			return
+		}
+
+		s, err := a.AuthenticateSession(ctx, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, sessions.ErrSessionNotFound):
+				http.SetCookie(c.Writer, a.SessionCookie(""))
+				c.Next()
+			case errors.Is(err, cachestore.ErrCacheNotInitialized):
+				abortWithProblem(c, http.StatusServiceUnavailable, "authentication unavailable")
+			default:
+				a.Logger.WithContext(ctx).Error(err.Error())
+				abortWithProblem(c, http.StatusInternalServerError, "server error")
+			}
+			return
+		}
+
+		if !safeMethod(c.Request.Method) {
+			err = a.VerifyCSRF(s, c.GetHeader(csrfHeader))
+			if err != nil {
+				abortWithProblem(c, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+
+		p := &auth.Principal{
+			Subject:   s.UserID,
+			Email:     s.Email,
+			SessionID: s.ID,
+			MFA:       s.MFA,
+			IssuedAt:  s.CreatedAt,
+			ExpiresAt: s.ExpiresAt,
+		}
+		ctx = auth.ContextWithPrincipal(ctx, p)
+		ctx = logger.ContextWithFields(ctx, "userID", p.Subject)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// requireAuth responds with status 401 to requests which are not authenticated
+func requireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if auth.PrincipalFromContext(c.Request.Context()) == nil {
+			unauthorized(c, auth.ErrUnauthenticated)
+			return
+		}
+		c.Next()
+	}
+}
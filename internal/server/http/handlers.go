@@ -15,8 +15,25 @@ type Handlers struct {
 func (h *Handlers) Health(c *gin.Context) {
 	d, err := h.api.Health()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(c)
 		return
 	}
 	c.JSON(http.StatusOK, d)
 }
+
+// Liveness is the HTTP handler for the liveness probe, it always responds with 200 as long as the
+// app is able to serve requests
+func (h *Handlers) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, h.api.Liveness())
+}
+
+// Readiness is the HTTP handler for the readiness probe. It responds with 503 if any of the critical
+// dependencies is unhealthy
+func (h *Handlers) Readiness(c *gin.Context) {
+	report := h.api.Readiness(c.Request.Context())
+	status := http.StatusOK
+	if report.Status == api.HealthStatusDown {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/apikeys"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// issueAPIKeyRequest is the request body of IssueAPIKey
+type issueAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInSecond is the lifetime of the key, it does not expire if it's 0
+	ExpiresInSecond int `json:"expiresInSecond"`
+}
+
+// issuedAPIKeyResponse is the response of IssueAPIKey and RotateAPIKey. Key is shown only once, it
+// cannot be retrieved later
+type issuedAPIKeyResponse struct {
+	Key    string       `json:"key"`
+	APIKey *apikeys.Key `json:"apiKey"`
+}
+
+// apiKeyError responds with the HTTP status code appropriate for the error returned by the API
+// key APIs
+func (h *Handlers) apiKeyError(c *gin.Context, err error) {
+	if h.accessError(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, apikeys.ErrKeyValidation):
+		problemJSON(c, http.StatusBadRequest, err.Error(), nil)
+	case errors.Is(err, apikeys.ErrKeyNotFound):
+		problemJSON(c, http.StatusNotFound, apikeys.ErrKeyNotFound.Error(), nil)
+	case errors.Is(err, apikeys.ErrInvalidKey):
+		// only revoked or expired keys can't be rotated
+		problemJSON(c, http.StatusConflict, "api key is revoked or expired", nil)
+	default:
+		serverError(c)
+	}
+}
+
+// IssueAPIKey is the HTTP handler to issue a new API key for a service
+func (h *Handlers) IssueAPIKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(issueAPIKeyRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+	if req.ExpiresInSecond < 0 {
+		badRequest(c, users.ErrUserValidation.Error(), users.ValidationErrors{{
+			Field:         "expiresInSecond",
+			Code:          users.ValidationInvalid,
+			Message:       "expiresInSecond should not be negative",
+			RejectedValue: req.ExpiresInSecond,
+		}})
+		return
+	}
+
+	key, k, err := h.api.IssueAPIKey(ctx, req.Name, req.Scopes, time.Duration(req.ExpiresInSecond)*time.Second)
+	if err != nil {
+		h.apiKeyError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, issuedAPIKeyResponse{Key: key, APIKey: k})
+}
+
+// ListAPIKeys is the HTTP handler to list all the API keys
+func (h *Handlers) ListAPIKeys(c *gin.Context) {
+	list, err := h.api.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		h.apiKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKeys": list})
+}
+
+// RotateAPIKey is the HTTP handler to replace the secret of an API key
+func (h *Handlers) RotateAPIKey(c *gin.Context) {
+	key, k, err := h.api.RotateAPIKey(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.apiKeyError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, issuedAPIKeyResponse{Key: key, APIKey: k})
+}
+
+// RevokeAPIKey is the HTTP handler to revoke an API key
+func (h *Handlers) RevokeAPIKey(c *gin.Context) {
+	k, err := h.api.RevokeAPIKey(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.apiKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, k)
+}
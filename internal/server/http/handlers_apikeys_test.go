@@ -0,0 +1,59 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.createUser(t, "jane@example.com")
+	ts.createUser(t, "admin@example.com", users.RoleAdmin)
+	jane := ts.bearer(t, "jane@example.com")
+	admin := ts.bearer(t, "admin@example.com")
+
+	w := ts.do(http.MethodPost, "/apikeys", `{"name": "billing", "scopes": ["users:read"]}`, "Authorization", jane)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("IssueAPIKey without permission status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w = ts.do(http.MethodPost, "/apikeys", `{"name": "billing", "scopes": ["users:read"]}`, "Authorization", admin)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("IssueAPIKey status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	issued := new(issuedAPIKeyResponse)
+	err := json.Unmarshal(w.Body.Bytes(), issued)
+	if err != nil || issued.Key == "" || issued.APIKey == nil {
+		t.Fatalf("IssueAPIKey response = %s, %v, want the key", w.Body.String(), err)
+	}
+	key := "ApiKey " + issued.Key
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		auth       string
+		wantStatus int
+	}{
+		{name: "within scope", method: http.MethodGet, path: "/users", auth: key, wantStatus: http.StatusOK},
+		{name: "outside scope", method: http.MethodDelete, path: "/users/delete?email=jane@example.com", auth: key, wantStatus: http.StatusForbidden},
+		{name: "unknown key", method: http.MethodGet, path: "/users", auth: "ApiKey nope", wantStatus: http.StatusUnauthorized},
+		{name: "outside the user routes", method: http.MethodGet, path: "/apikeys", auth: key, wantStatus: http.StatusUnauthorized},
+		{name: "revoke", method: http.MethodDelete, path: "/apikeys/" + issued.APIKey.ID, auth: admin, wantStatus: http.StatusOK},
+		{name: "revoked key", method: http.MethodGet, path: "/users", auth: key, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ts.do(tt.method, tt.path, "", "Authorization", tt.auth)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code >= http.StatusBadRequest {
+				decodeProblemResponse(t, w)
+			}
+		})
+	}
+}
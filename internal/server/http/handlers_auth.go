@@ -0,0 +1,100 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/lockout"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// refreshRequest is the request body of RefreshTokens and RevokeTokens
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// authError responds with the status matching err, which is returned by any of the token APIs
+func (h *Handlers) authError(c *gin.Context, err error) {
+	if mfaChallenge(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, users.ErrInvalidCredentials):
+		problemJSON(c, http.StatusUnauthorized, users.ErrInvalidCredentials.Error(), nil)
+	case errors.Is(err, lockout.ErrLocked):
+		tooManyRequests(c, err)
+	case errors.Is(err, auth.ErrInvalidToken):
+		problemJSON(c, http.StatusUnauthorized, auth.ErrInvalidToken.Error(), nil)
+	case errors.Is(err, cachestore.ErrCacheNotInitialized):
+		problemJSON(c, http.StatusServiceUnavailable, "authentication unavailable", nil)
+	default:
+		serverError(c)
+	}
+}
+
+// IssueTokens is the HTTP handler to log in with email and password, in exchange for tokens
+func (h *Handlers) IssueTokens(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(loginRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	pair, err := h.api.IssueTokens(ctx, req.Email, req.Password, c.ClientIP())
+	if err != nil {
+		h.authError(c, err)
+		return
+	}
+
+	// tokens must never be cached by browsers or proxies
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, pair)
+}
+
+// RefreshTokens is the HTTP handler to exchange a refresh token for a new pair of tokens
+func (h *Handlers) RefreshTokens(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(refreshRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	pair, err := h.api.RefreshTokens(ctx, req.RefreshToken)
+	if err != nil {
+		h.authError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, pair)
+}
+
+// RevokeTokens is the HTTP handler to log out, by revoking the access token of the request and
+// optionally the refresh token in the body
+func (h *Handlers) RevokeTokens(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(refreshRequest)
+	if c.Request.ContentLength != 0 {
+		if !bindJSON(c, req) {
+			return
+		}
+	}
+
+	err := h.api.RevokeTokens(ctx, req.RefreshToken)
+	if err != nil {
+		h.authError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// JWKS is the HTTP handler which lists the public keys tokens are verified with
+func (h *Handlers) JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, h.api.JWKS())
+}
@@ -0,0 +1,205 @@
+package http
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/lockout"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// mfaLoginRequest is the request body of the handlers completing a login with a one-time code
+type mfaLoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// mfaCodeRequest is the request body of ConfirmMFA and DisableMFA
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// mfaConfirmResponse is the response of ConfirmMFA. The recovery codes are shown only once
+type mfaConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// mfaChallengeProblem is the problem details of a login which has to be completed with a one-time
+// code, along with the token to complete it with
+type mfaChallengeProblem struct {
+	*problem
+	MFAToken     string `json:"mfa_token"`
+	MFAExpiresIn int    `json:"mfa_expires_in"`
+}
+
+// mfaChallenge responds with status 401 and the challenge token if err is a *users.MFAChallengeError,
+// and reports if it did
+func mfaChallenge(c *gin.Context, err error) bool {
+	var challengeErr *users.MFAChallengeError
+	if !errors.As(err, &challengeErr) {
+		return false
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", problemContentType)
+	c.JSON(http.StatusUnauthorized, &mfaChallengeProblem{
+		problem:      newProblem(c, http.StatusUnauthorized, users.ErrMFARequired.Error(), nil),
+		MFAToken:     challengeErr.Token,
+		MFAExpiresIn: int(math.Ceil(time.Until(challengeErr.ExpiresAt).Seconds())),
+	})
+	return true
+}
+
+// mfaLoginError responds with the status matching err, which is returned when completing a login
+// with a one-time code
+func mfaLoginError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, users.ErrInvalidMFACode):
+		problemJSON(c, http.StatusUnauthorized, users.ErrInvalidMFACode.Error(), nil)
+	case errors.Is(err, lockout.ErrLocked):
+		tooManyRequests(c, err)
+	case errors.Is(err, users.ErrInvalidToken):
+		problemJSON(c, http.StatusUnauthorized, users.ErrInvalidToken.Error(), nil)
+	case errors.Is(err, cachestore.ErrCacheNotInitialized):
+		problemJSON(c, http.StatusServiceUnavailable, "authentication unavailable", nil)
+	default:
+		serverError(c)
+	}
+}
+
+// mfaError responds with the HTTP status code appropriate for the error returned by the MFA
+// management APIs
+func (h *Handlers) mfaError(c *gin.Context, err error) {
+	if h.accessError(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, users.ErrInvalidMFACode):
+		problemJSON(c, http.StatusBadRequest, users.ErrInvalidMFACode.Error(), nil)
+	case errors.Is(err, lockout.ErrLocked):
+		tooManyRequests(c, err)
+	case errors.Is(err, users.ErrMFAAlreadyEnabled):
+		problemJSON(c, http.StatusConflict, users.ErrMFAAlreadyEnabled.Error(), nil)
+	case errors.Is(err, users.ErrMFANotEnabled):
+		problemJSON(c, http.StatusConflict, users.ErrMFANotEnabled.Error(), nil)
+	case errors.Is(err, users.ErrUserNotFound):
+		problemJSON(c, http.StatusNotFound, users.ErrUserNotFound.Error(), nil)
+	case errors.Is(err, cachestore.ErrCacheNotInitialized):
+		problemJSON(c, http.StatusServiceUnavailable, "mfa unavailable", nil)
+	default:
+		serverError(c)
+	}
+}
+
+// CompleteMFATokens is the HTTP handler to complete the login of IssueTokens with a one-time code,
+// in exchange for tokens
+func (h *Handlers) CompleteMFATokens(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(mfaLoginRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	pair, err := h.api.CompleteMFATokens(ctx, req.MFAToken, req.Code, c.ClientIP())
+	if err != nil {
+		mfaLoginError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, pair)
+}
+
+// CreateMFASession is the HTTP handler to complete the login of CreateSession with a one-time code
+func (h *Handlers) CreateMFASession(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(mfaLoginRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	token, s, err := h.api.CreateMFASession(ctx, req.MFAToken, req.Code, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		mfaLoginError(c, err)
+		return
+	}
+
+	http.SetCookie(c.Writer, h.api.SessionCookie(token))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, sessionResponse{Session: s, CSRFToken: s.CSRFToken})
+}
+
+// LoginMFA is the HTTP handler to complete the login of Login with a one-time code
+func (h *Handlers) LoginMFA(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(mfaLoginRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	u, err := h.api.LoginMFA(ctx, req.MFAToken, req.Code, c.ClientIP())
+	if err != nil {
+		mfaLoginError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, u)
+}
+
+// EnrollMFA is the HTTP handler to start the MFA enrollment of the authenticated user. The secret
+// and its otpauth:// URI are to be added to an authenticator app, then confirmed with ConfirmMFA
+func (h *Handlers) EnrollMFA(c *gin.Context) {
+	enrollment, err := h.api.EnrollMFA(c.Request.Context())
+	if err != nil {
+		h.mfaError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMFA is the HTTP handler to enable MFA for the authenticated user, with a code of the secret
+// returned by EnrollMFA
+func (h *Handlers) ConfirmMFA(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(mfaCodeRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	codes, err := h.api.ConfirmMFA(ctx, req.Code)
+	if err != nil {
+		h.mfaError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, mfaConfirmResponse{RecoveryCodes: codes})
+}
+
+// DisableMFA is the HTTP handler to disable MFA for the user with the email in the query, or the
+// authenticated user if it's missing. The code is required only for the authenticated user
+func (h *Handlers) DisableMFA(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(mfaCodeRequest)
+	if c.Request.ContentLength != 0 {
+		if !bindJSON(c, req) {
+			return
+		}
+	}
+
+	err := h.api.DisableMFA(ctx, c.Query("email"), req.Code)
+	if err != nil {
+		h.mfaError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/lockout"
+	"github.com/jerryan999/goapp/internal/pkg/ratelimit"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// forgotPasswordRequest is the request body of ForgotPassword
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// resetPasswordRequest is the request body of ResetPassword
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// tooManyRequests responds with status 429, and tells the client when to retry if err is a
+// *ratelimit.LimitError or a *lockout.LockedError
+func tooManyRequests(c *gin.Context, err error) {
+	var (
+		limitErr  *ratelimit.LimitError
+		lockedErr *lockout.LockedError
+	)
+	message := ratelimit.ErrLimited.Error()
+	switch {
+	case errors.As(err, &limitErr):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+	case errors.As(err, &lockedErr):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockedErr.RetryAfter.Seconds()))))
+		message = lockout.ErrLocked.Error()
+	}
+	problemJSON(c, http.StatusTooManyRequests, message, nil)
+}
+
+// ForgotPassword is the HTTP handler to ask for a password reset token by email. It responds the same
+// whether a user with the email exists or not
+func (h *Handlers) ForgotPassword(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(forgotPasswordRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	err := h.api.RequestPasswordReset(ctx, req.Email, c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, ratelimit.ErrLimited):
+			tooManyRequests(c, err)
+		case errors.Is(err, users.ErrUserValidation):
+			validationError(c, err)
+		default:
+			serverError(c)
+		}
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// ResetPassword is the HTTP handler to choose a new password, with the token sent by ForgotPassword
+func (h *Handlers) ResetPassword(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(resetPasswordRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	err := h.api.ResetPassword(ctx, req.Token, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrUserValidation):
+			validationError(c, err)
+		case errors.Is(err, users.ErrInvalidToken):
+			problemJSON(c, http.StatusBadRequest, users.ErrInvalidToken.Error(), nil)
+		case errors.Is(err, cachestore.ErrCacheNotInitialized):
+			problemJSON(c, http.StatusServiceUnavailable, "password reset unavailable", nil)
+		default:
+			serverError(c)
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
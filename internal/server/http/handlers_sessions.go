@@ -0,0 +1,137 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/lockout"
+	"github.com/jerryan999/goapp/internal/sessions"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// sessionResponse is the response of CreateSession and CurrentSession. The CSRF token has to be
+// sent in the X-CSRF-Token header of every state changing request authenticated by the session
+type sessionResponse struct {
+	Session   *sessions.Session `json:"session"`
+	CSRFToken string            `json:"csrf_token"`
+}
+
+// sessionError responds with the status matching err, which is returned by any of the session APIs
+func (h *Handlers) sessionError(c *gin.Context, err error) {
+	if mfaChallenge(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, users.ErrInvalidCredentials):
+		problemJSON(c, http.StatusUnauthorized, users.ErrInvalidCredentials.Error(), nil)
+	case errors.Is(err, lockout.ErrLocked):
+		tooManyRequests(c, err)
+	case errors.Is(err, auth.ErrUnauthenticated):
+		problemJSON(c, http.StatusUnauthorized, auth.ErrUnauthenticated.Error(), nil)
+	case errors.Is(err, sessions.ErrSessionNotFound):
+		problemJSON(c, http.StatusNotFound, sessions.ErrSessionNotFound.Error(), nil)
+	case errors.Is(err, cachestore.ErrCacheNotInitialized):
+		problemJSON(c, http.StatusServiceUnavailable, "sessions unavailable", nil)
+	default:
+		serverError(c)
+	}
+}
+
+// CreateSession is the HTTP handler to log in from a browser with email and password. The session
+// token is set as an HttpOnly cookie, and is never exposed to scripts
+func (h *Handlers) CreateSession(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(loginRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	token, s, err := h.api.CreateSession(ctx, req.Email, req.Password, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		h.sessionError(c, err)
+		return
+	}
+
+	http.SetCookie(c.Writer, h.api.SessionCookie(token))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, sessionResponse{Session: s, CSRFToken: s.CSRFToken})
+}
+
+// CurrentSession is the HTTP handler which returns the session of the request along with its CSRF
+// token, e.g. for a browser app to recover the token after a reload
+func (h *Handlers) CurrentSession(c *gin.Context) {
+	s, err := h.api.CurrentSession(c.Request.Context())
+	if err != nil {
+		h.sessionError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, sessionResponse{Session: s, CSRFToken: s.CSRFToken})
+}
+
+// ListSessions is the HTTP handler which lists all the active sessions of the authenticated user
+func (h *Handlers) ListSessions(c *gin.Context) {
+	list, err := h.api.ListSessions(c.Request.Context())
+	if err != nil {
+		h.sessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": list})
+}
+
+// Logout is the HTTP handler to revoke the session of the request, and remove its cookie
+func (h *Handlers) Logout(c *gin.Context) {
+	ctx := c.Request.Context()
+	p := auth.PrincipalFromContext(ctx)
+	if p.SessionID == "" {
+		problemJSON(c, http.StatusBadRequest, "not authenticated with a session", nil)
+		return
+	}
+
+	err := h.api.RevokeSession(ctx, p.SessionID)
+	if err != nil && !errors.Is(err, sessions.ErrSessionNotFound) {
+		h.sessionError(c, err)
+		return
+	}
+
+	http.SetCookie(c.Writer, h.api.SessionCookie(""))
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeSession is the HTTP handler to revoke one of the sessions of the authenticated user
+func (h *Handlers) RevokeSession(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	err := h.api.RevokeSession(ctx, id)
+	if err != nil {
+		h.sessionError(c, err)
+		return
+	}
+
+	if auth.PrincipalFromContext(ctx).SessionID == id {
+		http.SetCookie(c.Writer, h.api.SessionCookie(""))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeSessions is the HTTP handler to revoke all the sessions of the authenticated user
+func (h *Handlers) RevokeSessions(c *gin.Context) {
+	ctx := c.Request.Context()
+	err := h.api.RevokeSessions(ctx)
+	if err != nil {
+		h.sessionError(c, err)
+		return
+	}
+
+	if auth.PrincipalFromContext(ctx).SessionID != "" {
+		http.SetCookie(c.Writer, h.api.SessionCookie(""))
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSessionCSRF(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.createUser(t, "jane@example.com")
+
+	w := ts.do(http.MethodPost, "/sessions", `{"email": "jane@example.com", "password": "correct horse battery"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("CreateSession status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == ts.api.SessionCookieName() {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || !cookie.HttpOnly || !cookie.Secure {
+		t.Fatalf("CreateSession cookie = %+v, want a secure HttpOnly session cookie", cookie)
+	}
+	created := new(sessionResponse)
+	err := json.Unmarshal(w.Body.Bytes(), created)
+	if err != nil || created.CSRFToken == "" {
+		t.Fatalf("CreateSession response = %s, %v, want the CSRF token", w.Body.String(), err)
+	}
+	session := cookie.Name + "=" + cookie.Value
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		csrf       string
+		wantStatus int
+	}{
+		{name: "safe method without token", method: http.MethodGet, path: "/sessions/current", wantStatus: http.StatusOK},
+		{name: "unsafe method without token", method: http.MethodDelete, path: "/sessions/current", wantStatus: http.StatusForbidden},
+		{name: "unsafe method with wrong token", method: http.MethodDelete, path: "/sessions/current", csrf: "nope", wantStatus: http.StatusForbidden},
+		{name: "unsafe method with token", method: http.MethodDelete, path: "/sessions/current", csrf: created.CSRFToken, wantStatus: http.StatusNoContent},
+		{name: "revoked session", method: http.MethodGet, path: "/sessions/current", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := []string{"Cookie", session}
+			if tt.csrf != "" {
+				header = append(header, csrfHeader, tt.csrf)
+			}
+			w := ts.do(tt.method, tt.path, "", header...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code >= http.StatusBadRequest {
+				decodeProblemResponse(t, w)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/jerryan999/goapp/internal/api"
+)
+
+func TestHealth(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+
+	tests := []struct {
+		name       string
+		checks     []api.HealthCheck
+		wantStatus int
+		wantHealth string
+	}{
+		{name: "no dependencies", wantStatus: http.StatusOK, wantHealth: api.HealthStatusUp},
+		{
+			name: "all up",
+			checks: []api.HealthCheck{
+				{Name: "datastore", Critical: true, Check: up},
+				{Name: "cache", Check: up},
+			},
+			wantStatus: http.StatusOK,
+			wantHealth: api.HealthStatusUp,
+		},
+		{
+			name: "non-critical down",
+			checks: []api.HealthCheck{
+				{Name: "datastore", Critical: true, Check: up},
+				{Name: "cache", Check: down},
+			},
+			wantStatus: http.StatusOK,
+			wantHealth: api.HealthStatusDegraded,
+		},
+		{
+			name: "critical down",
+			checks: []api.HealthCheck{
+				{Name: "datastore", Critical: true, Check: down},
+				{Name: "cache", Check: up},
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantHealth: api.HealthStatusDown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil, tt.checks...)
+
+			w := ts.do(http.MethodGet, "/health/live", "")
+			if w.Code != http.StatusOK {
+				t.Errorf("liveness status = %d, want %d whatever the dependencies", w.Code, http.StatusOK)
+			}
+
+			w = ts.do(http.MethodGet, "/health/ready", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("readiness status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			report := new(api.HealthReport)
+			err := json.Unmarshal(w.Body.Bytes(), report)
+			if err != nil {
+				t.Fatalf("decoding readiness %q: %v", w.Body.String(), err)
+			}
+			if report.Status != tt.wantHealth || len(report.Dependencies) != len(tt.checks) {
+				t.Errorf("readiness = %+v, want %s with %d dependencies", report, tt.wantHealth, len(tt.checks))
+			}
+		})
+	}
+}
@@ -2,10 +2,16 @@ package http
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/lockout"
 	"github.com/jerryan999/goapp/internal/users"
 )
 
@@ -14,21 +20,54 @@ import (
 func (h *Handlers) CreateUser(c *gin.Context) {
 	ctx := c.Request.Context()
 	u := new(users.User)
-	if err := c.BindJSON(u); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, u) {
 		return
 	}
 
 	_, err := h.api.CreateUser(ctx, u)
 	if err != nil {
-		if errors.Is(err, users.ErrUserValidation) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": users.ErrUserValidation.Error()})
+		if h.accessError(c, err) {
+			return
+		} else if errors.Is(err, users.ErrUserValidation) {
+			validationError(c, err)
+		} else if errors.Is(err, users.ErrUserAlreadyExists) {
+			problemJSON(c, http.StatusConflict, users.ErrUserAlreadyExists.Error(), nil)
 		} else {
+			serverError(c)
+		}
+		return
+	}
+}
+
+// loginRequest is the request body of Login
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Login is the HTTP handler to verify the email and password of a user
+func (h *Handlers) Login(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(loginRequest)
+	if !bindJSON(c, req) {
+		return
+	}
 
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+	u, err := h.api.Login(ctx, req.Email, req.Password, c.ClientIP())
+	if err != nil {
+		if mfaChallenge(c, err) {
+			return
+		} else if errors.Is(err, users.ErrInvalidCredentials) {
+			problemJSON(c, http.StatusUnauthorized, users.ErrInvalidCredentials.Error(), nil)
+		} else if errors.Is(err, lockout.ErrLocked) {
+			tooManyRequests(c, err)
+		} else {
+			serverError(c)
 		}
 		return
 	}
+
+	c.JSON(http.StatusOK, u)
 }
 
 // ReadUserByEmail is the HTTP handler to read an existing user by email
@@ -37,13 +76,213 @@ func (h *Handlers) ReadUserByEmail(c *gin.Context) {
 	email := c.Query("email")
 	u, err := h.api.ReadUserByEmail(ctx, email)
 	if err != nil {
-		if errors.Is(err, users.ErrUserNotFound) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": users.ErrUserNotFound.Error()})
+		h.userError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, u)
+}
+
+// UpdateUser is the HTTP handler to replace an existing user identified by email
+func (h *Handlers) UpdateUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	email := c.Query("email")
+	u := new(users.User)
+	if !bindJSON(c, u) {
+		return
+	}
+
+	u, err := h.api.UpdateUser(ctx, email, u)
+	if err != nil {
+		h.userError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, u)
+}
+
+// PatchUser is the HTTP handler to partially update an existing user identified by email. The
+// request body is expected to be a JSON Merge Patch (RFC 7396) document
+func (h *Handlers) PatchUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	email := c.Query("email")
+	patch, err := c.GetRawData()
+	if err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			problemJSON(c, http.StatusRequestEntityTooLarge, errBodyTooLarge.Error(), nil)
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			badRequest(c, "request body could not be read", nil)
 		}
 		return
 	}
 
+	u, err := h.api.PatchUser(ctx, email, patch)
+	if err != nil {
+		h.userError(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, u)
 }
+
+// DeleteUser is the HTTP handler to delete an existing user identified by email
+func (h *Handlers) DeleteUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	email := c.Query("email")
+	err := h.api.DeleteUser(ctx, email)
+	if err != nil {
+		h.userError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// accessError responds with status 401 or 403 if err is an authentication or authorization error
+// of the policy layer, and reports if it did
+func (h *Handlers) accessError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		unauthorized(c, auth.ErrUnauthenticated)
+	case errors.Is(err, auth.ErrForbidden):
+		problemJSON(c, http.StatusForbidden, auth.ErrForbidden.Error(), nil)
+	default:
+		return false
+	}
+	return true
+}
+
+// rolesRequest is the request body of SetUserRoles
+type rolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// SetUserRoles is the HTTP handler to replace the roles of an existing user identified by email
+func (h *Handlers) SetUserRoles(c *gin.Context) {
+	ctx := c.Request.Context()
+	email := c.Query("email")
+	req := new(rolesRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	u, err := h.api.SetUserRoles(ctx, email, req.Roles)
+	if err != nil {
+		h.userError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, u)
+}
+
+// unlockRequest is the request body of UnlockUser. Either or both of the email and the client IP are
+// unlocked
+type unlockRequest struct {
+	Email string `json:"email"`
+	IP    string `json:"ip"`
+}
+
+// UnlockUser is the HTTP handler to lift the lockout of an account and/or a client IP, after too many
+// failed logins
+func (h *Handlers) UnlockUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(unlockRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	err := h.api.Unlock(ctx, req.Email, req.IP)
+	if err != nil {
+		if errors.Is(err, cachestore.ErrCacheNotInitialized) {
+			problemJSON(c, http.StatusServiceUnavailable, "lockout unavailable", nil)
+		} else {
+			h.userError(c, err)
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// userError responds with the HTTP status code appropriate for the error returned by the users APIs
+func (h *Handlers) userError(c *gin.Context, err error) {
+	if h.accessError(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, users.ErrUserValidation):
+		validationError(c, err)
+	case errors.Is(err, users.ErrUserNotFound):
+		problemJSON(c, http.StatusNotFound, users.ErrUserNotFound.Error(), nil)
+	case errors.Is(err, users.ErrUserAlreadyExists):
+		problemJSON(c, http.StatusConflict, users.ErrUserAlreadyExists.Error(), nil)
+	default:
+		serverError(c)
+	}
+}
+
+// ListUsers is the HTTP handler to list users, a page at a time. Supported query parameters are
+// name & email (prefixes), created_from & created_to (RFC3339), sort (createdAt or -createdAt),
+// limit and cursor (next_cursor of the previous page)
+func (h *Handlers) ListUsers(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	limit := users.DefaultListLimit
+	if v := c.Query("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > users.MaxListLimit {
+			badRequest(c, fmt.Sprintf("limit should be a number between 1 and %d", users.MaxListLimit), nil)
+			return
+		}
+		limit = l
+	}
+
+	filter := &users.ListFilter{
+		NamePrefix:  c.Query("name"),
+		EmailPrefix: c.Query("email"),
+	}
+
+	switch c.DefaultQuery("sort", "createdAt") {
+	case "createdAt":
+	case "-createdAt":
+		filter.Descending = true
+	default:
+		badRequest(c, "sort should be either createdAt or -createdAt", nil)
+		return
+	}
+
+	for _, r := range []struct {
+		param  string
+		target **time.Time
+	}{
+		{param: "created_from", target: &filter.CreatedFrom},
+		{param: "created_to", target: &filter.CreatedTo},
+	} {
+		param, target := r.param, r.target
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			badRequest(c, fmt.Sprintf("%s should be an RFC3339 timestamp", param), nil)
+			return
+		}
+		*target = &t
+	}
+
+	page, err := h.api.ListUsers(ctx, filter, c.Query("cursor"), limit)
+	if err != nil {
+		if h.accessError(c, err) {
+			return
+		} else if errors.Is(err, users.ErrInvalidCursor) {
+			badRequest(c, users.ErrInvalidCursor.Error(), nil)
+		} else {
+			serverError(c)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+func TestUsersAccessControl(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.createUser(t, "jane@example.com")
+	ts.createUser(t, "john@example.com")
+	ts.createUser(t, "admin@example.com", users.RoleAdmin)
+	ts.createUser(t, "gone@example.com")
+	jane := ts.bearer(t, "jane@example.com")
+	admin := ts.bearer(t, "admin@example.com")
+	gone := ts.bearer(t, "gone@example.com")
+	err := ts.users.DeleteUser(context.Background(), "gone@example.com")
+	if err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		auth       string
+		wantStatus int
+	}{
+		{name: "list anonymously", method: http.MethodGet, path: "/users", wantStatus: http.StatusUnauthorized},
+		{name: "list without permission", method: http.MethodGet, path: "/users", auth: jane, wantStatus: http.StatusForbidden},
+		{name: "list as admin", method: http.MethodGet, path: "/users", auth: admin, wantStatus: http.StatusOK},
+		{name: "invalid token", method: http.MethodGet, path: "/users", auth: "Bearer nope", wantStatus: http.StatusUnauthorized},
+		{name: "token of a deleted user", method: http.MethodGet, path: "/users/retrieve?email=gone@example.com", auth: gone, wantStatus: http.StatusUnauthorized},
+		{name: "read self", method: http.MethodGet, path: "/users/retrieve?email=jane@example.com", auth: jane, wantStatus: http.StatusOK},
+		{name: "read other", method: http.MethodGet, path: "/users/retrieve?email=john@example.com", auth: jane, wantStatus: http.StatusForbidden},
+		{name: "read other as admin", method: http.MethodGet, path: "/users/retrieve?email=john@example.com", auth: admin, wantStatus: http.StatusOK},
+		{name: "delete other", method: http.MethodDelete, path: "/users/delete?email=john@example.com", auth: jane, wantStatus: http.StatusForbidden},
+		{
+			name:       "grant own roles",
+			method:     http.MethodPut,
+			path:       "/users/roles?email=jane@example.com",
+			body:       `{"roles": ["admin"]}`,
+			auth:       jane,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "sign up as admin",
+			method:     http.MethodPost,
+			path:       "/users/create",
+			body:       `{"firstName": "Eve", "email": "eve@example.com", "password": "correct horse battery", "roles": ["admin"]}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "grant roles as admin",
+			method:     http.MethodPut,
+			path:       "/users/roles?email=john@example.com",
+			body:       `{"roles": ["admin"]}`,
+			auth:       admin,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header []string
+			if tt.auth != "" {
+				header = []string{"Authorization", tt.auth}
+			}
+			w := ts.do(tt.method, tt.path, tt.body, header...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("WWW-Authenticate header is missing from the 401 response")
+			}
+			if w.Code >= http.StatusBadRequest {
+				decodeProblemResponse(t, w)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+// verifyEmailRequest is the request body of VerifyEmail
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// resendVerificationRequest is the optional request body of ResendVerification
+type resendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// verificationError responds with the HTTP status code appropriate for the error returned by the
+// email verification APIs
+func (h *Handlers) verificationError(c *gin.Context, err error) {
+	if h.accessError(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, users.ErrInvalidToken):
+		problemJSON(c, http.StatusBadRequest, users.ErrInvalidToken.Error(), nil)
+	case errors.Is(err, users.ErrEmailAlreadyVerified):
+		problemJSON(c, http.StatusConflict, users.ErrEmailAlreadyVerified.Error(), nil)
+	case errors.Is(err, users.ErrUserNotFound):
+		problemJSON(c, http.StatusNotFound, users.ErrUserNotFound.Error(), nil)
+	case errors.Is(err, cachestore.ErrCacheNotInitialized):
+		problemJSON(c, http.StatusServiceUnavailable, "email verification unavailable", nil)
+	default:
+		serverError(c)
+	}
+}
+
+// VerifyEmail is the HTTP handler to verify the email of a user with the token sent to it
+func (h *Handlers) VerifyEmail(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(verifyEmailRequest)
+	if !bindJSON(c, req) {
+		return
+	}
+
+	u, err := h.api.VerifyEmail(ctx, req.Token)
+	if err != nil {
+		h.verificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, u)
+}
+
+// ResendVerification is the HTTP handler to send a new verification token. The email in the request
+// body is optional, it defaults to the one of the authenticated user
+func (h *Handlers) ResendVerification(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := new(resendVerificationRequest)
+	if c.Request.ContentLength != 0 {
+		if !bindJSON(c, req) {
+			return
+		}
+	}
+
+	err := h.api.ResendVerification(ctx, req.Email)
+	if err != nil {
+		h.verificationError(c, err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
@@ -1,23 +1,58 @@
 package http
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jerryan999/goapp/internal/api"
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/metrics"
 )
 
 // HTTP struct holds all the dependencies required for starting HTTP server
 type HTTP struct {
 	server *http.Server
 	cfg    *Config
+	// maxBodyBytes is accessed atomically, since it can be changed on configuration reload
+	maxBodyBytes int64
+	// rateLimits holds the []*RateLimit policies, which can be changed on configuration reload too
+	rateLimits atomic.Value
 }
 
-// Start starts the HTTP server
-func (h *HTTP) Start() {
-	h.server.ListenAndServe()
+// SetLimits sets the limits enforced by the middleware of h, from now on. Other fields of cfg, which
+// require restarting the server, are ignored. Invalid rate limit policies are ignored as well, since
+// cfg is validated before being applied
+func (h *HTTP) SetLimits(cfg *Config) {
+	atomic.StoreInt64(&h.maxBodyBytes, cfg.MaxBodyBytes)
+	policies, err := parseRateLimits(cfg.RateLimits)
+	if err == nil {
+		h.rateLimits.Store(policies)
+	}
+}
+
+// Start starts the HTTP server and blocks until it's stopped. It returns nil if the server was
+// stopped by Shutdown
+func (h *HTTP) Start() error {
+	err := h.server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting new connections and waits for the
+// in-flight requests to complete, until ctx is done
+func (h *HTTP) Shutdown(ctx context.Context) error {
+	err := h.server.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("http shutdown: %w", err)
+	}
+	return nil
 }
 
 // Config holds all the configuration required to start the HTTP server
@@ -27,26 +62,124 @@ type Config struct {
 	ReadTimeoutSecond  int    `json:"read_timeout_second"`
 	WriteTimeoutSecond int    `json:"write_timeout_second"`
 	DialTimeoutSecond  int    `json:"dial_timeout_second"`
+	// ShutdownGraceSecond is the maximum time allowed for draining in-flight requests on shutdown
+	ShutdownGraceSecond int `json:"shutdown_grace_second"`
+	// MaxBodyBytes is the maximum size of request bodies, larger requests are rejected. It can be
+	// changed without restarting the server, using SetLimits
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// RateLimitStore is where the rate limits of requests are kept, either 'redis', 'memory' or
+	// 'none' to disable them
+	RateLimitStore string `json:"rate_limit_store"`
+	// RateLimits are the rate limit policies, see ParseRateLimit. The first policy matching a request
+	// applies, and requests matching none are not limited. They can be changed without restarting the
+	// server, using SetLimits
+	RateLimits []string `json:"rate_limits"`
+	// TrustedProxies are the IPs or CIDRs of the proxies in front of the app, e.g. the ingress, whose
+	// X-Forwarded-For header is trusted for the client IP. Rate limits and lockouts are per client IP,
+	// so the header of any other peer is ignored, otherwise clients could pick a new IP on every request
+	TrustedProxies []string `json:"trusted_proxies"`
 }
 
-// NewService returns an instance of HTTP with all its dependencies set
-func NewService(cfg *Config, a *api.API) (*HTTP, error) {
+// NewService returns an instance of HTTP with all its dependencies set. The redis cache is not
+// required unless rate limits are kept in Redis
+func NewService(cfg *Config, a *api.API, cache *cachestore.Handle) (*HTTP, error) {
 	h := &Handlers{
 		api: a,
 	}
-	// Creates a gin router with default middleware:
-	// logger and recovery (crash-free) middleware
-	router := gin.Default()
+	server := &HTTP{
+		cfg:          cfg,
+		maxBodyBytes: cfg.MaxBodyBytes,
+	}
+
+	policies, err := parseRateLimits(cfg.RateLimits)
+	if err != nil {
+		return nil, err
+	}
+	server.rateLimits.Store(policies)
+	limiter, err := newRateLimiter(cfg.RateLimitStore, cache, a.Logger)
+	if err != nil {
+		return nil, err
+	}
+	// limit is added to every group, after the authentication middleware of the group, so that
+	// requests can be counted by principal. The health and metrics endpoints are not limited
+	limit := func(c *gin.Context) { c.Next() }
+	if limiter != nil {
+		limit = rateLimit(limiter, &server.rateLimits, a.Logger)
+	}
+
+	// gin's default logger and recovery middleware are replaced with the ones which log using
+	// our structured logger, so that all logs are in the same format
+	router := gin.New()
+	err = router.SetTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("http: %w", err)
+	}
+	router.Use(
+		requestID(),
+		trace(),
+		accessLog(a.Logger),
+		instrument(),
+		recovery(a.Logger),
+		bodyLimit(&server.maxBodyBytes),
+		authenticate(a),
+		authenticateSession(a),
+	)
+	router.GET("/metrics", gin.WrapH(metrics.Handler(metrics.Default)))
 	router.GET("/health", h.Health)
+	router.GET("/health/live", h.Liveness)
+	router.GET("/health/ready", h.Readiness)
+	router.GET("/.well-known/jwks.json", h.JWKS)
+
+	auth_group := router.Group("/auth", limit)
+	{
+		auth_group.POST("/login", h.IssueTokens)
+		auth_group.POST("/login/mfa", h.CompleteMFATokens)
+		auth_group.POST("/refresh", h.RefreshTokens)
+		auth_group.POST("/logout", requireAuth(), h.RevokeTokens)
+	}
+
+	session_group := router.Group("/sessions", limit)
+	{
+		session_group.POST("", h.CreateSession)
+		session_group.POST("/mfa", h.CreateMFASession)
+		session_group.GET("", requireAuth(), h.ListSessions)
+		session_group.DELETE("", requireAuth(), h.RevokeSessions)
+		session_group.GET("/current", requireAuth(), h.CurrentSession)
+		session_group.DELETE("/current", requireAuth(), h.Logout)
+		session_group.DELETE("/:id", requireAuth(), h.RevokeSession)
+	}
+
+	apikey_group := router.Group("/apikeys", limit, requireAuth())
+	{
+		apikey_group.POST("", h.IssueAPIKey)
+		apikey_group.GET("", h.ListAPIKeys)
+		apikey_group.POST("/:id/rotate", h.RotateAPIKey)
+		apikey_group.DELETE("/:id", h.RevokeAPIKey)
+	}
 
-	// User groups
-	user_group := router.Group("/users")
+	// User groups, which services can access with API keys as well
+	user_group := router.Group("/users", authenticateAPIKey(a), limit)
 	{
+		user_group.GET("", h.ListUsers)
 		user_group.POST("/create", h.CreateUser)
+		user_group.POST("/login", h.Login)
+		user_group.POST("/login/mfa", h.LoginMFA)
 		user_group.GET("/retrieve", h.ReadUserByEmail)
+		user_group.PUT("/update", h.UpdateUser)
+		user_group.PATCH("/patch", h.PatchUser)
+		user_group.DELETE("/delete", h.DeleteUser)
+		user_group.PUT("/roles", h.SetUserRoles)
+		user_group.POST("/unlock", requireAuth(), h.UnlockUser)
+		user_group.POST("/verify", h.VerifyEmail)
+		user_group.POST("/verify/resend", requireAuth(), h.ResendVerification)
+		user_group.POST("/password/forgot", h.ForgotPassword)
+		user_group.POST("/password/reset", h.ResetPassword)
+		user_group.POST("/mfa/enroll", requireAuth(), h.EnrollMFA)
+		user_group.POST("/mfa/confirm", requireAuth(), h.ConfirmMFA)
+		user_group.POST("/mfa/disable", requireAuth(), h.DisableMFA)
 	}
 
-	httpServer := &http.Server{
+	server.server = &http.Server{
 		Addr:              fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
 		Handler:           router,
 		ReadTimeout:       time.Second * time.Duration(cfg.ReadTimeoutSecond),
@@ -55,8 +188,5 @@ func NewService(cfg *Config, a *api.API) (*HTTP, error) {
 		IdleTimeout:       time.Second * time.Duration(cfg.DialTimeoutSecond),
 	}
 
-	return &HTTP{
-		server: httpServer,
-		cfg:    cfg,
-	}, nil
+	return server, nil
 }
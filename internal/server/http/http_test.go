@@ -0,0 +1,159 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/api"
+	"github.com/jerryan999/goapp/internal/apikeys"
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/pkg/mailer"
+	"github.com/jerryan999/goapp/internal/pkg/password"
+	"github.com/jerryan999/goapp/internal/sessions"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+const testPassword = "correct horse battery"
+
+// testServer serves requests with the handlers and middleware of the HTTP server, backed by the
+// in-memory implementations of all the dependencies of the APIs
+type testServer struct {
+	handler http.Handler
+	users   *users.Users
+	api     *api.API
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestServer returns a testServer with cfg, or the config without rate limits if it's nil, and
+// the health checks. Passwords are hashed with cheap parameters, so that tests are fast
+func newTestServer(t *testing.T, cfg *Config, checks ...api.HealthCheck) *testServer {
+	t.Helper()
+
+	if cfg == nil {
+		cfg = &Config{RateLimitStore: RateLimitStoreNone}
+	}
+
+	l := logger.New("goapp", "test", 1)
+	l.SetOutput(io.Discard)
+	h, err := password.NewService(&password.Config{
+		Argon2MemoryKiB:   64,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+	})
+	if err != nil {
+		t.Fatalf("password.NewService: %v", err)
+	}
+
+	us, err := users.NewService(&users.Config{
+		Store:      users.StoreMemory,
+		Cache:      users.CacheMemory,
+		TokenStore: users.TokenStoreMemory,
+	}, l, nil, nil, h, mailer.NewWriterMailer(&mail.Address{Address: "no-reply@localhost"}, io.Discard))
+	if err != nil {
+		t.Fatalf("users.NewService: %v", err)
+	}
+	tokens, err := auth.NewService(&auth.Config{
+		Store:     auth.StoreMemory,
+		Algorithm: "HS256",
+		Key:       "a secret of at least thirty-two bytes",
+	}, l, nil)
+	if err != nil {
+		t.Fatalf("auth.NewService: %v", err)
+	}
+	ss, err := sessions.NewService(&sessions.Config{Store: sessions.StoreMemory, CookieSecure: true}, l, nil)
+	if err != nil {
+		t.Fatalf("sessions.NewService: %v", err)
+	}
+	ak, err := apikeys.NewService(&apikeys.Config{Store: apikeys.StoreMemory}, l, nil)
+	if err != nil {
+		t.Fatalf("apikeys.NewService: %v", err)
+	}
+	a, err := api.NewService(l, us, tokens, ss, ak, api.BuildInfo{}, checks)
+	if err != nil {
+		t.Fatalf("api.NewService: %v", err)
+	}
+
+	server, err := NewService(cfg, a, nil)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return &testServer{handler: server.server.Handler, users: us, api: a}
+}
+
+// do serves a request with body, if it's not empty, and the header key value pairs
+func (ts *testServer) do(method string, path string, body string, header ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Add(header[i], header[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, req)
+	return w
+}
+
+// createUser creates a user with email, the test password and roles, if any
+func (ts *testServer) createUser(t *testing.T, email string, roles ...string) *users.User {
+	t.Helper()
+
+	ctx := context.Background()
+	u, err := ts.users.CreateUser(ctx, &users.User{FirstName: "Jane", Email: email, Password: testPassword})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if len(roles) > 0 {
+		u, err = ts.users.SetRoles(ctx, email, roles)
+		if err != nil {
+			t.Fatalf("SetRoles: %v", err)
+		}
+	}
+	return u
+}
+
+// bearer returns the Authorization header value with an access token of the user with email
+func (ts *testServer) bearer(t *testing.T, email string) string {
+	t.Helper()
+
+	pair, err := ts.api.IssueTokens(context.Background(), email, testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	return "Bearer " + pair.AccessToken
+}
+
+// decodeProblemResponse returns the problem details of the response, and fails the test if it's not one
+func decodeProblemResponse(t *testing.T, w *httptest.ResponseRecorder) *problem {
+	t.Helper()
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, problemContentType) {
+		t.Fatalf("Content-Type = %q, want %q", ct, problemContentType)
+	}
+	p := new(problem)
+	err := json.Unmarshal(w.Body.Bytes(), p)
+	if err != nil {
+		t.Fatalf("decoding problem %q: %v", w.Body.String(), err)
+	}
+	if p.Status != w.Code {
+		t.Errorf("problem status = %d, want the status of the response %d", p.Status, w.Code)
+	}
+	return p
+}
@@ -0,0 +1,236 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/pkg/metrics"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+const (
+	// HeaderRequestID is the header used to propagate the ID of a request across services
+	HeaderRequestID = "X-Request-ID"
+
+	maxRequestIDLength = 128
+)
+
+var (
+	httpRequests = metrics.NewCounterVec(
+		"http_requests_total",
+		"Number of HTTP requests served, partitioned by route and status",
+		"method", "route", "status",
+	)
+	httpRequestDuration = metrics.NewHistogramVec(
+		"http_request_duration_seconds",
+		"Latency of HTTP requests",
+		nil,
+		"method", "route",
+	)
+)
+
+// validRequestID reports if id, received from a client, is safe to be used as a request ID. It's
+// restricted to a small set of characters since it's written as is to the logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID propagates the request ID received in the X-Request-ID header, or generates a new one.
+// The ID is added to the response headers, and to the log fields of the request context
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(HeaderRequestID)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		c.Header(HeaderRequestID, id)
+		ctx := logger.ContextWithFields(c.Request.Context(), "requestID", id)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// trace starts a server span for every request, as a child of the span in the traceparent header if
+// any. The trace ID is added to the log fields of the request context, so logs can be correlated with traces
+func trace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if sc, err := tracing.ParseTraceparent(c.GetHeader(tracing.HeaderTraceparent)); err == nil {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "-"
+		}
+		ctx, span := tracing.StartServer(
+			ctx,
+			fmt.Sprintf("%s %s", c.Request.Method, route),
+			"http.method", c.Request.Method,
+			"http.route", route,
+			"http.client_ip", c.ClientIP(),
+		)
+		defer span.End()
+
+		sc := span.SpanContext()
+		ctx = logger.ContextWithFields(ctx, "traceID", sc.TraceID.String(), "spanID", sc.SpanID.String())
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes("http.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(status))
+		}
+	}
+}
+
+// accessLog writes a single structured log for every request, after it's served
+func accessLog(l logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			// requests which did not match any route
+			route = "-"
+		}
+
+		size := c.Writer.Size()
+		if size < 0 {
+			size = 0
+		}
+
+		status := c.Writer.Status()
+		rl := l.WithContext(c.Request.Context()).With(
+			"method", c.Request.Method,
+			"route", route,
+			"status", status,
+			"latencyMs", float64(time.Since(start).Microseconds())/1000,
+			"bytes", size,
+			"clientIP", c.ClientIP(),
+		)
+		if status >= http.StatusInternalServerError {
+			rl.Error("request served")
+		} else {
+			rl.Info("request served")
+		}
+	}
+}
+
+// instrument records the metrics of every request. Requests are partitioned by route template instead
+// of path, to keep the number of series bounded
+func instrument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "-"
+		}
+		httpRequests.With(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.With(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// recovery recovers from panics in handlers, logs them along with the stack trace and responds with
+// status 500
+func recovery(l logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			l.WithContext(c.Request.Context()).With(
+				"panic", fmt.Sprint(rec),
+				"stack", string(debug.Stack()),
+			).Error("recovered from panic")
+
+			if c.Writer.Written() {
+				// the response is already (partially) sent, the status cannot be changed anymore
+				c.Abort()
+				return
+			}
+			abortWithProblem(c, http.StatusInternalServerError, "server error")
+		}()
+
+		c.Next()
+	}
+}
+
+// bodyLimit rejects requests with a body larger than the limit, read atomically from maxBytes on
+// every request so that it can be changed at runtime. There's no limit if it's not positive
+func bodyLimit(maxBytes *int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := atomic.LoadInt64(maxBytes)
+		if limit <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > limit {
+			problemJSON(c, http.StatusRequestEntityTooLarge, errBodyTooLarge.Error(), nil)
+			c.Abort()
+			return
+		}
+		// the content length is not known for chunked requests, so the body is also limited while read
+		c.Request.Body = &limitedBody{
+			ReadCloser: http.MaxBytesReader(c.Writer, c.Request.Body, limit),
+			remaining:  limit,
+		}
+		c.Next()
+	}
+}
+
+// limitedBody is a request body limited by http.MaxBytesReader, which returns errBodyTooLarge
+// instead of the untyped error of the reader when the limit is exceeded
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if err != nil && err != io.EOF && b.remaining <= 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/pkg/password"
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+const (
+	// problemContentType is the media type of problem details (RFC 7807)
+	problemContentType = "application/problem+json"
+	// problemTypeDefault is the problem type of problems described by their status code alone
+	problemTypeDefault = "about:blank"
+)
+
+// errBodyTooLarge is returned when reading a request body over the limit set by bodyLimit
+var errBodyTooLarge = errors.New("request body too large")
+
+// problem holds the problem details (RFC 7807) of invalid requests. Errors is an extension member,
+// which lists the invalid fields, if any
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request
+	Instance string                 `json:"instance,omitempty"`
+	Errors   users.ValidationErrors `json:"errors,omitempty"`
+}
+
+// newProblem returns the problem details of the request, detail explains what went wrong with this
+// request
+func newProblem(c *gin.Context, status int, detail string, errs users.ValidationErrors) *problem {
+	return &problem{
+		Type:     problemTypeDefault,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: c.Request.URL.Path,
+		Errors:   errs,
+	}
+}
+
+// problemJSON responds with status and the problem details of the request, detail explains what
+// went wrong with this request
+func problemJSON(c *gin.Context, status int, detail string, errs users.ValidationErrors) {
+	c.Header("Content-Type", problemContentType)
+	c.JSON(status, newProblem(c, status, detail, errs))
+}
+
+// abortWithProblem is problemJSON for middleware, it also stops the remaining handlers of the request
+func abortWithProblem(c *gin.Context, status int, detail string) {
+	problemJSON(c, status, detail, nil)
+	c.Abort()
+}
+
+// badRequest responds with status 400 and the problem details of an invalid request
+func badRequest(c *gin.Context, detail string, errs users.ValidationErrors) {
+	problemJSON(c, http.StatusBadRequest, detail, errs)
+}
+
+// serverError responds with status 500, without any detail of err which could leak internals
+func serverError(c *gin.Context) {
+	problemJSON(c, http.StatusInternalServerError, "server error", nil)
+}
+
+// validationError responds with status 400 to a validation error of the APIs, with all the invalid
+// fields when they're known, so that clients can tell users what to fix
+func validationError(c *gin.Context, err error) {
+	var (
+		fieldErrs users.ValidationErrors
+		policyErr *password.PolicyError
+	)
+	switch {
+	case errors.As(err, &fieldErrs):
+		badRequest(c, users.ErrUserValidation.Error(), fieldErrs)
+	case errors.As(err, &policyErr):
+		// the policy is shown, so that users can choose a password which meets it
+		badRequest(c, users.ErrUserValidation.Error(), users.ValidationErrors{
+			{Field: "password", Code: users.ValidationInvalid, Message: policyErr.Error()},
+		})
+	default:
+		detail, fieldErrs := decodeProblem(err)
+		if detail == "" {
+			detail = users.ErrUserValidation.Error()
+		}
+		badRequest(c, detail, fieldErrs)
+	}
+}
+
+// bindJSON decodes the JSON body of the request into obj. If the body cannot be decoded, it responds
+// with its problem details, like for validation errors, and returns false
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, errBodyTooLarge) {
+		problemJSON(c, http.StatusRequestEntityTooLarge, errBodyTooLarge.Error(), nil)
+		return false
+	}
+	detail, fieldErrs := decodeProblem(err)
+	if detail == "" {
+		detail = "request body is not valid"
+	}
+	badRequest(c, detail, fieldErrs)
+	return false
+}
+
+// decodeProblem returns the detail and the invalid fields of err, an error decoding a JSON document.
+// The detail is empty if err is not a decoding error
+func decodeProblem(err error) (string, users.ValidationErrors) {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.Is(err, io.EOF):
+		return "request body is required", nil
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &syntaxErr):
+		return "request body is not valid JSON", nil
+	case errors.As(err, &typeErr) && typeErr.Field == "":
+		return fmt.Sprintf("request body must be a JSON %s", jsonKind(typeErr.Type)), nil
+	case errors.As(err, &typeErr):
+		return users.ErrUserValidation.Error(), users.ValidationErrors{{
+			Field:   typeErr.Field,
+			Code:    users.ValidationInvalid,
+			Message: fmt.Sprintf("%s must be a JSON %s, not %s", typeErr.Field, jsonKind(typeErr.Type), typeErr.Value),
+		}}
+	default:
+		return "", nil
+	}
+}
+
+// jsonKind returns the kind of JSON value t is decoded from
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
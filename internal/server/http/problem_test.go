@@ -0,0 +1,126 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jerryan999/goapp/internal/users"
+)
+
+func TestProblemResponses(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.createUser(t, "jane@example.com")
+	ts.createUser(t, "admin@example.com", users.RoleAdmin)
+	jane := ts.bearer(t, "jane@example.com")
+	admin := ts.bearer(t, "admin@example.com")
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		auth       string
+		wantStatus int
+		wantDetail string
+		wantField  string
+	}{
+		{
+			name:       "body is not json",
+			method:     http.MethodPost,
+			path:       "/users/create",
+			body:       `{"email":`,
+			wantStatus: http.StatusBadRequest,
+			wantDetail: "request body is not valid JSON",
+		},
+		{
+			name:       "field of the wrong type",
+			method:     http.MethodPost,
+			path:       "/users/create",
+			body:       `{"email": 42}`,
+			wantStatus: http.StatusBadRequest,
+			wantField:  "email",
+		},
+		{
+			name:       "invalid user",
+			method:     http.MethodPost,
+			path:       "/users/create",
+			body:       `{"firstName": "Jane", "email": "not an email", "password": "correct horse battery"}`,
+			wantStatus: http.StatusBadRequest,
+			wantField:  "email",
+		},
+		{
+			name:       "read by invalid email",
+			method:     http.MethodGet,
+			path:       "/users/retrieve?email=not-an-email",
+			auth:       admin,
+			wantStatus: http.StatusBadRequest,
+			wantField:  "email",
+		},
+		{
+			name:       "wrong password",
+			method:     http.MethodPost,
+			path:       "/users/login",
+			body:       `{"email": "jane@example.com", "password": "wrong horse battery"}`,
+			wantStatus: http.StatusUnauthorized,
+			wantDetail: users.ErrInvalidCredentials.Error(),
+		},
+		{
+			name:       "invalid reset token",
+			method:     http.MethodPost,
+			path:       "/users/password/reset",
+			body:       `{"token": "bogus", "password": "a brand new password"}`,
+			wantStatus: http.StatusBadRequest,
+			wantDetail: users.ErrInvalidToken.Error(),
+		},
+		{
+			name:       "invalid verification token",
+			method:     http.MethodPost,
+			path:       "/users/verify",
+			body:       `{"token": "bogus"}`,
+			wantStatus: http.StatusBadRequest,
+			wantDetail: users.ErrInvalidToken.Error(),
+		},
+		{
+			name:       "mfa not enrolled",
+			method:     http.MethodPost,
+			path:       "/users/mfa/confirm",
+			body:       `{"code": "123456"}`,
+			auth:       jane,
+			wantStatus: http.StatusConflict,
+			wantDetail: users.ErrMFANotEnabled.Error(),
+		},
+		{
+			name:       "logout without a session",
+			method:     http.MethodDelete,
+			path:       "/sessions/current",
+			auth:       jane,
+			wantStatus: http.StatusBadRequest,
+			wantDetail: "not authenticated with a session",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header []string
+			if tt.auth != "" {
+				header = []string{"Authorization", tt.auth}
+			}
+			w := ts.do(tt.method, tt.path, tt.body, header...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			p := decodeProblemResponse(t, w)
+			if path, _, _ := strings.Cut(tt.path, "?"); p.Instance != path {
+				t.Errorf("problem instance = %q, want %q", p.Instance, path)
+			}
+			if tt.wantDetail != "" && p.Detail != tt.wantDetail {
+				t.Errorf("problem detail = %q, want %q", p.Detail, tt.wantDetail)
+			}
+			if tt.wantField != "" && (len(p.Errors) == 0 || p.Errors[0].Field != tt.wantField) {
+				t.Errorf("problem errors = %+v, want an error of %s", p.Errors, tt.wantField)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jerryan999/goapp/internal/auth"
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/pkg/metrics"
+	"github.com/jerryan999/goapp/internal/pkg/ratelimit"
+)
+
+const (
+	// RateLimitStoreRedis shares the rate limits among all instances of the app, and falls back to
+	// local limits while Redis is down
+	RateLimitStoreRedis = "redis"
+	// RateLimitStoreMemory keeps local rate limits in each instance of the app
+	RateLimitStoreMemory = "memory"
+	// RateLimitStoreNone disables rate limiting
+	RateLimitStoreNone = "none"
+
+	// RateLimitKeyIP limits the requests of each client IP
+	RateLimitKeyIP = "ip"
+	// RateLimitKeyPrincipal limits the requests of each authenticated user or API key, and of each
+	// client IP for anonymous requests
+	RateLimitKeyPrincipal = "principal"
+
+	// rateLimitAny matches any method or route in a policy
+	rateLimitAny = "*"
+)
+
+var (
+	httpRateLimited = metrics.NewCounterVec(
+		"http_rate_limited_total",
+		"Number of HTTP requests rejected by rate limits, partitioned by policy",
+		"policy",
+	)
+)
+
+// RateLimit is the rate limit policy of the requests matching a method and a route
+type RateLimit struct {
+	// Method and Route are matched against the method and the route template of requests, e.g.
+	// /sessions/:id. Either can be * to match any
+	Method string
+	Route  string
+	// Key is what requests are counted by, either RateLimitKeyIP or RateLimitKeyPrincipal
+	Key    string
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRateLimit parses a policy written as '<method> <route> <key> <limit>/<window seconds>', e.g.
+// 'POST /auth/login ip 10/60' allows 10 logins per minute to each client IP
+func ParseRateLimit(policy string) (*RateLimit, error) {
+	fields := strings.Fields(policy)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("parseRateLimit: expected '<method> <route> <key> <limit>/<window seconds>', got '%s'", policy)
+	}
+
+	rl := &RateLimit{
+		Method: strings.ToUpper(fields[0]),
+		Route:  fields[1],
+		Key:    strings.ToLower(fields[2]),
+	}
+	if rl.Route != rateLimitAny && !strings.HasPrefix(rl.Route, "/") {
+		return nil, fmt.Errorf("parseRateLimit: route '%s' must start with / or be *", rl.Route)
+	}
+	if rl.Key != RateLimitKeyIP && rl.Key != RateLimitKeyPrincipal {
+		return nil, fmt.Errorf("parseRateLimit: key '%s' must be one of %s, %s", rl.Key, RateLimitKeyIP, RateLimitKeyPrincipal)
+	}
+
+	limit, window, ok := strings.Cut(fields[3], "/")
+	l, err := strconv.Atoi(limit)
+	if !ok || err != nil || l < 1 {
+		return nil, fmt.Errorf("parseRateLimit: limit '%s' must be a positive integer", limit)
+	}
+	w, err := strconv.Atoi(window)
+	if err != nil || w < 1 {
+		return nil, fmt.Errorf("parseRateLimit: window '%s' must be a positive number of seconds", window)
+	}
+	rl.Limit, rl.Window = l, time.Duration(w)*time.Second
+
+	return rl, nil
+}
+
+func (rl *RateLimit) matches(method string, route string) bool {
+	return (rl.Method == rateLimitAny || rl.Method == method) && (rl.Route == rateLimitAny || rl.Route == route)
+}
+
+// key returns the key c is counted by. Keys are namespaced by policy, so that a policy matching many
+// routes has a single budget shared by all of them
+func (rl *RateLimit) key(c *gin.Context) string {
+	key := "ip-" + c.ClientIP()
+	if rl.Key == RateLimitKeyPrincipal {
+		p := auth.PrincipalFromContext(c.Request.Context())
+		switch {
+		case p == nil:
+		case p.IsService():
+			key = "apikey-" + p.APIKeyID
+		default:
+			key = "user-" + p.Subject
+		}
+	}
+	return rl.Method + " " + rl.Route + " " + key
+}
+
+// parseRateLimits parses all the policies, in order
+func parseRateLimits(policies []string) ([]*RateLimit, error) {
+	parsed := make([]*RateLimit, 0, len(policies))
+	for _, policy := range policies {
+		rl, err := ParseRateLimit(policy)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, rl)
+	}
+	return parsed, nil
+}
+
+// newRateLimiter returns the limiter of store, nil if rate limiting is disabled. The Redis limiter
+// falls back to a local one while Redis is down, since limits which are less accurate for a while are
+// better than none
+func newRateLimiter(store string, cache *cachestore.Handle, l logger.Logger) (ratelimit.Limiter, error) {
+	switch store {
+	case RateLimitStoreNone:
+		return nil, nil
+	case RateLimitStoreMemory:
+		return ratelimit.NewTokenBucketLimiter(), nil
+	case RateLimitStoreRedis, "":
+		return ratelimit.NewFallbackLimiter(
+			ratelimit.NewRedisGCRALimiter(cache, "ratelimit-http-"),
+			ratelimit.NewTokenBucketLimiter(),
+			func(err error) {
+				if err != nil {
+					l.With("error", err).Error("rate limiter unavailable, falling back to local rate limits")
+				} else {
+					l.Info("rate limiter available again")
+				}
+			},
+		), nil
+	default:
+		return nil, fmt.Errorf("http: unknown rate limit store '%s'", store)
+	}
+}
+
+// rateLimitHeaders sets the RateLimit headers of the IETF draft on rate limit fields for HTTP, which
+// tell clients their limit and how much of it is left
+func rateLimitHeaders(c *gin.Context, rl *RateLimit, result *ratelimit.Result) {
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.ResetAfter.Seconds()))))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rl.Limit, int(rl.Window/time.Second)))
+}
+
+// rateLimit responds with status 429 to requests over the first policy matching their method and
+// route, if any. Policies are loaded atomically, since they can be changed on configuration reload.
+// It must come after the authentication middleware, so that requests can be counted by principal.
+// Requests are allowed if the limiter fails, so that an outage of the limiter is not one of the app
+func rateLimit(limiter ratelimit.Limiter, policies *atomic.Value, l logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var policy *RateLimit
+		for _, rl := range policies.Load().([]*RateLimit) {
+			if rl.matches(c.Request.Method, c.FullPath()) {
+				policy = rl
+				break
+			}
+		}
+		if policy == nil {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		result, err := limiter.Allow(ctx, policy.key(c), policy.Limit, policy.Window)
+		if err != nil {
+			l.WithContext(ctx).Error(err.Error())
+			c.Next()
+			return
+		}
+
+		rateLimitHeaders(c, policy, result)
+		if !result.Allowed {
+			httpRateLimited.With(policy.Method + " " + policy.Route).Inc()
+			tooManyRequests(c, result.Err())
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
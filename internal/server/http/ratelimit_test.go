@@ -0,0 +1,78 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	ts := newTestServer(t, &Config{
+		RateLimitStore: RateLimitStoreMemory,
+		RateLimits:     []string{"POST /users/login ip 2/60"},
+	})
+	ts.createUser(t, "jane@example.com")
+	login := `{"email": "jane@example.com", "password": "correct horse battery"}`
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		w := ts.do(http.MethodPost, "/users/login", login)
+		if w.Code != http.StatusOK {
+			t.Fatalf("login %d status = %d, want %d: %s", i, w.Code, http.StatusOK, w.Body.String())
+		}
+		for header, want := range map[string]string{
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": wantRemaining,
+			"RateLimit-Policy":    "2;w=60",
+		} {
+			if got := w.Header().Get(header); got != want {
+				t.Errorf("login %d %s = %q, want %q", i, header, got, want)
+			}
+		}
+		if w.Header().Get("RateLimit-Reset") == "" {
+			t.Errorf("login %d RateLimit-Reset is missing", i)
+		}
+	}
+
+	w := ts.do(http.MethodPost, "/users/login", login)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("login over the limit status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After is missing from the 429 response")
+	}
+	decodeProblemResponse(t, w)
+
+	w = ts.do(http.MethodGet, "/health/live", "")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("unlimited route status = %d, RateLimit-Limit = %q, want %d without limit", w.Code, w.Header().Get("RateLimit-Limit"), http.StatusOK)
+	}
+}
+
+func TestRateLimitTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		wantStatus     int
+	}{
+		// httptest requests come from 192.0.2.1
+		{name: "untrusted proxy", wantStatus: http.StatusTooManyRequests},
+		{name: "trusted proxy", trustedProxies: []string{"192.0.2.0/24"}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, &Config{
+				RateLimitStore: RateLimitStoreMemory,
+				RateLimits:     []string{"POST /users/login ip 1/60"},
+				TrustedProxies: tt.trustedProxies,
+			})
+			ts.createUser(t, "jane@example.com")
+			login := `{"email": "jane@example.com", "password": "correct horse battery"}`
+
+			ts.do(http.MethodPost, "/users/login", login, "X-Forwarded-For", "198.51.100.1")
+			w := ts.do(http.MethodPost, "/users/login", login, "X-Forwarded-For", "198.51.100.2")
+			if w.Code != tt.wantStatus {
+				t.Errorf("status of another client = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+// Package sessions manages server-side sessions of browser clients. The session token is only
+// stored in an HttpOnly cookie on the client, while the session itself is kept in Redis, keyed by the
+// hash of the token so that the tokens cannot be recovered from the store.
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+)
+
+const (
+	// StoreRedis keeps sessions in Redis, falling back to memory while Redis is unavailable
+	StoreRedis = "redis"
+	// StoreMemory keeps sessions in memory, it's meant for tests and local development
+	StoreMemory = "memory"
+
+	// touchInterval is the minimum time between two updates of the last seen time of a session, so
+	// that not every request results in a write
+	touchInterval = time.Minute
+
+	defaultIdleTimeout     = time.Minute * 30
+	defaultAbsoluteTimeout = time.Hour * 24
+)
+
+var (
+	// ErrSessionNotFound is returned for sessions which do not exist, have expired or were revoked
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrInvalidCSRFToken is returned when the CSRF token of a request does not match its session
+	ErrInvalidCSRFToken = errors.New("invalid csrf token")
+)
+
+// Session is a single login of a user from a browser
+type Session struct {
+	// ID is the hash of the session token, it identifies the session without revealing the token
+	ID        string `json:"id"`
+	UserID    string `json:"userId"`
+	Email     string `json:"email"`
+	CSRFToken string `json:"-"`
+	UserAgent string `json:"userAgent,omitempty"`
+	ClientIP  string `json:"clientIp,omitempty"`
+	// MFA reports if the user logged in with a one-time code, in addition to their password
+	MFA bool `json:"mfa"`
+
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	// ExpiresAt is the absolute expiry of the session, irrespective of activity
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// storedSession is how a session is serialized in the store. The CSRF token is not serialized in
+// responses, but has to be stored
+type storedSession struct {
+	Session
+	CSRFToken string `json:"csrfToken"`
+}
+
+// Config holds all the configuration required for this package
+type Config struct {
+	// Store is where sessions are kept, either 'redis' or 'memory'
+	Store        string `json:"store"`
+	CookieName   string `json:"cookie_name"`
+	CookieDomain string `json:"cookie_domain"`
+	// CookieSecure makes browsers send the cookie only over HTTPS. It should only be disabled for
+	// local development
+	CookieSecure bool `json:"cookie_secure"`
+	// CookieSameSite is one of 'strict', 'lax' or 'none'
+	CookieSameSite string `json:"cookie_same_site"`
+	// IdleTimeoutSecond is the sliding expiry, sessions expire if not used for this long
+	IdleTimeoutSecond int `json:"idle_timeout_second"`
+	// AbsoluteTimeoutSecond is the maximum lifetime of a session, irrespective of activity
+	AbsoluteTimeoutSecond int `json:"absolute_timeout_second"`
+}
+
+// Sessions creates, verifies and revokes sessions
+type Sessions struct {
+	store Store
+
+	cookieName      string
+	cookieDomain    string
+	cookieSecure    bool
+	cookieSameSite  http.SameSite
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sessionID returns the ID of the session of token
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// expiry returns when s expires if not used again, which is the earliest of its idle and absolute expiry
+func (ss *Sessions) expiry(s *Session) time.Time {
+	idle := s.LastSeenAt.Add(ss.idleTimeout)
+	if idle.Before(s.ExpiresAt) {
+		return idle
+	}
+	return s.ExpiresAt
+}
+
+// Create creates a new session for the user, and returns the session token which has to be sent
+// to the client. mfa reports if the user entered a one-time code
+func (ss *Sessions) Create(
+	ctx context.Context,
+	userID, email, userAgent, clientIP string,
+	mfa bool,
+) (string, *Session, error) {
+	now := time.Now()
+	token := randomToken()
+	s := &Session{
+		ID:         sessionID(token),
+		UserID:     userID,
+		Email:      email,
+		CSRFToken:  randomToken(),
+		UserAgent:  userAgent,
+		ClientIP:   clientIP,
+		MFA:        mfa,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ss.absoluteTimeout),
+	}
+
+	err := ss.store.Save(ctx, s, ss.expiry(s))
+	if err != nil {
+		return "", nil, fmt.Errorf("create: %w", err)
+	}
+
+	return token, s, nil
+}
+
+// Authenticate returns the session of token, and extends its idle expiry
+func (ss *Sessions) Authenticate(ctx context.Context, token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrSessionNotFound
+	}
+
+	s, err := ss.store.Get(ctx, sessionID(token))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !now.Before(ss.expiry(s)) {
+		// the store expires sessions as well, this is just in case it has not done so yet
+		return nil, fmt.Errorf("authenticate: %w", ErrSessionNotFound)
+	}
+
+	if now.Sub(s.LastSeenAt) >= touchInterval {
+		s.LastSeenAt = now
+		err = ss.store.Save(ctx, s, ss.expiry(s))
+		if err != nil {
+			return nil, fmt.Errorf("authenticate: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// VerifyCSRF returns ErrInvalidCSRFToken if token is not the CSRF token of s
+func (ss *Sessions) VerifyCSRF(s *Session, token string) error {
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) != 1 {
+		return ErrInvalidCSRFToken
+	}
+	return nil
+}
+
+// List returns all the active sessions of the user
+func (ss *Sessions) List(ctx context.Context, userID string) ([]*Session, error) {
+	return ss.store.ListByUser(ctx, userID)
+}
+
+// Get returns the session with the given ID, if it belongs to the user
+func (ss *Sessions) Get(ctx context.Context, userID string, id string) (*Session, error) {
+	s, err := ss.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// sessions of other users are reported as not found, so that their IDs cannot be probed
+	if s.UserID != userID {
+		return nil, fmt.Errorf("get: %w", ErrSessionNotFound)
+	}
+	return s, nil
+}
+
+// Revoke revokes the session with the given ID, if it belongs to the user
+func (ss *Sessions) Revoke(ctx context.Context, userID string, id string) error {
+	s, err := ss.Get(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	return ss.store.Delete(ctx, s)
+}
+
+// RevokeAll revokes all the sessions of the user, e.g. after their password is changed
+func (ss *Sessions) RevokeAll(ctx context.Context, userID string) error {
+	return ss.store.DeleteByUser(ctx, userID)
+}
+
+// Cookie returns the cookie which carries token. An empty token returns a cookie which removes the
+// session cookie from the browser
+func (ss *Sessions) Cookie(token string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     ss.cookieName,
+		Value:    token,
+		Path:     "/",
+		Domain:   ss.cookieDomain,
+		MaxAge:   int(ss.absoluteTimeout / time.Second),
+		Secure:   ss.cookieSecure,
+		HttpOnly: true,
+		SameSite: ss.cookieSameSite,
+	}
+	if token == "" {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+// CookieName returns the name of the session cookie
+func (ss *Sessions) CookieName() string {
+	return ss.cookieName
+}
+
+func parseSameSite(name string) (http.SameSite, error) {
+	switch strings.ToLower(name) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return http.SameSiteDefaultMode, fmt.Errorf("sessions: unknown same site mode '%s'", name)
+}
+
+// NewService returns a new instance of Sessions. cache is only required if the store is 'redis'
+func NewService(cfg *Config, l logger.Logger, cache *cachestore.Handle) (*Sessions, error) {
+	sameSite, err := parseSameSite(cfg.CookieSameSite)
+	if err != nil {
+		return nil, err
+	}
+	if sameSite == http.SameSiteNoneMode && !cfg.CookieSecure {
+		return nil, errors.New("sessions: same site mode 'none' requires secure cookies")
+	}
+	if !cfg.CookieSecure {
+		l.Warn("sessions: cookies are not secure, they're sent over plain HTTP as well")
+	}
+
+	var store Store
+	switch cfg.Store {
+	case StoreMemory:
+		store = NewMemoryStore()
+	case StoreRedis, "":
+		store = newFallbackStore(newRedisStore(cache), NewMemoryStore(), cache)
+	default:
+		return nil, fmt.Errorf("sessions: unknown store '%s'", cfg.Store)
+	}
+
+	cookieName := cfg.CookieName
+	if cookieName == "" {
+		cookieName = "goapp_session"
+	}
+	idle := time.Duration(cfg.IdleTimeoutSecond) * time.Second
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+	absolute := time.Duration(cfg.AbsoluteTimeoutSecond) * time.Second
+	if absolute <= 0 {
+		absolute = defaultAbsoluteTimeout
+	}
+
+	return &Sessions{
+		store:           store,
+		cookieName:      cookieName,
+		cookieDomain:    cfg.CookieDomain,
+		cookieSecure:    cfg.CookieSecure,
+		cookieSameSite:  sameSite,
+		idleTimeout:     idle,
+		absoluteTimeout: absolute,
+	}, nil
+}
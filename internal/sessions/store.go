@@ -0,0 +1,407 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+// Store persists sessions. Sessions are removed by the store once they expire
+type Store interface {
+	// Save creates or updates s, which expires at expiresAt unless saved again
+	Save(ctx context.Context, s *Session, expiresAt time.Time) error
+	Get(ctx context.Context, id string) (*Session, error)
+	Delete(ctx context.Context, s *Session) error
+	// ListByUser returns the sessions of the user, ordered by creation, oldest first
+	ListByUser(ctx context.Context, userID string) ([]*Session, error)
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// ttlSeconds returns the seconds until expiresAt, at least 1 since Redis rejects non-positive expiries
+func ttlSeconds(expiresAt time.Time) int64 {
+	ttl := int64(time.Until(expiresAt) / time.Second)
+	if ttl < 1 {
+		return 1
+	}
+	return ttl
+}
+
+func sortByCreation(list []*Session) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+}
+
+type redisStore struct {
+	cache *cachestore.Handle
+}
+
+func (rs *redisStore) conn(ctx context.Context) (redis.Conn, error) {
+	pool := rs.cache.Pool()
+	if pool == nil {
+		return nil, cachestore.ErrCacheNotInitialized
+	}
+	return pool.GetContext(ctx)
+}
+
+func sessionKey(id string) string {
+	return fmt.Sprintf("session-%s", id)
+}
+
+// userSessionsKey is the set of the IDs of all the sessions of a user. Since members of a set can't
+// expire, IDs of expired sessions are removed whenever the set is read
+func userSessionsKey(userID string) string {
+	return fmt.Sprintf("user-sessions-%s", userID)
+}
+
+// extendScript extends the expiry of a key, but never shortens it. The set of the sessions of a
+// user lives as long as the longest living session of the user
+var extendScript = redis.NewScript(1, `
+local ttl = redis.call('TTL', KEYS[1])
+if ttl < tonumber(ARGV[1]) then
+	redis.call('EXPIRE', KEYS[1], ARGV[1])
+end
+return ttl
+`)
+
+func (rs *redisStore) Save(ctx context.Context, s *Session, expiresAt time.Time) error {
+	ctx, span := tracing.StartClient(ctx, "redis MULTI", "db.system", "redis", "db.operation", "MULTI")
+	defer span.End()
+
+	payload, err := json.Marshal(storedSession{Session: *s, CSRFToken: s.CSRFToken})
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	defer conn.Close()
+
+	userKey := userSessionsKey(s.UserID)
+	_ = conn.Send("MULTI")
+	_ = conn.Send("SET", sessionKey(s.ID), payload, "EX", ttlSeconds(expiresAt))
+	_ = conn.Send("SADD", userKey, s.ID)
+	_ = extendScript.Send(conn, userKey, ttlSeconds(s.ExpiresAt))
+	_, err = conn.Do("EXEC")
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	return nil
+}
+
+func (rs *redisStore) Get(ctx context.Context, id string) (*Session, error) {
+	ctx, span := tracing.StartClient(ctx, "redis GET", "db.system", "redis", "db.operation", "GET")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+	defer conn.Close()
+
+	payload, err := redis.Bytes(conn.Do("GET", sessionKey(id)))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return nil, fmt.Errorf("get: %w", ErrSessionNotFound)
+		}
+		return nil, fmt.Errorf("get: %w", err)
+	}
+
+	return decode(payload)
+}
+
+func decode(payload []byte) (*Session, error) {
+	stored := storedSession{}
+	err := json.Unmarshal(payload, &stored)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	s := stored.Session
+	s.CSRFToken = stored.CSRFToken
+	return &s, nil
+}
+
+func (rs *redisStore) Delete(ctx context.Context, s *Session) error {
+	ctx, span := tracing.StartClient(ctx, "redis MULTI", "db.system", "redis", "db.operation", "MULTI")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	defer conn.Close()
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("DEL", sessionKey(s.ID))
+	_ = conn.Send("SREM", userSessionsKey(s.UserID), s.ID)
+	_, err = conn.Do("EXEC")
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+func (rs *redisStore) ListByUser(ctx context.Context, userID string) ([]*Session, error) {
+	ctx, span := tracing.StartClient(ctx, "redis SMEMBERS", "db.system", "redis", "db.operation", "SMEMBERS")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listByUser: %w", err)
+	}
+	defer conn.Close()
+
+	userKey := userSessionsKey(userID)
+	ids, err := redis.Strings(conn.Do("SMEMBERS", userKey))
+	if err != nil {
+		return nil, fmt.Errorf("listByUser: %w", err)
+	}
+	if len(ids) == 0 {
+		return []*Session{}, nil
+	}
+
+	keys := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, sessionKey(id))
+	}
+	payloads, err := redis.ByteSlices(conn.Do("MGET", keys...))
+	if err != nil {
+		return nil, fmt.Errorf("listByUser: %w", err)
+	}
+
+	list := make([]*Session, 0, len(ids))
+	expired := make([]interface{}, 0)
+	for i, payload := range payloads {
+		if payload == nil {
+			expired = append(expired, ids[i])
+			continue
+		}
+		s, err := decode(payload)
+		if err != nil {
+			return nil, fmt.Errorf("listByUser: %w", err)
+		}
+		list = append(list, s)
+	}
+
+	if len(expired) > 0 {
+		_, err = conn.Do("SREM", append([]interface{}{userKey}, expired...)...)
+		if err != nil {
+			return nil, fmt.Errorf("listByUser: %w", err)
+		}
+	}
+
+	sortByCreation(list)
+	return list, nil
+}
+
+func (rs *redisStore) DeleteByUser(ctx context.Context, userID string) error {
+	ctx, span := tracing.StartClient(ctx, "redis DEL", "db.system", "redis", "db.operation", "DEL")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return fmt.Errorf("deleteByUser: %w", err)
+	}
+	defer conn.Close()
+
+	userKey := userSessionsKey(userID)
+	ids, err := redis.Strings(conn.Do("SMEMBERS", userKey))
+	if err != nil {
+		return fmt.Errorf("deleteByUser: %w", err)
+	}
+
+	keys := make([]interface{}, 0, len(ids)+1)
+	keys = append(keys, userKey)
+	for _, id := range ids {
+		keys = append(keys, sessionKey(id))
+	}
+	_, err = conn.Do("DEL", keys...)
+	if err != nil {
+		return fmt.Errorf("deleteByUser: %w", err)
+	}
+	return nil
+}
+
+func newRedisStore(cache *cachestore.Handle) *redisStore {
+	if cache == nil {
+		cache = cachestore.NewHandle(nil)
+	}
+	return &redisStore{cache: cache}
+}
+
+// fallbackStore keeps sessions in Redis, and in memory while Redis is unavailable, so that users
+// can still log in. Sessions created in memory are only valid on this instance of the app, and
+// are lost on restart
+type fallbackStore struct {
+	redis  *redisStore
+	memory *MemoryStore
+	cache  *cachestore.Handle
+}
+
+func (fs *fallbackStore) available() bool {
+	return fs.cache.Pool() != nil
+}
+
+// unavailable reports if err is caused by Redis being unreachable, rather than by a failed command
+func unavailable(err error) bool {
+	var redisErr redis.Error
+	return errors.Is(err, cachestore.ErrCacheNotInitialized) || !errors.As(err, &redisErr)
+}
+
+func (fs *fallbackStore) Save(ctx context.Context, s *Session, expiresAt time.Time) error {
+	// a session created during an outage is kept in memory for its whole lifetime, rather than
+	// moved to Redis once it's available again
+	if _, err := fs.memory.Get(ctx, s.ID); err == nil || !fs.available() {
+		return fs.memory.Save(ctx, s, expiresAt)
+	}
+
+	err := fs.redis.Save(ctx, s, expiresAt)
+	if err != nil && unavailable(err) {
+		return fs.memory.Save(ctx, s, expiresAt)
+	}
+	return err
+}
+
+func (fs *fallbackStore) Get(ctx context.Context, id string) (*Session, error) {
+	s, err := fs.memory.Get(ctx, id)
+	if err == nil || !fs.available() {
+		return s, err
+	}
+	return fs.redis.Get(ctx, id)
+}
+
+func (fs *fallbackStore) Delete(ctx context.Context, s *Session) error {
+	err := fs.memory.Delete(ctx, s)
+	if err != nil || !fs.available() {
+		return err
+	}
+	return fs.redis.Delete(ctx, s)
+}
+
+func (fs *fallbackStore) ListByUser(ctx context.Context, userID string) ([]*Session, error) {
+	list, err := fs.memory.ListByUser(ctx, userID)
+	if err != nil || !fs.available() {
+		return list, err
+	}
+
+	stored, err := fs.redis.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	list = append(list, stored...)
+	sortByCreation(list)
+	return list, nil
+}
+
+func (fs *fallbackStore) DeleteByUser(ctx context.Context, userID string) error {
+	err := fs.memory.DeleteByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !fs.available() {
+		return fmt.Errorf("deleteByUser: %w", cachestore.ErrCacheNotInitialized)
+	}
+	return fs.redis.DeleteByUser(ctx, userID)
+}
+
+func newFallbackStore(rs *redisStore, ms *MemoryStore, cache *cachestore.Handle) *fallbackStore {
+	if cache == nil {
+		cache = rs.cache
+	}
+	return &fallbackStore{redis: rs, memory: ms, cache: cache}
+}
+
+type memorySession struct {
+	session   Session
+	expiresAt time.Time
+}
+
+// MemoryStore is a thread-safe, in-memory implementation of Store
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+// sweep removes all the expired sessions. It must be called with the lock held
+func (ms *MemoryStore) sweep(now time.Time) {
+	for id, s := range ms.sessions {
+		if !now.Before(s.expiresAt) {
+			delete(ms.sessions, id)
+		}
+	}
+}
+
+func (ms *MemoryStore) Save(ctx context.Context, s *Session, expiresAt time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sweep(time.Now())
+	ms.sessions[s.ID] = memorySession{session: *s, expiresAt: expiresAt}
+	return nil
+}
+
+func (ms *MemoryStore) Get(ctx context.Context, id string) (*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, ok := ms.sessions[id]
+	if !ok || !time.Now().Before(s.expiresAt) {
+		return nil, fmt.Errorf("get: %w", ErrSessionNotFound)
+	}
+	session := s.session
+	return &session, nil
+}
+
+func (ms *MemoryStore) Delete(ctx context.Context, s *Session) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.sessions, s.ID)
+	return nil
+}
+
+func (ms *MemoryStore) ListByUser(ctx context.Context, userID string) ([]*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sweep(time.Now())
+	list := make([]*Session, 0)
+	for _, s := range ms.sessions {
+		if s.session.UserID == userID {
+			session := s.session
+			list = append(list, &session)
+		}
+	}
+	sortByCreation(list)
+	return list, nil
+}
+
+func (ms *MemoryStore) DeleteByUser(ctx context.Context, userID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for id, s := range ms.sessions {
+		if s.session.UserID == userID {
+			delete(ms.sessions, id)
+		}
+	}
+	return nil
+}
+
+// NewMemoryStore returns a new, empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]memorySession),
+	}
+}
@@ -4,50 +4,105 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 
 	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
 )
 
-type userCachestore interface {
+// invalidationTTL is how long users are not cached after they're deleted from the cache. It has to
+// be longer than a read-through takes, so that a user read from the datastore before a change is not
+// cached after the change invalidated it
+const invalidationTTL = time.Second * 30
+
+// Cachestore is the read-through cache of users, keyed by their email
+type Cachestore interface {
+	// SetUser caches u, unless the user was deleted from the cache in the last invalidationTTL
 	SetUser(ctx context.Context, email string, u *User) error
 	ReadUserByEmail(ctx context.Context, email string) (*User, error)
+	// DeleteUser removes the cached user, and keeps it from being cached again for invalidationTTL
+	DeleteUser(ctx context.Context, email string) error
 }
 
 type usercache struct {
-	pool *redis.Pool
+	// cache is a handle instead of the pool itself, since the pool may be attached only after startup
+	cache *cachestore.Handle
+	// ttl is a time.Duration, accessed atomically since it can be changed on configuration reload
+	ttl int64
+}
+
+// SetTTL sets the expiry of the users cached from now on
+func (uc *usercache) SetTTL(ttl time.Duration) {
+	atomic.StoreInt64(&uc.ttl, int64(ttl))
 }
 
 func (uc *usercache) conn(ctx context.Context) (redis.Conn, error) {
-	return uc.pool.GetContext(ctx)
+	pool := uc.cache.Pool()
+	if pool == nil {
+		return nil, cachestore.ErrCacheNotInitialized
+	}
+	return pool.GetContext(ctx)
+}
+
+// startCacheOperation starts a span for a cache operation, which has to be ended by the caller
+func startCacheOperation(ctx context.Context, command string) (context.Context, *tracing.Span) {
+	return tracing.StartClient(ctx, "redis "+command, "db.system", "redis", "db.operation", command)
 }
 
-func userCacheKey(id string) string {
-	return fmt.Sprintf("user-%s", id)
+// userCacheKey returns the cache key for the user with the given email. Emails are case insensitive,
+// so all variants of an email share the same key
+func userCacheKey(email string) string {
+	return fmt.Sprintf("user-%s", strings.ToLower(email))
 }
 
+// userInvalidatedKey returns the key which is set while the user with the given email must not be
+// cached, see invalidationTTL
+func userInvalidatedKey(email string) string {
+	return fmt.Sprintf("user-invalidated-%s", strings.ToLower(email))
+}
+
+// setUserScript caches a user along with its expiry, unless the user was invalidated recently.
+// KEYS[1] is the key of the user, KEYS[2] its invalidation key, ARGV[1] the user and ARGV[2] the TTL
+// in seconds
+var setUserScript = redis.NewScript(2, `
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+return 1
+`)
+
+// deleteUserScript removes a cached user, and marks it as invalidated. KEYS[1] is the key of the
+// user, KEYS[2] its invalidation key and ARGV[1] the invalidation TTL in milliseconds
+var deleteUserScript = redis.NewScript(2, `
+redis.call('SET', KEYS[2], 1, 'PX', ARGV[1])
+return redis.call('DEL', KEYS[1])
+`)
+
 func (uc *usercache) SetUser(ctx context.Context, email string, u *User) error {
-	if uc.pool == nil {
-		return cachestore.ErrCacheNotInitialized
-	}
+	ctx, span := startCacheOperation(ctx, "EVALSHA")
+	defer span.End()
 
 	conn, err := uc.conn(ctx)
 	if err != nil {
 		return fmt.Errorf("setUser: %w", err)
 	}
+	defer conn.Close()
 
+	// the password hash is never marshaled to JSON, and the plain password is cleared in case it was
+	// not already, so that no credentials are ever written to the cache
+	cached := *u
+	cached.Password = ""
 	// it is safe to ignore error here because User struct has no field which can cause the marshal to fail
-	payload, _ := json.Marshal(u)
-
-	key := userCacheKey(email)
-	_, err = conn.Do("SET", key, payload)
-	if err != nil {
-		return fmt.Errorf("setUser: %w", err)
-	}
+	payload, _ := json.Marshal(&cached)
 
-	// expiry in seconds. 1hr
-	_, err = conn.Do("EXPIRE", key, 60*60*1)
+	// the expiry is set along with the value, so that the key cannot be left without one
+	ttl := int64(time.Duration(atomic.LoadInt64(&uc.ttl)) / time.Second)
+	_, err = setUserScript.Do(conn, userCacheKey(email), userInvalidatedKey(email), payload, ttl)
 	if err != nil {
 		return fmt.Errorf("setUser: %w", err)
 	}
@@ -56,14 +111,14 @@ func (uc *usercache) SetUser(ctx context.Context, email string, u *User) error {
 }
 
 func (uc *usercache) ReadUserByEmail(ctx context.Context, email string) (*User, error) {
-	if uc.pool == nil {
-		return nil, cachestore.ErrCacheNotInitialized
-	}
+	ctx, span := startCacheOperation(ctx, "GET")
+	defer span.End()
 
 	conn, err := uc.conn(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("readUserByEmail: %w", err)
 	}
+	defer conn.Close()
 
 	key := userCacheKey(email)
 
@@ -85,8 +140,30 @@ func (uc *usercache) ReadUserByEmail(ctx context.Context, email string) (*User,
 	return u, nil
 }
 
-func newCacheStore(pool *redis.Pool) (*usercache, error) {
+func (uc *usercache) DeleteUser(ctx context.Context, email string) error {
+	ctx, span := startCacheOperation(ctx, "EVALSHA")
+	defer span.End()
+
+	conn, err := uc.conn(ctx)
+	if err != nil {
+		return fmt.Errorf("deleteUser: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = deleteUserScript.Do(conn, userCacheKey(email), userInvalidatedKey(email), invalidationTTL.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("deleteUser: %w", err)
+	}
+
+	return nil
+}
+
+func newCacheStore(cache *cachestore.Handle, ttl time.Duration) (*usercache, error) {
+	if cache == nil {
+		cache = cachestore.NewHandle(nil)
+	}
 	return &usercache{
-		pool: pool,
+		cache: cache,
+		ttl:   int64(ttl),
 	}, nil
 }
@@ -0,0 +1,127 @@
+package users
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+const (
+	// DefaultListLimit is the number of users returned in a page when no limit is specified
+	DefaultListLimit = 20
+	// MaxListLimit is the maximum number of users which can be returned in a single page
+	MaxListLimit = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// ListFilter holds all the criteria based on which users are listed
+type ListFilter struct {
+	// NamePrefix matches users whose first name or last name starts with it, case insensitive
+	NamePrefix string
+	// EmailPrefix matches users whose email starts with it, case insensitive
+	EmailPrefix string
+	// CreatedFrom matches users created at or after it
+	CreatedFrom *time.Time
+	// CreatedTo matches users created before it
+	CreatedTo *time.Time
+	// Descending sorts the users by newest first, the default is oldest first
+	Descending bool
+}
+
+// ListCursor is the position in the list of users after which the next page starts. Users are
+// always sorted by their creation time, and then by ID to break ties
+type ListCursor struct {
+	CreatedAt  time.Time `json:"c"`
+	ID         string    `json:"i"`
+	Descending bool      `json:"d,omitempty"`
+}
+
+func (lc *ListCursor) encode() string {
+	// it is safe to ignore error here because ListCursor has no field which can cause the marshal to fail
+	payload, _ := json.Marshal(lc)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func decodeListCursor(token string) (*ListCursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("decodeListCursor: %w", ErrInvalidCursor)
+	}
+
+	lc := new(ListCursor)
+	err = json.Unmarshal(payload, lc)
+	if err != nil || lc.ID == "" {
+		return nil, fmt.Errorf("decodeListCursor: %w", ErrInvalidCursor)
+	}
+
+	return lc, nil
+}
+
+// Page is a single page of users. NextCursor is empty when there are no more users to list
+type Page struct {
+	Users      []*User `json:"users"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// List returns a page of users matching the filter, starting after the given cursor. An empty
+// cursor returns the first page
+func (us *Users) List(ctx context.Context, filter *ListFilter, cursor string, limit int) (*Page, error) {
+	ctx, span := tracing.Start(ctx, "users.List")
+	defer span.End()
+
+	if limit <= 0 {
+		limit = DefaultListLimit
+	} else if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	filter.NamePrefix = strings.TrimSpace(filter.NamePrefix)
+	filter.EmailPrefix = strings.TrimSpace(filter.EmailPrefix)
+
+	var after *ListCursor
+	if cursor != "" {
+		lc, err := decodeListCursor(cursor)
+		if err != nil {
+			us.log(ctx).Warn(err.Error())
+			return nil, err
+		}
+		if lc.Descending != filter.Descending {
+			return nil, fmt.Errorf("list: sort order does not match cursor: %w", ErrInvalidCursor)
+		}
+		after = lc
+	}
+
+	// one extra user is fetched to find out if there's a next page
+	list, err := us.store.List(ctx, filter, after, limit+1)
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return nil, err
+	}
+
+	page := &Page{
+		Users: list,
+	}
+	if len(list) > limit {
+		page.Users = list[:limit]
+		last := page.Users[limit-1]
+		lc := &ListCursor{
+			ID:         last.ID,
+			Descending: filter.Descending,
+		}
+		if last.CreatedAt != nil {
+			lc.CreatedAt = *last.CreatedAt
+		}
+		page.NextCursor = lc.encode()
+	}
+
+	return page, nil
+}
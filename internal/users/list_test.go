@@ -0,0 +1,128 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// listAll returns the IDs of all the users matching filter, reading pages of size limit
+func listAll(t *testing.T, us *Users, filter ListFilter, limit int) ([]string, int) {
+	t.Helper()
+
+	var (
+		ids    []string
+		pages  int
+		cursor string
+	)
+	for {
+		f := filter
+		page, err := us.List(context.Background(), &f, cursor, limit)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		pages++
+		if limit > 0 && len(page.Users) > limit {
+			t.Fatalf("List returned %d users, more than the limit %d", len(page.Users), limit)
+		}
+		for _, u := range page.Users {
+			ids = append(ids, u.ID)
+		}
+		if page.NextCursor == "" {
+			return ids, pages
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func TestList(t *testing.T) {
+	env := newTestUsers(t, nil)
+
+	var ascending []string
+	for i := 0; i < 5; i++ {
+		u := env.createUser(t, fmt.Sprintf("user%d@example.com", i))
+		ascending = append(ascending, u.ID)
+	}
+	descending := make([]string, 0, len(ascending))
+	for i := len(ascending) - 1; i >= 0; i-- {
+		descending = append(descending, ascending[i])
+	}
+
+	tests := []struct {
+		name      string
+		filter    ListFilter
+		limit     int
+		want      []string
+		wantPages int
+	}{
+		{name: "single page", limit: 10, want: ascending, wantPages: 1},
+		{name: "default limit", want: ascending, wantPages: 1},
+		{name: "pages", limit: 2, want: ascending, wantPages: 3},
+		{name: "exact pages", limit: 5, want: ascending, wantPages: 1},
+		{name: "page of one", limit: 1, want: ascending, wantPages: 5},
+		{name: "descending", filter: ListFilter{Descending: true}, limit: 2, want: descending, wantPages: 3},
+		{name: "email prefix", filter: ListFilter{EmailPrefix: " USER3"}, limit: 2, want: ascending[3:4], wantPages: 1},
+		{name: "no match", filter: ListFilter{NamePrefix: "John"}, limit: 2, want: nil, wantPages: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, pages := listAll(t, env.us, tt.filter, tt.limit)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("List = %v, want %v", got, tt.want)
+			}
+			if pages != tt.wantPages {
+				t.Errorf("List read %d pages, want %d", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestListCreatedRange(t *testing.T) {
+	env := newTestUsers(t, nil)
+	env.createUser(t, "before@example.com")
+	time.Sleep(time.Millisecond * 10)
+	from := time.Now()
+	within := env.createUser(t, "within@example.com")
+	to := time.Now()
+	time.Sleep(time.Millisecond * 10)
+	env.createUser(t, "after@example.com")
+
+	got, _ := listAll(t, env.us, ListFilter{CreatedFrom: &from, CreatedTo: &to}, 10)
+	if len(got) != 1 || got[0] != within.ID {
+		t.Errorf("List = %v, want [%s]", got, within.ID)
+	}
+}
+
+func TestListInvalidCursor(t *testing.T) {
+	env := newTestUsers(t, nil)
+	for i := 0; i < 3; i++ {
+		env.createUser(t, fmt.Sprintf("user%d@example.com", i))
+	}
+	page, err := env.us.List(context.Background(), &ListFilter{}, "", 1)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter ListFilter
+		cursor string
+	}{
+		{name: "not base64", cursor: "not a cursor!"},
+		{name: "not json", cursor: "bm90IGpzb24"},
+		{name: "no id", cursor: (&ListCursor{CreatedAt: time.Now()}).encode()},
+		{name: "other sort order", filter: ListFilter{Descending: true}, cursor: page.NextCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.us.List(context.Background(), &tt.filter, tt.cursor, 1)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("List error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
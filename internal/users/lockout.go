@@ -0,0 +1,231 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/lockout"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+const (
+	// defaultLockoutWindow is the time after which failed logins are forgotten when none is configured
+	defaultLockoutWindow = time.Minute * 15
+	// defaultLockoutDuration is the time accounts and IPs are locked out for when none is configured
+	defaultLockoutDuration = time.Minute * 15
+	// lockoutBaseDelay is the first progressive delay of an account, which doubles with every failure
+	lockoutBaseDelay = time.Second
+	// defaultLockoutMaxDelay is the longest progressive delay when none is configured
+	defaultLockoutMaxDelay = time.Second * 30
+)
+
+// lockoutPolicies returns the policies of accounts and client IPs. Progressive delays only apply to
+// accounts, since an IP can be shared by many users, e.g. behind a NAT. The policy of IPs is nil if
+// they're not locked out at all
+func lockoutPolicies(cfg *Config) (*lockout.Policy, *lockout.Policy) {
+	window := time.Duration(cfg.LockoutWindowSecond) * time.Second
+	if window <= 0 {
+		window = defaultLockoutWindow
+	}
+	duration := time.Duration(cfg.LockoutDurationSecond) * time.Second
+	if duration <= 0 {
+		duration = defaultLockoutDuration
+	}
+	maxDelay := time.Duration(cfg.LockoutMaxDelaySecond) * time.Second
+	if maxDelay <= 0 {
+		maxDelay = defaultLockoutMaxDelay
+	}
+
+	account := &lockout.Policy{
+		MaxFailures: cfg.LockoutMaxFailuresPerAccount,
+		Window:      window,
+		Lockout:     duration,
+		DelayAfter:  cfg.LockoutDelayAfter,
+		BaseDelay:   lockoutBaseDelay,
+		MaxDelay:    maxDelay,
+	}
+	if cfg.LockoutMaxFailuresPerIP <= 0 {
+		return account, nil
+	}
+	ip := &lockout.Policy{
+		MaxFailures: cfg.LockoutMaxFailuresPerIP,
+		Window:      window,
+		Lockout:     duration,
+	}
+	return account, ip
+}
+
+// accountKey returns the lockout key of the account with email. Emails without a user are counted as
+// well, so that lockouts do not tell which accounts exist
+func accountKey(email string) string {
+	return "account-" + hashEmail(email)
+}
+
+func ipKey(clientIP string) string {
+	return "ip-" + clientIP
+}
+
+// loginAttempt is a credential check of an account, and of the client IP if any, counted as failed
+// by the guard until it turns out to succeed
+type loginAttempt struct {
+	email    string
+	clientIP string
+	// account and ip are the states after counting the attempt, nil if it was not counted, e.g.
+	// because the guard is not available
+	account *lockout.State
+	ip      *lockout.State
+}
+
+// reserveLogin counts a credential check of the account with email and of clientIP, if any, before
+// the credentials are checked, so that concurrent checks cannot exceed the lockout policies. It
+// returns a *lockout.LockedError if either has to wait before its next check. Checks are allowed
+// when the guard is not available, so that users can still log in
+func (us *Users) reserveLogin(ctx context.Context, email string, clientIP string) (*loginAttempt, error) {
+	attempt := &loginAttempt{email: email, clientIP: clientIP}
+
+	var err error
+	attempt.account, err = us.guard.Attempt(ctx, accountKey(email), us.accountLockout)
+	if err != nil {
+		if !errors.Is(err, lockout.ErrLocked) {
+			us.log(ctx).Error(err.Error())
+			return attempt, nil
+		}
+		us.log(ctx).With("event", "login_blocked", "lockout", "account", "clientIP", clientIP).Warn(err.Error())
+		return nil, err
+	}
+
+	if clientIP == "" || us.ipLockout == nil {
+		return attempt, nil
+	}
+	attempt.ip, err = us.guard.Attempt(ctx, ipKey(clientIP), us.ipLockout)
+	if err != nil {
+		if !errors.Is(err, lockout.ErrLocked) {
+			us.log(ctx).Error(err.Error())
+			return attempt, nil
+		}
+		// the credentials are not checked, so the attempt of the account is not counted either
+		us.releaseLogin(ctx, attempt)
+		us.log(ctx).With("event", "login_blocked", "lockout", "ip", "clientIP", clientIP).Warn(err.Error())
+		return nil, err
+	}
+
+	return attempt, nil
+}
+
+// failLogin emits the security events of a failed credential check, and of the lockouts it caused.
+// The failure was already counted by reserveLogin. The user ID is empty if there's no user with the
+// email. reason is the kind of credential, e.g. 'password'
+func (us *Users) failLogin(ctx context.Context, attempt *loginAttempt, userID string, reason string) {
+	state := attempt.account
+	if state == nil {
+		state = new(lockout.State)
+	}
+	us.log(ctx).With(
+		"event", "login_failed",
+		"reason", reason,
+		"userID", userID,
+		"clientIP", attempt.clientIP,
+		"failures", state.Failures,
+	).Warn("failed login")
+
+	if state.Locked {
+		us.log(ctx).With(
+			"event", "account_locked",
+			"userID", userID,
+			"clientIP", attempt.clientIP,
+			"failures", state.Failures,
+			"lockedFor", state.RetryAfter.String(),
+		).Error("account locked out after too many failed logins")
+	}
+
+	if attempt.ip != nil && attempt.ip.Locked {
+		us.log(ctx).With(
+			"event", "ip_locked",
+			"clientIP", attempt.clientIP,
+			"failures", attempt.ip.Failures,
+			"lockedFor", attempt.ip.RetryAfter.String(),
+		).Error("client IP locked out after too many failed logins")
+	}
+}
+
+// succeedLogin forgets the failed logins of the account of attempt. Those of the client IP are kept,
+// only the successful attempt is uncounted, so that an attacker cannot reset them by logging in to
+// their own account
+func (us *Users) succeedLogin(ctx context.Context, attempt *loginAttempt) {
+	if attempt.account != nil {
+		err := us.guard.Reset(ctx, accountKey(attempt.email))
+		if err != nil {
+			us.log(ctx).Error(err.Error())
+		}
+	}
+	if attempt.ip != nil {
+		err := us.guard.Release(ctx, ipKey(attempt.clientIP), us.ipLockout)
+		if err != nil {
+			us.log(ctx).Error(err.Error())
+		}
+	}
+}
+
+// releaseLogin uncounts attempt, without forgetting the previous failed logins, e.g. when the
+// password is valid but the login still requires a one-time code
+func (us *Users) releaseLogin(ctx context.Context, attempt *loginAttempt) {
+	if attempt.account != nil {
+		err := us.guard.Release(ctx, accountKey(attempt.email), us.accountLockout)
+		if err != nil {
+			us.log(ctx).Error(err.Error())
+		}
+	}
+	if attempt.ip != nil {
+		err := us.guard.Release(ctx, ipKey(attempt.clientIP), us.ipLockout)
+		if err != nil {
+			us.log(ctx).Error(err.Error())
+		}
+	}
+}
+
+// Unlock forgets the failed logins of the account with email, which lifts its lockout and progressive
+// delays. The account does not have to exist, since failed logins of unknown emails are counted too
+func (us *Users) Unlock(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "users.Unlock")
+	defer span.End()
+
+	ctx = contextWithEmailHash(ctx, email)
+	email, err := validateEmail(email)
+	if err != nil {
+		us.log(ctx).Warn(err.Error())
+		return err
+	}
+
+	err = us.guard.Reset(ctx, accountKey(email))
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return fmt.Errorf("unlock: %w", err)
+	}
+	us.log(ctx).With("event", "account_unlocked").Warn("account unlocked")
+
+	return nil
+}
+
+// UnlockIP forgets the failed logins of clientIP, which lifts its lockout
+func (us *Users) UnlockIP(ctx context.Context, clientIP string) error {
+	ctx, span := tracing.Start(ctx, "users.UnlockIP")
+	defer span.End()
+
+	clientIP = strings.TrimSpace(clientIP)
+	if net.ParseIP(clientIP) == nil {
+		return fmt.Errorf("unlockIP: %w, ip: %s", ErrUserValidation, clientIP)
+	}
+
+	err := us.guard.Reset(ctx, ipKey(clientIP))
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return fmt.Errorf("unlockIP: %w", err)
+	}
+	us.log(ctx).With("event", "ip_unlocked", "clientIP", clientIP).Warn("client IP unlocked")
+
+	return nil
+}
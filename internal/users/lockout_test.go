@@ -0,0 +1,43 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jerryan999/goapp/internal/pkg/lockout"
+)
+
+func TestIPLockout(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxFailuresPerIP int
+		wantLocked       bool
+	}{
+		{name: "disabled", maxFailuresPerIP: 0, wantLocked: false},
+		{name: "enabled", maxFailuresPerIP: 3, wantLocked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestUsers(t, &Config{
+				LockoutMaxFailuresPerAccount: 100,
+				LockoutMaxFailuresPerIP:      tt.maxFailuresPerIP,
+			})
+
+			locked := false
+			for i := 0; i < 5 && !locked; i++ {
+				email := fmt.Sprintf("user%d@example.com", i)
+				_, err := env.us.Authenticate(context.Background(), email, testPassword, "192.0.2.1")
+				locked = errors.Is(err, lockout.ErrLocked)
+				if !locked && !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("Authenticate error = %v, want %v", err, ErrInvalidCredentials)
+				}
+			}
+			if locked != tt.wantLocked {
+				t.Errorf("client IP locked = %v, want %v", locked, tt.wantLocked)
+			}
+		})
+	}
+}
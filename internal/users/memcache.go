@@ -0,0 +1,104 @@
+package users
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+)
+
+type memoryCacheItem struct {
+	user      *User
+	expiresAt time.Time
+}
+
+// MemoryCache is a thread-safe, in-memory implementation of Cachestore. Like the Redis cache, items
+// expire after the configured TTL. It's meant for tests and local development
+type MemoryCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	items map[string]memoryCacheItem
+	// invalidated holds when the users deleted from the cache can be cached again, see invalidationTTL
+	invalidated map[string]time.Time
+}
+
+// SetTTL sets the expiry of the users cached from now on
+func (mc *MemoryCache) SetTTL(ttl time.Duration) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.ttl = ttl
+}
+
+func (mc *MemoryCache) SetUser(ctx context.Context, email string, u *User) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	now := time.Now()
+	// expired items are otherwise removed only when read, so they're swept on every write to keep
+	// the cache from growing indefinitely
+	for key, item := range mc.items {
+		if !now.Before(item.expiresAt) {
+			delete(mc.items, key)
+		}
+	}
+	for key, until := range mc.invalidated {
+		if !now.Before(until) {
+			delete(mc.invalidated, key)
+		}
+	}
+
+	key := userCacheKey(email)
+	if _, ok := mc.invalidated[key]; ok {
+		return nil
+	}
+
+	// like the Redis cache, credentials are never cached
+	cached := copyUser(u)
+	cached.Password = ""
+	cached.PasswordHash = ""
+	mc.items[key] = memoryCacheItem{
+		user:      cached,
+		expiresAt: now.Add(mc.ttl),
+	}
+
+	return nil
+}
+
+func (mc *MemoryCache) ReadUserByEmail(ctx context.Context, email string) (*User, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	key := userCacheKey(email)
+	item, ok := mc.items[key]
+	if !ok {
+		return nil, cachestore.ErrCacheMiss
+	}
+
+	if !time.Now().Before(item.expiresAt) {
+		delete(mc.items, key)
+		return nil, cachestore.ErrCacheMiss
+	}
+
+	return copyUser(item.user), nil
+}
+
+func (mc *MemoryCache) DeleteUser(ctx context.Context, email string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	key := userCacheKey(email)
+	delete(mc.items, key)
+	mc.invalidated[key] = time.Now().Add(invalidationTTL)
+
+	return nil
+}
+
+// NewMemoryCache returns a new, empty instance of MemoryCache where items expire after ttl
+func NewMemoryCache(ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		ttl:         ttl,
+		items:       make(map[string]memoryCacheItem),
+		invalidated: make(map[string]time.Time),
+	}
+}
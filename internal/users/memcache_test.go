@@ -0,0 +1,139 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	ttl := time.Millisecond * 50
+	mc := NewMemoryCache(ttl)
+
+	err := mc.SetUser(ctx, "jane@example.com", &User{
+		ID:           "user-1",
+		Email:        "jane@example.com",
+		Password:     testPassword,
+		PasswordHash: "$argon2id$hash",
+	})
+	if err != nil {
+		t.Fatalf("SetUser: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		wait    time.Duration
+		email   string
+		wantErr error
+	}{
+		{name: "cached", email: "jane@example.com"},
+		{name: "other case", email: "JANE@example.com"},
+		{name: "not cached", email: "john@example.com", wantErr: cachestore.ErrCacheMiss},
+		{name: "expired", wait: ttl, email: "jane@example.com", wantErr: cachestore.ErrCacheMiss},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			time.Sleep(tt.wait)
+			u, err := mc.ReadUserByEmail(ctx, tt.email)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadUserByEmail error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if u.ID != "user-1" {
+				t.Errorf("ReadUserByEmail ID = %s, want user-1", u.ID)
+			}
+			if u.Password != "" || u.PasswordHash != "" {
+				t.Error("credentials are cached")
+			}
+		})
+	}
+}
+
+func TestReadByEmailCacheTTL(t *testing.T) {
+	ctx := context.Background()
+	ttl := time.Millisecond * 50
+	env := newTestUsers(t, nil)
+	env.cache.SetTTL(ttl)
+	env.createUser(t, "jane@example.com")
+
+	_, err := env.us.ReadByEmail(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("ReadByEmail: %v", err)
+	}
+
+	// the user is changed in the store only, so that reads return the cached user until it expires
+	stored, err := env.store.ReadByEmail(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("store.ReadByEmail: %v", err)
+	}
+	stored.FirstName = "Janet"
+	err = env.store.Update(ctx, "jane@example.com", stored)
+	if err != nil {
+		t.Fatalf("store.Update: %v", err)
+	}
+
+	steps := []struct {
+		wait time.Duration
+		want string
+	}{
+		{want: "Jane"},
+		{wait: ttl, want: "Janet"},
+	}
+	for i, step := range steps {
+		time.Sleep(step.wait)
+		u, err := env.us.ReadByEmail(ctx, "jane@example.com")
+		if err != nil {
+			t.Fatalf("step %d: ReadByEmail: %v", i+1, err)
+		}
+		if u.FirstName != step.want {
+			t.Errorf("step %d: first name = %s, want %s", i+1, u.FirstName, step.want)
+		}
+	}
+}
+
+func TestMemoryCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(time.Minute)
+	stale := &User{ID: "user-1", Email: "jane@example.com", FirstName: "Jane"}
+
+	// a user read from the store before it changed is not cached once the change invalidated it
+	err := mc.DeleteUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	err = mc.SetUser(ctx, "jane@example.com", stale)
+	if err != nil {
+		t.Fatalf("SetUser: %v", err)
+	}
+	_, err = mc.ReadUserByEmail(ctx, "JANE@example.com")
+	if !errors.Is(err, cachestore.ErrCacheMiss) {
+		t.Errorf("ReadUserByEmail after an invalidation error = %v, want %v", err, cachestore.ErrCacheMiss)
+	}
+
+	// other users are cached as usual
+	err = mc.SetUser(ctx, "john@example.com", &User{ID: "user-2", Email: "john@example.com"})
+	if err != nil {
+		t.Fatalf("SetUser: %v", err)
+	}
+	_, err = mc.ReadUserByEmail(ctx, "john@example.com")
+	if err != nil {
+		t.Errorf("ReadUserByEmail of another user: %v", err)
+	}
+
+	// once the invalidation expires, the user is cached again
+	mc.mu.Lock()
+	mc.invalidated[userCacheKey("jane@example.com")] = time.Now()
+	mc.mu.Unlock()
+	_ = mc.SetUser(ctx, "jane@example.com", stale)
+	_, err = mc.ReadUserByEmail(ctx, "jane@example.com")
+	if err != nil {
+		t.Errorf("ReadUserByEmail after the invalidation expired: %v", err)
+	}
+}
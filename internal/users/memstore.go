@@ -0,0 +1,329 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore is a thread-safe, in-memory implementation of Store. It's meant for tests and
+// local development, all the users are lost when the app stops
+type MemoryStore struct {
+	mu sync.RWMutex
+	// users are keyed by their lowercased email, since emails are case insensitive
+	users map[string]*User
+}
+
+func memoryKey(email string) string {
+	return strings.ToLower(email)
+}
+
+// copyUser returns a deep copy of u, so that callers cannot modify the stored users
+func copyUser(u *User) *User {
+	cp := *u
+	if u.CreatedAt != nil {
+		t := *u.CreatedAt
+		cp.CreatedAt = &t
+	}
+	if u.UpdatedAt != nil {
+		t := *u.UpdatedAt
+		cp.UpdatedAt = &t
+	}
+	if u.PasswordChangedAt != nil {
+		t := *u.PasswordChangedAt
+		cp.PasswordChangedAt = &t
+	}
+	if u.MFA != nil {
+		cp.MFA = copyMFA(u.MFA)
+	}
+	if u.EmailVerifiedAt != nil {
+		t := *u.EmailVerifiedAt
+		cp.EmailVerifiedAt = &t
+	}
+	if u.Roles != nil {
+		cp.Roles = append([]string(nil), u.Roles...)
+	}
+	return &cp
+}
+
+// copyMFA returns a deep copy of mfa
+func copyMFA(mfa *MFA) *MFA {
+	cp := *mfa
+	if mfa.EnabledAt != nil {
+		t := *mfa.EnabledAt
+		cp.EnabledAt = &t
+	}
+	if mfa.RecoveryCodes != nil {
+		cp.RecoveryCodes = append([]string(nil), mfa.RecoveryCodes...)
+	}
+	return &cp
+}
+
+func (ms *MemoryStore) Create(ctx context.Context, u *User) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(u.Email)
+	if _, ok := ms.users[key]; ok {
+		return fmt.Errorf("memorystore create: %w", ErrUserAlreadyExists)
+	}
+
+	if u.ID == "" {
+		u.ID = primitive.NewObjectID().Hex()
+	}
+	ms.users[key] = copyUser(u)
+
+	return nil
+}
+
+func (ms *MemoryStore) ReadByEmail(ctx context.Context, email string) (*User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	u, ok := ms.users[memoryKey(email)]
+	if !ok {
+		return nil, fmt.Errorf("memorystore readbyEmail: %w", ErrUserNotFound)
+	}
+
+	return copyUser(u), nil
+}
+
+func (ms *MemoryStore) ReadByID(ctx context.Context, id string) (*User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, u := range ms.users {
+		if u.ID == id {
+			return copyUser(u), nil
+		}
+	}
+
+	return nil, fmt.Errorf("memorystore readByID: %w", ErrUserNotFound)
+}
+
+func (ms *MemoryStore) Update(ctx context.Context, email string, u *User) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(email)
+	existing, ok := ms.users[key]
+	if !ok {
+		return fmt.Errorf("memorystore update: %w", ErrUserNotFound)
+	}
+
+	newKey := memoryKey(u.Email)
+	if newKey != key {
+		if _, ok := ms.users[newKey]; ok {
+			return fmt.Errorf("memorystore update: %w", ErrUserAlreadyExists)
+		}
+	}
+
+	// like the Mongo store, only the profile is changed
+	updated := copyUser(existing)
+	updated.FirstName = u.FirstName
+	updated.LastName = u.LastName
+	updated.Mobile = u.Mobile
+	updated.UpdatedAt = u.UpdatedAt
+	if !strings.EqualFold(u.Email, email) {
+		updated.EmailVerified = false
+		updated.EmailVerifiedAt = nil
+	}
+	updated.Email = u.Email
+	delete(ms.users, key)
+	ms.users[newKey] = updated
+
+	return nil
+}
+
+func (ms *MemoryStore) SetPasswordHash(ctx context.Context, email string, hash string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	u, ok := ms.users[memoryKey(email)]
+	if !ok {
+		return fmt.Errorf("memorystore setPasswordHash: %w", ErrUserNotFound)
+	}
+	u.PasswordHash = hash
+
+	return nil
+}
+
+func (ms *MemoryStore) SetPassword(ctx context.Context, email string, hash string, changedAt time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	u, ok := ms.users[memoryKey(email)]
+	if !ok {
+		return fmt.Errorf("memorystore setPassword: %w", ErrUserNotFound)
+	}
+	u.PasswordHash = hash
+	u.PasswordChangedAt = &changedAt
+
+	return nil
+}
+
+func (ms *MemoryStore) SetMFA(ctx context.Context, email string, enabled bool, mfa *MFA) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	u, ok := ms.users[memoryKey(email)]
+	if !ok {
+		return fmt.Errorf("memorystore setMFA: %w", ErrUserNotFound)
+	}
+	u.MFAEnabled = enabled
+	u.MFA = nil
+	if mfa != nil {
+		u.MFA = copyMFA(mfa)
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) SetEmailVerified(ctx context.Context, email string, at time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	u, ok := ms.users[memoryKey(email)]
+	if !ok {
+		return fmt.Errorf("memorystore setEmailVerified: %w", ErrUserNotFound)
+	}
+	u.EmailVerified = true
+	u.EmailVerifiedAt = &at
+
+	return nil
+}
+
+func (ms *MemoryStore) SetRoles(ctx context.Context, email string, roles []string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	u, ok := ms.users[memoryKey(email)]
+	if !ok {
+		return fmt.Errorf("memorystore setRoles: %w", ErrUserNotFound)
+	}
+	u.Roles = append([]string(nil), roles...)
+
+	return nil
+}
+
+func (ms *MemoryStore) HasRole(ctx context.Context, role string) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, u := range ms.users {
+		for _, r := range u.Roles {
+			if r == role {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (ms *MemoryStore) Delete(ctx context.Context, email string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := memoryKey(email)
+	if _, ok := ms.users[key]; !ok {
+		return fmt.Errorf("memorystore delete: %w", ErrUserNotFound)
+	}
+	delete(ms.users, key)
+
+	return nil
+}
+
+func (ms *MemoryStore) List(ctx context.Context, filter *ListFilter, after *ListCursor, limit int) ([]*User, error) {
+	ms.mu.RLock()
+	list := make([]*User, 0, len(ms.users))
+	for _, u := range ms.users {
+		if matchesListFilter(u, filter) {
+			list = append(list, copyUser(u))
+		}
+	}
+	ms.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		less := compareListPosition(list[i], createdAt(list[j]), list[j].ID) < 0
+		if filter.Descending {
+			return !less
+		}
+		return less
+	})
+
+	result := make([]*User, 0, limit)
+	for _, u := range list {
+		if len(result) == limit {
+			break
+		}
+
+		if after != nil {
+			cmp := compareListPosition(u, after.CreatedAt, after.ID)
+			if (!filter.Descending && cmp <= 0) || (filter.Descending && cmp >= 0) {
+				continue
+			}
+		}
+		result = append(result, u)
+	}
+
+	return result, nil
+}
+
+func createdAt(u *User) time.Time {
+	if u.CreatedAt == nil {
+		return time.Time{}
+	}
+	return *u.CreatedAt
+}
+
+// compareListPosition compares the position of u in a list sorted by creation time and ID, with
+// the given position. It returns -1 if u is before, 0 if equal and +1 if u is after the position
+func compareListPosition(u *User, t time.Time, id string) int {
+	ut := createdAt(u)
+	switch {
+	case ut.Before(t):
+		return -1
+	case ut.After(t):
+		return 1
+	}
+	return strings.Compare(u.ID, id)
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func matchesListFilter(u *User, filter *ListFilter) bool {
+	if filter.NamePrefix != "" &&
+		!hasPrefixFold(u.FirstName, filter.NamePrefix) &&
+		!hasPrefixFold(u.LastName, filter.NamePrefix) {
+		return false
+	}
+
+	if filter.EmailPrefix != "" && !hasPrefixFold(u.Email, filter.EmailPrefix) {
+		return false
+	}
+
+	t := createdAt(u)
+	if filter.CreatedFrom != nil && t.Before(*filter.CreatedFrom) {
+		return false
+	}
+
+	if filter.CreatedTo != nil && !t.Before(*filter.CreatedTo) {
+		return false
+	}
+
+	return true
+}
+
+// NewMemoryStore returns a new, empty instance of MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: make(map[string]*User),
+	}
+}
@@ -0,0 +1,504 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/totp"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+const (
+	// purposeMFAChallenge is the purpose of the tokens which let users who passed the password step
+	// of a login complete it with a one-time code
+	purposeMFAChallenge = "mfa"
+	// mfaChallengeTTL is the time users have to enter a one-time code after their password
+	mfaChallengeTTL = time.Minute * 5
+	// mfaChallengeAttempts is the number of wrong codes allowed per challenge, after which the
+	// password has to be entered again
+	mfaChallengeAttempts = 5
+	// recoveryCodeCount is the number of recovery codes generated when MFA is enabled
+	recoveryCodeCount = 10
+	// recoveryCodeBytes is the number of random bytes of every recovery code
+	recoveryCodeBytes = 5
+	// defaultMFAIssuer is the issuer shown by authenticator apps when none is configured
+	defaultMFAIssuer = "goapp"
+)
+
+var (
+	// ErrMFARequired is returned by Authenticate when the password is correct, but the user has to
+	// enter a one-time code as well. The error is a *MFAChallengeError
+	ErrMFARequired = errors.New("mfa required")
+	// ErrInvalidMFACode is returned for one-time and recovery codes which are wrong, or were used
+	// already
+	ErrInvalidMFACode = errors.New("invalid mfa code")
+	// ErrMFANotEnabled is returned when confirming or disabling MFA, for users who did not start or
+	// complete the enrollment
+	ErrMFANotEnabled = errors.New("mfa not enabled")
+	// ErrMFAAlreadyEnabled is returned when enrolling users who have MFA enabled already
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+
+	recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// MFAChallengeError is returned by Authenticate for users with MFA enabled. The login is completed
+// by CompleteMFAChallenge with Token and a one-time code. It matches ErrMFARequired with errors.Is
+type MFAChallengeError struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (ce *MFAChallengeError) Error() string {
+	return ErrMFARequired.Error()
+}
+
+func (ce *MFAChallengeError) Is(target error) bool {
+	return target == ErrMFARequired
+}
+
+// MFA holds the TOTP credentials of a user. It's only stored in the primary datastore
+type MFA struct {
+	// Secret is the TOTP secret, once the enrollment is confirmed
+	Secret string
+	// PendingSecret is the TOTP secret of an enrollment which is not confirmed yet
+	PendingSecret string
+	// RecoveryCodes are the hashes of the unused recovery codes
+	RecoveryCodes []string
+	EnabledAt     *time.Time
+}
+
+// MFAEnrollment is a TOTP secret to be added to an authenticator app, either by typing Secret or by
+// scanning a QR code of URI
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// mfaChallenge is the data of MFA challenge tokens
+type mfaChallenge struct {
+	Email     string    `json:"email"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// MFARequired reports if u is required to log in with a one-time code to use their permissions, i.e.
+// if they're an admin and MFA is required for admins
+func (us *Users) MFARequired(u *User) bool {
+	if !us.mfaRequiredForAdmins {
+		return false
+	}
+	for _, role := range u.Roles {
+		if role == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRecoveryCode returns code without separators, in lower case, as it's hashed
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// hashRecoveryCode returns the hash of code. Recovery codes are random, so a fast hash is enough
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns new random recovery codes, which are shown to the user only once, and
+// their hashes which are stored
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, recoveryCodeBytes)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("newRecoveryCodes: %w", err)
+		}
+		encoded := strings.ToLower(recoveryEncoding.EncodeToString(raw))
+		code := encoded[:len(encoded)/2] + "-" + encoded[len(encoded)/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// isTOTPCode reports if code looks like a TOTP code rather than a recovery code
+func isTOTPCode(code string) bool {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totp.Digits {
+		return false
+	}
+	_, err := strconv.Atoi(code)
+	return err == nil
+}
+
+// verifyTOTP returns nil if code is the current TOTP code of secret, and was not used before by the
+// user. Used codes are remembered until they expire, so that a code seen by an attacker cannot be
+// replayed
+func (us *Users) verifyTOTP(ctx context.Context, u *User, secret string, code string) error {
+	now := time.Now()
+	counter, ok, err := totp.Validate(secret, code, now)
+	if err != nil {
+		return fmt.Errorf("verifyTOTP: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("verifyTOTP: %w", ErrInvalidMFACode)
+	}
+
+	used, err := us.tokens.MarkUsed(
+		ctx,
+		fmt.Sprintf("totp-%s-%d", u.ID, counter),
+		now.Add(totp.Period*(2*totp.Skew+1)),
+	)
+	if err != nil {
+		return fmt.Errorf("verifyTOTP: %w", err)
+	}
+	if used {
+		us.log(ctx).With("event", "mfa_code_replayed", "userID", u.ID).Warn("one-time code used again")
+		return fmt.Errorf("verifyTOTP: %w: already used", ErrInvalidMFACode)
+	}
+
+	return nil
+}
+
+// verifyMFA returns nil if code is either the current TOTP code of u, or one of their unused
+// recovery codes. Recovery codes are removed once used
+func (us *Users) verifyMFA(ctx context.Context, u *User, code string) error {
+	if !u.MFAEnabled || u.MFA == nil || u.MFA.Secret == "" {
+		return fmt.Errorf("verifyMFA: %w", ErrMFANotEnabled)
+	}
+	if isTOTPCode(code) {
+		return us.verifyTOTP(ctx, u, u.MFA.Secret, code)
+	}
+
+	hash := hashRecoveryCode(code)
+	found := -1
+	for i, h := range u.MFA.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return fmt.Errorf("verifyMFA: %w", ErrInvalidMFACode)
+	}
+
+	// the code is marked as used before it's removed, so that concurrent requests cannot both use it
+	used, err := us.tokens.MarkUsed(ctx, "recovery-"+hash, time.Now().Add(time.Hour*24))
+	if err != nil {
+		return fmt.Errorf("verifyMFA: %w", err)
+	}
+	if used {
+		return fmt.Errorf("verifyMFA: %w: already used", ErrInvalidMFACode)
+	}
+
+	mfa := *u.MFA
+	mfa.RecoveryCodes = make([]string, 0, len(u.MFA.RecoveryCodes)-1)
+	mfa.RecoveryCodes = append(mfa.RecoveryCodes, u.MFA.RecoveryCodes[:found]...)
+	mfa.RecoveryCodes = append(mfa.RecoveryCodes, u.MFA.RecoveryCodes[found+1:]...)
+	err = us.store.SetMFA(ctx, u.Email, true, &mfa)
+	if err != nil {
+		return fmt.Errorf("verifyMFA: %w", err)
+	}
+	u.MFA = &mfa
+	us.log(ctx).With(
+		"event", "mfa_recovery_code_used",
+		"userID", u.ID,
+		"remaining", len(mfa.RecoveryCodes),
+	).Warn("recovery code used")
+
+	return nil
+}
+
+// challengeMFA returns a *MFAChallengeError with a new challenge token for u, which invalidates
+// the challenges issued earlier
+func (us *Users) challengeMFA(ctx context.Context, u *User) error {
+	token, hash, err := newToken()
+	if err != nil {
+		return fmt.Errorf("challengeMFA: %w", err)
+	}
+
+	challenge := &mfaChallenge{
+		Email:     strings.ToLower(u.Email),
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	}
+	// it is safe to ignore error here because mfaChallenge has no field which can cause the marshal to fail
+	data, _ := json.Marshal(challenge)
+	err = us.tokens.Issue(ctx, purposeMFAChallenge, u.ID, hash, string(data), challenge.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("challengeMFA: %w", err)
+	}
+	us.log(ctx).With("event", "mfa_challenge_issued", "userID", u.ID).Info("mfa challenge issued")
+
+	return &MFAChallengeError{Token: token, ExpiresAt: challenge.ExpiresAt}
+}
+
+// CompleteMFAChallenge returns the user who was issued the challenge token by Authenticate, if code
+// is either their current TOTP code or one of their recovery codes. A challenge allows a few wrong
+// codes, after which the user has to enter their password again. ErrInvalidToken is returned for
+// unknown, expired or exhausted challenges. Wrong codes count as failed logins, like in Authenticate
+func (us *Users) CompleteMFAChallenge(ctx context.Context, token string, code string, clientIP string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "users.CompleteMFAChallenge")
+	defer span.End()
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, fmt.Errorf("completeMFAChallenge: %w", ErrInvalidToken)
+	}
+
+	hash := hashToken(token)
+	userID, data, err := us.tokens.Consume(ctx, purposeMFAChallenge, hash)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			us.log(ctx).Warn(err.Error())
+		} else {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+
+	challenge := new(mfaChallenge)
+	err = json.Unmarshal([]byte(data), challenge)
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return nil, fmt.Errorf("completeMFAChallenge: %w", err)
+	}
+
+	ctx = contextWithEmailHash(ctx, challenge.Email)
+	u, err := us.store.ReadByEmail(ctx, challenge.Email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, fmt.Errorf("completeMFAChallenge: %w", ErrInvalidToken)
+		}
+		us.log(ctx).Error(err.Error())
+		return nil, err
+	}
+	if u.ID != userID || !u.MFAEnabled {
+		return nil, fmt.Errorf("completeMFAChallenge: %w", ErrInvalidToken)
+	}
+
+	attempt, err := us.reserveLogin(ctx, u.Email, clientIP)
+	if err != nil {
+		// the challenge is issued again as is, so that it can be completed once the wait is over
+		data, _ := json.Marshal(challenge)
+		ierr := us.tokens.Issue(ctx, purposeMFAChallenge, u.ID, hash, string(data), challenge.ExpiresAt)
+		if ierr != nil {
+			us.log(ctx).Error(ierr.Error())
+		}
+		return nil, err
+	}
+
+	err = us.verifyMFA(ctx, u, code)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidMFACode) {
+			us.releaseLogin(ctx, attempt)
+			us.log(ctx).Error(err.Error())
+			return nil, err
+		}
+		us.failLogin(ctx, attempt, u.ID, "mfa")
+
+		challenge.Attempts++
+		log := us.log(ctx).With("event", "mfa_failed", "userID", u.ID, "attempts", challenge.Attempts)
+		if challenge.Attempts >= mfaChallengeAttempts || !time.Now().Before(challenge.ExpiresAt) {
+			log.Warn("wrong one-time code, challenge exhausted")
+			return nil, err
+		}
+		log.Warn("wrong one-time code")
+
+		// the same challenge is issued again, so that the user can correct a typo
+		data, _ := json.Marshal(challenge)
+		ierr := us.tokens.Issue(ctx, purposeMFAChallenge, u.ID, hash, string(data), challenge.ExpiresAt)
+		if ierr != nil {
+			us.log(ctx).Error(ierr.Error())
+		}
+		return nil, err
+	}
+	us.log(ctx).With("event", "mfa_verified", "userID", u.ID).Info("mfa challenge completed")
+	us.succeedLogin(ctx, attempt)
+
+	return u, nil
+}
+
+// EnrollMFA starts the TOTP enrollment of the user identified by email, and returns the new secret.
+// MFA is enabled only once the user confirms they added it to their app, see ConfirmMFA. Starting
+// again replaces the pending secret
+func (us *Users) EnrollMFA(ctx context.Context, email string) (*MFAEnrollment, error) {
+	ctx, span := tracing.Start(ctx, "users.EnrollMFA")
+	defer span.End()
+
+	email = canonicalEmail(email)
+	ctx = contextWithEmailHash(ctx, email)
+	u, err := us.store.ReadByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+	if u.MFAEnabled {
+		return nil, fmt.Errorf("enrollMFA: %w", ErrMFAAlreadyEnabled)
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return nil, err
+	}
+	err = us.store.SetMFA(ctx, u.Email, false, &MFA{PendingSecret: secret})
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return nil, err
+	}
+	us.log(ctx).With("event", "mfa_enrollment_started", "userID", u.ID).Info("mfa enrollment started")
+
+	return &MFAEnrollment{
+		Secret: secret,
+		URI:    totp.URI(us.mfaIssuer, u.Email, secret),
+	}, nil
+}
+
+// ConfirmMFA enables MFA for the user identified by email, if code is the current code of the
+// pending secret. It returns the recovery codes, which are not stored and cannot be shown again
+func (us *Users) ConfirmMFA(ctx context.Context, email string, code string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "users.ConfirmMFA")
+	defer span.End()
+
+	email = canonicalEmail(email)
+	ctx = contextWithEmailHash(ctx, email)
+	u, err := us.store.ReadByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+	if u.MFAEnabled {
+		return nil, fmt.Errorf("confirmMFA: %w", ErrMFAAlreadyEnabled)
+	}
+	if u.MFA == nil || u.MFA.PendingSecret == "" {
+		return nil, fmt.Errorf("confirmMFA: %w", ErrMFANotEnabled)
+	}
+
+	err = us.verifyTOTP(ctx, u, u.MFA.PendingSecret, code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			us.log(ctx).Warn(err.Error())
+		} else {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return nil, err
+	}
+	now := time.Now()
+	err = us.store.SetMFA(ctx, u.Email, true, &MFA{
+		Secret:        u.MFA.PendingSecret,
+		RecoveryCodes: hashes,
+		EnabledAt:     &now,
+	})
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return nil, err
+	}
+	us.invalidateCache(ctx, u.Email)
+	us.log(ctx).With("event", "mfa_enabled", "userID", u.ID).Warn("mfa enabled")
+
+	return codes, nil
+}
+
+// DisableMFA disables MFA for the user identified by email, if code is either their current TOTP
+// code or one of their recovery codes. Wrong codes count as failed logins of the account
+func (us *Users) DisableMFA(ctx context.Context, email string, code string) error {
+	ctx, span := tracing.Start(ctx, "users.DisableMFA")
+	defer span.End()
+
+	email = canonicalEmail(email)
+	ctx = contextWithEmailHash(ctx, email)
+	u, err := us.store.ReadByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+		}
+		return err
+	}
+
+	attempt, err := us.reserveLogin(ctx, u.Email, "")
+	if err != nil {
+		return err
+	}
+
+	err = us.verifyMFA(ctx, u, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidMFACode):
+			us.failLogin(ctx, attempt, u.ID, "mfa")
+		case errors.Is(err, ErrMFANotEnabled):
+			us.releaseLogin(ctx, attempt)
+			us.log(ctx).Warn(err.Error())
+		default:
+			us.releaseLogin(ctx, attempt)
+			us.log(ctx).Error(err.Error())
+		}
+		return err
+	}
+	// the failed logins are kept, since disabling MFA is not a login
+	us.releaseLogin(ctx, attempt)
+
+	return us.resetMFA(ctx, u)
+}
+
+// ResetMFA disables MFA for the user identified by email without any code, e.g. by an admin for a
+// user who lost their device and recovery codes
+func (us *Users) ResetMFA(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "users.ResetMFA")
+	defer span.End()
+
+	email = canonicalEmail(email)
+	ctx = contextWithEmailHash(ctx, email)
+	u, err := us.store.ReadByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+		}
+		return err
+	}
+	if !u.MFAEnabled {
+		return fmt.Errorf("resetMFA: %w", ErrMFANotEnabled)
+	}
+
+	return us.resetMFA(ctx, u)
+}
+
+func (us *Users) resetMFA(ctx context.Context, u *User) error {
+	err := us.store.SetMFA(ctx, u.Email, false, nil)
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return err
+	}
+	us.invalidateCache(ctx, u.Email)
+
+	err = us.tokens.Revoke(ctx, purposeMFAChallenge, u.ID)
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+	}
+	us.log(ctx).With("event", "mfa_disabled", "userID", u.ID).Warn("mfa disabled")
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/totp"
+)
+
+// enableMFA enrolls the user identified by email, and returns the TOTP secret and recovery codes
+func (env *testEnv) enableMFA(t *testing.T, email string) (string, []string) {
+	t.Helper()
+
+	ctx := context.Background()
+	enrollment, err := env.us.EnrollMFA(ctx, email)
+	if err != nil {
+		t.Fatalf("EnrollMFA: %v", err)
+	}
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("totp.Code: %v", err)
+	}
+	codes, err := env.us.ConfirmMFA(ctx, email, code)
+	if err != nil {
+		t.Fatalf("ConfirmMFA: %v", err)
+	}
+	return enrollment.Secret, codes
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{code: "abcd-efgh", want: "abcdefgh"},
+		{code: " ABCD-EFGH ", want: "abcdefgh"},
+		{code: "abcd efgh", want: "abcdefgh"},
+		{code: "abcdefgh", want: "abcdefgh"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+		if hashRecoveryCode(tt.code) != hashRecoveryCode(tt.want) {
+			t.Errorf("hashRecoveryCode(%q) differs from the hash of %q", tt.code, tt.want)
+		}
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatalf("newRecoveryCodes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("newRecoveryCodes returned %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	seen := make(map[string]bool, len(codes))
+	for i, code := range codes {
+		if seen[code] {
+			t.Errorf("code %s is repeated", code)
+		}
+		seen[code] = true
+		if isTOTPCode(code) {
+			t.Errorf("code %s looks like a one-time code", code)
+		}
+		if hashes[i] != hashRecoveryCode(code) {
+			t.Errorf("hash %d is not the hash of %s", i, code)
+		}
+	}
+}
+
+func TestConfirmMFA(t *testing.T) {
+	tests := []struct {
+		name    string
+		enroll  bool
+		code    func(secret string) string
+		wantErr error
+	}{
+		{
+			name:   "current code",
+			enroll: true,
+			code: func(secret string) string {
+				code, _ := totp.Code(secret, time.Now())
+				return code
+			},
+		},
+		{name: "wrong code", enroll: true, code: func(string) string { return "000000" }, wantErr: ErrInvalidMFACode},
+		{name: "not enrolled", code: func(string) string { return "000000" }, wantErr: ErrMFANotEnabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestUsers(t, nil)
+			env.createUser(t, "jane@example.com")
+
+			var secret string
+			if tt.enroll {
+				enrollment, err := env.us.EnrollMFA(ctx, "jane@example.com")
+				if err != nil {
+					t.Fatalf("EnrollMFA: %v", err)
+				}
+				secret = enrollment.Secret
+			}
+
+			codes, err := env.us.ConfirmMFA(ctx, "jane@example.com", tt.code(secret))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ConfirmMFA error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && len(codes) != recoveryCodeCount {
+				t.Errorf("ConfirmMFA returned %d recovery codes, want %d", len(codes), recoveryCodeCount)
+			}
+
+			u, err := env.us.ReadByEmail(ctx, "jane@example.com")
+			if err != nil {
+				t.Fatalf("ReadByEmail: %v", err)
+			}
+			if u.MFAEnabled != (tt.wantErr == nil) {
+				t.Errorf("MFAEnabled = %v, want %v", u.MFAEnabled, tt.wantErr == nil)
+			}
+
+			_, err = env.us.EnrollMFA(ctx, "jane@example.com")
+			if tt.wantErr == nil && !errors.Is(err, ErrMFAAlreadyEnabled) {
+				t.Errorf("EnrollMFA after enabling error = %v, want %v", err, ErrMFAAlreadyEnabled)
+			}
+		})
+	}
+}
+
+// challenge authenticates the user identified by email, and returns the MFA challenge token
+func (env *testEnv) challenge(t *testing.T, email string) string {
+	t.Helper()
+
+	_, err := env.us.Authenticate(context.Background(), email, testPassword, "192.0.2.1")
+	var challengeErr *MFAChallengeError
+	if !errors.As(err, &challengeErr) {
+		t.Fatalf("Authenticate error = %v, want %v", err, ErrMFARequired)
+	}
+	return challengeErr.Token
+}
+
+func TestCompleteMFAChallenge(t *testing.T) {
+	ctx := context.Background()
+	env := newTestUsers(t, nil)
+	created := env.createUser(t, "jane@example.com")
+	secret, codes := env.enableMFA(t, "jane@example.com")
+	// the code used to confirm the enrollment cannot be used again, so the next period's is used
+	next, _ := totp.Code(secret, time.Now().Add(totp.Period))
+
+	steps := []struct {
+		name    string
+		code    string
+		wantErr error
+	}{
+		{name: "wrong code", code: "000000", wantErr: ErrInvalidMFACode},
+		{name: "one-time code", code: next},
+		{name: "one-time code replayed", code: next, wantErr: ErrInvalidMFACode},
+		{name: "recovery code", code: codes[0]},
+		{name: "recovery code reused", code: codes[0], wantErr: ErrInvalidMFACode},
+		{name: "recovery code in upper case", code: " " + strings.ToUpper(codes[1]) + " "},
+		{name: "unknown recovery code", code: "aaaa-bbbb", wantErr: ErrInvalidMFACode},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			token := env.challenge(t, "jane@example.com")
+			u, err := env.us.CompleteMFAChallenge(ctx, token, step.code, "192.0.2.1")
+			if !errors.Is(err, step.wantErr) {
+				t.Fatalf("CompleteMFAChallenge error = %v, want %v", err, step.wantErr)
+			}
+			if err == nil && u.ID != created.ID {
+				t.Errorf("CompleteMFAChallenge ID = %s, want %s", u.ID, created.ID)
+			}
+		})
+	}
+
+	u, err := env.store.ReadByEmail(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("ReadByEmail: %v", err)
+	}
+	if len(u.MFA.RecoveryCodes) != recoveryCodeCount-2 {
+		t.Errorf("%d recovery codes left, want %d", len(u.MFA.RecoveryCodes), recoveryCodeCount-2)
+	}
+}
+
+func TestCompleteMFAChallengeToken(t *testing.T) {
+	ctx := context.Background()
+	env := newTestUsers(t, nil)
+	env.createUser(t, "jane@example.com")
+	_, codes := env.enableMFA(t, "jane@example.com")
+
+	token := env.challenge(t, "jane@example.com")
+	_, err := env.us.CompleteMFAChallenge(ctx, token, codes[0], "192.0.2.1")
+	if err != nil {
+		t.Fatalf("CompleteMFAChallenge: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "empty", token: ""},
+		{name: "unknown", token: "unknown"},
+		{name: "used", token: token},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.us.CompleteMFAChallenge(ctx, tt.token, codes[1], "192.0.2.1")
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("CompleteMFAChallenge error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// mergePatch applies patch to target as described in RFC 7396 (JSON Merge Patch) and returns
+// the resulting document
+func mergePatch(target, patch []byte) ([]byte, error) {
+	var patchValue interface{}
+	err := json.Unmarshal(patch, &patchValue)
+	if err != nil {
+		return nil, fmt.Errorf("mergePatch: %w", err)
+	}
+
+	var targetValue interface{}
+	err = json.Unmarshal(target, &targetValue)
+	if err != nil {
+		return nil, fmt.Errorf("mergePatch: %w", err)
+	}
+
+	return json.Marshal(mergeValue(targetValue, patchValue))
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		// a patch which is not an object replaces the target entirely
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergeValue(targetObj[key], value)
+	}
+
+	return targetObj
+}
@@ -0,0 +1,127 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// the examples of RFC 7396, appendix A
+	tests := []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{target: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{target: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+		{target: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{target: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+		{target: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{target: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{target: `["a","b"]`, patch: `["c","d"]`, want: `["c","d"]`},
+		{target: `{"a":"b"}`, patch: `["c"]`, want: `["c"]`},
+		{target: `{"a":"foo"}`, patch: `null`, want: `null`},
+		{target: `{"a":"foo"}`, patch: `"bar"`, want: `"bar"`},
+		{target: `{"e":null}`, patch: `{"a":1}`, want: `{"a":1,"e":null}`},
+		{target: `[1,2]`, patch: `{"a":"b","c":null}`, want: `{"a":"b"}`},
+		{target: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		got, err := mergePatch([]byte(tt.target), []byte(tt.patch))
+		if err != nil {
+			t.Fatalf("mergePatch(%s, %s): %v", tt.target, tt.patch, err)
+		}
+
+		var gotValue, wantValue interface{}
+		_ = json.Unmarshal(got, &gotValue)
+		_ = json.Unmarshal([]byte(tt.want), &wantValue)
+		if !reflect.DeepEqual(gotValue, wantValue) {
+			t.Errorf("mergePatch(%s, %s) = %s, want %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	_, err := mergePatch([]byte(`{}`), []byte(`{"a":`))
+	if err == nil {
+		t.Error("mergePatch of an invalid patch returned no error")
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   string
+		patch   string
+		wantErr error
+		check   func(t *testing.T, u *User)
+	}{
+		{
+			name:  "replace a field",
+			email: "jane@example.com",
+			patch: `{"firstName":"Janet"}`,
+			check: func(t *testing.T, u *User) {
+				if u.FirstName != "Janet" || u.LastName != "Doe" {
+					t.Errorf("names = %s %s, want only the first name replaced", u.FirstName, u.LastName)
+				}
+			},
+		},
+		{
+			name:  "remove a field",
+			email: "jane@example.com",
+			patch: `{"lastName":null}`,
+			check: func(t *testing.T, u *User) {
+				if u.FirstName != "Jane" || u.LastName != "" {
+					t.Errorf("names = %q %q, want the last name removed", u.FirstName, u.LastName)
+				}
+			},
+		},
+		{
+			name:  "id is ignored",
+			email: "jane@example.com",
+			patch: `{"id":"5f1d7f3b0000000000000000","firstName":"Janet"}`,
+			check: func(t *testing.T, u *User) {
+				if u.ID == "5f1d7f3b0000000000000000" {
+					t.Error("the ID was replaced by the patch")
+				}
+			},
+		},
+		{name: "invalid json", email: "jane@example.com", patch: `{"firstName":`, wantErr: ErrUserValidation},
+		{name: "invalid field type", email: "jane@example.com", patch: `{"firstName":1}`, wantErr: ErrUserValidation},
+		{name: "invalid email", email: "jane@example.com", patch: `{"email":"jane"}`, wantErr: ErrUserValidation},
+		{name: "password", email: "jane@example.com", patch: `{"password":"another password"}`, wantErr: ErrUserValidation},
+		{name: "unknown user", email: "john@example.com", patch: `{"firstName":"John"}`, wantErr: ErrUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestUsers(t, nil)
+			created := env.createUser(t, "jane@example.com")
+
+			u, err := env.us.PatchUser(ctx, tt.email, []byte(tt.patch))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PatchUser error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if u.ID != created.ID {
+				t.Errorf("PatchUser ID = %s, want %s", u.ID, created.ID)
+			}
+			tt.check(t, u)
+
+			read, err := env.us.ReadByEmail(ctx, u.Email)
+			if err != nil {
+				t.Fatalf("ReadByEmail: %v", err)
+			}
+			tt.check(t, read)
+		})
+	}
+}
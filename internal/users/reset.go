@@ -0,0 +1,276 @@
+package users
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/pkg/mailer"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+const (
+	// purposeReset is the purpose of the tokens sent to reset passwords
+	purposeReset = "reset"
+	// defaultResetTTL is the expiry of password reset tokens when none is configured
+	defaultResetTTL = time.Hour
+	// defaultResetLimitWindow is the window of the rate limits of password reset requests when none
+	// is configured
+	defaultResetLimitWindow = time.Hour
+	// mailTimeout is the maximum time allowed for sending an email in the background
+	mailTimeout = time.Second * 30
+)
+
+// CredentialsChangedAfter reports if the password of u was changed after t, e.g. the time a token
+// or session was issued. Times are compared in seconds, which is the precision of token timestamps
+func (u *User) CredentialsChangedAfter(t time.Time) bool {
+	if u.PasswordChangedAt == nil {
+		return false
+	}
+	return t.Before(u.PasswordChangedAt.Truncate(time.Second))
+}
+
+// hashEmail returns a hash of the lowercased email, so that it can be used in keys without storing
+// the email itself
+func hashEmail(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// detach returns a context which is not canceled with ctx, and carries its log fields. It's meant
+// for work which outlives a request, e.g. sending emails in the background
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(
+		logger.ContextWithFields(context.Background(), logger.FieldsFromContext(ctx)...),
+		mailTimeout,
+	)
+}
+
+// goDetached runs f in the background with a context detached from ctx, see detach. It's awaited by
+// Wait, so that emails are not dropped on shutdown
+func (us *Users) goDetached(ctx context.Context, f func(ctx context.Context)) {
+	detached, cancel := detach(ctx)
+	us.background.Add(1)
+	go func() {
+		defer us.background.Done()
+		defer cancel()
+		f(detached)
+	}()
+}
+
+// Wait waits for the emails being sent in the background, or until ctx is done. It's meant to be
+// called on shutdown, once no more requests are served
+func (us *Users) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		us.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait: %w", ctx.Err())
+	}
+}
+
+// allowReset counts a password reset request of key, and returns a *ratelimit.LimitError if it's
+// over limit. Requests are allowed when the limiter is not available, so that users can still
+// recover their accounts
+func (us *Users) allowReset(ctx context.Context, key string, limit int) error {
+	result, err := us.limiter.Allow(ctx, key, limit, us.resetLimitWindow)
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return nil
+	}
+	return result.Err()
+}
+
+// RequestPasswordReset sends a password reset token to email, if there's a user with it. The result
+// is the same whether the user exists or not, and the email is sent in the background, so that
+// callers cannot tell. Requests are rate limited per client IP, which returns a
+// *ratelimit.LimitError, and per email, which silently drops the request since the error would tell
+// that someone else is asking to reset that password
+func (us *Users) RequestPasswordReset(ctx context.Context, email string, clientIP string) error {
+	ctx, span := tracing.Start(ctx, "users.RequestPasswordReset")
+	defer span.End()
+
+	ctx = contextWithEmailHash(ctx, email)
+	email, err := validateEmail(email)
+	if err != nil {
+		us.log(ctx).Warn(err.Error())
+		return err
+	}
+
+	if clientIP != "" {
+		err = us.allowReset(ctx, "ip-"+clientIP, us.resetLimitPerIP)
+		if err != nil {
+			us.log(ctx).With("event", "password_reset_limited", "limit", "ip").Warn(err.Error())
+			return err
+		}
+	}
+	err = us.allowReset(ctx, "email-"+hashEmail(email), us.resetLimitPerEmail)
+	if err != nil {
+		us.log(ctx).With("event", "password_reset_limited", "limit", "email").Warn(err.Error())
+		return nil
+	}
+
+	u, err := us.store.ReadByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+			return err
+		}
+		us.log(ctx).With("event", "password_reset_requested").Info("password reset requested for an unknown email")
+		return nil
+	}
+	us.log(ctx).With("event", "password_reset_requested", "userID", u.ID).Info("password reset requested")
+
+	us.goDetached(ctx, func(sendCtx context.Context) {
+		err := us.sendPasswordReset(sendCtx, u)
+		if err != nil {
+			us.log(sendCtx).Error(err.Error())
+		}
+	})
+
+	return nil
+}
+
+// resetMessage returns the email which lets the owner of email reset their password using token
+func (us *Users) resetMessage(email string, token string) *mailer.Message {
+	body := new(strings.Builder)
+	body.WriteString("Someone asked to reset the password of the account with this email address.\n\n")
+	if us.resetURL != "" {
+		link := us.resetURL
+		sep := "?"
+		if strings.Contains(link, "?") {
+			sep = "&"
+		}
+		fmt.Fprintf(body, "Open the following link to choose a new password:\n%s%stoken=%s\n\n", link, sep, url.QueryEscape(token))
+	}
+	fmt.Fprintf(body, "Password reset token: %s\n\n", token)
+	fmt.Fprintf(body, "The token expires in %s. If you did not ask for it, you can ignore this email, your password is unchanged.\n", us.resetTTL)
+
+	return &mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body:    body.String(),
+	}
+}
+
+// sendPasswordReset issues a reset token for u and sends it, which invalidates the tokens sent
+// earlier. The token carries the email, so that it's invalid if the user changes theirs
+func (us *Users) sendPasswordReset(ctx context.Context, u *User) error {
+	token, hash, err := newToken()
+	if err != nil {
+		return fmt.Errorf("sendPasswordReset: %w", err)
+	}
+
+	err = us.tokens.Issue(ctx, purposeReset, u.ID, hash, strings.ToLower(u.Email), time.Now().Add(us.resetTTL))
+	if err != nil {
+		return fmt.Errorf("sendPasswordReset: %w", err)
+	}
+
+	err = us.mailer.Send(ctx, us.resetMessage(u.Email, token))
+	if err != nil {
+		return fmt.Errorf("sendPasswordReset: %w", err)
+	}
+	us.log(ctx).With("event", "password_reset_sent", "userID", u.ID).Info("password reset email sent")
+
+	return nil
+}
+
+// ResetPassword replaces the password of the user who was sent token. The token can be used only
+// once, and all the other reset tokens of the user are invalidated. Tokens and sessions issued before
+// the reset are rejected afterwards, see CredentialsChangedAfter. ErrInvalidToken is returned if the
+// token is unknown, expired, used, or if the user changed their email since it was sent
+func (us *Users) ResetPassword(ctx context.Context, token string, plain string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "users.ResetPassword")
+	defer span.End()
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, fmt.Errorf("resetPassword: %w", ErrInvalidToken)
+	}
+
+	// the policy is checked before using the token, so that a weak password does not waste it. The
+	// checks involving the details of the user are done once it's known
+	err := us.passwords.Validate(plain)
+	if err != nil {
+		err = &validationError{cause: err}
+		us.log(ctx).Warn(err.Error())
+		return nil, err
+	}
+
+	userID, email, err := us.tokens.Consume(ctx, purposeReset, hashToken(token))
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			us.log(ctx).With("event", "password_reset_failed").Warn(err.Error())
+		} else {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+
+	ctx = contextWithEmailHash(ctx, email)
+	u, err := us.store.ReadByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, fmt.Errorf("resetPassword: %w", ErrInvalidToken)
+		}
+		us.log(ctx).Error(err.Error())
+		return nil, err
+	}
+	if u.ID != userID {
+		// the email was given to another user since the token was sent
+		return nil, fmt.Errorf("resetPassword: %w", ErrInvalidToken)
+	}
+
+	u.Password = plain
+	err = us.setPassword(u)
+	if err != nil {
+		if errors.Is(err, ErrUserValidation) {
+			us.log(ctx).Warn(err.Error())
+		} else {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	err = us.store.SetPassword(ctx, u.Email, u.PasswordHash, now)
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return nil, err
+	}
+	u.PasswordChangedAt = &now
+	us.invalidateCache(ctx, u.Email)
+
+	// the token used was deleted already, but another one may have been sent since
+	err = us.tokens.Revoke(ctx, purposeReset, u.ID)
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+	}
+	us.log(ctx).With("event", "password_reset", "userID", u.ID).Warn("password reset")
+
+	us.goDetached(ctx, func(sendCtx context.Context) {
+		err := us.mailer.Send(sendCtx, &mailer.Message{
+			To:      u.Email,
+			Subject: "Your password was changed",
+			Body: "The password of the account with this email address was just reset, and all its sessions " +
+				"were logged out.\n\nIf you did not reset it, please reset it again right away and contact support.\n",
+		})
+		if err != nil {
+			us.log(sendCtx).Error(err.Error())
+		}
+	})
+
+	return u, nil
+}
@@ -0,0 +1,181 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+const (
+	// RoleUser is the role of every user, it grants no permission on other users
+	RoleUser = "user"
+	// RoleAdmin grants all the permissions on all users
+	RoleAdmin = "admin"
+
+	// PermissionUsersRead allows reading and listing any user
+	PermissionUsersRead = "users:read"
+	// PermissionUsersWrite allows updating and deleting any user
+	PermissionUsersWrite = "users:write"
+	// PermissionRolesWrite allows assigning roles to users, including on signup
+	PermissionRolesWrite = "roles:write"
+	// PermissionAPIKeysWrite allows issuing, rotating and revoking the API keys of services
+	PermissionAPIKeysWrite = "apikeys:write"
+)
+
+// rolePermissions are the permissions granted by each role. Permissions on the user themself are
+// not listed, since every user is allowed to read and change their own details
+var rolePermissions = map[string][]string{
+	RoleUser:  {},
+	RoleAdmin: {PermissionUsersRead, PermissionUsersWrite, PermissionRolesWrite, PermissionAPIKeysWrite},
+}
+
+// Permissions returns the permissions granted by all the roles of u
+func (u *User) Permissions() []string {
+	return permissionsOf(u.Roles)
+}
+
+// HasPermission reports if any of the roles of u grants permission
+func (u *User) HasPermission(permission string) bool {
+	for _, p := range u.Permissions() {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func permissionsOf(roles []string) []string {
+	seen := make(map[string]struct{})
+	permissions := make([]string, 0)
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// DefaultRoles reports if roles are only the ones every user has, i.e. RoleUser, so that assigning them
+// requires no permission
+func DefaultRoles(roles []string) bool {
+	for _, role := range roles {
+		if strings.ToLower(strings.TrimSpace(role)) != RoleUser {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeRoles returns the sorted, deduplicated roles, which always include RoleUser. An error is
+// returned for unknown roles
+func normalizeRoles(roles []string) ([]string, error) {
+	seen := map[string]struct{}{RoleUser: {}}
+	normalized := []string{RoleUser}
+	for _, role := range roles {
+		role = strings.ToLower(strings.TrimSpace(role))
+		if _, ok := rolePermissions[role]; !ok {
+			return nil, &validationError{cause: fmt.Errorf("unknown role '%s'", role)}
+		}
+		if _, ok := seen[role]; !ok {
+			seen[role] = struct{}{}
+			normalized = append(normalized, role)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// sameRoles reports if a and b have the same roles, irrespective of their order
+func sameRoles(a, b []string) bool {
+	na, errA := normalizeRoles(a)
+	nb, errB := normalizeRoles(b)
+	if errA != nil || errB != nil || len(na) != len(nb) {
+		return false
+	}
+	for i := range na {
+		if na[i] != nb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetRoles replaces the roles of the user identified by email
+func (us *Users) SetRoles(ctx context.Context, email string, roles []string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "users.SetRoles")
+	defer span.End()
+
+	email = canonicalEmail(email)
+	ctx = contextWithEmailHash(ctx, email)
+
+	roles, err := normalizeRoles(roles)
+	if err != nil {
+		us.log(ctx).Warn(err.Error())
+		return nil, err
+	}
+
+	err = us.store.SetRoles(ctx, email, roles)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+	us.invalidateCache(ctx, email)
+
+	u, err := us.store.ReadByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	us.log(ctx).With("event", "roles_changed", "userID", u.ID, "roles", roles).Info("roles of user changed")
+
+	return u, nil
+}
+
+// BootstrapAdmin grants RoleAdmin to the user with the bootstrap admin email, unless there's an admin
+// already. The user must have verified the email, so that whoever signs up first with it is not made
+// admin without owning it. It's called at startup for a user who verified their email earlier, and
+// when the email is verified otherwise. It returns the promoted user, or nil if no user was promoted
+func (us *Users) BootstrapAdmin(ctx context.Context) (*User, error) {
+	if us.bootstrapAdminEmail == "" {
+		return nil, nil
+	}
+
+	ctx = contextWithEmailHash(ctx, us.bootstrapAdminEmail)
+	exists, err := us.store.HasRole(ctx, RoleAdmin)
+	if err != nil {
+		return nil, fmt.Errorf("bootstrapAdmin: %w", err)
+	}
+	if exists {
+		return nil, nil
+	}
+
+	u, err := us.store.ReadByEmail(ctx, us.bootstrapAdminEmail)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Warn("no admin exists, the bootstrap admin is promoted once they sign up and verify their email")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("bootstrapAdmin: %w", err)
+	}
+	if !u.EmailVerified {
+		us.log(ctx).Warn("no admin exists, the bootstrap admin is promoted once they verify their email")
+		return nil, nil
+	}
+
+	u, err = us.SetRoles(ctx, u.Email, append(u.Roles, RoleAdmin))
+	if err != nil {
+		return nil, fmt.Errorf("bootstrapAdmin: %w", err)
+	}
+	us.log(ctx).With("event", "admin_bootstrapped", "userID", u.ID).Warn("bootstrap admin promoted")
+
+	return u, nil
+}
@@ -0,0 +1,32 @@
+package users
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBootstrapAdmin(t *testing.T) {
+	ctx := context.Background()
+	env := newTestUsers(t, &Config{BootstrapAdminEmail: "Ops@Example.com"})
+
+	u := env.createUser(t, "ops@example.com")
+	if len(u.Roles) != 1 || u.Roles[0] != RoleUser {
+		t.Fatalf("roles on signup = %v, want only %s until the email is verified", u.Roles, RoleUser)
+	}
+	promoted, err := env.us.BootstrapAdmin(ctx)
+	if err != nil || promoted != nil {
+		t.Fatalf("BootstrapAdmin = %v, %v, want no promotion of an unverified user", promoted, err)
+	}
+
+	_, err = env.us.VerifyEmail(ctx, env.mail.token(t, "ops@example.com", "Verification token:"))
+	if err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	u, err = env.us.ReadByEmail(ctx, "ops@example.com")
+	if err != nil {
+		t.Fatalf("ReadByEmail: %v", err)
+	}
+	if !u.HasPermission(PermissionRolesWrite) {
+		t.Errorf("roles after verifying = %v, want %s", u.Roles, RoleAdmin)
+	}
+}
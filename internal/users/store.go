@@ -2,20 +2,57 @@ package users
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jerryan999/goapp/internal/pkg/datastore"
 )
 
 var (
 	Database       = "goapp"
 	UserCollection = "user"
+
+	// emailCollation makes comparison of emails case insensitive. It has to be used for all queries
+	// on email, so that they're served by the unique index on email
+	emailCollation = &options.Collation{Locale: "en", Strength: 2}
 )
 
-type store interface {
+// Store is the primary datastore of users
+type Store interface {
 	Create(ctx context.Context, u *User) error
 	ReadByEmail(ctx context.Context, email string) (*User, error)
+	ReadByID(ctx context.Context, id string) (*User, error)
+	// Update sets only the profile of the user identified by email, i.e. names, mobile and email,
+	// to those of u
+	Update(ctx context.Context, email string, u *User) error
+	Delete(ctx context.Context, email string) error
+	// SetPasswordHash replaces only the password hash of the user identified by email
+	SetPasswordHash(ctx context.Context, email string, hash string) error
+	// SetPassword replaces the password hash of the user identified by email, and records when it
+	// was changed
+	SetPassword(ctx context.Context, email string, hash string, changedAt time.Time) error
+	// SetMFA replaces the MFA credentials of the user identified by email, and whether MFA is enabled
+	SetMFA(ctx context.Context, email string, enabled bool, mfa *MFA) error
+	// SetEmailVerified marks the email of the user identified by email as verified at the given time
+	SetEmailVerified(ctx context.Context, email string, at time.Time) error
+	// SetRoles replaces only the roles of the user identified by email
+	SetRoles(ctx context.Context, email string, roles []string) error
+	// HasRole reports if any user has the role
+	HasRole(ctx context.Context, role string) (bool, error)
+	List(ctx context.Context, filter *ListFilter, after *ListCursor, limit int) ([]*User, error)
+}
+
+// startOperation starts a span for an operation on the users collection, see datastore.StartOperation
+func startOperation(ctx context.Context, operation string) (context.Context, func()) {
+	return datastore.StartOperation(ctx, Database, UserCollection, operation)
 }
 
 type userStore struct {
@@ -24,22 +61,317 @@ type userStore struct {
 }
 
 func (us *userStore) Create(ctx context.Context, u *User) error {
-	_, err := us.userCollection.InsertOne(ctx, u)
+	ctx, end := startOperation(ctx, "create")
+	defer end()
+
+	result, err := us.userCollection.InsertOne(ctx, u)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("userstore create: %w", ErrUserAlreadyExists)
+		}
 		return fmt.Errorf("userstore create: %w", err)
 	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		u.ID = id.Hex()
+	}
 	return nil
 }
 
 func (us *userStore) ReadByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, end := startOperation(ctx, "readByEmail")
+	defer end()
+
 	var u User
-	err := us.userCollection.FindOne(ctx, bson.D{{Key: "email", Value: email}}).Decode(&u)
+	err := us.userCollection.FindOne(
+		ctx,
+		bson.D{{Key: "email", Value: email}},
+		options.FindOne().SetCollation(emailCollation),
+	).Decode(&u)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("userstore readbyEmail: %w", ErrUserNotFound)
+		}
 		return nil, fmt.Errorf("userstore readbyEmail: %w", err)
 	}
 	return &u, nil
 }
 
+func (us *userStore) ReadByID(ctx context.Context, id string) (*User, error) {
+	ctx, end := startOperation(ctx, "readByID")
+	defer end()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("userstore readByID: %w", ErrUserNotFound)
+	}
+
+	var u User
+	err = us.userCollection.FindOne(ctx, bson.D{{Key: "_id", Value: oid}}).Decode(&u)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("userstore readByID: %w", ErrUserNotFound)
+		}
+		return nil, fmt.Errorf("userstore readByID: %w", err)
+	}
+	return &u, nil
+}
+
+// Update sets the profile of the user identified by email, i.e. the names, mobile and email, to
+// those of u. Credentials, roles and MFA are left as they are, so that a concurrent change of any
+// of them is never undone. The email of u may differ from email, in which case the user's email is
+// changed and marked as not verified
+func (us *userStore) Update(ctx context.Context, email string, u *User) error {
+	ctx, end := startOperation(ctx, "update")
+	defer end()
+
+	set := bson.D{
+		{Key: "firstname", Value: u.FirstName},
+		{Key: "lastname", Value: u.LastName},
+		{Key: "mobile", Value: u.Mobile},
+		{Key: "email", Value: u.Email},
+		{Key: "updatedat", Value: u.UpdatedAt},
+	}
+	update := bson.D{{Key: "$set", Value: set}}
+	if !strings.EqualFold(u.Email, email) {
+		update[0].Value = append(set, bson.E{Key: "emailverified", Value: false})
+		update = append(update, bson.E{Key: "$unset", Value: bson.D{{Key: "emailverifiedat", Value: ""}}})
+	}
+
+	result, err := us.userCollection.UpdateOne(
+		ctx,
+		bson.D{{Key: "email", Value: email}},
+		update,
+		options.Update().SetCollation(emailCollation),
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("userstore update: %w", ErrUserAlreadyExists)
+		}
+		return fmt.Errorf("userstore update: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("userstore update: %w", ErrUserNotFound)
+	}
+	return nil
+}
+
+func (us *userStore) SetPasswordHash(ctx context.Context, email string, hash string) error {
+	ctx, end := startOperation(ctx, "setPasswordHash")
+	defer end()
+
+	result, err := us.userCollection.UpdateOne(
+		ctx,
+		bson.D{{Key: "email", Value: email}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "passwordhash", Value: hash}}}},
+		options.Update().SetCollation(emailCollation),
+	)
+	if err != nil {
+		return fmt.Errorf("userstore setPasswordHash: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("userstore setPasswordHash: %w", ErrUserNotFound)
+	}
+	return nil
+}
+
+func (us *userStore) SetPassword(ctx context.Context, email string, hash string, changedAt time.Time) error {
+	ctx, end := startOperation(ctx, "setPassword")
+	defer end()
+
+	result, err := us.userCollection.UpdateOne(
+		ctx,
+		bson.D{{Key: "email", Value: email}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "passwordhash", Value: hash},
+			{Key: "passwordchangedat", Value: changedAt},
+		}}},
+		options.Update().SetCollation(emailCollation),
+	)
+	if err != nil {
+		return fmt.Errorf("userstore setPassword: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("userstore setPassword: %w", ErrUserNotFound)
+	}
+	return nil
+}
+
+func (us *userStore) SetMFA(ctx context.Context, email string, enabled bool, mfa *MFA) error {
+	ctx, end := startOperation(ctx, "setMFA")
+	defer end()
+
+	result, err := us.userCollection.UpdateOne(
+		ctx,
+		bson.D{{Key: "email", Value: email}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "mfaenabled", Value: enabled},
+			{Key: "mfa", Value: mfa},
+		}}},
+		options.Update().SetCollation(emailCollation),
+	)
+	if err != nil {
+		return fmt.Errorf("userstore setMFA: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("userstore setMFA: %w", ErrUserNotFound)
+	}
+	return nil
+}
+
+func (us *userStore) SetEmailVerified(ctx context.Context, email string, at time.Time) error {
+	ctx, end := startOperation(ctx, "setEmailVerified")
+	defer end()
+
+	result, err := us.userCollection.UpdateOne(
+		ctx,
+		bson.D{{Key: "email", Value: email}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "emailverified", Value: true},
+			{Key: "emailverifiedat", Value: at},
+		}}},
+		options.Update().SetCollation(emailCollation),
+	)
+	if err != nil {
+		return fmt.Errorf("userstore setEmailVerified: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("userstore setEmailVerified: %w", ErrUserNotFound)
+	}
+	return nil
+}
+
+func (us *userStore) SetRoles(ctx context.Context, email string, roles []string) error {
+	ctx, end := startOperation(ctx, "setRoles")
+	defer end()
+
+	result, err := us.userCollection.UpdateOne(
+		ctx,
+		bson.D{{Key: "email", Value: email}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "roles", Value: roles}}}},
+		options.Update().SetCollation(emailCollation),
+	)
+	if err != nil {
+		return fmt.Errorf("userstore setRoles: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("userstore setRoles: %w", ErrUserNotFound)
+	}
+	return nil
+}
+
+func (us *userStore) HasRole(ctx context.Context, role string) (bool, error) {
+	ctx, end := startOperation(ctx, "hasRole")
+	defer end()
+
+	count, err := us.userCollection.CountDocuments(
+		ctx,
+		bson.D{{Key: "roles", Value: role}},
+		options.Count().SetLimit(1),
+	)
+	if err != nil {
+		return false, fmt.Errorf("userstore hasRole: %w", err)
+	}
+	return count > 0, nil
+}
+
+func (us *userStore) Delete(ctx context.Context, email string) error {
+	ctx, end := startOperation(ctx, "delete")
+	defer end()
+
+	result, err := us.userCollection.DeleteOne(
+		ctx,
+		bson.D{{Key: "email", Value: email}},
+		options.Delete().SetCollation(emailCollation),
+	)
+	if err != nil {
+		return fmt.Errorf("userstore delete: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("userstore delete: %w", ErrUserNotFound)
+	}
+	return nil
+}
+
+func (us *userStore) List(ctx context.Context, filter *ListFilter, after *ListCursor, limit int) ([]*User, error) {
+	ctx, end := startOperation(ctx, "list")
+	defer end()
+
+	query := bson.A{}
+	if filter.NamePrefix != "" {
+		pattern := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(filter.NamePrefix), Options: "i"}
+		query = append(query, bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "firstname", Value: pattern}},
+			bson.D{{Key: "lastname", Value: pattern}},
+		}}})
+	}
+	if filter.EmailPrefix != "" {
+		pattern := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(filter.EmailPrefix), Options: "i"}
+		query = append(query, bson.D{{Key: "email", Value: pattern}})
+	}
+	if filter.CreatedFrom != nil {
+		query = append(query, bson.D{{Key: "createdat", Value: bson.D{{Key: "$gte", Value: *filter.CreatedFrom}}}})
+	}
+	if filter.CreatedTo != nil {
+		query = append(query, bson.D{{Key: "createdat", Value: bson.D{{Key: "$lt", Value: *filter.CreatedTo}}}})
+	}
+
+	order, cmp := 1, "$gt"
+	if filter.Descending {
+		order, cmp = -1, "$lt"
+	}
+
+	if after != nil {
+		id, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, fmt.Errorf("userstore list: %w", ErrInvalidCursor)
+		}
+		query = append(query, bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "createdat", Value: bson.D{{Key: cmp, Value: after.CreatedAt}}}},
+			bson.D{
+				{Key: "createdat", Value: after.CreatedAt},
+				{Key: "_id", Value: bson.D{{Key: cmp, Value: id}}},
+			},
+		}}})
+	}
+
+	where := bson.D{}
+	if len(query) > 0 {
+		where = bson.D{{Key: "$and", Value: query}}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdat", Value: order}, {Key: "_id", Value: order}}).
+		SetLimit(int64(limit))
+
+	cursor, err := us.userCollection.Find(ctx, where, opts)
+	if err != nil {
+		return nil, fmt.Errorf("userstore list: %w", err)
+	}
+
+	list := make([]*User, 0, limit)
+	err = cursor.All(ctx, &list)
+	if err != nil {
+		return nil, fmt.Errorf("userstore list: %w", err)
+	}
+
+	return list, nil
+}
+
+// ensureIndexes creates all the indexes required by userStore, if they don't exist already
+func (us *userStore) ensureIndexes(ctx context.Context) error {
+	_, err := us.userCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().
+			SetName("email_unique").
+			SetUnique(true).
+			SetCollation(emailCollation),
+	})
+	if err != nil {
+		return fmt.Errorf("userstore ensureIndexes: %w", err)
+	}
+	return nil
+}
+
 func newStore(mongoClient *mongo.Client) (*userStore, error) {
 	return &userStore{
 		mongoClient:    mongoClient,
@@ -0,0 +1,340 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+)
+
+const (
+	// TokenStoreRedis keeps single use tokens in Redis, so that they're shared by all instances of the app
+	TokenStoreRedis = "redis"
+	// TokenStoreMemory keeps single use tokens in memory, it's meant for tests and local development
+	TokenStoreMemory = "memory"
+
+	// tokenBytes is the number of random bytes of single use tokens
+	tokenBytes = 32
+)
+
+var (
+	// ErrInvalidToken is returned for single use tokens which do not exist, have expired or have
+	// already been used. Callers cannot tell which is the case
+	ErrInvalidToken = errors.New("invalid or expired token")
+)
+
+// TokenStore keeps single use tokens, e.g. the ones sent by email to verify it. Only hashes of the
+// tokens are stored. Every subject, e.g. a user ID, has at most one outstanding token per purpose
+type TokenStore interface {
+	// Issue stores the token hash of subject until expiresAt, along with data. Any other token of
+	// subject with the same purpose is invalidated
+	Issue(ctx context.Context, purpose string, subject string, hash string, data string, expiresAt time.Time) error
+	// Consume returns the subject and data of the token hash, and deletes it so that it cannot be
+	// used again. ErrInvalidToken is returned if there's no such token
+	Consume(ctx context.Context, purpose string, hash string) (subject string, data string, err error)
+	// Revoke invalidates the outstanding token of subject, if any
+	Revoke(ctx context.Context, purpose string, subject string) error
+	// MarkUsed marks key, e.g. a one-time code, as used until expiresAt and reports if it was already
+	// used. It's atomic, so that a key can be used only once even with concurrent requests
+	MarkUsed(ctx context.Context, key string, expiresAt time.Time) (bool, error)
+}
+
+// newToken returns a new random token, and its hash which is the only thing stored
+func newToken() (string, string, error) {
+	raw := make([]byte, tokenBytes)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("newToken: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// storedToken is the value stored for every token
+type storedToken struct {
+	Subject string `json:"subject"`
+	Data    string `json:"data"`
+}
+
+var (
+	// issueScript replaces the outstanding token of a subject. KEYS[1] is the key of the subject,
+	// KEYS[2] the key of the new token, ARGV are the token hash, the value, the expiry in seconds
+	// and the prefix of token keys
+	issueScript = redis.NewScript(2, `
+local previous = redis.call('GET', KEYS[1])
+if previous then
+	redis.call('DEL', ARGV[4] .. previous)
+end
+redis.call('SET', KEYS[2], ARGV[2], 'EX', ARGV[3])
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[3])
+return 1
+`)
+	// consumeScript gets and deletes a token atomically, so that it's used only once even with
+	// concurrent requests. KEYS[1] is the key of the token, ARGV are the token hash and the prefix
+	// of subject keys
+	consumeScript = redis.NewScript(1, `
+local value = redis.call('GET', KEYS[1])
+if not value then
+	return false
+end
+redis.call('DEL', KEYS[1])
+local subjectKey = ARGV[2] .. cjson.decode(value).subject
+if redis.call('GET', subjectKey) == ARGV[1] then
+	redis.call('DEL', subjectKey)
+end
+return value
+`)
+	// revokeScript deletes the outstanding token of a subject. KEYS[1] is the key of the subject,
+	// ARGV[1] the prefix of token keys
+	revokeScript = redis.NewScript(1, `
+local hash = redis.call('GET', KEYS[1])
+if hash then
+	redis.call('DEL', ARGV[1] .. hash)
+	redis.call('DEL', KEYS[1])
+end
+return 1
+`)
+)
+
+type redisTokenStore struct {
+	cache *cachestore.Handle
+}
+
+func (rs *redisTokenStore) conn(ctx context.Context) (redis.Conn, error) {
+	pool := rs.cache.Pool()
+	if pool == nil {
+		return nil, cachestore.ErrCacheNotInitialized
+	}
+	return pool.GetContext(ctx)
+}
+
+// tokenKeyPrefix is the prefix of the keys of tokens with purpose, followed by the token hash
+func tokenKeyPrefix(purpose string) string {
+	return fmt.Sprintf("usertoken-%s-", purpose)
+}
+
+// subjectKeyPrefix is the prefix of the keys of subjects with purpose, followed by the subject. It
+// holds the hash of the outstanding token
+func subjectKeyPrefix(purpose string) string {
+	return fmt.Sprintf("usertoken-%s-subject-", purpose)
+}
+
+func (rs *redisTokenStore) Issue(
+	ctx context.Context,
+	purpose string,
+	subject string,
+	hash string,
+	data string,
+	expiresAt time.Time,
+) error {
+	ctx, span := startCacheOperation(ctx, "EVALSHA")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return fmt.Errorf("issue: %w", err)
+	}
+	defer conn.Close()
+
+	// it is safe to ignore error here because storedToken has no field which can cause the marshal to fail
+	value, _ := json.Marshal(&storedToken{Subject: subject, Data: data})
+	ttl := int64(time.Until(expiresAt) / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	_, err = issueScript.Do(
+		conn,
+		subjectKeyPrefix(purpose)+subject,
+		tokenKeyPrefix(purpose)+hash,
+		hash,
+		value,
+		ttl,
+		tokenKeyPrefix(purpose),
+	)
+	if err != nil {
+		return fmt.Errorf("issue: %w", err)
+	}
+	return nil
+}
+
+func (rs *redisTokenStore) Consume(ctx context.Context, purpose string, hash string) (string, string, error) {
+	ctx, span := startCacheOperation(ctx, "EVALSHA")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("consume: %w", err)
+	}
+	defer conn.Close()
+
+	value, err := redis.Bytes(consumeScript.Do(conn, tokenKeyPrefix(purpose)+hash, hash, subjectKeyPrefix(purpose)))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return "", "", fmt.Errorf("consume: %w", ErrInvalidToken)
+		}
+		return "", "", fmt.Errorf("consume: %w", err)
+	}
+
+	st := new(storedToken)
+	err = json.Unmarshal(value, st)
+	if err != nil {
+		return "", "", fmt.Errorf("consume: %w", err)
+	}
+	return st.Subject, st.Data, nil
+}
+
+func (rs *redisTokenStore) Revoke(ctx context.Context, purpose string, subject string) error {
+	ctx, span := startCacheOperation(ctx, "EVALSHA")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return fmt.Errorf("revoke: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = revokeScript.Do(conn, subjectKeyPrefix(purpose)+subject, tokenKeyPrefix(purpose))
+	if err != nil {
+		return fmt.Errorf("revoke: %w", err)
+	}
+	return nil
+}
+
+func (rs *redisTokenStore) MarkUsed(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	ctx, span := startCacheOperation(ctx, "SET")
+	defer span.End()
+
+	conn, err := rs.conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("markUsed: %w", err)
+	}
+	defer conn.Close()
+
+	ttl := int64(time.Until(expiresAt) / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	reply, err := conn.Do("SET", "usertoken-used-"+key, 1, "NX", "EX", ttl)
+	if err != nil {
+		return false, fmt.Errorf("markUsed: %w", err)
+	}
+	return reply == nil, nil
+}
+
+func newRedisTokenStore(cache *cachestore.Handle) *redisTokenStore {
+	if cache == nil {
+		cache = cachestore.NewHandle(nil)
+	}
+	return &redisTokenStore{cache: cache}
+}
+
+type memoryToken struct {
+	storedToken
+	hash      string
+	expiresAt time.Time
+}
+
+// MemoryTokenStore is a thread-safe, in-memory implementation of TokenStore. It's meant for tests
+// and local development, since tokens are not shared with other instances of the app
+type MemoryTokenStore struct {
+	mu sync.Mutex
+	// tokens are keyed by purpose and subject, since there's at most one token per subject
+	tokens map[string]*memoryToken
+	used   map[string]time.Time
+}
+
+func memoryTokenKey(purpose string, subject string) string {
+	return purpose + "-" + subject
+}
+
+// sweep removes all the expired tokens. It must be called with the lock held
+func (ms *MemoryTokenStore) sweep(now time.Time) {
+	for key, t := range ms.tokens {
+		if !now.Before(t.expiresAt) {
+			delete(ms.tokens, key)
+		}
+	}
+	for key, expiresAt := range ms.used {
+		if !now.Before(expiresAt) {
+			delete(ms.used, key)
+		}
+	}
+}
+
+func (ms *MemoryTokenStore) Issue(
+	ctx context.Context,
+	purpose string,
+	subject string,
+	hash string,
+	data string,
+	expiresAt time.Time,
+) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sweep(time.Now())
+	ms.tokens[memoryTokenKey(purpose, subject)] = &memoryToken{
+		storedToken: storedToken{Subject: subject, Data: data},
+		hash:        hash,
+		expiresAt:   expiresAt,
+	}
+	return nil
+}
+
+func (ms *MemoryTokenStore) Consume(ctx context.Context, purpose string, hash string) (string, string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sweep(time.Now())
+	for key, t := range ms.tokens {
+		if t.hash == hash && key == memoryTokenKey(purpose, t.Subject) {
+			delete(ms.tokens, key)
+			return t.Subject, t.Data, nil
+		}
+	}
+	return "", "", fmt.Errorf("memorytokenstore consume: %w", ErrInvalidToken)
+}
+
+func (ms *MemoryTokenStore) Revoke(ctx context.Context, purpose string, subject string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.tokens, memoryTokenKey(purpose, subject))
+	return nil
+}
+
+func (ms *MemoryTokenStore) MarkUsed(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sweep(time.Now())
+	if _, ok := ms.used[key]; ok {
+		return true, nil
+	}
+	ms.used[key] = expiresAt
+	return false, nil
+}
+
+// NewMemoryTokenStore returns a new, empty MemoryTokenStore
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: make(map[string]*memoryToken),
+		used:   make(map[string]time.Time),
+	}
+}
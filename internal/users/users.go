@@ -2,29 +2,158 @@ package users
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/gomodule/redigo/redis"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/jerryan999/goapp/internal/pkg/cachestore"
+	"github.com/jerryan999/goapp/internal/pkg/lockout"
 	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/pkg/mailer"
+	"github.com/jerryan999/goapp/internal/pkg/metrics"
+	"github.com/jerryan999/goapp/internal/pkg/password"
+	"github.com/jerryan999/goapp/internal/pkg/ratelimit"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+const (
+	// StoreMongo stores users in MongoDB
+	StoreMongo = "mongo"
+	// StoreMemory stores users in memory, it's meant for tests and local development
+	StoreMemory = "memory"
+	// CacheRedis caches users in Redis
+	CacheRedis = "redis"
+	// CacheMemory caches users in memory, it's meant for tests and local development
+	CacheMemory = "memory"
+
+	// indexTimeout is the maximum time allowed for creating indexes at startup
+	indexTimeout = time.Second * 30
+	// defaultCacheTTL is the expiry of cached users when none is configured
+	defaultCacheTTL = time.Hour
 )
 
 var (
-	ErrUserNotFound   = errors.New("user not found")
-	ErrUserValidation = errors.New("validation error")
+	cacheLookups = metrics.NewCounterVec(
+		"users_cache_lookups_total",
+		"Number of lookups of users in cache, partitioned by result (hit, miss, error or unavailable)",
+		"result",
+	)
 )
 
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrUserValidation    = errors.New("validation error")
+	ErrUserAlreadyExists = errors.New("user already exists")
+	// ErrInvalidCredentials is returned when either the user does not exist or the password does not
+	// match, so that callers cannot tell if a user exists
+	ErrInvalidCredentials = errors.New("invalid email or password")
+)
+
+// validationError is a validation error which carries the cause, e.g. a password policy error. It
+// matches ErrUserValidation with errors.Is, and the cause with errors.As
+type validationError struct {
+	cause error
+}
+
+func (ve *validationError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUserValidation.Error(), ve.cause.Error())
+}
+
+func (ve *validationError) Is(target error) bool {
+	return target == ErrUserValidation
+}
+
+func (ve *validationError) Unwrap() error {
+	return ve.cause
+}
+
+// Config holds all the configuration required for the users package
+type Config struct {
+	// Store is the primary datastore of users, either 'mongo' or 'memory'
+	Store string `json:"store"`
+	// Cache is the cache of users, either 'redis' or 'memory'
+	Cache          string `json:"cache"`
+	CacheTTLSecond int    `json:"cache_ttl_second"`
+	// BootstrapAdminEmail is the email of the user who's made the first admin, once they verified it,
+	// either at startup or when they verify it. Nobody is promoted once there's an admin. It should be
+	// the email of an operator, who signs up right after the first deployment
+	BootstrapAdminEmail string `json:"bootstrap_admin_email"`
+	// TokenStore is where single use tokens, e.g. the ones verifying emails, the counters of rate
+	// limits and the failed logins are kept, either 'redis' or 'memory'
+	TokenStore            string `json:"token_store"`
+	VerificationTTLSecond int    `json:"verification_ttl_second"`
+	// VerificationURL is the page which verifies emails, the token is added as the 'token' query
+	// parameter of the link in the email. The email only includes the token if it's empty
+	VerificationURL string `json:"verification_url"`
+	ResetTTLSecond  int    `json:"reset_ttl_second"`
+	// ResetURL is the page which resets passwords, the token is added like for VerificationURL
+	ResetURL string `json:"reset_url"`
+	// ResetLimitPerEmail and ResetLimitPerIP are the maximum number of password reset requests per
+	// email and per client IP, within every window of ResetLimitWindowSecond
+	ResetLimitPerEmail     int `json:"reset_limit_per_email"`
+	ResetLimitPerIP        int `json:"reset_limit_per_ip"`
+	ResetLimitWindowSecond int `json:"reset_limit_window_second"`
+	// MFAIssuer is the name authenticator apps show for the TOTP secrets of users
+	MFAIssuer string `json:"mfa_issuer"`
+	// MFARequiredForAdmins withholds the permissions of admins unless they logged in with a one-time
+	// code. Admins can still act on themselves, so that they can enroll
+	MFARequiredForAdmins bool `json:"mfa_required_for_admins"`
+	// LockoutMaxFailuresPerAccount and LockoutMaxFailuresPerIP are the number of failed logins,
+	// within every window of LockoutWindowSecond since the last one, after which the account or the
+	// client IP is locked out for LockoutDurationSecond. Failed one-time codes count as failed logins.
+	// Client IPs are not locked out if LockoutMaxFailuresPerIP is 0
+	LockoutMaxFailuresPerAccount int `json:"lockout_max_failures_per_account"`
+	LockoutMaxFailuresPerIP      int `json:"lockout_max_failures_per_ip"`
+	LockoutWindowSecond          int `json:"lockout_window_second"`
+	LockoutDurationSecond        int `json:"lockout_duration_second"`
+	// LockoutDelayAfter is the number of failed logins of an account after which every login has to
+	// wait, starting at a second and doubling up to LockoutMaxDelaySecond. 0 disables the delays
+	LockoutDelayAfter     int `json:"lockout_delay_after"`
+	LockoutMaxDelaySecond int `json:"lockout_max_delay_second"`
+	// MobileDefaultRegion is the ISO 3166-1 alpha-2 region of mobile numbers written without + and
+	// the country code, e.g. US. Mobile numbers are stored in the E.164 format
+	MobileDefaultRegion string `json:"mobile_default_region"`
+	// BlockDisposableEmails refuses signups and email changes to DisposableEmailDomains and their
+	// subdomains. Existing users are left alone
+	BlockDisposableEmails  bool     `json:"block_disposable_emails"`
+	DisposableEmailDomains []string `json:"disposable_email_domains"`
+}
+
 // User holds all data required to represent a user
 type User struct {
-	FirstName string     `json:"firstName,omitempty"`
-	LastName  string     `json:"lastName,omitempty"`
-	Mobile    string     `json:"mobile,omitempty"`
-	Email     string     `json:"email,omitempty"`
+	ID        string `json:"id,omitempty" bson:"_id,omitempty"`
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+	Mobile    string `json:"mobile,omitempty"`
+	Email     string `json:"email,omitempty"`
+	// Password is only accepted on signup, and is hashed right away. It's never stored, cached or
+	// returned
+	Password string `json:"password,omitempty" bson:"-"`
+	// PasswordHash is never serialized to JSON, so that it's neither returned nor cached
+	PasswordHash string `json:"-" bson:"passwordhash,omitempty"`
+	// PasswordChangedAt is set when the password is reset. Tokens and sessions issued earlier are
+	// rejected, see CredentialsChangedAfter
+	PasswordChangedAt *time.Time `json:"passwordChangedAt,omitempty"`
+	// Roles grant permissions on other users, see rolePermissions. They can only be changed using
+	// SetRoles
+	Roles []string `json:"roles,omitempty" bson:"roles,omitempty"`
+	// EmailVerified is set once the user proves they own the email, see VerifyEmail. It's reset
+	// whenever the email changes, and cannot be changed by updating the user
+	EmailVerified   bool       `json:"emailVerified"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+	// MFAEnabled is set once the user confirms their TOTP enrollment, after which password logins
+	// require a one-time code as well. It can only be changed using the MFA methods
+	MFAEnabled bool `json:"mfaEnabled"`
+	// MFA is never serialized to JSON, so that the TOTP secret and recovery codes are neither
+	// returned nor cached
+	MFA       *MFA       `json:"-" bson:"mfa,omitempty"`
 	CreatedAt *time.Time `json:"createdAt,omitempty"`
 	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
 }
@@ -48,77 +177,237 @@ func (u *User) Sanitize() {
 	u.Mobile = strings.TrimSpace(u.Mobile)
 }
 
-// Validate is used to validate the fields of User
-func (u *User) Validate() error {
-	if u.Email == "" {
-		return fmt.Errorf("validate:%w", ErrUserValidation)
-	}
-
-	err := validateEmail(u.Email)
-	if err != nil {
-		return err
-	}
-
-	return nil
+type Users struct {
+	logHandler logger.Logger
+	cachestore Cachestore
+	store      Store
+	passwords  *password.Hasher
+	tokens     TokenStore
+	limiter    ratelimit.Limiter
+	guard      lockout.Guard
+	mailer     mailer.Mailer
+	// bootstrapAdminEmail is the email of the user who's promoted to admin once they verify it, if
+	// there's no admin
+	bootstrapAdminEmail string
+	verificationTTL     time.Duration
+	verificationURL     string
+	resetTTL            time.Duration
+	resetURL            string
+	resetLimitPerEmail  int
+	resetLimitPerIP     int
+	resetLimitWindow    time.Duration
+	mfaIssuer           string
+	// mfaRequiredForAdmins withholds the permissions of admins who did not log in with a one-time code
+	mfaRequiredForAdmins bool
+	accountLockout       *lockout.Policy
+	ipLockout            *lockout.Policy
+	mobileDefaultRegion  string
+	// blockedDomains are the email domains refused on signup and email changes, nil if none are
+	blockedDomains map[string]struct{}
+	// background tracks the emails sent in the background, so that they can be awaited on shutdown
+	background sync.WaitGroup
 }
 
-func validateEmail(email string) error {
-	parts := strings.Split(email, "@")
-	if len(parts) != 2 {
-		return fmt.Errorf("validateEmail: %w, email: %s", ErrUserValidation, email)
-	}
-
-	return nil
+// log returns the logger with all the log fields of ctx
+func (us *Users) log(ctx context.Context) logger.Logger {
+	return us.logHandler.WithContext(ctx)
 }
 
-type Users struct {
-	logHandler logger.Logger
-	cachestore userCachestore
-	store      store
+// contextWithEmailHash adds a hash of email to the log fields of ctx, so that logs can be correlated
+// to a user without logging their email
+func contextWithEmailHash(ctx context.Context, email string) context.Context {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return logger.ContextWithFields(ctx, "userEmailHash", hex.EncodeToString(sum[:8]))
 }
 
 // CreateUser creates a new user
 func (us *Users) CreateUser(ctx context.Context, u *User) (*User, error) {
+	ctx, span := tracing.Start(ctx, "users.CreateUser")
+	defer span.End()
+
+	// the ID and timestamps are set by the server, so that clients cannot pick an ID which breaks
+	// cursors, or reorder the list of users
+	u.ID = ""
+	u.CreatedAt = nil
+	u.UpdatedAt = nil
+	u.PasswordChangedAt = nil
 	u.setDefaults()
 	u.Sanitize()
+	ctx = contextWithEmailHash(ctx, u.Email)
+
+	err := us.validateUser(u, "")
+	if err != nil {
+		if errors.Is(err, ErrUserValidation) {
+			us.log(ctx).Warn(err.Error())
+		} else {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
 
-	err := u.Validate()
+	err = us.setPassword(u)
 	if err != nil {
 		if errors.Is(err, ErrUserValidation) {
-			us.logHandler.Warn(err.Error())
+			us.log(ctx).Warn(err.Error())
 		} else {
-			us.logHandler.Error(err.Error())
+			us.log(ctx).Error(err.Error())
 		}
 		return nil, err
 	}
 
+	u.Roles, err = normalizeRoles(u.Roles)
+	if err != nil {
+		us.log(ctx).Warn(err.Error())
+		return nil, err
+	}
+	u.EmailVerified = false
+	u.EmailVerifiedAt = nil
+	u.MFAEnabled = false
+	u.MFA = nil
+
 	err = us.store.Create(ctx, u)
 	if err != nil {
-		us.logHandler.Error(err.Error())
+		if errors.Is(err, ErrUserAlreadyExists) {
+			us.log(ctx).Warn(err.Error())
+		} else {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+	// failing to send the verification email does not fail the signup, the user can ask for another one
+	err = us.sendVerification(ctx, u)
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+	}
+
+	return u, nil
+}
+
+// setPassword replaces the plain password of u, if any, with its hash. The password is validated
+// against the strength policy first
+func (us *Users) setPassword(u *User) error {
+	if u.Password == "" {
+		return nil
+	}
+
+	plain := u.Password
+	// the plain password is cleared before anything else, so that it cannot leak even on errors
+	u.Password = ""
+
+	err := us.passwords.Validate(plain, u.Email, u.FirstName, u.LastName)
+	if err != nil {
+		return &validationError{cause: err}
+	}
+
+	hash, err := us.passwords.Hash(plain)
+	if err != nil {
+		return fmt.Errorf("setPassword: %w", err)
+	}
+	u.PasswordHash = hash
+
+	return nil
+}
+
+// Authenticate returns the user identified by email if password matches theirs. Users are always
+// read from the primary datastore, since password hashes are not cached. ErrInvalidCredentials is
+// returned if the user does not exist, has no password or the password does not match. For users with
+// MFA enabled, a *MFAChallengeError is returned instead of the user, see CompleteMFAChallenge.
+// Failed logins are counted per account and per clientIP, if any, and a *lockout.LockedError is
+// returned without checking the password while either has to wait, see Config.LockoutDelayAfter
+func (us *Users) Authenticate(ctx context.Context, email string, plain string, clientIP string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "users.Authenticate")
+	defer span.End()
+
+	email = canonicalEmail(email)
+	ctx = contextWithEmailHash(ctx, email)
+
+	attempt, err := us.reserveLogin(ctx, email, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := us.store.ReadByEmail(ctx, email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		us.releaseLogin(ctx, attempt)
+		us.log(ctx).Error(err.Error())
 		return nil, err
 	}
+	if u == nil || u.PasswordHash == "" {
+		// a hash is verified anyway, so that the response time does not reveal if the user exists
+		_ = us.passwords.VerifyDummy(plain)
+		userID := ""
+		if u != nil {
+			userID = u.ID
+		}
+		us.failLogin(ctx, attempt, userID, "password")
+		return nil, fmt.Errorf("authenticate: %w", ErrInvalidCredentials)
+	}
+
+	needsRehash, err := us.passwords.Verify(plain, u.PasswordHash)
+	if err != nil {
+		if !errors.Is(err, password.ErrMismatch) {
+			us.log(ctx).Error(err.Error())
+		}
+		us.failLogin(ctx, attempt, u.ID, "password")
+		return nil, fmt.Errorf("authenticate: %w", ErrInvalidCredentials)
+	}
+
+	if needsRehash {
+		// the hash is upgraded to the current algorithm and parameters while the plain password is
+		// available. Failing to do so does not fail the login, it's retried on the next one
+		hash, err := us.passwords.Hash(plain)
+		if err == nil {
+			err = us.store.SetPasswordHash(ctx, u.Email, hash)
+		}
+		if err != nil {
+			us.log(ctx).Error(err.Error())
+		} else {
+			u.PasswordHash = hash
+			us.log(ctx).Info("password rehashed with the current parameters")
+		}
+	}
+
+	if u.MFAEnabled {
+		// the failed logins of the account are kept until the one-time code is verified as well
+		us.releaseLogin(ctx, attempt)
+		err = us.challengeMFA(ctx, u)
+		if !errors.Is(err, ErrMFARequired) {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+	us.succeedLogin(ctx, attempt)
 
 	return u, nil
 }
 
 // ReadByEmail returns a user which matches the given email
 func (us *Users) ReadByEmail(ctx context.Context, email string) (*User, error) {
-	email = strings.TrimSpace(email)
-	err := validateEmail(email)
+	ctx, span := tracing.Start(ctx, "users.ReadByEmail")
+	defer span.End()
+
+	ctx = contextWithEmailHash(ctx, email)
+	email, err := validateEmail(email)
 	if err != nil {
-		us.logHandler.Info("ReadByEmail: %s", err.Error())
+		us.log(ctx).Infof("ReadByEmail: %s", err.Error())
 		return nil, err
 	}
 
 	u, err := us.cachestore.ReadUserByEmail(ctx, email)
-	if err != nil &&
-		!errors.Is(err, cachestore.ErrCacheMiss) &&
-		!errors.Is(err, cachestore.ErrCacheNotInitialized) {
+	switch {
+	case err == nil:
+		cacheLookups.With("hit").Inc()
+		span.SetAttributes("cache.hit", true)
+		return u, nil
+	case errors.Is(err, cachestore.ErrCacheMiss):
+		cacheLookups.With("miss").Inc()
+	case errors.Is(err, cachestore.ErrCacheNotInitialized):
+		cacheLookups.With("unavailable").Inc()
+	default:
 		// caches are usually read-through, i.e. in case of error, just log and continue to fetch from
 		// primary datastore
-		us.logHandler.Error(err.Error())
-	} else if err == nil {
-		return u, nil
+		cacheLookups.With("error").Inc()
+		us.log(ctx).Error(err.Error())
 	}
 
 	u, err = us.store.ReadByEmail(ctx, email)
@@ -130,28 +419,357 @@ func (us *Users) ReadByEmail(ctx context.Context, email string) (*User, error) {
 	if err != nil {
 		// in case of error while storing in cache, it is only logged
 		// This behaviour as well as read-through cache behaviour depends on your business logic.
-		us.logHandler.Error(err.Error())
+		us.log(ctx).Error(err.Error())
 	}
 
 	return u, nil
 }
 
-// NewService initializes the Users struct with all its dependencies and returns a new instance
-// all dependencies of Users should be sent as arguments of NewService
-func NewService(l logger.Logger, m *mongo.Client, redispool *redis.Pool) (*Users, error) {
-	ustore, err := newStore(m)
+// ReadByID returns the user with id. Users are always read from the primary datastore, since the
+// cache is keyed by email
+func (us *Users) ReadByID(ctx context.Context, id string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "users.ReadByID")
+	defer span.End()
+
+	u, err := us.store.ReadByID(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// UpdateUser replaces all the editable fields of the user identified by email with the ones in u
+func (us *Users) UpdateUser(ctx context.Context, email string, u *User) (*User, error) {
+	ctx, span := tracing.Start(ctx, "users.UpdateUser")
+	defer span.End()
+
+	email = canonicalEmail(email)
+	ctx = contextWithEmailHash(ctx, email)
+	existing, err := us.store.ReadByEmail(ctx, email)
 	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+		}
 		return nil, err
 	}
 
-	cstore, err := newCacheStore(redispool)
+	u.ID = existing.ID
+	u.CreatedAt = existing.CreatedAt
+	u.UpdatedAt = nil
+
+	return us.update(ctx, email, u, existing)
+}
+
+// PatchUser updates the user identified by email by applying patch, a JSON Merge Patch (RFC 7396)
+// document, on the existing user
+func (us *Users) PatchUser(ctx context.Context, email string, patch []byte) (*User, error) {
+	ctx, span := tracing.Start(ctx, "users.PatchUser")
+	defer span.End()
+
+	email = canonicalEmail(email)
+	ctx = contextWithEmailHash(ctx, email)
+	existing, err := us.store.ReadByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+
+	// it is safe to ignore error here because User struct has no field which can cause the marshal to fail
+	current, _ := json.Marshal(existing)
+	patched, err := mergePatch(current, patch)
+	if err != nil {
+		us.log(ctx).Warn(err.Error())
+		return nil, fmt.Errorf("patchUser: %w", &validationError{cause: err})
+	}
+
+	u := new(User)
+	err = json.Unmarshal(patched, u)
+	if err != nil {
+		us.log(ctx).Warn(err.Error())
+		return nil, fmt.Errorf("patchUser: %w", &validationError{cause: err})
+	}
+
+	u.ID = existing.ID
+	u.CreatedAt = existing.CreatedAt
+	u.UpdatedAt = nil
+
+	return us.update(ctx, email, u, existing)
+}
+
+// update sets the profile of existing, the user identified by email, to that of u. Fields which are
+// not editable are copied from existing only to return the complete user: the store never writes
+// them, so that a concurrent password reset or MFA change is not undone
+func (us *Users) update(ctx context.Context, email string, u *User, existing *User) (*User, error) {
+	if u.Password != "" {
+		u.Password = ""
+		err := &validationError{cause: errors.New("password cannot be changed by updating the user")}
+		us.log(ctx).Warn(err.Error())
+		return nil, err
+	}
+	u.PasswordHash = existing.PasswordHash
+	u.PasswordChangedAt = existing.PasswordChangedAt
+	u.MFAEnabled = existing.MFAEnabled
+	u.MFA = existing.MFA
+
+	if u.Roles != nil && !sameRoles(u.Roles, existing.Roles) {
+		err := &validationError{cause: errors.New("roles cannot be changed by updating the user")}
+		us.log(ctx).Warn(err.Error())
+		return nil, err
+	}
+	u.Roles = existing.Roles
+
+	u.setDefaults()
+	u.Sanitize()
+
+	err := us.validateUser(u, existing.Email)
+	if err != nil {
+		us.log(ctx).Warn(err.Error())
+		return nil, err
+	}
+
+	emailChanged := !strings.EqualFold(u.Email, canonicalEmail(existing.Email))
+	if emailChanged {
+		u.EmailVerified = false
+		u.EmailVerifiedAt = nil
+	} else {
+		u.EmailVerified = existing.EmailVerified
+		u.EmailVerifiedAt = existing.EmailVerifiedAt
+	}
+
+	err = us.store.Update(ctx, email, u)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) && !errors.Is(err, ErrUserAlreadyExists) {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+
+	us.invalidateCache(ctx, email, u.Email)
+
+	if emailChanged {
+		// the new email has to be verified as well. Tokens sent to the previous email are invalid
+		// already, since they carry it
+		err = us.sendVerification(ctx, u)
+		if err != nil {
+			us.log(ctx).Error(err.Error())
+		}
+	}
+
+	return u, nil
+}
+
+// DeleteUser deletes the user identified by email
+func (us *Users) DeleteUser(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "users.DeleteUser")
+	defer span.End()
+
+	email = canonicalEmail(email)
+	ctx = contextWithEmailHash(ctx, email)
+	err := us.store.Delete(ctx, email)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+		}
+		return err
+	}
+
+	us.invalidateCache(ctx, email)
+
+	return nil
+}
+
+// invalidateCache removes the cached users of all the given emails, so that subsequent reads are
+// served from the primary datastore
+func (us *Users) invalidateCache(ctx context.Context, emails ...string) {
+	for _, email := range emails {
+		err := us.cachestore.DeleteUser(ctx, email)
+		if err != nil && !errors.Is(err, cachestore.ErrCacheNotInitialized) {
+			us.log(ctx).Error(err.Error())
+		}
+	}
+}
+
+// ttlSetter is implemented by the caches whose TTL can be changed while the app is running
+type ttlSetter interface {
+	SetTTL(ttl time.Duration)
+}
+
+// SetCacheTTL sets the expiry of the users cached from now on. Users which are already cached
+// keep their expiry. It does nothing if the cache does not support changing the TTL
+func (us *Users) SetCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	if ts, ok := us.cachestore.(ttlSetter); ok {
+		ts.SetTTL(ttl)
+	}
+}
+
+// New returns a new instance of Users which uses the given store, cache, token store, limiter and
+// lockout guard. It's useful when they're not the default ones, e.g. the in-memory implementations in
+// tests
+func New(
+	cfg *Config,
+	l logger.Logger,
+	s Store,
+	c Cachestore,
+	t TokenStore,
+	rl ratelimit.Limiter,
+	g lockout.Guard,
+	h *password.Hasher,
+	m mailer.Mailer,
+) (*Users, error) {
+	if s == nil {
+		return nil, errors.New("users: store is required")
+	}
+	if c == nil {
+		return nil, errors.New("users: cachestore is required")
+	}
+	if t == nil {
+		return nil, errors.New("users: token store is required")
+	}
+	if rl == nil {
+		return nil, errors.New("users: rate limiter is required")
+	}
+	if g == nil {
+		return nil, errors.New("users: lockout guard is required")
+	}
+	if h == nil {
+		return nil, errors.New("users: password hasher is required")
+	}
+	if m == nil {
+		return nil, errors.New("users: mailer is required")
+	}
+
+	verificationTTL := time.Duration(cfg.VerificationTTLSecond) * time.Second
+	if verificationTTL <= 0 {
+		verificationTTL = defaultVerificationTTL
+	}
+	resetTTL := time.Duration(cfg.ResetTTLSecond) * time.Second
+	if resetTTL <= 0 {
+		resetTTL = defaultResetTTL
+	}
+	resetLimitWindow := time.Duration(cfg.ResetLimitWindowSecond) * time.Second
+	if resetLimitWindow <= 0 {
+		resetLimitWindow = defaultResetLimitWindow
+	}
+	mfaIssuer := strings.TrimSpace(cfg.MFAIssuer)
+	if mfaIssuer == "" {
+		mfaIssuer = defaultMFAIssuer
+	}
+	accountLockout, ipLockout := lockoutPolicies(cfg)
+	mobileDefaultRegion := strings.ToUpper(strings.TrimSpace(cfg.MobileDefaultRegion))
+	if mobileDefaultRegion == "" {
+		mobileDefaultRegion = defaultMobileRegion
+	}
+	blocked, err := blockedDomains(cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Users{
 		logHandler: l,
-		cachestore: cstore,
-		store:      ustore,
+		cachestore: c,
+		store:      s,
+		passwords:  h,
+		tokens:     t,
+		limiter:    rl,
+		guard:      g,
+		mailer:     m,
+
+		bootstrapAdminEmail: canonicalEmail(cfg.BootstrapAdminEmail),
+		verificationTTL:     verificationTTL,
+		verificationURL:     strings.TrimSpace(cfg.VerificationURL),
+		resetTTL:            resetTTL,
+		resetURL:            strings.TrimSpace(cfg.ResetURL),
+		resetLimitPerEmail:  cfg.ResetLimitPerEmail,
+		resetLimitPerIP:     cfg.ResetLimitPerIP,
+		resetLimitWindow:    resetLimitWindow,
+		mfaIssuer:           mfaIssuer,
+
+		mfaRequiredForAdmins: cfg.MFARequiredForAdmins,
+		accountLockout:       accountLockout,
+		ipLockout:            ipLockout,
+		mobileDefaultRegion:  mobileDefaultRegion,
+		blockedDomains:       blocked,
 	}, nil
 }
+
+// NewService initializes the Users struct with all its dependencies and returns a new instance
+// all dependencies of Users should be sent as arguments of NewService. The mongo client or the redis
+// cache are not required when the respective in-memory implementation is configured
+func NewService(
+	cfg *Config,
+	l logger.Logger,
+	m *mongo.Client,
+	cache *cachestore.Handle,
+	h *password.Hasher,
+	mail mailer.Mailer,
+) (*Users, error) {
+	var (
+		ustore Store
+		cstore Cachestore
+		tstore TokenStore
+		rl     ratelimit.Limiter
+		guard  lockout.Guard
+	)
+
+	switch cfg.Store {
+	case StoreMemory:
+		ustore = NewMemoryStore()
+	case StoreMongo, "":
+		mstore, err := newStore(m)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+		defer cancel()
+		err = mstore.ensureIndexes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ustore = mstore
+	default:
+		return nil, fmt.Errorf("users: unknown store '%s'", cfg.Store)
+	}
+
+	ttl := time.Duration(cfg.CacheTTLSecond) * time.Second
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	switch cfg.Cache {
+	case CacheMemory:
+		cstore = NewMemoryCache(ttl)
+	case CacheRedis, "":
+		rcache, err := newCacheStore(cache, ttl)
+		if err != nil {
+			return nil, err
+		}
+		cstore = rcache
+	default:
+		return nil, fmt.Errorf("users: unknown cache '%s'", cfg.Cache)
+	}
+
+	switch cfg.TokenStore {
+	case TokenStoreMemory:
+		tstore = NewMemoryTokenStore()
+		rl = ratelimit.NewMemoryLimiter()
+		guard = lockout.NewMemoryGuard()
+	case TokenStoreRedis, "":
+		tstore = newRedisTokenStore(cache)
+		rl = ratelimit.NewRedisLimiter(cache, "ratelimit-users-reset-")
+		guard = lockout.NewRedisGuard(cache, "lockout-users-")
+	default:
+		return nil, fmt.Errorf("users: unknown token store '%s'", cfg.TokenStore)
+	}
+
+	return New(cfg, l, ustore, cstore, tstore, rl, guard, h, mail)
+}
@@ -0,0 +1,392 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/lockout"
+	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/pkg/mailer"
+	"github.com/jerryan999/goapp/internal/pkg/password"
+	"github.com/jerryan999/goapp/internal/pkg/ratelimit"
+)
+
+const testPassword = "correct horse battery"
+
+// recordingMailer keeps all the messages sent, so that tests can read the tokens they carry
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []*mailer.Message
+}
+
+func (rm *recordingMailer) Send(ctx context.Context, msg *mailer.Message) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.messages = append(rm.messages, msg)
+	return nil
+}
+
+// token returns the token of the last message sent to email, which is on the line starting with prefix
+func (rm *recordingMailer) token(t *testing.T, email string, prefix string) string {
+	t.Helper()
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for i := len(rm.messages) - 1; i >= 0; i-- {
+		if rm.messages[i].To != email {
+			continue
+		}
+		for _, line := range strings.Split(rm.messages[i].Body, "\n") {
+			if strings.HasPrefix(line, prefix) {
+				return strings.TrimSpace(strings.TrimPrefix(line, prefix))
+			}
+		}
+	}
+	t.Fatalf("no message with %q sent to %s", prefix, email)
+	return ""
+}
+
+type testEnv struct {
+	us    *Users
+	store *MemoryStore
+	cache *MemoryCache
+	mail  *recordingMailer
+}
+
+// newTestUsers returns Users with the in-memory implementations of all its dependencies, and cheap
+// password hashing so that tests are fast
+func newTestUsers(t *testing.T, cfg *Config) *testEnv {
+	t.Helper()
+
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	l := logger.New("goapp", "test", 1)
+	l.SetOutput(io.Discard)
+	h, err := password.NewService(&password.Config{
+		Argon2MemoryKiB:   64,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+	})
+	if err != nil {
+		t.Fatalf("password.NewService: %v", err)
+	}
+
+	env := &testEnv{
+		store: NewMemoryStore(),
+		cache: NewMemoryCache(time.Minute),
+		mail:  new(recordingMailer),
+	}
+	env.us, err = New(
+		cfg,
+		l,
+		env.store,
+		env.cache,
+		NewMemoryTokenStore(),
+		ratelimit.NewMemoryLimiter(),
+		lockout.NewMemoryGuard(),
+		h,
+		env.mail,
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return env
+}
+
+// createUser creates a user with email and the test password
+func (env *testEnv) createUser(t *testing.T, email string) *User {
+	t.Helper()
+
+	u, err := env.us.CreateUser(context.Background(), &User{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     email,
+		Password:  testPassword,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return u
+}
+
+func TestCreateUser(t *testing.T) {
+	spoofed := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		user      *User
+		wantErr   error
+		wantField string
+		check     func(t *testing.T, u *User)
+	}{
+		{
+			name: "valid",
+			user: &User{FirstName: " Jane ", Email: " Jane@Example.COM ", Mobile: "(202) 555-0143", Password: testPassword},
+			check: func(t *testing.T, u *User) {
+				if u.ID == "" || u.FirstName != "Jane" || u.Email != "Jane@example.com" || u.Mobile != "+12025550143" {
+					t.Errorf("user = %+v, want an ID, and the fields sanitized and normalized", u)
+				}
+				if u.Password != "" || !strings.HasPrefix(u.PasswordHash, "$argon2id$") {
+					t.Error("the password is not replaced by its hash")
+				}
+				if len(u.Roles) != 1 || u.Roles[0] != RoleUser || u.EmailVerified {
+					t.Errorf("roles = %v, verified = %v, want an unverified user", u.Roles, u.EmailVerified)
+				}
+			},
+		},
+		{
+			name: "server set fields",
+			user: &User{
+				ID:                "5f1d7f3b0000000000000000",
+				Email:             "jane@example.com",
+				CreatedAt:         &spoofed,
+				UpdatedAt:         &spoofed,
+				PasswordChangedAt: &spoofed,
+				EmailVerified:     true,
+				MFAEnabled:        true,
+			},
+			check: func(t *testing.T, u *User) {
+				if u.ID == "5f1d7f3b0000000000000000" || u.CreatedAt.Equal(spoofed) || u.UpdatedAt.Equal(spoofed) {
+					t.Errorf("user = %+v, want the ID and timestamps set by the server", u)
+				}
+				if u.PasswordChangedAt != nil || u.EmailVerified || u.MFAEnabled {
+					t.Errorf("user = %+v, want the credentials state to be reset", u)
+				}
+			},
+		},
+		{name: "missing email", user: &User{FirstName: "Jane"}, wantErr: ErrUserValidation, wantField: "email"},
+		{name: "invalid mobile", user: &User{Email: "jane@example.com", Mobile: "12"}, wantErr: ErrUserValidation, wantField: "mobile"},
+		{name: "weak password", user: &User{Email: "jane@example.com", Password: "short"}, wantErr: ErrUserValidation},
+		{name: "unknown role", user: &User{Email: "jane@example.com", Roles: []string{"root"}}, wantErr: ErrUserValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestUsers(t, nil)
+
+			u, err := env.us.CreateUser(context.Background(), tt.user)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateUser error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantField != "" {
+				var errs ValidationErrors
+				if !errors.As(err, &errs) || !errs.has(tt.wantField) {
+					t.Errorf("CreateUser error = %v, want an error of %s", err, tt.wantField)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, u)
+			}
+		})
+	}
+}
+
+func TestReadByEmail(t *testing.T) {
+	env := newTestUsers(t, nil)
+	created := env.createUser(t, "jane@example.com")
+
+	tests := []struct {
+		name    string
+		email   string
+		wantErr error
+	}{
+		{name: "same email", email: "jane@example.com"},
+		{name: "other case of the domain", email: " jane@EXAMPLE.com "},
+		{name: "unknown", email: "john@example.com", wantErr: ErrUserNotFound},
+		{name: "invalid", email: "jane", wantErr: ErrUserValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := env.us.ReadByEmail(context.Background(), tt.email)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadByEmail error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && u.ID != created.ID {
+				t.Errorf("ReadByEmail ID = %s, want %s", u.ID, created.ID)
+			}
+		})
+	}
+}
+
+func TestReadByID(t *testing.T) {
+	env := newTestUsers(t, nil)
+	created := env.createUser(t, "jane@example.com")
+
+	u, err := env.us.ReadByID(context.Background(), created.ID)
+	if err != nil {
+		t.Fatalf("ReadByID: %v", err)
+	}
+	if u.Email != created.Email {
+		t.Errorf("ReadByID email = %s, want %s", u.Email, created.Email)
+	}
+
+	_, err = env.us.ReadByID(context.Background(), "unknown")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("ReadByID error = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        string
+		update       *User
+		wantErr      error
+		wantVerified bool
+	}{
+		{name: "names", email: "jane@example.com", update: &User{FirstName: "Janet", Email: "jane@example.com"}, wantVerified: true},
+		{name: "email", email: "jane@example.com", update: &User{FirstName: "Jane", Email: "janet@example.com"}},
+		{name: "email taken", email: "jane@example.com", update: &User{Email: "john@example.com"}, wantErr: ErrUserAlreadyExists},
+		{name: "password", email: "jane@example.com", update: &User{Email: "jane@example.com", Password: "another password"}, wantErr: ErrUserValidation},
+		{name: "roles", email: "jane@example.com", update: &User{Email: "jane@example.com", Roles: []string{RoleAdmin}}, wantErr: ErrUserValidation},
+		{name: "same roles", email: "jane@example.com", update: &User{Email: "jane@example.com", Roles: []string{RoleUser}}, wantVerified: true},
+		{name: "invalid email", email: "jane@example.com", update: &User{Email: "jane"}, wantErr: ErrUserValidation},
+		{name: "unknown", email: "nobody@example.com", update: &User{Email: "nobody@example.com"}, wantErr: ErrUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestUsers(t, nil)
+			created := env.createUser(t, "jane@example.com")
+			env.createUser(t, "john@example.com")
+			_, err := env.us.VerifyEmail(ctx, env.mail.token(t, "jane@example.com", "Verification token:"))
+			if err != nil {
+				t.Fatalf("VerifyEmail: %v", err)
+			}
+			// the user is cached, so that the update has to invalidate it
+			_, _ = env.us.ReadByEmail(ctx, tt.email)
+
+			u, err := env.us.UpdateUser(ctx, tt.email, tt.update)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateUser error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if u.ID != created.ID || !u.CreatedAt.Equal(*created.CreatedAt) || u.PasswordHash != created.PasswordHash {
+				t.Errorf("user = %+v, want the ID, creation time and password of the existing user", u)
+			}
+			if u.EmailVerified != tt.wantVerified {
+				t.Errorf("EmailVerified = %v, want %v", u.EmailVerified, tt.wantVerified)
+			}
+
+			read, err := env.us.ReadByEmail(ctx, tt.update.Email)
+			if err != nil {
+				t.Fatalf("ReadByEmail: %v", err)
+			}
+			if read.FirstName != tt.update.FirstName {
+				t.Errorf("ReadByEmail first name = %q, want %q", read.FirstName, tt.update.FirstName)
+			}
+			if tt.update.Email != tt.email {
+				_, err = env.us.ReadByEmail(ctx, tt.email)
+				if !errors.Is(err, ErrUserNotFound) {
+					t.Errorf("ReadByEmail of the previous email error = %v, want %v", err, ErrUserNotFound)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateKeepsCredentials(t *testing.T) {
+	ctx := context.Background()
+	env := newTestUsers(t, nil)
+	env.createUser(t, "jane@example.com")
+
+	// the user is read before a password reset, and written after it
+	stale, err := env.store.ReadByEmail(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("ReadByEmail: %v", err)
+	}
+	changedAt := time.Now()
+	err = env.store.SetPassword(ctx, "jane@example.com", "new hash", changedAt)
+	if err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	stale.FirstName = "Janet"
+	err = env.store.Update(ctx, "jane@example.com", stale)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	u, err := env.store.ReadByEmail(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("ReadByEmail: %v", err)
+	}
+	if u.FirstName != "Janet" {
+		t.Errorf("first name = %s, want Janet", u.FirstName)
+	}
+	if u.PasswordHash != "new hash" || u.PasswordChangedAt == nil || !u.PasswordChangedAt.Equal(changedAt) {
+		t.Errorf("password = %s changed at %v, want the reset kept", u.PasswordHash, u.PasswordChangedAt)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	env := newTestUsers(t, nil)
+	env.createUser(t, "jane@example.com")
+	_, _ = env.us.ReadByEmail(ctx, "jane@example.com")
+
+	err := env.us.DeleteUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	// the cached user is deleted as well
+	_, err = env.us.ReadByEmail(ctx, "jane@example.com")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("ReadByEmail after DeleteUser error = %v, want %v", err, ErrUserNotFound)
+	}
+
+	err = env.us.DeleteUser(ctx, "jane@example.com")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("DeleteUser of a deleted user error = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestCreateUserAlreadyExists(t *testing.T) {
+	env := newTestUsers(t, nil)
+	env.createUser(t, "jane@example.com")
+
+	_, err := env.us.CreateUser(context.Background(), &User{Email: "JANE@example.com"})
+	if !errors.Is(err, ErrUserAlreadyExists) {
+		t.Errorf("CreateUser error = %v, want %v", err, ErrUserAlreadyExists)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		wantErr  error
+	}{
+		{name: "valid", email: "jane@example.com", password: testPassword},
+		{name: "other case of the domain", email: "jane@EXAMPLE.COM", password: testPassword},
+		{name: "wrong password", email: "jane@example.com", password: "wrong password", wantErr: ErrInvalidCredentials},
+		{name: "unknown user", email: "john@example.com", password: testPassword, wantErr: ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestUsers(t, nil)
+			created := env.createUser(t, "jane@example.com")
+
+			u, err := env.us.Authenticate(context.Background(), tt.email, tt.password, "192.0.2.1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && u.ID != created.ID {
+				t.Errorf("Authenticate ID = %s, want %s", u.ID, created.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package users
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jerryan999/goapp/internal/pkg/contact"
+)
+
+const (
+	// ValidationRequired is the code of fields which are required but missing
+	ValidationRequired = "required"
+	// ValidationInvalid is the code of fields with a value which is not valid
+	ValidationInvalid = "invalid"
+	// ValidationBlocked is the code of fields with a valid value which is not allowed, e.g. an email
+	// of a disposable domain
+	ValidationBlocked = "blocked"
+
+	// defaultMobileRegion is the region of mobile numbers without a country code when none is configured
+	defaultMobileRegion = "US"
+)
+
+// FieldError is the validation error of a single field of a user
+type FieldError struct {
+	// Field is the JSON name of the field, e.g. mobile
+	Field string `json:"field"`
+	// Code is one of the Validation* codes, so that clients can handle errors without parsing messages
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RejectedValue is the value of the field as it was received, if it's safe to return it. It's not
+	// part of the error message, so that it's not logged
+	RejectedValue interface{} `json:"rejectedValue,omitempty"`
+}
+
+func (fe *FieldError) Error() string {
+	return fe.Field + ": " + fe.Message
+}
+
+// ValidationErrors are the errors of all the invalid fields of a user. It matches ErrUserValidation
+// with errors.Is, and can be extracted with errors.As to tell users what to fix
+type ValidationErrors []*FieldError
+
+func (ve ValidationErrors) Error() string {
+	messages := make([]string, 0, len(ve))
+	for _, fe := range ve {
+		messages = append(messages, fe.Error())
+	}
+	return fmt.Sprintf("%s: %s", ErrUserValidation.Error(), strings.Join(messages, "; "))
+}
+
+func (ve ValidationErrors) Is(target error) bool {
+	return target == ErrUserValidation
+}
+
+// has reports if there's an error for field
+func (ve ValidationErrors) has(field string) bool {
+	for _, fe := range ve {
+		if fe.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// err returns ve as an error, nil if there's no error
+func (ve ValidationErrors) err() error {
+	if len(ve) == 0 {
+		return nil
+	}
+	return ve
+}
+
+// normalizeEmail returns the canonical form of email, see contact.NormalizeEmail, or its field error
+func normalizeEmail(email string) (string, *FieldError) {
+	if email == "" {
+		return "", &FieldError{Field: "email", Code: ValidationRequired, Message: "email is required"}
+	}
+	normalized, err := contact.NormalizeEmail(email)
+	if err != nil {
+		return "", &FieldError{Field: "email", Code: ValidationInvalid, Message: err.Error(), RejectedValue: email}
+	}
+	return normalized, nil
+}
+
+// validateEmail returns the canonical form of email, or ValidationErrors if it's not valid
+func validateEmail(email string) (string, error) {
+	normalized, fe := normalizeEmail(strings.TrimSpace(email))
+	if fe != nil {
+		return "", ValidationErrors{fe}
+	}
+	return normalized, nil
+}
+
+// canonicalEmail returns the canonical form of email, so that users are looked up the way they're
+// stored. Invalid emails are only trimmed, since there cannot be a user with them anyway
+func canonicalEmail(email string) string {
+	email = strings.TrimSpace(email)
+	normalized, fe := normalizeEmail(email)
+	if fe != nil {
+		return email
+	}
+	return normalized
+}
+
+// validate returns the errors of all the fields of u, and normalizes the valid email and mobile
+func (u *User) validate(defaultRegion string) ValidationErrors {
+	var errs ValidationErrors
+
+	email, fe := normalizeEmail(u.Email)
+	if fe != nil {
+		errs = append(errs, fe)
+	} else {
+		u.Email = email
+	}
+
+	if u.Mobile != "" {
+		mobile, err := contact.NormalizePhone(u.Mobile, defaultRegion)
+		if err != nil {
+			errs = append(errs, &FieldError{
+				Field:         "mobile",
+				Code:          ValidationInvalid,
+				Message:       err.Error(),
+				RejectedValue: u.Mobile,
+			})
+		} else {
+			u.Mobile = mobile
+		}
+	}
+
+	return errs
+}
+
+// Validate returns ValidationErrors with all the invalid fields of u. The email and the mobile are
+// normalized if they're valid, see contact.NormalizeEmail and contact.NormalizePhone. Mobile numbers
+// without a country code are numbers of defaultRegion
+func (u *User) Validate(defaultRegion string) error {
+	return u.validate(defaultRegion).err()
+}
+
+// validateUser validates u with the configured region, and refuses blocked email domains unless the
+// email is previousEmail, so that users with an email blocked later on can still update their profile
+func (us *Users) validateUser(u *User, previousEmail string) error {
+	errs := u.validate(us.mobileDefaultRegion)
+	if errs.has("email") || strings.EqualFold(u.Email, canonicalEmail(previousEmail)) {
+		return errs.err()
+	}
+
+	if contact.DomainIn(contact.EmailDomain(u.Email), us.blockedDomains) {
+		errs = append(errs, &FieldError{
+			Field:         "email",
+			Code:          ValidationBlocked,
+			Message:       "disposable email addresses are not allowed",
+			RejectedValue: u.Email,
+		})
+	}
+	return errs.err()
+}
+
+// blockedDomains returns the normalized email domains refused by cfg, nil if none are
+func blockedDomains(cfg *Config) (map[string]struct{}, error) {
+	if !cfg.BlockDisposableEmails {
+		return nil, nil
+	}
+
+	blocked := make(map[string]struct{}, len(cfg.DisposableEmailDomains))
+	for _, domain := range cfg.DisposableEmailDomains {
+		normalized, err := contact.NormalizeDomain(strings.TrimSpace(domain))
+		if err != nil {
+			return nil, fmt.Errorf("users: disposable email domain '%s': %w", domain, err)
+		}
+		blocked[normalized] = struct{}{}
+	}
+	return blocked, nil
+}
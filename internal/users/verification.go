@@ -0,0 +1,161 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jerryan999/goapp/internal/pkg/mailer"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
+)
+
+const (
+	// purposeVerification is the purpose of the tokens sent to verify emails
+	purposeVerification = "verify"
+	// defaultVerificationTTL is the expiry of verification tokens when none is configured
+	defaultVerificationTTL = time.Hour * 24
+)
+
+var (
+	// ErrEmailAlreadyVerified is returned when asking to verify an email which is verified already
+	ErrEmailAlreadyVerified = errors.New("email already verified")
+)
+
+// verificationMessage returns the email which asks the owner of email to verify it using token
+func (us *Users) verificationMessage(email string, token string) *mailer.Message {
+	body := new(strings.Builder)
+	body.WriteString("Please confirm that this is your email address.\n\n")
+	if us.verificationURL != "" {
+		link := us.verificationURL
+		sep := "?"
+		if strings.Contains(link, "?") {
+			sep = "&"
+		}
+		fmt.Fprintf(body, "Open the following link to verify it:\n%s%stoken=%s\n\n", link, sep, url.QueryEscape(token))
+	}
+	fmt.Fprintf(body, "Verification token: %s\n\n", token)
+	fmt.Fprintf(body, "The token expires in %s. If you did not sign up, you can ignore this email.\n", us.verificationTTL)
+
+	return &mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body:    body.String(),
+	}
+}
+
+// SendVerification sends a new verification token to the email of the user identified by email,
+// which invalidates all the tokens sent earlier
+func (us *Users) SendVerification(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "users.SendVerification")
+	defer span.End()
+
+	email = canonicalEmail(email)
+	ctx = contextWithEmailHash(ctx, email)
+
+	u, err := us.store.ReadByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			us.log(ctx).Error(err.Error())
+		}
+		return err
+	}
+	if u.EmailVerified {
+		return fmt.Errorf("sendVerification: %w", ErrEmailAlreadyVerified)
+	}
+
+	err = us.sendVerification(ctx, u)
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// sendVerification issues a verification token for the current email of u and sends it. The token
+// carries the email, so that it cannot verify another email if the user changes theirs
+func (us *Users) sendVerification(ctx context.Context, u *User) error {
+	token, hash, err := newToken()
+	if err != nil {
+		return fmt.Errorf("sendVerification: %w", err)
+	}
+
+	err = us.tokens.Issue(ctx, purposeVerification, u.ID, hash, strings.ToLower(u.Email), time.Now().Add(us.verificationTTL))
+	if err != nil {
+		return fmt.Errorf("sendVerification: %w", err)
+	}
+
+	err = us.mailer.Send(ctx, us.verificationMessage(u.Email, token))
+	if err != nil {
+		return fmt.Errorf("sendVerification: %w", err)
+	}
+	us.log(ctx).With("event", "email_verification_sent", "userID", u.ID).Info("verification email sent")
+
+	return nil
+}
+
+// VerifyEmail marks the email of the user who was sent token as verified. The token can be used only
+// once. ErrInvalidToken is returned if the token is unknown, expired, used, or if the user changed
+// their email since it was sent
+func (us *Users) VerifyEmail(ctx context.Context, token string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "users.VerifyEmail")
+	defer span.End()
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, fmt.Errorf("verifyEmail: %w", ErrInvalidToken)
+	}
+
+	userID, email, err := us.tokens.Consume(ctx, purposeVerification, hashToken(token))
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			us.log(ctx).Warn(err.Error())
+		} else {
+			us.log(ctx).Error(err.Error())
+		}
+		return nil, err
+	}
+
+	ctx = contextWithEmailHash(ctx, email)
+	u, err := us.store.ReadByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, fmt.Errorf("verifyEmail: %w", ErrInvalidToken)
+		}
+		us.log(ctx).Error(err.Error())
+		return nil, err
+	}
+	if u.ID != userID {
+		// the email was given to another user since the token was sent
+		return nil, fmt.Errorf("verifyEmail: %w", ErrInvalidToken)
+	}
+	if u.EmailVerified {
+		return u, nil
+	}
+
+	now := time.Now()
+	err = us.store.SetEmailVerified(ctx, u.Email, now)
+	if err != nil {
+		us.log(ctx).Error(err.Error())
+		return nil, err
+	}
+	us.invalidateCache(ctx, u.Email)
+	u.EmailVerified = true
+	u.EmailVerifiedAt = &now
+	us.log(ctx).With("event", "email_verified", "userID", u.ID).Info("email verified")
+
+	if strings.EqualFold(u.Email, us.bootstrapAdminEmail) {
+		// failing to promote the bootstrap admin does not fail the verification, it's retried on the
+		// next startup
+		admin, err := us.BootstrapAdmin(ctx)
+		if err != nil {
+			us.log(ctx).Error(err.Error())
+		} else if admin != nil {
+			u = admin
+		}
+	}
+
+	return u, nil
+}
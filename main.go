@@ -1,56 +1,135 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
 	"github.com/jerryan999/goapp/internal/api"
+	"github.com/jerryan999/goapp/internal/apikeys"
+	"github.com/jerryan999/goapp/internal/auth"
 	"github.com/jerryan999/goapp/internal/configs"
 	"github.com/jerryan999/goapp/internal/pkg/cachestore"
 	"github.com/jerryan999/goapp/internal/pkg/datastore"
 	"github.com/jerryan999/goapp/internal/pkg/logger"
+	"github.com/jerryan999/goapp/internal/pkg/mailer"
+	"github.com/jerryan999/goapp/internal/pkg/password"
+	"github.com/jerryan999/goapp/internal/pkg/tracing"
 	"github.com/jerryan999/goapp/internal/server/http"
+	"github.com/jerryan999/goapp/internal/sessions"
 	"github.com/jerryan999/goapp/internal/users"
 )
 
+var (
+	// version, commit and buildTime are injected at build time, e.g.
+	// go build -ldflags "-X main.version=v1.0.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+// teardownTimeout is the maximum time allowed for each step of the shutdown after draining the HTTP
+// server, e.g. disconnecting from the datastore
+const teardownTimeout = time.Second * 5
+
 func main() {
-	l := logger.New("goapp", "v1.0.0", 1)
-	cfg, err := configs.NewService()
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "path of the configuration file, in JSON, YAML or TOML")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration, with secrets redacted, and exit")
+	flag.Parse()
+
+	l := logger.New("goapp", version, 1)
+	cfg, err := configs.NewService(*configFile)
 	if err != nil {
 		l.Fatal(err.Error())
 		return
 	}
 
-	dscfg, err := cfg.Datastore()
+	// all the invalid keys are reported at once, instead of failing on the first one
+	err = cfg.Validate()
 	if err != nil {
+		var kerrs configs.Errors
+		if errors.As(err, &kerrs) {
+			for _, ke := range kerrs {
+				l.With("key", ke.Key).Error(ke.Reason)
+			}
+		}
 		l.Fatal(err.Error())
 		return
 	}
 
-	mongoClient, err := datastore.NewService(dscfg)
+	if *printConfig {
+		err = cfg.Print(os.Stdout)
+		if err != nil {
+			l.Fatal(err.Error())
+		}
+		return
+	}
+
+	logCfg, err := cfg.Logger()
 	if err != nil {
 		l.Fatal(err.Error())
 		return
 	}
 
-	cacheCfg, err := cfg.Cachestore()
+	err = l.Configure(logCfg)
 	if err != nil {
 		l.Fatal(err.Error())
 		return
 	}
 
-	redispool, err := cachestore.NewService(cacheCfg)
+	tracingCfg, err := cfg.Tracing()
 	if err != nil {
-		// Cache could be something we'd be willing to tolerate if not available
-		// Though this is strictly based on how critical cache is to your application
-		l.Error(err)
+		l.Fatal(err.Error())
 		return
 	}
 
-	us, err := users.NewService(l, mongoClient, redispool)
+	tracer, err := tracing.NewService(tracingCfg, version)
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+	tracing.SetGlobal(tracer)
+
+	usersCfg, err := cfg.Users()
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	apiKeysCfg, err := cfg.APIKeys()
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	var mongoClient *mongo.Client
+	if usersCfg.Store != users.StoreMemory || apiKeysCfg.Store != apikeys.StoreMemory {
+		dscfg, err := cfg.Datastore()
+		if err != nil {
+			l.Fatal(err.Error())
+			return
+		}
+
+		mongoClient, err = datastore.NewService(dscfg)
+		if err != nil {
+			l.Fatal(err.Error())
+			return
+		}
+	}
+
+	authCfg, err := cfg.Auth()
 	if err != nil {
 		l.Fatal(err.Error())
 		return
 	}
 
-	a, err := api.NewService(l, us)
+	sessionsCfg, err := cfg.Sessions()
 	if err != nil {
 		l.Fatal(err.Error())
 		return
@@ -62,14 +141,253 @@ func main() {
 		return
 	}
 
+	// the cache is shared by the users cache, the single use tokens of users, the token revocation
+	// list, the sessions and the rate limits of requests
+	cache := cachestore.NewHandle(nil)
+	cache.RegisterMetrics()
+	stopCacheRetry := func() {}
+	cacheRequired := usersCfg.Cache != users.CacheMemory ||
+		usersCfg.TokenStore != users.TokenStoreMemory ||
+		authCfg.Store != auth.StoreMemory ||
+		sessionsCfg.Store != sessions.StoreMemory ||
+		httpCfg.RateLimitStore == http.RateLimitStoreRedis
+	if cacheRequired {
+		cacheCfg, err := cfg.Cachestore()
+		if err != nil {
+			l.Fatal(err.Error())
+			return
+		}
+
+		redispool, err := cachestore.NewService(cacheCfg)
+		switch {
+		case err == nil:
+			cache.Attach(redispool)
+		case cacheCfg.Optional:
+			// Cache could be something we'd be willing to tolerate if not available
+			// Though this is strictly based on how critical cache is to your application
+			l.With("error", err).Warn("cache unavailable, starting in degraded mode")
+			stopCacheRetry = connectCache(l, cacheCfg, cache)
+		default:
+			l.Fatal(err.Error())
+			return
+		}
+	}
+
+	passwordCfg, err := cfg.Password()
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	hasher, err := password.NewService(passwordCfg)
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	mailerCfg, err := cfg.Mailer()
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	mail, err := mailer.NewService(mailerCfg)
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	us, err := users.NewService(usersCfg, l, mongoClient, cache, hasher, mail)
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	// the bootstrap admin is promoted right away if they verified their email before it was configured
+	bootstrapCtx, cancelBootstrap := context.WithTimeout(context.Background(), time.Second*10)
+	_, err = us.BootstrapAdmin(bootstrapCtx)
+	cancelBootstrap()
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	tokens, err := auth.NewService(authCfg, l, cache)
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	ss, err := sessions.NewService(sessionsCfg, l, cache)
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	ak, err := apikeys.NewService(apiKeysCfg, l, mongoClient)
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
+	healthChecks := make([]api.HealthCheck, 0, 2)
+	if mongoClient != nil {
+		healthChecks = append(healthChecks, api.HealthCheck{
+			Name:     "datastore",
+			Critical: true,
+			Check: func(ctx context.Context) error {
+				return datastore.Ping(ctx, mongoClient)
+			},
+		})
+	}
+	if cacheRequired {
+		healthChecks = append(healthChecks, api.HealthCheck{
+			Name: "cachestore",
+			// users are still served from the datastore when the cache is down
+			Critical: false,
+			Check: func(ctx context.Context) error {
+				redispool := cache.Pool()
+				if redispool == nil {
+					return cachestore.ErrCacheNotInitialized
+				}
+				return cachestore.Ping(ctx, redispool)
+			},
+		})
+	}
+
+	a, err := api.NewService(
+		l,
+		us,
+		tokens,
+		ss,
+		ak,
+		api.BuildInfo{
+			Env:       cfg.Environment(),
+			Version:   version,
+			Commit:    commit,
+			BuildTime: buildTime,
+		},
+		healthChecks,
+	)
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+
 	h, err := http.NewService(
 		httpCfg,
 		a,
+		cache,
 	)
 	if err != nil {
 		l.Fatal(err.Error())
 		return
 	}
 
-	h.Start()
+	reloader, err := configs.NewReloader(cfg, l)
+	if err != nil {
+		l.Fatal(err.Error())
+		return
+	}
+	reloader.Subscribe(func(rt *configs.Runtime) {
+		level, err := logger.ParseLevel(rt.Logger.Level)
+		if err == nil {
+			l.SetLevel(level)
+		}
+		us.SetCacheTTL(time.Duration(rt.Users.CacheTTLSecond) * time.Second)
+		h.SetLimits(rt.HTTP)
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go reloader.Watch(ctx)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- h.Start()
+	}()
+
+	var serveErr error
+	select {
+	case serveErr = <-serverErr:
+		if serveErr != nil {
+			l.Error(serveErr.Error())
+		}
+	case <-ctx.Done():
+		l.Info("shutdown signal received, draining connections")
+	}
+	// any further signal will kill the app immediately instead of waiting for the graceful shutdown
+	stop()
+	stopCacheRetry()
+
+	shutdown(l, time.Duration(httpCfg.ShutdownGraceSecond)*time.Second, h, us, tracer, mongoClient, cache)
+	if serveErr != nil {
+		// the app exits with an error, so that it's restarted as having crashed
+		os.Exit(1)
+	}
+}
+
+// connectCache keeps trying to connect to the cache in the background, and attaches the pool to
+// cache once connected. The returned function stops retrying, and waits for the retries to stop
+func connectCache(l logger.Logger, cfg *cachestore.Config, cache *cachestore.Handle) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		redispool, err := cachestore.Connect(ctx, cfg, func(attempt int, err error) {
+			l.With("attempt", attempt, "error", err).Warn("cache connection failed")
+		})
+		if err != nil {
+			return
+		}
+
+		cache.Attach(redispool)
+		l.Info("cache connected, leaving degraded mode")
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// shutdown tears down all the dependencies in the reverse order of their initialization. The HTTP
+// server is shut down first, so that in-flight requests can still use the datastore and cache
+// while being drained. Every step has its own deadline, so that a slow drain does not leave the
+// following steps without time
+func shutdown(
+	l *logger.LogHandler,
+	grace time.Duration,
+	h *http.HTTP,
+	us *users.Users,
+	tracer *tracing.Tracer,
+	mongoClient *mongo.Client,
+	cache *cachestore.Handle,
+) {
+	step := func(timeout time.Duration, teardown func(ctx context.Context) error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		err := teardown(ctx)
+		if err != nil {
+			l.Error(err.Error())
+		}
+	}
+
+	step(grace, h.Shutdown)
+	// emails sent in the background by the drained requests
+	step(teardownTimeout, us.Wait)
+	// spans of the drained requests are flushed before the datastore and cache are closed, since
+	// those are not required for exporting
+	step(teardownTimeout, tracer.Shutdown)
+	if mongoClient != nil {
+		step(teardownTimeout, mongoClient.Disconnect)
+	}
+	step(teardownTimeout, func(ctx context.Context) error {
+		return cache.Close()
+	})
+
+	l.Info("shutdown complete")
+	_ = l.Close()
 }